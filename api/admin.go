@@ -0,0 +1,246 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ── Administration ────────────────────────────────────────────────────────────
+// GET /admin/stats rassemble l'état des magasins du service — index des images, uploads tus en
+// cours, consommation du mois, seaux de limitation, presets, mémoire du processus — pour ne pas
+// recouper /images, /usage, le disque d'UPLOAD_DIR et les métriques système à la main.
+// POST /admin/purge?older_than=<durée>[&target=images|uploads|all] supprime les entrées plus
+// anciennes que la durée (72h, 30m, ou 7d en jours) : entrées d'index, uploads tus abandonnés.
+//
+// Redis, MinIO et RabbitMQ ne sont pas branchés dans l'API (cf. docker-compose) : pas de compteurs
+// de clés ni de file à rejouer ici — les magasins en mémoire ci-dessus tiennent leur rôle.
+//
+// Accès : scope admin du JWT si l'authentification est activée ; sinon header
+// "Authorization: Bearer <ADMIN_TOKEN>". Sans l'un ni l'autre, les routes /admin répondent 403.
+
+var (
+	adminToken string    // secret partagé, utilisé seulement sans JWKS_URL
+	startedAt  time.Time // uptime de /admin/stats
+)
+
+// initAdmin lit ADMIN_TOKEN — appelé au démarrage, après initAuth.
+func initAdmin() {
+	startedAt = time.Now()
+	adminToken = os.Getenv("ADMIN_TOKEN")
+	switch {
+	case jwksURL != "":
+		logger.Info().Str("component", "init").Str("scope", adminScope).Msg("administration : scope JWT")
+	case adminToken != "":
+		logger.Info().Str("component", "init").Msg("administration : ADMIN_TOKEN")
+	default:
+		logger.Info().Str("component", "init").Msg("administration désactivée (ni JWKS_URL ni ADMIN_TOKEN)")
+	}
+}
+
+// requireAdmin refuse en 403 les requêtes sans le scope admin (ou sans ADMIN_TOKEN, hors JWT).
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if msg := adminDenied(r); msg != "" {
+			writeError(w, http.StatusForbidden, codeForbidden, msg)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// adminDenied retourne pourquoi r n'a pas accès à l'administration, "" s'il y a accès.
+func adminDenied(r *http.Request) string {
+	if jwksURL != "" {
+		if claims := claimsFrom(r.Context()); claims == nil || !claims.hasScope(adminScope) {
+			return "Réservé au scope " + adminScope
+		}
+		return ""
+	}
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if adminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+		return "Administration désactivée ou ADMIN_TOKEN invalide"
+	}
+	return ""
+}
+
+// handleAdminStats retourne l'état des magasins en mémoire et sur disque.
+func handleAdminStats(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	month := usageMonth(now)
+
+	images := map[string]any{"capacity": maxIndexEntries}
+	byFormat := map[string]int{}
+	var bytes, sourceBytes int64
+	indexMu.RLock()
+	for _, rec := range imageIndex {
+		byFormat[rec.Format]++
+		bytes += int64(rec.Bytes)
+		sourceBytes += int64(rec.SourceBytes)
+	}
+	images["entries"] = len(imageIndex)
+	if len(imageIndex) > 0 {
+		images["oldest"], images["newest"] = imageIndex[0].Created, imageIndex[len(imageIndex)-1].Created
+	}
+	indexMu.RUnlock()
+	images["by_format"], images["bytes"], images["source_bytes"] = byFormat, bytes, sourceBytes
+
+	uploads, err := uploadStats()
+	if err != nil {
+		logger.Error().Str("step", "admin").Err(err).Msg("lecture UPLOAD_DIR KO")
+		writeError(w, http.StatusInternalServerError, codeInternal, "UPLOAD_DIR illisible")
+		return
+	}
+
+	usageMu.Lock()
+	total := usageCounters{}
+	for _, c := range usage[month] {
+		total.Requests += c.Requests
+		total.BytesIn += c.BytesIn
+		total.BytesOut += c.BytesOut
+		total.OptimizerSeconds += c.OptimizerSeconds
+	}
+	clients, months := len(usage[month]), len(usage)
+	usageMu.Unlock()
+
+	rateMu.Lock()
+	nBuckets := len(buckets)
+	rateMu.Unlock()
+	presetsMu.RLock()
+	presetCount, presetTenants := nPresets, len(presets)
+	presetsMu.RUnlock()
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{ //nolint:errcheck — erreur réseau côté client, pas récupérable
+		"images":     images,
+		"uploads":    uploads,
+		"usage":      map[string]any{"month": month, "clients": clients, "months": months, "total": total},
+		"rate_limit": map[string]any{"buckets": nBuckets},
+		"presets":    map[string]any{"count": presetCount, "tenants": presetTenants},
+		"runtime": map[string]any{
+			"uptime_seconds": int(now.Sub(startedAt).Seconds()),
+			"goroutines":     runtime.NumGoroutine(),
+			"heap_bytes":     mem.HeapAlloc,
+			"sys_bytes":      mem.Sys,
+			"gc_runs":        mem.NumGC,
+		},
+	})
+}
+
+// uploadStats compte les uploads tus en cours et leur place sur disque.
+func uploadStats() (map[string]any, error) {
+	entries, err := os.ReadDir(uploadDir)
+	if err != nil {
+		return nil, err
+	}
+	var count, complete int
+	var received, diskBytes int64
+	for _, e := range entries {
+		if fi, err := e.Info(); err == nil {
+			diskBytes += fi.Size() // fichiers orphelins compris : le sweep les supprimera
+		}
+		id, ext, _ := strings.Cut(e.Name(), ".")
+		if ext != "json" {
+			continue
+		}
+		info, err := readTusInfo(id)
+		if err != nil {
+			continue
+		}
+		count++
+		received += info.Offset
+		if info.Offset == info.Length {
+			complete++
+		}
+	}
+	return map[string]any{"count": count, "complete": complete, "bytes_received": received, "disk_bytes": diskBytes, "dir": uploadDir}, nil
+}
+
+// handleAdminPurge supprime les entrées d'index et/ou les uploads créés avant now - older_than.
+func handleAdminPurge(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	age, ok := parseAge(q.Get("older_than"))
+	if !ok {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "older_than requis : durée positive (72h, 30m, 7d)")
+		return
+	}
+	target := q.Get("target")
+	if target == "" {
+		target = "images"
+	}
+	if target != "images" && target != "uploads" && target != "all" {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "target invalide (images, uploads, all)")
+		return
+	}
+	cutoff := time.Now().Add(-age)
+
+	removed := map[string]int{}
+	if target != "uploads" {
+		removed["images"] = purgeImages(cutoff)
+	}
+	if target != "images" {
+		n, err := purgeUploads(cutoff)
+		if err != nil {
+			logger.Error().Str("step", "admin").Err(err).Msg("purge uploads KO")
+			writeError(w, http.StatusInternalServerError, codeInternal, "UPLOAD_DIR illisible")
+			return
+		}
+		removed["uploads"] = n
+	}
+	logger.Info().Str("step", "admin").Str("target", target).Time("cutoff", cutoff).Interface("removed", removed).Msg("purge")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"cutoff": cutoff.UTC(), "removed": removed}) //nolint:errcheck — erreur réseau côté client, pas récupérable
+}
+
+// parseAge lit une durée Go (72h, 90m) ou un nombre de jours (7d). "0" purge tout.
+func parseAge(v string) (time.Duration, bool) {
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		n, err := strconv.Atoi(days)
+		return time.Duration(n) * 24 * time.Hour, err == nil && n >= 0
+	}
+	d, err := time.ParseDuration(v)
+	return d, err == nil && d >= 0
+}
+
+// purgeImages retire de l'index les images traitées avant cutoff.
+func purgeImages(cutoff time.Time) int {
+	indexMu.Lock()
+	defer indexMu.Unlock()
+	n := len(imageIndex)
+	imageIndex = slices.DeleteFunc(imageIndex, func(rec *imageRecord) bool {
+		if rec.Created.Before(cutoff) {
+			delete(byHash, rec.Hash)
+			return true
+		}
+		return false
+	})
+	return n - len(imageIndex)
+}
+
+// purgeUploads supprime les uploads tus créés avant cutoff, complets ou non.
+func purgeUploads(cutoff time.Time) (int, error) {
+	entries, err := os.ReadDir(uploadDir)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, e := range entries {
+		id, ext, _ := strings.Cut(e.Name(), ".")
+		if ext != "json" {
+			continue
+		}
+		if info, err := readTusInfo(id); err == nil && info.Expires.Add(-tusExpiry).Before(cutoff) && removeUpload(id) { // PATCH en cours : laissé
+			n++
+		}
+	}
+	return n, nil
+}
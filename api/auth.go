@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256" // crypto.SHA256 — enregistré par l'import
+	_ "crypto/sha512" // crypto.SHA384, crypto.SHA512
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ── Authentification (JWT / OIDC) ─────────────────────────────────────────────
+// Avec JWKS_URL défini, chaque requête doit porter un header Authorization: Bearer <jwt> signé
+// par une clé de ce JWKS — typiquement https://<fournisseur>/.well-known/jwks.json. Sans JWKS_URL,
+// l'API reste ouverte, comme avant. Vérifications : signature (RS*, PS*, ES*, EdDSA — jamais none
+// ni HS*), exp obligatoire, nbf, et iss / aud si JWT_ISSUER / JWT_AUDIENCE sont définis.
+//
+// Les claims utiles sont attachés au contexte de la requête (claimsFrom) : sujet, tenant (claim
+// JWT_TENANT_CLAIM, tenant_id par défaut) et scopes (scope ou scp). Le tenant cloisonne l'index
+// des images ; un token portant le scope JWT_ADMIN_SCOPE (admin par défaut) voit tous les tenants.
+//
+// Le JWKS est rechargé toutes les jwksRefresh, et au plus une fois par jwksMinRefresh quand un
+// token cite un kid inconnu — rotation de clé chez le fournisseur. Un seul téléchargement à la fois,
+// hors de jwksMu : les tokens dont le kid est connu sont vérifiés avec les clés en cache pendant
+// ce temps ; seuls ceux qui citent un kid inconnu attendent la fin du téléchargement en cours.
+
+const (
+	jwksRefresh    = 10 * time.Minute
+	jwksMinRefresh = time.Minute      // un kid inconnu ne doit pas faire marteler le fournisseur
+	jwtLeeway      = 60 * time.Second // décalage d'horloge toléré sur exp et nbf
+	maxJWKSBytes   = 1 << 20
+)
+
+// authClaims : identité du porteur du token, attachée au contexte de la requête.
+type authClaims struct {
+	subject string
+	tenant  string
+	scopes  []string
+}
+
+// hasScope indique si le token porte le scope s.
+func (c *authClaims) hasScope(s string) bool {
+	return c != nil && slices.Contains(c.scopes, s)
+}
+
+type claimsKey struct{}
+
+// claimsFrom retourne les claims de la requête — nil si l'authentification est désactivée.
+func claimsFrom(ctx context.Context) *authClaims {
+	c, _ := ctx.Value(claimsKey{}).(*authClaims)
+	return c
+}
+
+var (
+	jwksURL     string // vide : authentification désactivée
+	jwtIssuer   string
+	jwtAudience string
+	tenantClaim = "tenant_id"
+	adminScope  = "admin"
+
+	jwksClient  = &http.Client{Timeout: 10 * time.Second}
+	jwksMu      sync.Mutex
+	jwksKeys    map[string]crypto.PublicKey // par kid
+	jwksAt      time.Time                   // dernier chargement réussi
+	jwksTried   time.Time                   // dernière tentative, réussie ou non
+	jwksLoading chan struct{}               // téléchargement en cours, fermé à la fin — nil sinon
+)
+
+// initAuth lit la configuration JWT et charge le JWKS — appelé au démarrage.
+// Un fournisseur injoignable au démarrage n'est pas fatal : le chargement est retenté à la première requête.
+func initAuth() {
+	jwksURL = os.Getenv("JWKS_URL")
+	if jwksURL == "" {
+		logger.Info().Str("component", "init").Msg("authentification désactivée (JWKS_URL absent)")
+		return
+	}
+	jwtIssuer, jwtAudience = os.Getenv("JWT_ISSUER"), os.Getenv("JWT_AUDIENCE")
+	if v := os.Getenv("JWT_TENANT_CLAIM"); v != "" {
+		tenantClaim = v
+	}
+	if v := os.Getenv("JWT_ADMIN_SCOPE"); v != "" {
+		adminScope = v
+	}
+	logger.Info().Str("component", "init").Str("jwks_url", jwksURL).Str("issuer", jwtIssuer).Str("audience", jwtAudience).Str("tenant_claim", tenantClaim).Msg("authentification JWT activée")
+	jwksMu.Lock()
+	done := startRefresh()
+	jwksMu.Unlock()
+	<-done // échec loggé, retenté à la première requête
+}
+
+// authMiddleware refuse en 401 les requêtes sans token valide et attache les claims au contexte.
+func authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if jwksURL == "" || (r.Method == http.MethodGet && strings.TrimPrefix(r.URL.Path, "/v1") == "/openapi.json") { // la spec est publique
+			next.ServeHTTP(w, r)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer`)
+			writeError(w, http.StatusUnauthorized, codeUnauthorized, "Token manquant (Authorization: Bearer)")
+			return
+		}
+		claims, err := verifyJWT(strings.TrimSpace(token), time.Now())
+		if err != nil {
+			logger.Warn().Str("step", "auth").Err(err).Str("path", r.URL.Path).Msg("token refusé")
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			writeError(w, http.StatusUnauthorized, codeUnauthorized, "Token invalide")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), claimsKey{}, claims)))
+	})
+}
+
+// verifyJWT vérifie la signature et les dates d'un JWT compact, puis en extrait les claims.
+func verifyJWT(token string, now time.Time) (*authClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("JWT mal formé")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("en-tête JWT : %w", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("signature JWT mal encodée")
+	}
+	key, err := jwksKey(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("claims JWT : %w", err)
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, errors.New("claim exp manquant")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(jwtLeeway)) {
+		return nil, errors.New("token expiré")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(jwtLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return nil, errors.New("token pas encore valide")
+	}
+	if jwtIssuer != "" && claims["iss"] != jwtIssuer {
+		return nil, fmt.Errorf("émetteur inattendu : %v", claims["iss"])
+	}
+	if jwtAudience != "" && !slices.Contains(stringList(claims["aud"]), jwtAudience) {
+		return nil, fmt.Errorf("audience inattendue : %v", claims["aud"])
+	}
+
+	c := &authClaims{}
+	c.subject, _ = claims["sub"].(string)
+	c.tenant, _ = claims[tenantClaim].(string)
+	if s, ok := claims["scope"].(string); ok { // RFC 8693 : liste séparée par des espaces
+		c.scopes = strings.Fields(s)
+	} else {
+		c.scopes = stringList(claims["scp"]) // Azure AD, Okta : tableau ou chaîne
+	}
+	return c, nil
+}
+
+// decodeSegment décode un segment base64url de JWT dans v.
+func decodeSegment(seg string, v any) error {
+	raw, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// stringList lit un claim chaîne ou tableau de chaînes (aud, scp).
+func stringList(v any) []string {
+	switch v := v.(type) {
+	case string:
+		return strings.Fields(v)
+	case []any:
+		var out []string
+		for _, s := range v {
+			if s, ok := s.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// verifySignature vérifie sig pour l'algorithme JWS alg — la clé doit être du type attendu par alg.
+func verifySignature(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	hashes := map[string]crypto.Hash{"256": crypto.SHA256, "384": crypto.SHA384, "512": crypto.SHA512}
+	if alg == "EdDSA" {
+		if k, ok := key.(ed25519.PublicKey); ok && ed25519.Verify(k, signed, sig) {
+			return nil
+		}
+		return errors.New("signature invalide")
+	}
+	if len(alg) != 5 || hashes[alg[2:]] == 0 {
+		return fmt.Errorf("algorithme non supporté : %q", alg)
+	}
+	h := hashes[alg[2:]]
+	hasher := h.New()
+	hasher.Write(signed)
+	digest := hasher.Sum(nil)
+
+	var ok bool
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		switch alg[:2] {
+		case "RS":
+			ok = rsa.VerifyPKCS1v15(k, h, digest, sig) == nil
+		case "PS":
+			ok = rsa.VerifyPSS(k, h, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil
+		}
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8 // signature JWS : r || s, chacun sur size octets
+		if alg[:2] == "ES" && len(sig) == 2*size {
+			ok = ecdsa.Verify(k, digest, new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:]))
+		}
+	}
+	if !ok {
+		return errors.New("signature invalide")
+	}
+	return nil
+}
+
+// ── JWKS ──
+
+// jwksKey retourne la clé kid du JWKS. JWKS périmé : rechargé en arrière-plan, la clé en cache sert
+// en attendant. Kid inconnu : attend le rechargement (au plus un par jwksMinRefresh). Un kid vide
+// convient si le JWKS n'a qu'une clé.
+func jwksKey(kid string) (crypto.PublicKey, error) {
+	jwksMu.Lock()
+	key, found := lookupKey(kid)
+	var wait chan struct{}
+	if !found || time.Since(jwksAt) > jwksRefresh {
+		wait = startRefresh()
+	}
+	jwksMu.Unlock()
+	if !found && wait != nil { // seul un JWKS rechargé peut connaître ce kid
+		<-wait
+		jwksMu.Lock()
+		key, found = lookupKey(kid)
+		jwksMu.Unlock()
+	}
+	if !found {
+		jwksMu.Lock()
+		loaded := jwksKeys != nil
+		jwksMu.Unlock()
+		if !loaded {
+			return nil, errors.New("JWKS indisponible")
+		}
+		return nil, fmt.Errorf("clé inconnue : kid %q", kid)
+	}
+	return key, nil
+}
+
+// startRefresh lance le téléchargement du JWKS si aucun n'est en cours et que jwksMinRefresh est
+// écoulé depuis la dernière tentative. Retourne le canal du téléchargement en cours, fermé à sa
+// fin — nil s'il n'y en a pas. jwksMu est tenu par l'appelant.
+func startRefresh() chan struct{} {
+	if jwksLoading == nil && time.Since(jwksTried) > jwksMinRefresh {
+		jwksTried = time.Now()
+		jwksLoading = make(chan struct{})
+		go refreshJWKS(jwksLoading)
+	}
+	return jwksLoading
+}
+
+// refreshJWKS télécharge le JWKS sans tenir jwksMu, installe les clés et ferme done. En cas
+// d'échec, les clés précédentes restent en service.
+func refreshJWKS(done chan struct{}) {
+	defer close(done)
+	keys, err := fetchJWKS()
+	jwksMu.Lock()
+	jwksLoading = nil
+	if err == nil {
+		jwksKeys, jwksAt = keys, time.Now()
+	}
+	jwksMu.Unlock()
+	if err != nil {
+		logger.Error().Str("step", "auth").Err(err).Str("jwks_url", jwksURL).Msg("chargement JWKS échoué")
+		return
+	}
+	logger.Info().Str("step", "auth").Int("keys", len(keys)).Msg("JWKS chargé")
+}
+
+// lookupKey cherche kid dans le JWKS chargé — jwksMu est tenu par l'appelant.
+func lookupKey(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(jwksKeys) == 1 {
+		for _, k := range jwksKeys {
+			return k, true
+		}
+	}
+	k, ok := jwksKeys[kid]
+	return k, ok && kid != ""
+}
+
+// fetchJWKS télécharge et lit le JWKS. Les clés d'un type inconnu, ou réservées au chiffrement
+// (use=enc), sont ignorées.
+func fetchJWKS() (map[string]crypto.PublicKey, error) {
+	resp, err := jwksClient.Get(jwksURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWKS : HTTP %d", resp.StatusCode)
+	}
+	var set struct {
+		Keys []struct {
+			Kty, Kid, Use, Crv, N, E, X, Y string
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxJWKSBytes)).Decode(&set); err != nil {
+		return nil, fmt.Errorf("JWKS illisible : %w", err)
+	}
+	b64 := base64.RawURLEncoding.DecodeString
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Use == "enc" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, err1 := b64(k.N)
+			e, err2 := b64(k.E)
+			if err1 != nil || err2 != nil || len(e) > 4 {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			curve := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}[k.Crv]
+			x, err1 := b64(k.X)
+			y, err2 := b64(k.Y)
+			if curve == nil || err1 != nil || err2 != nil {
+				continue
+			}
+			pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+			if !curve.IsOnCurve(pub.X, pub.Y) { //nolint:staticcheck — pas d'équivalent crypto/ecdh pour une clé de vérification
+				continue
+			}
+			keys[k.Kid] = pub
+		case "OKP":
+			x, err := b64(k.X)
+			if k.Crv != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+				continue
+			}
+			keys[k.Kid] = ed25519.PublicKey(x)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS sans clé de signature utilisable")
+	}
+	return keys, nil
+}
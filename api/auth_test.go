@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testJWKS sert un JWKS d'une clé RSA (kid "rsa") et compte les téléchargements.
+type testJWKS struct {
+	key     *rsa.PrivateKey
+	kids    atomic.Value // []string : kids publiés pour la clé
+	fetches atomic.Int32
+}
+
+func newTestJWKS(t *testing.T) *testJWKS {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := &testJWKS{key: key}
+	j.kids.Store([]string{"rsa"})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		j.fetches.Add(1)
+		b64 := base64.RawURLEncoding.EncodeToString
+		var keys []map[string]string
+		for _, kid := range j.kids.Load().([]string) {
+			keys = append(keys, map[string]string{"kty": "RSA", "kid": kid, "n": b64(key.N.Bytes()), "e": b64(big.NewInt(int64(key.E)).Bytes())})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"keys": keys}) //nolint:errcheck
+	}))
+	t.Cleanup(srv.Close)
+
+	// état global de auth.go : remis à zéro pour chaque test
+	old := jwksURL
+	jwksURL, jwksKeys, jwksAt, jwksTried, jwksLoading = srv.URL, nil, time.Time{}, time.Time{}, nil
+	t.Cleanup(func() { jwksURL, jwksKeys = old, nil })
+	return j
+}
+
+// sign retourne un JWT RS256 signé par la clé du JWKS.
+func (j *testJWKS) sign(t *testing.T, kid string, claims map[string]any) string {
+	t.Helper()
+	return signJWT(t, map[string]any{"alg": "RS256", "kid": kid}, claims, func(signed []byte) []byte {
+		digest := sha256.Sum256(signed)
+		sig, err := rsa.SignPKCS1v15(rand.Reader, j.key, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sig
+	})
+}
+
+func signJWT(t *testing.T, header, claims map[string]any, sign func([]byte) []byte) string {
+	t.Helper()
+	seg := func(v any) string {
+		raw, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(raw)
+	}
+	signed := seg(header) + "." + seg(claims)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(signed)))
+}
+
+func TestVerifyJWT(t *testing.T) {
+	j := newTestJWKS(t)
+	now := time.Now()
+	valid := map[string]any{"sub": "alice", "tenant_id": "acme", "scope": "read admin", "exp": now.Add(time.Hour).Unix()}
+
+	c, err := verifyJWT(j.sign(t, "rsa", valid), now)
+	if err != nil {
+		t.Fatalf("token valide refusé : %v", err)
+	}
+	if c.subject != "alice" || c.tenant != "acme" || !c.hasScope("admin") {
+		t.Errorf("claims %+v", c)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := strings.Split(j.sign(t, "rsa", valid), ".")
+	forged, _ := json.Marshal(map[string]any{"sub": "mallory", "exp": now.Add(time.Hour).Unix()})
+	tampered[1] = base64.RawURLEncoding.EncodeToString(forged)
+
+	for _, tc := range []struct {
+		name, token, err string
+	}{
+		{"signature modifiée", strings.Join(tampered, "."), "signature invalide"},
+		{"signature d'une autre clé", signJWT(t, map[string]any{"alg": "ES256", "kid": "rsa"}, valid, func(signed []byte) []byte {
+			digest := sha256.Sum256(signed)
+			sig, err := ecdsa.SignASN1(rand.Reader, ecKey, digest[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			return sig
+		}), "signature invalide"},
+		{"alg none", signJWT(t, map[string]any{"alg": "none", "kid": "rsa"}, valid, func([]byte) []byte { return nil }), "algorithme non supporté"},
+		{"alg HS256", signJWT(t, map[string]any{"alg": "HS256", "kid": "rsa"}, valid, func([]byte) []byte { return []byte("secret") }), "signature invalide"},
+		{"expiré", j.sign(t, "rsa", map[string]any{"sub": "alice", "exp": now.Add(-time.Hour).Unix()}), "token expiré"},
+		{"sans exp", j.sign(t, "rsa", map[string]any{"sub": "alice"}), "claim exp manquant"},
+		{"kid inconnu", j.sign(t, "autre", valid), "clé inconnue"},
+		{"mal formé", "abc.def", "JWT mal formé"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := verifyJWT(tc.token, now)
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("erreur %v, attendu %q", err, tc.err)
+			}
+		})
+	}
+}
+
+// Un kid inconnu recharge le JWKS au plus une fois par jwksMinRefresh ; un kid apparu depuis
+// (rotation chez le fournisseur) est accepté une fois le délai passé.
+func TestJWKSRotation(t *testing.T) {
+	j := newTestJWKS(t)
+	now := time.Now()
+	claims := map[string]any{"sub": "alice", "exp": now.Add(time.Hour).Unix()}
+	if _, err := verifyJWT(j.sign(t, "rsa", claims), now); err != nil {
+		t.Fatal(err)
+	}
+
+	j.kids.Store([]string{"rsa", "rsa-2"})
+	token := j.sign(t, "rsa-2", claims)
+	for range 5 {
+		if _, err := verifyJWT(token, now); err == nil {
+			t.Fatal("kid publié après le dernier chargement accepté avant jwksMinRefresh")
+		}
+	}
+	if n := j.fetches.Load(); n != 1 {
+		t.Errorf("%d téléchargements du JWKS, attendu 1", n)
+	}
+
+	jwksMu.Lock()
+	jwksTried = time.Now().Add(-2 * jwksMinRefresh)
+	jwksMu.Unlock()
+	if _, err := verifyJWT(token, now); err != nil {
+		t.Errorf("kid rsa-2 après rotation : %v", err)
+	}
+	if n := j.fetches.Load(); n != 2 {
+		t.Errorf("%d téléchargements du JWKS, attendu 2", n)
+	}
+}
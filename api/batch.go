@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ── Upload par lot ────────────────────────────────────────────────────────────
+// POST /upload/batch reçoit plusieurs parts "image" avec les mêmes champs que /upload (preset,
+// watermark, sortie), communs à tout le lot. Les images partent vers l'optimizer en parallèle,
+// au plus batchWorkers à la fois : un lot de 50 photos ne prend pas tous les slots de l'optimizer
+// aux autres clients. La réponse est un tableau JSON, un résultat par image dans l'ordre d'envoi ;
+// une image refusée n'interrompt pas le lot, son statut et son erreur sont dans son résultat.
+//
+// Sans stockage objet côté API, url est une data URL (base64) du résultat, utilisable telle quelle
+// dans un <img src>. hash est le SHA-256 de l'original — la même image envoyée deux fois a le même.
+
+const (
+	batchWorkers  = 4        // requêtes simultanées vers l'optimizer pour un même lot
+	maxBatchFiles = 50       // au-delà, découper le lot côté client
+	maxBatchBytes = 64 << 20 // formulaire complet, toutes images comprises
+)
+
+// batchResult est le résultat d'une image du lot.
+type batchResult struct {
+	Name   string    `json:"name"`   // nom du fichier envoyé
+	Hash   string    `json:"hash"`   // SHA-256 hexadécimal de l'original
+	Status int       `json:"status"` // code HTTP qu'aurait renvoyé /upload pour cette image
+	URL    string    `json:"url,omitempty"`
+	Error  string    `json:"error,omitempty"`
+	Code   errorCode `json:"code,omitempty"` // code de l'erreur, comme dans les réponses d'erreur (errors.go)
+}
+
+func handleBatchUpload(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxBatchBytes)
+	if err := r.ParseMultipartForm(32 << 20); err != nil { // au-delà de 32 Mo, les parts vont sur disque
+		if errors.As(err, new(*http.MaxBytesError)) {
+			writeError(w, http.StatusRequestEntityTooLarge, codeImageTooLarge, fmt.Sprintf("Lot trop volumineux (max %s)", formatBytes(maxBatchBytes)))
+			return
+		}
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Formulaire invalide")
+		return
+	}
+	headers := r.MultipartForm.File["image"]
+	if len(headers) == 0 {
+		writeError(w, http.StatusBadRequest, codeImageMissing, "Image manquante")
+		return
+	}
+	if len(headers) > maxBatchFiles {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Trop d'images (max %d par lot, reçu %d)", maxBatchFiles, len(headers)))
+		return
+	}
+	if rateRPS > 0 && !allowRequest(w, r, min(float64(len(headers)), rateBurst)-1) { // un jeton par image, plafonné au burst — le premier est pris par le middleware
+		return
+	}
+	if r.FormValue("sizes") != "" { // réponse multipart par image — pas de représentation dans le tableau JSON
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "sizes non supporté par /upload/batch")
+		return
+	}
+	opts, ok := parseUploadOptions(w, r)
+	if !ok {
+		return
+	}
+	logger.Info().Str("step", "batch").Int("files", len(headers)).Int("workers", batchWorkers).Msg("lot reçu")
+
+	results := make([]batchResult, len(headers))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range min(batchWorkers, len(headers)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = processBatchFile(r.Context(), headers[i], opts)
+			}
+		}()
+	}
+	for i := range headers {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	failed := 0
+	for _, res := range results {
+		if res.Status != http.StatusOK {
+			failed++
+		}
+	}
+	logger.Info().Str("step", "batch").Int("files", len(results)).Int("failed", failed).Dur("duration", time.Since(start)).Msg("lot terminé")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results) //nolint:errcheck — erreur réseau côté client, pas récupérable
+}
+
+// processBatchFile lit une image du lot et la fait traiter par processImage.
+func processBatchFile(ctx context.Context, fh *multipart.FileHeader, opts *uploadOptions) batchResult {
+	tRead := time.Now()
+	file, err := fh.Open()
+	if err != nil {
+		return batchResult{Name: fh.Filename, Status: http.StatusInternalServerError, Code: codeInternal, Error: "Erreur lecture"}
+	}
+	data, err := io.ReadAll(file)
+	file.Close()
+	if err != nil {
+		return batchResult{Name: fh.Filename, Status: http.StatusInternalServerError, Code: codeInternal, Error: "Erreur lecture"}
+	}
+	return processImage(ctx, fh.Filename, data, fh.Header.Get("Content-Type"), opts, time.Since(tRead))
+}
+
+// processImage envoie une image à l'optimizer, avec les mêmes règles d'erreur que /upload — un lot
+// comme la mutation GraphQL upload (cf. graphql.go). declared est le Content-Type envoyé par le client.
+// sizes (champ ou preset) est refusé : l'optimizer répondrait un multipart de variantes, qui n'a
+// pas de représentation en data URL.
+func processImage(ctx context.Context, name string, data []byte, declared string, opts *uploadOptions, readDur time.Duration) batchResult {
+	res := batchResult{Name: name}
+	observeStage("read", readDur)
+	if opts.extra["sizes"] != "" {
+		res.Status, res.Code, res.Error = http.StatusBadRequest, codeInvalidRequest, "sizes non supporté : une seule image par résultat"
+		return res
+	}
+	if err := checkImageType(data, declared); err != nil {
+		logger.Warn().Str("step", "batch").Str("filename", name).Err(err).Msg("fichier refusé")
+		res.Status, res.Code, res.Error = http.StatusUnsupportedMediaType, codeUnsupportedFormat, err.Error()
+		return res
+	}
+	res.Hash = imageHash(data)
+
+	t := time.Now()
+	result, meta, err := sendToOptimizer(ctx, optimizerAddr(), name, data, opts)
+	meterOptimizer(ctx, time.Since(t))
+	if oe := (*optimizerError)(nil); errors.As(err, &oe) && (oe.status < 500 || oe.status == http.StatusNotImplemented) {
+		logger.Warn().Str("step", "batch").Str("filename", name).Int("status", oe.status).Str("reason", oe.msg).Msg("image refusée")
+		res.Status, res.Code, res.Error = oe.status, optimizerCode(oe.status), oe.msg
+		return res
+	}
+	if err != nil {
+		logger.Error().Str("step", "batch").Str("filename", name).Err(err).Msg("optimizer KO")
+		res.Status, res.Code, res.Error = http.StatusBadGateway, codeOptimizerUnavailable, "Microservice indisponible"
+		return res
+	}
+	logger.Info().Str("step", "batch").Str("filename", name).Str("size", formatBytes(len(result))).Dur("duration", time.Since(t)).Msg("image optimisée")
+	recordImage(newImageRecord(name, data, result, meta, opts, readDur, time.Since(t)))
+	res.Status = http.StatusOK
+	res.URL = "data:" + detectContentType(result) + ";base64," + base64.StdEncoding.EncodeToString(result)
+	return res
+}
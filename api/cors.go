@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// ── CORS ──────────────────────────────────────────────────────────────────────
+// ALLOWED_ORIGINS liste les origines autorisées, séparées par des virgules
+// (ex: "https://app.example.com,http://localhost:5173"). Absente ou "*" : toute origine, comme
+// en dev. Avec une liste, l'origine de la requête est renvoyée si elle y figure, et rien sinon —
+// le navigateur bloque alors la réponse ; un preflight d'une origine inconnue est refusé en 403.
+//
+// CORS_ALLOW_CREDENTIALS=true autorise les cookies et l'authentification HTTP (intégrations à
+// session), ce que la norme interdit avec "*" : il exige une liste explicite.
+
+const (
+	corsMaxAge        = "600" // secondes pendant lesquelles le navigateur garde un preflight en cache
+	corsAllowHeaders  = "Content-Type, Authorization, API-Version, X-Request-Id, Tus-Resumable, Upload-Length, Upload-Offset, Upload-Metadata"
+	corsExposeHeaders = "X-T-Read, X-T-Optimizer, X-Image-Quality, X-Image-Experiment, X-Image-Blurhash, X-Image-Dominant-Color, X-Image-Palette, X-Image-Gps-Stripped, X-Image-Wm-Position, X-Image-Variants, X-Image-Width, X-Image-Height, X-Image-Source-Width, X-Image-Source-Height, X-Image-Icc, X-Image-Frames, X-Image-Pages, Location, Tus-Resumable, Upload-Offset, Upload-Length, Upload-Expires, API-Version, X-Request-Id" // headers de timing et métadonnées image lisibles par le front
+)
+
+var (
+	allowedOrigins  map[string]bool // nil : toute origine
+	corsCredentials bool
+)
+
+// initCORS lit ALLOWED_ORIGINS et CORS_ALLOW_CREDENTIALS — appelé au démarrage.
+func initCORS() error {
+	corsCredentials = os.Getenv("CORS_ALLOW_CREDENTIALS") == "true"
+	if v := strings.TrimSpace(os.Getenv("ALLOWED_ORIGINS")); v != "" && v != "*" {
+		allowedOrigins = map[string]bool{}
+		for _, o := range strings.Split(v, ",") {
+			if o = strings.TrimRight(strings.TrimSpace(o), "/"); o != "" { // "https://app.example.com/" : le navigateur envoie l'origine sans slash
+				allowedOrigins[o] = true
+			}
+		}
+	}
+	if corsCredentials && allowedOrigins == nil {
+		return errors.New("CORS_ALLOW_CREDENTIALS=true exige une liste ALLOWED_ORIGINS explicite")
+	}
+	origins := []string{"*"}
+	if allowedOrigins != nil {
+		origins = slices.Sorted(maps.Keys(allowedOrigins))
+	}
+	logger.Info().Str("component", "init").Strs("origins", origins).Bool("credentials", corsCredentials).Msg("CORS")
+	return nil
+}
+
+// corsMiddleware ajoute les headers CORS pour permettre les appels depuis le front React (dev + prod).
+func corsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" // un OPTIONS tus, lui, va au handler
+		h := w.Header()
+		switch {
+		case allowedOrigins == nil:
+			h.Set("Access-Control-Allow-Origin", "*")
+		case allowedOrigins[origin]:
+			h.Set("Access-Control-Allow-Origin", origin)
+			h.Add("Vary", "Origin") // la réponse dépend de l'origine : un cache partagé ne doit pas la resservir à une autre
+		default:
+			h.Add("Vary", "Origin")
+			if preflight {
+				logger.Warn().Str("step", "cors").Str("origin", origin).Str("path", r.URL.Path).Msg("origine refusée")
+				writeError(w, http.StatusForbidden, codeForbidden, "Origine non autorisée")
+				return
+			}
+			next.ServeHTTP(w, r) // requête simple ou même origine : traitée, mais illisible par un script d'une autre origine
+			return
+		}
+		if corsCredentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+		h.Set("Access-Control-Expose-Headers", corsExposeHeaders)
+
+		if preflight { // répondre sans passer au handler
+			h.Set("Access-Control-Allow-Methods", "GET, POST, HEAD, PATCH, DELETE, OPTIONS")
+			h.Set("Access-Control-Allow-Headers", corsAllowHeaders)
+			h.Set("Access-Control-Max-Age", corsMaxAge)
+			h.Add("Vary", "Access-Control-Request-Method, Access-Control-Request-Headers")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// ── Erreurs ───────────────────────────────────────────────────────────────────
+// Toutes les erreurs de l'API ont le même corps JSON :
+//   {"code": "IMAGE_TOO_LARGE", "message": "Fichier trop volumineux (max 50 Mo)", "request_id": "…", "retryable": false}
+// code est stable (liste ci-dessous, reprise dans /openapi.json) : les clients branchent dessus.
+// message reste lisible, en français, et peut changer d'une version à l'autre. retryable : la même
+// requête peut réussir plus tard sans modification — après Retry-After s'il est présent.
+// request_id reprend X-Request-Id, celui du client s'il en envoie un valide, sinon généré ; il est
+// renvoyé dans chaque réponse pour recouper une erreur avec les logs.
+//
+// Les refus de l'optimizer (texte brut, réseau interne) sont relayés avec le code de leur statut
+// (optimizerCode), message d'origine conservé.
+
+type errorCode string
+
+const (
+	codeInvalidRequest       errorCode = "INVALID_REQUEST"       // 400 : paramètre, formulaire ou header invalide
+	codeImageMissing         errorCode = "IMAGE_MISSING"         // 400 : ni image, ni upload_id
+	codeImageTooLarge        errorCode = "IMAGE_TOO_LARGE"       // 413 : MAX_UPLOAD_SIZE, lot, Upload-Length
+	codeUnsupportedFormat    errorCode = "UNSUPPORTED_FORMAT"    // 415 : fichier qui n'est pas une image acceptée
+	codeImageRejected        errorCode = "IMAGE_REJECTED"        // 422 : dimensions, politique GPS (optimizer)
+	codeUnauthorized         errorCode = "UNAUTHORIZED"          // 401 : token absent ou invalide
+	codeForbidden            errorCode = "FORBIDDEN"             // 403 : scope manquant, origine refusée
+	codeNotFound             errorCode = "NOT_FOUND"             // 404 : route, image, preset ou upload inconnu
+	codeMethodNotAllowed     errorCode = "METHOD_NOT_ALLOWED"    // 405
+	codeUploadConflict       errorCode = "UPLOAD_CONFLICT"       // 409 : Upload-Offset différent de l'offset courant
+	codeUploadBusy           errorCode = "UPLOAD_BUSY"           // 409 : un autre PATCH est en cours sur l'upload
+	codeUnsupportedVersion   errorCode = "UNSUPPORTED_VERSION"   // 400 / 412 : API-Version ou Tus-Resumable inconnu
+	codeRateLimited          errorCode = "RATE_LIMITED"          // 429 : débit, Retry-After
+	codeQuotaExceeded        errorCode = "QUOTA_EXCEEDED"        // 429 / 402 : quota mensuel, jusqu'au mois suivant
+	codeStorageFull          errorCode = "STORAGE_FULL"          // 507 : nombre de presets maximal
+	codeInternal             errorCode = "INTERNAL"              // 500
+	codeNotImplemented       errorCode = "NOT_IMPLEMENTED"       // 501 : option indisponible sur l'optimizer (ffmpeg…)
+	codeOptimizerUnavailable errorCode = "OPTIMIZER_UNAVAILABLE" // 502 / 503 / 504 : optimizer injoignable ou saturé
+)
+
+// errorCodes : codes documentés, et s'ils valent d'être réessayés.
+var errorCodes = map[errorCode]bool{
+	codeInvalidRequest:       false,
+	codeImageMissing:         false,
+	codeImageTooLarge:        false,
+	codeUnsupportedFormat:    false,
+	codeImageRejected:        false,
+	codeUnauthorized:         false,
+	codeForbidden:            false,
+	codeNotFound:             false,
+	codeMethodNotAllowed:     false,
+	codeUploadConflict:       false, // relire l'offset (HEAD) avant de renvoyer
+	codeUploadBusy:           true,
+	codeUnsupportedVersion:   false,
+	codeRateLimited:          true,
+	codeQuotaExceeded:        false, // pas avant le mois suivant
+	codeStorageFull:          false,
+	codeInternal:             true,
+	codeNotImplemented:       false,
+	codeOptimizerUnavailable: true,
+}
+
+// apiError : corps d'une réponse d'erreur. Implémente error et les extensions GraphQL (code).
+type apiError struct {
+	Code      errorCode `json:"code"`
+	Message   string    `json:"message"`
+	RequestID string    `json:"request_id,omitempty"`
+	Retryable bool      `json:"retryable"`
+}
+
+func (e *apiError) Error() string { return e.Message }
+
+// Extensions ajoute code et retryable aux erreurs GraphQL (graph-gophers les lit sur l'erreur).
+func (e *apiError) Extensions() map[string]any {
+	return map[string]any{"code": e.Code, "retryable": e.Retryable}
+}
+
+// writeError répond status avec le corps JSON d'erreur — remplace http.Error.
+func writeError(w http.ResponseWriter, status int, code errorCode, msg string) {
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(&apiError{Code: code, Message: msg, RequestID: h.Get("X-Request-Id"), Retryable: errorCodes[code]}) //nolint:errcheck — erreur réseau côté client, pas récupérable
+}
+
+// readError relit une erreur écrite par writeError dans une réponse en mémoire (bufferedResponse).
+func readError(body string) *apiError {
+	e := &apiError{}
+	if json.Unmarshal([]byte(body), e) != nil || e.Code == "" {
+		return &apiError{Code: codeInternal, Message: strings.TrimSpace(body), Retryable: true}
+	}
+	return e
+}
+
+// optimizerCode retourne le code d'un refus de l'optimizer, d'après son statut.
+func optimizerCode(status int) errorCode {
+	switch status {
+	case http.StatusBadRequest:
+		return codeInvalidRequest
+	case http.StatusRequestEntityTooLarge:
+		return codeImageTooLarge
+	case http.StatusUnsupportedMediaType:
+		return codeUnsupportedFormat
+	case http.StatusUnprocessableEntity:
+		return codeImageRejected
+	case http.StatusNotImplemented:
+		return codeNotImplemented
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return codeOptimizerUnavailable
+	}
+	return codeInternal
+}
+
+// requestIDPattern : X-Request-Id accepté du client — court et sans caractère à échapper dans les logs.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// requestIDMiddleware pose X-Request-Id sur la réponse : celui du client, ou un identifiant aléatoire.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-Id")
+		if !requestIDPattern.MatchString(id) {
+			id = rand.Text()
+		}
+		w.Header().Set("X-Request-Id", id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// routeErrors sert r par mux, en remplaçant les 404 et 405 en texte brut du mux (route inconnue,
+// méthode non servie) par le corps JSON d'erreur.
+func routeErrors(mux *http.ServeMux, w http.ResponseWriter, r *http.Request) {
+	h, pattern := mux.Handler(r)
+	if pattern != "" {
+		mux.ServeHTTP(w, r)
+		return
+	}
+	rec := &statusOnly{ResponseWriter: w} // Allow (405) est posé directement sur w
+	h.ServeHTTP(rec, r)
+	switch rec.status {
+	case http.StatusMethodNotAllowed:
+		writeError(w, rec.status, codeMethodNotAllowed, "Méthode "+r.Method+" non servie sur "+r.URL.Path)
+	case http.StatusNotFound, 0:
+		writeError(w, http.StatusNotFound, codeNotFound, "Route inconnue : "+r.URL.Path)
+	default: // redirection (slash final) : telle quelle
+		w.WriteHeader(rec.status)
+	}
+}
+
+// statusOnly retient le statut d'une réponse et en jette le corps.
+type statusOnly struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusOnly) WriteHeader(status int)      { s.status = status }
+func (s *statusOnly) Write(p []byte) (int, error) { return len(p), nil }
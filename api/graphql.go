@@ -0,0 +1,466 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+// ── GraphQL ───────────────────────────────────────────────────────────────────
+// POST /graphql expose l'index des images, la consommation et l'upload dans un seul schéma : un
+// tableau de bord récupère en un aller-retour exactement les champs qu'il affiche. Les données et
+// les règles d'accès sont celles des routes REST (GET /images, GET /image/{hash}/meta, GET /usage,
+// /upload) — un tenant ne voit que ses images. Pas de jobs : l'API traite chaque image dans la
+// requête, il n'y a pas de file de traitement à interroger.
+//
+// L'upload suit la spécification « GraphQL multipart request » (champs operations, map, puis les
+// fichiers), comme les clients Apollo et urql : l'image arrive dans la variable de type Upload. Elle
+// peut aussi venir d'un upload tus terminé (uploadId). Le résultat est celui d'une image de
+// /upload/batch : statut, hash, data URL. Une opération à N mutations upload coûte comme un lot de
+// N images — N jetons de débit (plafonnés au burst), N requêtes au quota — et N ≤ maxBatchFiles.
+
+const maxGraphQLBody = 1 << 20 // requête JSON sans fichier
+
+const graphqlSchema = `
+	schema {
+		query: Query
+		mutation: Mutation
+	}
+
+	scalar Upload
+
+	type Query {
+		# Images indexées, les plus récentes d'abord — mêmes filtres que GET /images.
+		images(from: String, to: String, format: String, minBytes: Int, maxBytes: Int, limit: Int = 50, offset: Int = 0): ImagePage!
+		image(hash: String!): Image
+		# Consommation du client appelant pour month (AAAA-MM), le mois en cours par défaut.
+		usage(month: String): Usage!
+	}
+
+	type Mutation {
+		# Optimise une image envoyée (file) ou déjà reçue par tus (uploadId). fields : champs de
+		# /upload (wm_text, wm_preset, max_w…), sauf sizes.
+		upload(file: Upload, uploadId: String, fields: [FieldInput!]): UploadResult!
+	}
+
+	input FieldInput {
+		name: String!
+		value: String!
+	}
+
+	type Field {
+		name: String!
+		value: String!
+	}
+
+	type ImagePage {
+		total: Int!
+		offset: Int!
+		limit: Int!
+		items: [Image!]!
+	}
+
+	type Image {
+		hash: String!
+		name: String!
+		tenant: String!
+		format: String!
+		bytes: Int!
+		sourceBytes: Int!
+		sourceWidth: Int!
+		sourceHeight: Int!
+		width: Int!
+		height: Int!
+		quality: Int!
+		watermark: [Field!]!
+		readMs: Float!
+		optimizerMs: Float!
+		created: String!
+	}
+
+	# Compteurs en Float : les octets dépassent vite les 2^31 d'un Int GraphQL.
+	type Usage {
+		month: String!
+		client: String!
+		requests: Float!
+		bytesIn: Float!
+		bytesOut: Float!
+		optimizerSeconds: Float!
+		quotaRequests: Float!
+		quotaBytes: Float!
+	}
+
+	type UploadResult {
+		name: String!
+		hash: String!
+		status: Int!
+		url: String
+		error: String
+		code: String
+	}
+`
+
+// schema est analysé au démarrage : une erreur dans graphqlSchema ou un résolveur manquant fait
+// échouer le lancement plutôt que la première requête.
+var schema = graphql.MustParseSchema(graphqlSchema, &graphqlResolver{}, graphql.MaxDepth(6))
+
+type (
+	graphqlRequestKey struct{}
+	graphqlUploadsKey struct{} // *atomic.Int32 : mutations upload de l'opération
+)
+
+// handleGraphQL exécute une requête GraphQL, JSON ou multipart (upload).
+func handleGraphQL(w http.ResponseWriter, r *http.Request) {
+	var params struct {
+		Query         string         `json:"query"`
+		OperationName string         `json:"operationName"`
+		Variables     map[string]any `json:"variables"`
+	}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if !limitUpload(w, r) {
+			return
+		}
+		var fileMap map[string][]string // part du fichier → chemins des variables qui le reçoivent
+		if err := json.Unmarshal([]byte(r.FormValue("operations")), &params); err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "operations invalide")
+			return
+		}
+		if err := json.Unmarshal([]byte(r.FormValue("map")), &fileMap); err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "map invalide")
+			return
+		}
+		for part, paths := range fileMap {
+			fhs := r.MultipartForm.File[part]
+			if len(fhs) == 0 {
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, "Fichier manquant : "+part)
+				return
+			}
+			for _, p := range paths {
+				if !setVariable(params.Variables, p, fhs[0]) {
+					writeError(w, http.StatusBadRequest, codeInvalidRequest, "Chemin de map invalide : "+p)
+					return
+				}
+			}
+		}
+	} else {
+		r.Body = http.MaxBytesReader(w, r.Body, maxGraphQLBody)
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Requête GraphQL invalide")
+			return
+		}
+	}
+
+	ctx := context.WithValue(r.Context(), graphqlRequestKey{}, r)
+	ctx = context.WithValue(ctx, graphqlUploadsKey{}, new(atomic.Int32))
+	resp := schema.Exec(ctx, params.Query, params.OperationName, params.Variables)
+	if len(resp.Errors) > 0 {
+		logger.Warn().Str("step", "graphql").Str("operation", params.OperationName).Str("error", resp.Errors[0].Message).Msg("requête GraphQL en erreur")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp) //nolint:errcheck — erreur réseau côté client, pas récupérable
+}
+
+// setVariable place value au chemin path de la map des fichiers ("variables.file",
+// "variables.input.files.0"). Retourne false si le chemin ne désigne pas une variable existante.
+func setVariable(vars map[string]any, path string, value any) bool {
+	keys := strings.Split(path, ".")
+	if len(keys) < 2 || keys[0] != "variables" {
+		return false
+	}
+	var node any = vars
+	for i, k := range keys[1:] {
+		last := i == len(keys)-2
+		switch n := node.(type) {
+		case map[string]any:
+			if _, ok := n[k]; !ok {
+				return false
+			}
+			if last {
+				n[k] = value
+				return true
+			}
+			node = n[k]
+		case []any:
+			idx, err := strconv.Atoi(k)
+			if err != nil || idx < 0 || idx >= len(n) {
+				return false
+			}
+			if last {
+				n[idx] = value
+				return true
+			}
+			node = n[idx]
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// ── Résolveurs ────────────────────────────────────────────────────────────────
+
+type graphqlResolver struct{}
+
+func (graphqlResolver) Images(ctx context.Context, args struct {
+	From, To, Format   *string
+	MinBytes, MaxBytes *int32
+	Limit, Offset      int32
+}) (*imagePageResolver, error) {
+	from, ok1 := parseDate(deref(args.From), false)
+	to, ok2 := parseDate(deref(args.To), true)
+	if !ok1 || !ok2 {
+		return nil, errors.New("from / to invalide (RFC 3339 ou AAAA-MM-JJ)")
+	}
+	f := imageFilter{from: from, to: to, format: deref(args.Format)}
+	if args.MinBytes != nil {
+		f.minBytes = int(*args.MinBytes)
+	}
+	if args.MaxBytes != nil {
+		f.maxBytes = int(*args.MaxBytes)
+	}
+	if args.Limit <= 0 || args.Offset < 0 || f.minBytes < 0 || f.maxBytes < 0 {
+		return nil, errors.New("minBytes, maxBytes, limit et offset : entiers positifs attendus")
+	}
+	limit := min(int(args.Limit), maxPageSize)
+	items, total := listImages(claimsFrom(ctx), f, int(args.Offset), limit)
+	return &imagePageResolver{items: items, total: total, offset: int(args.Offset), limit: limit}, nil
+}
+
+func (graphqlResolver) Image(ctx context.Context, args struct{ Hash string }) *imageResolver {
+	if rec := lookupImage(claimsFrom(ctx), args.Hash); rec != nil {
+		return &imageResolver{rec}
+	}
+	return nil // null, comme le 404 de GET /image/{hash}/meta
+}
+
+func (graphqlResolver) Usage(ctx context.Context, args struct{ Month *string }) (*usageResolver, error) {
+	month := deref(args.Month)
+	if month == "" {
+		month = usageMonth(time.Now())
+	} else if _, err := time.Parse("2006-01", month); err != nil {
+		return nil, errors.New("month invalide (AAAA-MM)")
+	}
+	r := ctx.Value(graphqlRequestKey{}).(*http.Request)
+	client := clientKey(r)
+	usageMu.Lock()
+	c := usageOf(month, client)
+	usageMu.Unlock()
+	return &usageResolver{month: month, client: client, c: c}, nil
+}
+
+func (graphqlResolver) Upload(ctx context.Context, args struct {
+	File     *graphqlUpload
+	UploadID *string
+	Fields   *[]struct{ Name, Value string }
+}) (*uploadResultResolver, error) {
+	r := ctx.Value(graphqlRequestKey{}).(*http.Request)
+	if err := chargeUpload(ctx, r); err != nil {
+		return nil, err
+	}
+
+	// Champs de la mutation présentés à parseUploadOptions comme un formulaire /upload.
+	form := url.Values{}
+	if args.Fields != nil {
+		for _, f := range *args.Fields {
+			form.Set(f.Name, f.Value)
+		}
+	}
+	sub := r.Clone(ctx)
+	sub.Form, sub.PostForm = form, form
+	sub.MultipartForm = &multipart.Form{} // déjà parsé : pas de nouvelle lecture du body, pas de wm_logo (passer par un preset)
+	rec := &bufferedResponse{header: http.Header{}}
+	opts, ok := parseUploadOptions(rec, sub)
+	if !ok { // preset inconnu, fichier illisible : l'erreur HTTP devient l'erreur GraphQL, code en extension
+		return nil, readError(rec.body.String())
+	}
+
+	tRead := time.Now()
+	var name, declared string
+	var data []byte
+	switch {
+	case args.File != nil && args.UploadID != nil:
+		return nil, errors.New("file ou uploadId, pas les deux")
+	case args.File != nil:
+		f, err := args.File.fh.Open()
+		if err != nil {
+			return nil, errors.New("Erreur lecture")
+		}
+		data, err = io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return nil, errors.New("Erreur lecture")
+		}
+		name, declared = args.File.fh.Filename, args.File.fh.Header.Get("Content-Type")
+	case args.UploadID != nil:
+		var err error
+		if data, name, err = completedUpload(r, *args.UploadID); err != nil {
+			return nil, err
+		}
+		if int64(len(data)) > maxUploadSize { // Upload-Length accepté avant un changement de MAX_UPLOAD_SIZE
+			return nil, fmt.Errorf("Fichier trop volumineux (max %s)", formatBytes(int(maxUploadSize)))
+		}
+	default:
+		return nil, errors.New("Image manquante : file ou uploadId")
+	}
+	res := processImage(ctx, name, data, declared, opts, time.Since(tRead))
+	return &uploadResultResolver{res}, nil
+}
+
+// chargeUpload compte une mutation upload. La première est couverte par le jeton et la requête
+// déjà pris par les middlewares ; chacune des suivantes prend un jeton de plus (jusqu'au burst,
+// comme un lot) et une requête de plus au quota.
+func chargeUpload(ctx context.Context, r *http.Request) error {
+	n := ctx.Value(graphqlUploadsKey{}).(*atomic.Int32).Add(1)
+	if n == 1 {
+		return nil
+	}
+	if n > maxBatchFiles {
+		return &apiError{Code: codeInvalidRequest, Message: fmt.Sprintf("Trop d'uploads (max %d par opération)", maxBatchFiles)}
+	}
+	client := clientKey(r)
+	if rateRPS > 0 && float64(n) <= rateBurst {
+		if ok, retry := takeTokens(client, 1, time.Now()); !ok {
+			logger.Warn().Str("step", "rate_limit").Str("client", client).Str("path", r.URL.Path).Int32("upload", n).Dur("retry_after", retry).Msg("débit dépassé")
+			return &apiError{Code: codeRateLimited, Message: fmt.Sprintf("Trop de requêtes, réessayer dans %s", retry.Round(time.Second)), Retryable: true}
+		}
+	}
+	return chargeRequest(ctx, client)
+}
+
+// deref retourne *p, ou "" pour un argument absent.
+func deref(p *string) string {
+	if p == nil {
+		return ""
+	}
+	return *p
+}
+
+// graphqlUpload est le scalaire Upload : un fichier de la requête multipart, placé dans les
+// variables par handleGraphQL.
+type graphqlUpload struct {
+	fh *multipart.FileHeader
+}
+
+func (graphqlUpload) ImplementsGraphQLType(name string) bool { return name == "Upload" }
+
+func (u *graphqlUpload) UnmarshalGraphQL(input any) error {
+	fh, ok := input.(*multipart.FileHeader)
+	if !ok {
+		return errors.New("Upload attend un fichier de la requête multipart (champ map)")
+	}
+	u.fh = fh
+	return nil
+}
+
+type imagePageResolver struct {
+	items                []*imageRecord
+	total, offset, limit int
+}
+
+func (p *imagePageResolver) Total() int32  { return int32(p.total) }
+func (p *imagePageResolver) Offset() int32 { return int32(p.offset) }
+func (p *imagePageResolver) Limit() int32  { return int32(p.limit) }
+
+func (p *imagePageResolver) Items() []*imageResolver {
+	items := make([]*imageResolver, len(p.items))
+	for i, rec := range p.items {
+		items[i] = &imageResolver{rec}
+	}
+	return items
+}
+
+type imageResolver struct{ rec *imageRecord }
+
+func (i *imageResolver) Hash() string         { return i.rec.Hash }
+func (i *imageResolver) Name() string         { return i.rec.Name }
+func (i *imageResolver) Tenant() string       { return i.rec.Tenant }
+func (i *imageResolver) Format() string       { return i.rec.Format }
+func (i *imageResolver) Bytes() int32         { return int32(i.rec.Bytes) }
+func (i *imageResolver) SourceBytes() int32   { return int32(i.rec.SourceBytes) }
+func (i *imageResolver) SourceWidth() int32   { return int32(i.rec.SourceWidth) }
+func (i *imageResolver) SourceHeight() int32  { return int32(i.rec.SourceHeight) }
+func (i *imageResolver) Width() int32         { return int32(i.rec.Width) }
+func (i *imageResolver) Height() int32        { return int32(i.rec.Height) }
+func (i *imageResolver) Quality() int32       { return int32(i.rec.Quality) }
+func (i *imageResolver) ReadMs() float64      { return i.rec.ReadMs }
+func (i *imageResolver) OptimizerMs() float64 { return i.rec.OptimizerMs }
+func (i *imageResolver) Created() string      { return i.rec.Created.Format(time.RFC3339Nano) }
+
+func (i *imageResolver) Watermark() []*fieldResolver {
+	fields := make([]*fieldResolver, 0, len(i.rec.Watermark))
+	for _, k := range slices.Sorted(maps.Keys(i.rec.Watermark)) {
+		fields = append(fields, &fieldResolver{k, i.rec.Watermark[k]})
+	}
+	return fields
+}
+
+type fieldResolver struct{ name, value string }
+
+func (f *fieldResolver) Name() string  { return f.name }
+func (f *fieldResolver) Value() string { return f.value }
+
+type usageResolver struct {
+	month, client string
+	c             usageCounters
+}
+
+func (u *usageResolver) Month() string             { return u.month }
+func (u *usageResolver) Client() string            { return u.client }
+func (u *usageResolver) Requests() float64         { return float64(u.c.Requests) }
+func (u *usageResolver) BytesIn() float64          { return float64(u.c.BytesIn) }
+func (u *usageResolver) BytesOut() float64         { return float64(u.c.BytesOut) }
+func (u *usageResolver) OptimizerSeconds() float64 { return u.c.OptimizerSeconds }
+func (u *usageResolver) QuotaRequests() float64    { return float64(quotaRequests) } // 0 : illimité
+func (u *usageResolver) QuotaBytes() float64       { return float64(quotaBytes) }
+
+type uploadResultResolver struct{ res batchResult }
+
+func (u *uploadResultResolver) Name() string  { return u.res.Name }
+func (u *uploadResultResolver) Hash() string  { return u.res.Hash }
+func (u *uploadResultResolver) Status() int32 { return int32(u.res.Status) }
+
+func (u *uploadResultResolver) URL() *string {
+	if u.res.URL == "" {
+		return nil
+	}
+	return &u.res.URL
+}
+
+func (u *uploadResultResolver) Code() *string {
+	if u.res.Code == "" {
+		return nil
+	}
+	c := string(u.res.Code)
+	return &c
+}
+
+func (u *uploadResultResolver) Error() *string {
+	if u.res.Error == "" {
+		return nil
+	}
+	return &u.res.Error
+}
+
+// bufferedResponse garde en mémoire une réponse écrite par un handler HTTP appelé hors requête.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   strings.Builder
+}
+
+func (b *bufferedResponse) Header() http.Header         { return b.header }
+func (b *bufferedResponse) Write(p []byte) (int, error) { return b.body.Write(p) }
+func (b *bufferedResponse) WriteHeader(status int)      { b.status = status }
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	"api/optimizerpb"
+)
+
+// ── Optimizer en gRPC ─────────────────────────────────────────────────────────
+// Avec OPTIMIZER_GRPC_ADDR (ex: "dns:///optimizer:3002"), /upload et /upload/batch envoient les
+// images à l'optimizer en gRPC (optimizerpb, généré depuis optimizer/optimizerpb/optimizer.proto)
+// plutôt qu'en multipart HTTP : image en morceaux sur une connexion HTTP/2 partagée, échéance
+// transmise à l'optimizer. Les autres routes relayées (sprite, PDF, vidéo…) restent sur OPTIMIZER_URL.
+//
+// Les appels sont répartis en round_robin entre les adresses de la cible : avec "dns:///" et un
+// nom qui résout vers plusieurs répliques, chaque image va à la suivante.
+
+const grpcChunkSize = 64 << 10 // taille des morceaux envoyés
+
+var (
+	optimizerGRPC optimizerpb.OptimizerClient // nil : multipart HTTP
+	optimizerConn *grpc.ClientConn            // état de la connexion, pour /readyz
+)
+
+// initOptimizerGRPC prépare le client gRPC si OPTIMIZER_GRPC_ADDR est défini — appelé au démarrage.
+// La connexion est établie au premier appel.
+func initOptimizerGRPC() error {
+	addr := os.Getenv("OPTIMIZER_GRPC_ADDR")
+	if addr == "" {
+		return nil
+	}
+	conn, err := grpc.NewClient(addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()), // réseau interne, comme OPTIMIZER_URL en http://
+		grpc.WithDefaultServiceConfig(`{"loadBalancingConfig": [{"round_robin": {}}]}`),
+		grpc.WithDefaultCallOptions(grpc.WaitForReady(true)), // optimizer qui redémarre : attendre la reconnexion dans l'échéance plutôt qu'échouer d'emblée
+	)
+	if err != nil {
+		return err
+	}
+	optimizerConn, optimizerGRPC = conn, optimizerpb.NewOptimizerClient(conn)
+	logger.Info().Str("component", "init").Str("optimizer_grpc_addr", addr).Msg("optimizer en gRPC")
+	return nil
+}
+
+// optimizeGRPC est sendToOptimizer en gRPC : mêmes champs, mêmes headers en retour, et une image
+// refusée donne la même optimizerError qu'en HTTP (code lu dans le trailer "http-status").
+func optimizeGRPC(ctx context.Context, filename string, data []byte, opts *uploadOptions) ([]byte, http.Header, error) {
+	ctx, cancel := context.WithTimeout(ctx, httpClient.Timeout) // même échéance qu'en HTTP, connue de l'optimizer
+	defer cancel()
+	stream, err := optimizerGRPC.Optimize(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fields := map[string]string{"wm_text": opts.text, "wm_position": opts.position, "wm_format": opts.format}
+	maps.Copy(fields, opts.extra)
+	head := &optimizerpb.ImageChunk{Filename: filename, Fields: fields}
+	for _, k := range slices.Sorted(maps.Keys(opts.files)) {
+		head.Files = append(head.Files, &optimizerpb.FormFile{Field: k, Filename: opts.files[k].name, Data: opts.files[k].data})
+	}
+	for msg, rest := head, data; ; msg = new(optimizerpb.ImageChunk) {
+		n := min(len(rest), grpcChunkSize)
+		msg.Data, rest = rest[:n], rest[n:]
+		if err := stream.Send(msg); err != nil || len(rest) == 0 {
+			break // io.EOF : l'optimizer a déjà répondu (refus) — l'erreur vient avec Recv
+		}
+	}
+	stream.CloseSend() //nolint:errcheck — une erreur d'envoi ressort au Recv
+
+	header := http.Header{}
+	var body []byte
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return body, header, nil
+		}
+		if err != nil {
+			if hs := stream.Trailer().Get("http-status"); len(hs) > 0 {
+				if code, convErr := strconv.Atoi(hs[0]); convErr == nil {
+					return nil, nil, &optimizerError{status: code, msg: status.Convert(err).Message()}
+				}
+			}
+			return nil, nil, err // optimizer injoignable, échéance dépassée… : 502 comme en HTTP
+		}
+		for k, v := range chunk.GetFields() { // premier message seulement
+			header.Set(k, v)
+		}
+		body = append(body, chunk.GetData()...)
+	}
+}
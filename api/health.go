@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc/connectivity"
+)
+
+// ── Sondes ────────────────────────────────────────────────────────────────────
+// GET /livez et /healthz : le processus répond — rien d'autre n'est vérifié, un redémarrage ne
+// réparerait pas une dépendance absente. GET /readyz : l'API peut traiter des images —
+// optimizer joignable (et prêt), UPLOAD_DIR inscriptible, clés JWKS chargées si l'authentification
+// est activée. 503 sinon, avec le détail par vérification : un orchestrateur retire la réplique du
+// trafic sans la tuer. Redis, MinIO et RabbitMQ ne sont pas utilisés par l'API : pas vérifiés.
+//
+// Les sondes sont servies hors /v1, sans authentification, limitation ni comptage. Dans l'image
+// (FROM scratch, ni curl ni wget), "service healthcheck" interroge /readyz et sort en 0 ou 1.
+
+const probeTimeout = 2 * time.Second
+
+var probeClient = &http.Client{Timeout: probeTimeout}
+
+// withProbes sert les sondes et /metrics, et passe le reste à next.
+func withProbes(next http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", promhttp.Handler()) // cf. metrics.go
+	mux.HandleFunc("GET /livez", handleLive)
+	mux.HandleFunc("GET /healthz", handleLive) // nom historique, même réponse que /livez
+	mux.HandleFunc("GET /readyz", handleReady)
+	mux.Handle("/", next)
+	return mux
+}
+
+func handleLive(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
+
+// handleReady lance les vérifications et retourne {"status": "ok"|"degraded", "checks": {…}}.
+func handleReady(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), probeTimeout)
+	defer cancel()
+	checks := map[string]string{}
+	status, code := "ok", http.StatusOK
+	check := func(name string, err error) {
+		if err != nil {
+			checks[name] = err.Error()
+			status, code = "degraded", http.StatusServiceUnavailable
+			return
+		}
+		checks[name] = "ok"
+	}
+	check("optimizer", checkOptimizer(ctx))
+	if optimizerConn != nil {
+		check("optimizer_grpc", checkOptimizerGRPC())
+	}
+	check("upload_dir", checkUploadDir())
+	if jwksURL != "" {
+		check("jwks", checkJWKS())
+	}
+	if code != http.StatusOK {
+		logger.Warn().Str("step", "readyz").Interface("checks", checks).Msg("API pas prête")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]any{"status": status, "checks": checks}) //nolint:errcheck — erreur réseau côté client, pas récupérable
+}
+
+// checkOptimizer interroge le /readyz de l'optimizer.
+func checkOptimizer(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, optimizerAddr()+"/readyz", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := probeClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("readyz : %s", resp.Status)
+	}
+	return nil
+}
+
+// checkOptimizerGRPC vérifie que la connexion gRPC n'est pas en échec — Idle (pas encore d'appel)
+// compte comme prête : la connexion s'établit au premier appel, et le /readyz HTTP a déjà vu l'optimizer.
+func checkOptimizerGRPC() error {
+	if s := optimizerConn.GetState(); s == connectivity.TransientFailure || s == connectivity.Shutdown {
+		optimizerConn.Connect() // relance la tentative sans attendre la fin du backoff
+		return fmt.Errorf("connexion %s", s)
+	}
+	return nil
+}
+
+// checkUploadDir vérifie qu'UPLOAD_DIR est inscriptible (uploads tus).
+func checkUploadDir() error {
+	f, err := os.CreateTemp(uploadDir, ".readyz-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// checkJWKS vérifie que des clés de signature sont chargées — sans elles, tout serait refusé en 401.
+func checkJWKS() error {
+	jwksMu.Lock()
+	loaded := jwksKeys != nil
+	var wait chan struct{}
+	if !loaded {
+		wait = startRefresh() // même cadence que jwksKey
+	}
+	jwksMu.Unlock()
+	if wait != nil {
+		<-wait
+		jwksMu.Lock()
+		loaded = jwksKeys != nil
+		jwksMu.Unlock()
+	}
+	if !loaded {
+		return errors.New("JWKS non chargé")
+	}
+	return nil
+}
+
+// runHealthcheck interroge /readyz sur addr et retourne le code de sortie — sonde Docker sans curl.
+func runHealthcheck(addr string) int {
+	resp, err := probeClient.Get("http://127.0.0.1" + addr + "/readyz")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintln(os.Stderr, resp.Status)
+		return 1
+	}
+	return 0
+}
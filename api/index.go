@@ -0,0 +1,237 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ── Index des images traitées ─────────────────────────────────────────────────
+// Chaque image optimisée par /upload ou /upload/batch est inscrite dans un petit index en mémoire,
+// consultable par GET /images et, image par image, GET /image/{hash}/meta : les exploitants voient
+// ce qui passe dans le service — dimensions, qualité, watermark, durées — sans fouiller les logs.
+// L'index ne garde que des métadonnées — ni l'original ni le résultat — et se limite aux
+// maxIndexEntries dernières images ; il est perdu au redémarrage, comme les presets sans
+// PRESETS_FILE. Une même image (même SHA-256) renvoyée remplace son entrée.
+
+const (
+	maxIndexEntries = 10000 // ~2 Mo de métadonnées au plus
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// imageRecord : métadonnées d'une image traitée. Les dimensions et la qualité viennent des
+// headers X-Image-* de l'optimizer — absentes pour les variantes et les animations.
+type imageRecord struct {
+	Hash         string            `json:"hash"` // SHA-256 hexadécimal de l'original
+	Name         string            `json:"name"`
+	Tenant       string            `json:"tenant,omitempty"` // claim tenant du JWT de l'envoi
+	Format       string            `json:"format"`           // format de sortie : jpeg, webp, png, gif
+	Bytes        int               `json:"bytes"`            // taille du résultat
+	SourceBytes  int               `json:"source_bytes"`
+	SourceWidth  int               `json:"source_width,omitempty"`
+	SourceHeight int               `json:"source_height,omitempty"`
+	Width        int               `json:"width,omitempty"`
+	Height       int               `json:"height,omitempty"`
+	Quality      int               `json:"quality,omitempty"`
+	Watermark    map[string]string `json:"watermark"` // champs wm_* envoyés à l'optimizer, preset résolu
+	ReadMs       float64           `json:"read_ms"`
+	OptimizerMs  float64           `json:"optimizer_ms"`
+	Created      time.Time         `json:"created"`
+}
+
+var (
+	indexMu    sync.RWMutex
+	imageIndex []*imageRecord              // ordre d'inscription, le plus ancien en tête
+	byHash     = map[string]*imageRecord{} // mêmes entrées qu'imageIndex, par hash
+)
+
+// imageHash retourne l'identifiant d'un original : son SHA-256 en hexadécimal.
+func imageHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// recordImage inscrit rec dans l'index, à la place d'une éventuelle entrée de même hash.
+func recordImage(rec *imageRecord) {
+	indexMu.Lock()
+	defer indexMu.Unlock()
+	if old := byHash[rec.Hash]; old != nil {
+		imageIndex = slices.DeleteFunc(imageIndex, func(r *imageRecord) bool { return r == old })
+	}
+	if len(imageIndex) >= maxIndexEntries {
+		delete(byHash, imageIndex[0].Hash)
+		imageIndex = slices.Delete(imageIndex, 0, 1)
+	}
+	imageIndex = append(imageIndex, rec)
+	byHash[rec.Hash] = rec
+}
+
+// newImageRecord construit l'entrée d'index d'une image que l'optimizer a traitée.
+func newImageRecord(name string, data, result []byte, meta http.Header, opts *uploadOptions, readDur, optimizerDur time.Duration) *imageRecord {
+	header := func(k string) int { // 0 si absent
+		n, _ := strconv.Atoi(meta.Get(k))
+		return n
+	}
+	wm := map[string]string{"wm_text": opts.text, "wm_position": opts.position}
+	for k, v := range opts.extra {
+		if strings.HasPrefix(k, wmFilePrefix) {
+			wm[k] = v
+		}
+	}
+	for k, f := range opts.files { // logo, police : le nom du fichier, pas son contenu
+		wm[k] = f.name
+	}
+	return &imageRecord{
+		Hash:         imageHash(data),
+		Name:         name,
+		Tenant:       opts.tenant,
+		Format:       outputFormat(result, meta.Get("Content-Type"), opts.format),
+		Bytes:        len(result),
+		SourceBytes:  len(data),
+		SourceWidth:  header("X-Image-Source-Width"),
+		SourceHeight: header("X-Image-Source-Height"),
+		Width:        header("X-Image-Width"),
+		Height:       header("X-Image-Height"),
+		Quality:      header("X-Image-Quality"),
+		Watermark:    wm,
+		ReadMs:       durationMs(readDur),
+		OptimizerMs:  durationMs(optimizerDur),
+		Created:      time.Now().UTC(),
+	}
+}
+
+// durationMs convertit une durée en millisecondes, au µs près — même précision que fmtMs.
+func durationMs(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// outputFormat retourne le format d'un résultat de l'optimizer pour l'index — pour des variantes
+// (réponse multipart), le format demandé.
+func outputFormat(result []byte, ct, requested string) string {
+	if strings.HasPrefix(ct, "multipart/") {
+		return requested
+	}
+	return strings.TrimPrefix(detectContentType(result), "image/")
+}
+
+// handleListImages liste les images indexées, les plus récentes d'abord.
+// Filtres : from / to (RFC 3339 ou AAAA-MM-JJ, to inclus), format, min_bytes / max_bytes.
+// Pagination : limit (50 par défaut, 500 max) et offset ; total compte les entrées filtrées.
+func handleListImages(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	from, ok1 := parseDate(q.Get("from"), false)
+	to, ok2 := parseDate(q.Get("to"), true)
+	minBytes, ok3 := queryInt(q.Get("min_bytes"), 0)
+	maxBytes, ok4 := queryInt(q.Get("max_bytes"), 0)
+	limit, ok5 := queryInt(q.Get("limit"), defaultPageSize)
+	offset, ok6 := queryInt(q.Get("offset"), 0)
+	if !ok1 || !ok2 {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "from / to invalide (RFC 3339 ou AAAA-MM-JJ)")
+		return
+	}
+	if !ok3 || !ok4 || !ok5 || !ok6 || limit == 0 {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "min_bytes, max_bytes, limit et offset : entiers positifs attendus")
+		return
+	}
+	limit = min(limit, maxPageSize)
+	f := imageFilter{from: from, to: to, format: q.Get("format"), minBytes: minBytes, maxBytes: maxBytes}
+	items, total := listImages(claimsFrom(r.Context()), f, offset, limit)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"total": total, "offset": offset, "limit": limit, "items": items}) //nolint:errcheck — erreur réseau côté client, pas récupérable
+}
+
+// imageFilter : critères de GET /images et de la requête GraphQL images. Valeurs zéro : pas de filtre.
+type imageFilter struct {
+	from, to           time.Time
+	format             string
+	minBytes, maxBytes int
+}
+
+// listImages retourne la page [offset, offset+limit) des images visibles par claims qui passent f,
+// les plus récentes d'abord, et le nombre total d'images filtrées.
+func listImages(claims *authClaims, f imageFilter, offset, limit int) ([]*imageRecord, int) {
+	format := strings.ToLower(f.format)
+	if format == "jpg" {
+		format = "jpeg"
+	}
+	items := []*imageRecord{} // [] et non null dans le JSON quand rien ne correspond
+	total := 0
+	indexMu.RLock()
+	defer indexMu.RUnlock()
+	for _, rec := range slices.Backward(imageIndex) {
+		if !canSee(claims, rec) || (format != "" && rec.Format != format) ||
+			(!f.from.IsZero() && rec.Created.Before(f.from)) || (!f.to.IsZero() && rec.Created.After(f.to)) ||
+			rec.Bytes < f.minBytes || (f.maxBytes > 0 && rec.Bytes > f.maxBytes) {
+			continue
+		}
+		if total >= offset && len(items) < limit {
+			items = append(items, rec)
+		}
+		total++
+	}
+	return items, total
+}
+
+// lookupImage retourne l'entrée d'index de hash si claims peut la voir, nil sinon.
+func lookupImage(claims *authClaims, hash string) *imageRecord {
+	indexMu.RLock()
+	rec := byHash[strings.ToLower(hash)]
+	indexMu.RUnlock()
+	if rec == nil || !canSee(claims, rec) {
+		return nil
+	}
+	return rec
+}
+
+// handleImageMeta retourne l'entrée d'index d'une image (404 si inconnue ou sortie de l'index).
+func handleImageMeta(w http.ResponseWriter, r *http.Request) {
+	rec := lookupImage(claimsFrom(r.Context()), r.PathValue("hash"))
+	if rec == nil { // 404 et non 403 : ne pas révéler l'image d'un autre tenant
+		writeError(w, http.StatusNotFound, codeNotFound, "Image inconnue")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rec) //nolint:errcheck — erreur réseau côté client, pas récupérable
+}
+
+// canSee indique si le porteur de claims peut consulter rec : tout est visible sans authentification
+// ou avec le scope admin, sinon seules les images de son tenant.
+func canSee(claims *authClaims, rec *imageRecord) bool {
+	return claims == nil || claims.hasScope(adminScope) || rec.Tenant == claims.tenant
+}
+
+// parseDate lit une borne de date, incluse. Une date seule (AAAA-MM-JJ) vaut minuit UTC, ou la fin
+// de la journée si end : to=2026-03-01 inclut tout le 1er mars. Vide : zéro, sans borne.
+func parseDate(v string, end bool) (time.Time, bool) {
+	if v == "" {
+		return time.Time{}, true
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, true
+	}
+	t, err := time.Parse(time.DateOnly, v)
+	if err != nil {
+		return time.Time{}, false
+	}
+	if end {
+		t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+	return t, true
+}
+
+// queryInt lit un paramètre entier positif — def s'il est absent.
+func queryInt(v string, def int) (int, bool) {
+	if v == "" {
+		return def, true
+	}
+	n, err := strconv.Atoi(v)
+	return n, err == nil && n >= 0
+}
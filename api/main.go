@@ -3,11 +3,16 @@ package main
 import (
 	"bytes"
 	"compress/gzip" // compression gzip à la volée pour réduire la bande passante
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"mime/multipart" // construction du formulaire multipart envoyé à l'optimizer
 	"net/http"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -15,22 +20,100 @@ import (
 )
 
 // Ce microservice reçoit une image, la forward à l'optimizer, puis renvoie le résultat au client.
-var httpClient = &http.Client{Timeout: 30 * time.Second} // timeout global pour éviter de bloquer indéfiniment sur l'optimizer
+var httpClient = &http.Client{Timeout: 30 * time.Second}  // timeout global pour éviter de bloquer indéfiniment sur l'optimizer
+var videoClient = &http.Client{Timeout: 15 * time.Minute} // ré-encodage ffmpeg : au-delà du VIDEO_TIMEOUT_SECONDS de l'optimizer
 
 var logger zerolog.Logger
 
+// wmPassthrough liste les champs watermark optionnels relayés tels quels à l'optimizer, qui les valide.
+var wmPassthrough = []string{"wm_logo_scale", "wm_size", "wm_font", "wm_opacity", "wm_outline", "wm_shadow", "wm_x", "wm_y", "wm_color", "wm_angle", "wm_plate", "wm_plate_padding", "wm_qr", "wm_qr_size", "wm_blend", "wm_layers"}
+
+// outputPassthrough liste les champs de dimensions de sortie relayés à l'optimizer, qui les valide.
+var outputPassthrough = []string{"max_w", "max_h", "w", "h", "upscale", "interp", "sizes", "crop", "crop_gravity", "rotate", "flip", "filters", "blur_regions", "auto_faces", "sharpen", "keep_icc", "keep_exif", "set_copyright", "bg_color", "fit", "progressive", "quality", "max_bytes", "subsampling"}
+
+// wmFilePrefix : tout fichier dont le champ commence par ce préfixe est relayé à l'optimizer —
+// wm_logo, wm_font_file, et les fichiers référencés par les calques de wm_layers (wm_logo_2…).
+const wmFilePrefix = "wm_"
+
+// maxUploadSize borne le body de /upload (MAX_UPLOAD_SIZE, octets ou suffixe K/M/G) — l'optimizer
+// applique la même variable : un fichier accepté ici n'est pas refusé là-bas pour sa taille.
+var maxUploadSize int64 = 50 << 20
+
+// cacheControl est le Cache-Control des images renvoyées par /upload (CACHE_CONTROL), ex:
+// "public, max-age=31536000, immutable" derrière un CDN qui met en cache par contenu. Vide : pas de
+// header, comme avant — le client ne met rien en cache. Un max-age ajoute le Expires équivalent
+// pour les caches HTTP/1.0.
+var cacheControl string
+
 // ── Main ─────────────────────────────────────────────────────────────────────
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "healthcheck" { // sonde Docker : l'image n'a ni curl ni wget
+		os.Exit(runHealthcheck(":4000"))
+	}
 	zerolog.TimeFieldFormat = time.RFC3339                                             // RFC3339 est plus lisible que l'epoch dans les logs structurés
 	logger = zerolog.New(os.Stdout).With().Timestamp().Str("service", "api").Logger() // champ "service" identifie ce service dans une stack multi-conteneurs
 
 	logger.Info().Str("addr", ":4000").Msg("démarrage")
 
+	if err := loadPresets(); err != nil {
+		logger.Fatal().Err(err).Msg("chargement des presets impossible") // mieux vaut refuser de démarrer que perdre les presets au prochain save
+	}
+	if v := os.Getenv("MAX_UPLOAD_SIZE"); v != "" {
+		n, err := parseByteSize(v)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("MAX_UPLOAD_SIZE invalide")
+		}
+		maxUploadSize = n
+	}
+	logger.Info().Str("component", "init").Str("max_upload_size", formatBytes(int(maxUploadSize))).Msg("taille d'upload maximale")
+	cacheControl = os.Getenv("CACHE_CONTROL")
+	if cacheControl != "" {
+		logger.Info().Str("component", "init").Str("cache_control", cacheControl).Msg("cache des réponses")
+	}
+	if err := initCORS(); err != nil {
+		logger.Fatal().Err(err).Msg("configuration CORS invalide")
+	}
+	initAuth() // JWT obligatoire si JWKS_URL est défini
+	initRateLimit()
+	initAdmin()
+	if err := initUsage(); err != nil {
+		logger.Fatal().Err(err).Msg("chargement de la consommation impossible") // repartir de zéro offrirait un mois gratuit
+	}
+	if err := initTus(); err != nil {
+		logger.Fatal().Err(err).Msg("UPLOAD_DIR inutilisable")
+	}
+	if err := initOptimizerGRPC(); err != nil {
+		logger.Fatal().Err(err).Msg("OPTIMIZER_GRPC_ADDR invalide")
+	}
+
 	mux := http.NewServeMux()
-	mux.HandleFunc("POST /upload", handleUpload) // point d'entrée principal : upload + watermark
+	mux.HandleFunc("POST /upload", handleUpload)            // point d'entrée principal : upload + watermark
+	mux.HandleFunc("POST /upload/batch", handleBatchUpload) // plusieurs images, résultats en JSON
+	// Planche de miniatures et aperçu rapide pour les galeries — relayés tels quels à l'optimizer.
+	mux.HandleFunc("POST /sprite", relay("/sprite", "sprite", "planche relayée"))
+	mux.HandleFunc("POST /thumbnail", relay("/thumbnail", "thumbnail", "miniature relayée"))
+	mux.HandleFunc("POST /optimize-pdf", relay("/optimize-pdf", "pdf", "PDF relayé"))
+	mux.HandleFunc("POST /optimize-video", relayWith(videoClient, "/optimize-video", "video", "vidéo relayée"))
+	mux.HandleFunc("GET /images", handleListImages) // index en mémoire des dernières images traitées
+	mux.HandleFunc("GET /image/{hash}/meta", handleImageMeta)
+	mux.HandleFunc("GET /usage", handleUsage)      // consommation du mois et quotas
+	mux.HandleFunc("POST /graphql", handleGraphQL) // images, consommation et upload en une requête
+	// Uploads reprenables (tus) — l'original complet passe ensuite par /upload avec upload_id.
+	mux.HandleFunc("OPTIONS /uploads", handleTusOptions)
+	mux.HandleFunc("POST /uploads", handleTusCreate)
+	mux.HandleFunc("HEAD /uploads/{id}", handleTusHead)
+	mux.HandleFunc("PATCH /uploads/{id}", handleTusPatch)
+	mux.HandleFunc("DELETE /uploads/{id}", handleTusDelete)
+	mux.HandleFunc("POST /presets", handleCreatePreset)
+	mux.HandleFunc("GET /presets", handleListPresets)
+	mux.HandleFunc("GET /admin/stats", requireAdmin(handleAdminStats))  // état des magasins (index, uploads, consommation)
+	mux.HandleFunc("POST /admin/purge", requireAdmin(handleAdminPurge)) // ?older_than=7d&target=images|uploads|all
+	mux.HandleFunc("GET /openapi.json", handleOpenAPI) // description OpenAPI 3.1 des routes ci-dessus
 
-	http.ListenAndServe(":4000", corsMiddleware(mux)) //nolint:errcheck — erreur fatale, le conteneur redémarre
+	// /v1/… et chemins sans version (cf. versions.go)
+	handler := versionedMux(map[string]*http.ServeMux{"1": mux})
+	http.ListenAndServe(":4000", withProbes(requestIDMiddleware(metricsMiddleware(mux, corsMiddleware(authMiddleware(rateLimitMiddleware(usageMiddleware(handler)))))))) //nolint:errcheck — erreur fatale, le conteneur redémarre
 }
 
 // ── Handler ───────────────────────────────────────────────────────────────────
@@ -39,64 +122,225 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 	start := time.Now() // point de référence pour mesurer la durée totale du pipeline
 
 	// ── ① Lecture ────────────────────────────────────────
-	file, header, err := r.FormFile("image") // lit le fichier depuis le formulaire multipart
-	if err != nil {
-		http.Error(w, "Image manquante", http.StatusBadRequest)
+	if !limitUpload(w, r) { // refusé avant de lire le body : pas de 2 Go en mémoire
 		return
 	}
-	defer file.Close() // libérer la mémoire multipart dès que le handler retourne
-
+	var data []byte
+	var filename, declared string // declared : Content-Type de la part multipart, vide pour un upload tus
 	tRead := time.Now()
-	data, err := io.ReadAll(file) // charger l'image en mémoire — nécessaire pour envoyer à l'optimizer
-	if err != nil {
-		http.Error(w, "Erreur lecture", http.StatusInternalServerError)
-		return
+	if id := r.FormValue("upload_id"); id != "" { // original déjà envoyé par morceaux (tus, cf. tus.go)
+		var err error
+		if data, filename, err = completedUpload(r, id); err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+			return
+		}
+		if int64(len(data)) > maxUploadSize { // Upload-Length accepté avant un changement de MAX_UPLOAD_SIZE
+			writeError(w, http.StatusRequestEntityTooLarge, codeImageTooLarge, fmt.Sprintf("Fichier trop volumineux (max %s)", formatBytes(int(maxUploadSize))))
+			return
+		}
+	} else {
+		file, header, err := r.FormFile("image") // lit le fichier depuis le formulaire multipart
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeImageMissing, "Image manquante")
+			return
+		}
+		defer file.Close() // libérer la mémoire multipart dès que le handler retourne
+
+		data, err = io.ReadAll(file) // charger l'image en mémoire — nécessaire pour envoyer à l'optimizer
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternal, "Erreur lecture")
+			return
+		}
+		filename = header.Filename
+		declared = header.Header.Get("Content-Type")
 	}
 	readDur := time.Since(tRead)
-	logger.Info().Str("step", "read").Str("filename", header.Filename).Str("size", formatBytes(len(data))).Dur("duration", readDur).Msg("lecture image")
+	observeStage("read", readDur)
+	logger.Info().Str("step", "read").Str("filename", filename).Str("size", formatBytes(len(data))).Dur("duration", readDur).Msg("lecture image")
+	if err := checkImageType(data, declared); err != nil { // avant tout traitement : un fichier quelconque ne va pas jusqu'à l'optimizer
+		logger.Warn().Str("step", "read").Str("filename", filename).Str("content_type", declared).Err(err).Msg("fichier refusé")
+		writeError(w, http.StatusUnsupportedMediaType, codeUnsupportedFormat, err.Error())
+		return
+	}
 
 	// ── ② Paramètres watermark + format de sortie ────────
-	wmText := r.FormValue("wm_text")
-	if wmText == "" {
-		wmText = "NWS © 2026" // fallback si le champ est absent (appel direct à l'API)
-	}
-	wmPosition := r.FormValue("wm_position")
-	if wmPosition == "" {
-		wmPosition = "bottom-right" // position la moins intrusive par défaut
+	opts, ok := parseUploadOptions(w, r)
+	if !ok {
+		return
 	}
-	// Négociation de format : WebP si le navigateur le supporte (~30% plus léger), JPEG sinon.
-	wmFormat := bestFormat(r)
-	logger.Info().Str("step", "format").Str("accept", r.Header.Get("Accept")).Str("chosen", wmFormat).Msg("négociation format")
 
 	// ── ③ Forward vers l'optimizer ───────────────────────
-	optimizerURL := os.Getenv("OPTIMIZER_URL")
-	if optimizerURL == "" {
-		optimizerURL = "http://localhost:3001" // défaut dev local
-	}
-
 	tOptimizer := time.Now()
-	result, err := sendToOptimizer(optimizerURL, header.Filename, data, wmText, wmPosition, wmFormat)
+	result, meta, err := sendToOptimizer(r.Context(), optimizerAddr(), filename, data, opts)
+	if oe := (*optimizerError)(nil); errors.As(err, &oe) && (oe.status < 500 || oe.status == http.StatusNotImplemented) {
+		// image refusée par l'optimizer (format, dimensions, politique GPS) ou option non disponible
+		// (501) — erreur client, relayée telle quelle
+		logger.Warn().Str("step", "optimizer").Int("status", oe.status).Str("reason", oe.msg).Msg("image refusée")
+		writeError(w, oe.status, optimizerCode(oe.status), oe.msg)
+		return
+	}
 	if err != nil {
 		logger.Error().Str("step", "optimizer").Err(err).Msg("optimizer KO")
-		http.Error(w, "Microservice indisponible", http.StatusBadGateway)
+		writeError(w, http.StatusBadGateway, codeOptimizerUnavailable, "Microservice indisponible")
 		return
 	}
 	optimizerDur := time.Since(tOptimizer)
-	logger.Info().Str("step", "optimizer").Str("format", wmFormat).Str("size", formatBytes(len(result))).Dur("duration", optimizerDur).Msg("image optimisée")
+	meterOptimizer(r.Context(), optimizerDur)
+	recordImage(newImageRecord(filename, data, result, meta, opts, readDur, optimizerDur))
+	logger.Info().Str("step", "optimizer").Str("format", opts.format).Str("experiment", meta.Get("X-Image-Experiment")).Str("size", formatBytes(len(result))).Dur("duration", optimizerDur).Msg("image optimisée")
 
 	// ── ④ Réponse ─────────────────────────────────────────
 	gzipped := strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") // loggé pour debug — la compression est gérée dans sendResponse
-	logger.Info().Str("step", "response").Bool("gzip", gzipped).Str("format", wmFormat).Str("size", formatBytes(len(result))).Msg("envoi réponse")
+	logger.Info().Str("step", "response").Bool("gzip", gzipped).Str("format", opts.format).Str("size", formatBytes(len(result))).Msg("envoi réponse")
 	logger.Info().Str("step", "total").Dur("duration", time.Since(start)).Msg("requête terminée")
 
 	w.Header().Set("X-T-Read", fmtMs(readDur))
 	w.Header().Set("X-T-Optimizer", fmtMs(optimizerDur))
-	w.Header().Set("Vary", "Accept") // indique au CDN que la réponse varie selon le header Accept
-	sendResponse(w, r, result)
+	w.Header().Add("Vary", "Accept") // indique au CDN que la réponse varie selon le header Accept — Add : garde le Vary: Origin du CORS
+	copyImageHeaders(w.Header(), meta)
+	setCacheHeaders(w.Header(), time.Now())
+	ct := detectContentType(result)
+	if mt := meta.Get("Content-Type"); strings.HasPrefix(mt, "multipart/") { // variantes (sizes) : le boundary est dans le Content-Type
+		ct = mt
+	}
+	sendResponse(w, r, ct, result)
+}
+
+// uploadOptions : réglages watermark et de sortie d'un envoi, communs à toutes ses images.
+type uploadOptions struct {
+	text, position, format string
+	tenant                 string               // claim tenant du JWT — vide sans authentification
+	extra                  map[string]string    // wmPassthrough et outputPassthrough renseignés
+	files                  map[string]*formFile // wm_logo, wm_font_file… — preset, puis formulaire
+}
+
+// parseUploadOptions lit les champs watermark et de sortie de r, preset compris. Le formulaire
+// multipart doit être déjà parsé. En cas d'erreur, la réponse est écrite et ok vaut false.
+func parseUploadOptions(w http.ResponseWriter, r *http.Request) (opts *uploadOptions, ok bool) {
+	var p *preset
+	if name := r.FormValue("wm_preset"); name != "" {
+		if p = lookupPreset(r, name); p == nil {
+			writeError(w, http.StatusNotFound, codeNotFound, "Preset inconnu : "+name)
+			return nil, false
+		}
+		logger.Info().Str("step", "preset").Str("name", name).Msg("preset appliqué")
+	}
+	field := func(k string) string { // champ du formulaire, sinon valeur du preset
+		if v := r.FormValue(k); v != "" || p == nil {
+			return v
+		}
+		return p.Fields[k]
+	}
+	wmText := field("wm_text")
+	if wmText == "" {
+		wmText = "NWS © 2026" // fallback si le champ est absent (appel direct à l'API)
+	}
+	wmPosition := field("wm_position")
+	if wmPosition == "" {
+		wmPosition = "bottom-right" // position la moins intrusive par défaut
+	}
+	// Négociation de format : WebP si le navigateur le supporte (~30% plus léger), JPEG sinon.
+	// wm_format=png est toujours honoré : le sans-perte est un choix lié au contenu (captures, graphiques).
+	wmFormat := bestFormat(r)
+	if field("wm_format") == "png" {
+		wmFormat = "png"
+	}
+	logger.Info().Str("step", "format").Str("accept", r.Header.Get("Accept")).Str("chosen", wmFormat).Msg("négociation format")
+	files := map[string]*formFile{}
+	if p != nil {
+		for k, f := range p.Files { // remplacés ci-dessous par les fichiers envoyés avec l'image
+			files[k] = &formFile{name: f.Name, data: f.Data}
+		}
+	}
+	var formFiles map[string][]*multipart.FileHeader // nil : formulaire urlencoded (upload_id)
+	if r.MultipartForm != nil {
+		formFiles = r.MultipartForm.File
+	}
+	for k := range formFiles { // formulaire déjà parsé par l'appelant
+		if !strings.HasPrefix(k, wmFilePrefix) {
+			continue
+		}
+		f, err := optionalFile(r, k)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Fichier illisible : "+k)
+			return nil, false
+		}
+		if f != nil {
+			files[k] = f
+		}
+	}
+	extra := map[string]string{}
+	for _, k := range wmPassthrough {
+		if v := field(k); v != "" {
+			extra[k] = v
+		}
+	}
+	for _, k := range outputPassthrough { // propres à chaque envoi — jamais repris d'un preset
+		if v := r.FormValue(k); v != "" {
+			extra[k] = v
+		}
+	}
+
+	opts = &uploadOptions{text: wmText, position: wmPosition, format: wmFormat, extra: extra, files: files}
+	if c := claimsFrom(r.Context()); c != nil {
+		opts.tenant = c.tenant
+	}
+	return opts, true
+}
+
+// relay retourne un handler qui relaie le formulaire multipart à l'endpoint path de l'optimizer sans le relire :
+// le body est streamé directement, l'API ne garde aucune image en mémoire.
+func relay(path, step, msg string) http.HandlerFunc {
+	return relayWith(httpClient, path, step, msg)
+}
+
+// relayWith est relay avec un client dédié — pour les routes plus lentes que le timeout global.
+func relayWith(client *http.Client, path, step, msg string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		// Contexte du client : s'il raccroche, la requête à l'optimizer est annulée et libère son slot (ffmpeg compris).
+		req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, optimizerAddr()+path, r.Body)
+		if err != nil {
+			logger.Error().Str("step", step).Err(err).Msg("requête optimizer invalide")
+			writeError(w, http.StatusInternalServerError, codeInternal, "Erreur interne")
+			return
+		}
+		req.Header.Set("Content-Type", r.Header.Get("Content-Type")) // conserve le boundary multipart
+		resp, err := client.Do(req)
+		if err != nil {
+			logger.Error().Str("step", step).Err(err).Msg("optimizer KO")
+			writeError(w, http.StatusBadGateway, codeOptimizerUnavailable, "Microservice indisponible")
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode >= 400 { // 400 de l'optimizer (image invalide, trop d'images) : message relayé dans l'erreur JSON
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+			meterOptimizer(r.Context(), time.Since(start))
+			logger.Warn().Str("step", step).Int("status", resp.StatusCode).Str("reason", strings.TrimSpace(string(body))).Msg("refus de l'optimizer relayé")
+			writeError(w, resp.StatusCode, optimizerCode(resp.StatusCode), strings.TrimSpace(string(body)))
+			return
+		}
+
+		w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+		copyImageHeaders(w.Header(), resp.Header)
+		w.WriteHeader(resp.StatusCode)
+		n, _ := io.Copy(w, resp.Body)
+		meterOptimizer(r.Context(), time.Since(start))
+		logger.Info().Str("step", step).Int("status", resp.StatusCode).Str("size", formatBytes(int(n))).Dur("duration", time.Since(start)).Msg(msg)
+	}
 }
 
 // ── Helpers ───────────────────────────────────────────────────────────────────
 
+// optimizerAddr retourne l'URL de base de l'optimizer (OPTIMIZER_URL, défaut dev local).
+func optimizerAddr() string {
+	if u := os.Getenv("OPTIMIZER_URL"); u != "" {
+		return u
+	}
+	return "http://localhost:3001" // défaut dev local
+}
+
 // bestFormat lit le header Accept et retourne "webp" ou "jpeg".
 // WebP offre ~30% de réduction par rapport à JPEG à qualité visuelle équivalente.
 func bestFormat(r *http.Request) string {
@@ -109,19 +353,84 @@ func bestFormat(r *http.Request) string {
 // detectContentType identifie le format à partir des magic bytes.
 // Utilisé pour fixer le Content-Type correct sans avoir besoin de le stocker séparément.
 //
-// Magic bytes : WebP = "RIFF????WEBP" | JPEG = 0xFF 0xD8
+// Magic bytes : WebP = "RIFF????WEBP" | PNG = 0x89 "PNG" | JPEG = 0xFF 0xD8
 func detectContentType(data []byte) string {
+	if bytes.HasPrefix(data, []byte("\x89PNG")) { // sortie sans perte demandée par wm_format=png
+		return "image/png"
+	}
+	if bytes.HasPrefix(data, []byte("GIF8")) { // animation GIF ré-encodée telle quelle
+		return "image/gif"
+	}
 	if len(data) >= 12 &&
 		data[0] == 'R' && data[1] == 'I' && data[2] == 'F' && data[3] == 'F' && // signature RIFF (conteneur WebP)
 		data[8] == 'W' && data[9] == 'E' && data[10] == 'B' && data[11] == 'P' { // identifiant WebP dans le conteneur RIFF
 		return "image/webp"
 	}
-	return "image/jpeg" // tout ce qui n'est pas WebP est traité comme JPEG — on ne supporte que ces deux formats
+	return "image/jpeg" // tout le reste est traité comme JPEG — seuls JPEG, WebP, PNG et GIF sont produits
+}
+
+// imageSignatures : magic bytes des formats d'entrée que l'optimizer sait décoder. Sa liste
+// INPUT_FORMATS peut être plus stricte — il reste seul juge ; l'API écarte seulement ce qui n'est
+// pas une image.
+var imageSignatures = []struct {
+	format string
+	match  func([]byte) bool
+}{
+	{"jpeg", func(b []byte) bool { return bytes.HasPrefix(b, []byte("\xff\xd8\xff")) }},
+	{"png", func(b []byte) bool { return bytes.HasPrefix(b, []byte("\x89PNG\r\n\x1a\n")) }},
+	{"gif", func(b []byte) bool { return bytes.HasPrefix(b, []byte("GIF87a")) || bytes.HasPrefix(b, []byte("GIF89a")) }},
+	{"webp", func(b []byte) bool { return len(b) >= 12 && string(b[:4]) == "RIFF" && string(b[8:12]) == "WEBP" }},
+	{"tiff", func(b []byte) bool { return bytes.HasPrefix(b, []byte("II*\x00")) || bytes.HasPrefix(b, []byte("MM\x00*")) }},
+}
+
+// checkImageType vérifie que data commence par la signature d'un format image accepté, et que
+// le Content-Type déclaré par le client, s'il y en a un, est bien celui d'une image —
+// application/octet-stream toléré, c'est ce qu'envoient curl et les clients HTTP génériques.
+func checkImageType(data []byte, declared string) error {
+	if mt, _, _ := strings.Cut(declared, ";"); mt != "" && !strings.HasPrefix(mt, "image/") && mt != "application/octet-stream" {
+		return fmt.Errorf("Type de fichier non supporté : %s", mt)
+	}
+	formats := make([]string, 0, len(imageSignatures))
+	for _, sig := range imageSignatures {
+		if sig.match(data) {
+			return nil
+		}
+		formats = append(formats, sig.format)
+	}
+	return fmt.Errorf("Fichier non reconnu comme image (acceptés : %s)", strings.Join(formats, ", "))
+}
+
+// formFile est un fichier multipart lu en mémoire, prêt à être relayé à l'optimizer.
+type formFile struct {
+	name string
+	data []byte
+}
+
+// optionalFile lit un champ fichier facultatif — nil sans erreur s'il est absent.
+func optionalFile(r *http.Request, field string) (*formFile, error) {
+	file, header, err := r.FormFile(field)
+	if errors.Is(err, http.ErrMissingFile) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	return &formFile{name: header.Filename, data: data}, nil
 }
 
-// sendToOptimizer envoie l'image à l'optimizer via HTTP multipart et retourne le résultat.
-// Utilise io.Pipe pour streamer le multipart sans charger deux fois l'image en mémoire.
-func sendToOptimizer(optimizerURL, filename string, data []byte, wmText, wmPosition, wmFormat string) ([]byte, error) {
+// sendToOptimizer envoie l'image à l'optimizer via HTTP multipart et retourne le résultat
+// ainsi que les headers de la réponse (métadonnées X-Image-* à relayer au client).
+// Utilise io.Pipe pour streamer le multipart sans charger deux fois l'image en mémoire —
+// ou le gRPC de l'optimizer si OPTIMIZER_GRPC_ADDR est défini (cf. grpc.go).
+func sendToOptimizer(ctx context.Context, optimizerURL, filename string, data []byte, opts *uploadOptions) ([]byte, http.Header, error) {
+	if optimizerGRPC != nil {
+		return optimizeGRPC(ctx, filename, data, opts)
+	}
 	pr, pw := io.Pipe()           // tuyau synchrone : la goroutine écrit pendant que Post lit
 	mw := multipart.NewWriter(pw)
 
@@ -132,32 +441,69 @@ func sendToOptimizer(optimizerURL, filename string, data []byte, wmText, wmPosit
 			return
 		}
 		io.Copy(part, bytes.NewReader(data)) //nolint:errcheck — si la copie échoue, CloseWithError est géré par le Post
-		mw.WriteField("wm_text", wmText)
-		mw.WriteField("wm_position", wmPosition)
-		mw.WriteField("wm_format", wmFormat)
+		mw.WriteField("wm_text", opts.text)
+		mw.WriteField("wm_position", opts.position)
+		mw.WriteField("wm_format", opts.format)
+		for _, k := range slices.Sorted(maps.Keys(opts.extra)) { // ordre stable — facilite la lecture des captures réseau
+			mw.WriteField(k, opts.extra[k])
+		}
+		for _, k := range slices.Sorted(maps.Keys(opts.files)) {
+			if fp, err := mw.CreateFormFile(k, opts.files[k].name); err == nil {
+				fp.Write(opts.files[k].data) //nolint:errcheck — même gestion que la copie de l'image
+			}
+		}
 		mw.Close() // finalise le boundary multipart
 		pw.Close() // signale la fin du stream au lecteur (httpClient.Post)
 	}()
 
-	resp, err := httpClient.Post(optimizerURL+"/optimize", mw.FormDataContentType(), pr) // lit le pipe pendant que la goroutine écrit
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, optimizerURL+"/optimize", pr) // client parti : la requête à l'optimizer est annulée aussi
 	if err != nil {
-		return nil, err
+		pr.CloseWithError(err) // débloque la goroutine d'écriture
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	resp, err := httpClient.Do(req) // lit le pipe pendant que la goroutine écrit
+	if err != nil {
+		return nil, nil, err
 	}
 	defer resp.Body.Close()
-	return io.ReadAll(resp.Body) // lire la réponse complète (image encodée)
+	body, err := io.ReadAll(resp.Body) // lire la réponse complète (image encodée)
+	if err == nil && resp.StatusCode != http.StatusOK { // le body est alors un message d'erreur, pas une image
+		return nil, nil, &optimizerError{status: resp.StatusCode, msg: strings.TrimSpace(string(body))}
+	}
+	return body, resp.Header, err
+}
+
+// optimizerError est une réponse non-200 de l'optimizer : image refusée (4xx) ou échec de traitement (5xx).
+type optimizerError struct {
+	status int
+	msg    string
 }
 
-// sendResponse envoie les données au client avec le Content-Type correct (détecté par magic bytes)
-// et compression gzip si le navigateur le supporte.
-func sendResponse(w http.ResponseWriter, r *http.Request, data []byte) {
-	ct := detectContentType(data)
+func (e *optimizerError) Error() string {
+	return fmt.Sprintf("optimizer %d: %s", e.status, e.msg)
+}
+
+// copyImageHeaders relaie au client les headers X-Image-* posés par l'optimizer
+// (qualité, bras d'expérience…) — l'API n'a pas besoin de les connaître un par un.
+func copyImageHeaders(dst, src http.Header) {
+	for k, v := range src {
+		if strings.HasPrefix(k, "X-Image-") { // clés canonisées par net/http
+			dst[k] = v
+		}
+	}
+}
+
+// sendResponse envoie les données au client avec le Content-Type donné (détecté par magic bytes
+// pour une image seule) et compression gzip si le navigateur le supporte.
+func sendResponse(w http.ResponseWriter, r *http.Request, ct string, data []byte) {
 	w.Header().Set("Content-Type", ct)
 
 	if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") { // le client supporte gzip → compresser à la volée
 		w.Header().Set("Content-Encoding", "gzip")
 		gz, err := gzip.NewWriterLevel(w, gzip.BestSpeed) // BestSpeed : favorise la latence sur le taux de compression
 		if err != nil {
-			http.Error(w, "Erreur compression", http.StatusInternalServerError)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Erreur compression")
 			return
 		}
 		defer gz.Close()  // flush + écriture du footer gzip avant de retourner
@@ -167,21 +513,52 @@ func sendResponse(w http.ResponseWriter, r *http.Request, data []byte) {
 	}
 }
 
-// corsMiddleware ajoute les headers CORS pour permettre les appels depuis le front React (dev + prod).
-func corsMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")                   // en prod, restreindre au domaine du front
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-		w.Header().Set("Access-Control-Expose-Headers", "X-T-Read, X-T-Optimizer") // expose les headers de timing au front pour le debug
-
-		if r.Method == http.MethodOptions { // preflight CORS — répondre sans passer au handler
-			w.WriteHeader(http.StatusNoContent)
-			return
+// setCacheHeaders pose Cache-Control et, si la directive porte un max-age, Expires à now + max-age.
+func setCacheHeaders(h http.Header, now time.Time) {
+	if cacheControl == "" {
+		return
+	}
+	h.Set("Cache-Control", cacheControl)
+	for _, d := range strings.Split(cacheControl, ",") {
+		if v, ok := strings.CutPrefix(strings.TrimSpace(d), "max-age="); ok {
+			if secs, err := strconv.Atoi(v); err == nil {
+				h.Set("Expires", now.Add(time.Duration(secs)*time.Second).UTC().Format(http.TimeFormat))
+			}
 		}
+	}
+}
 
-		next.ServeHTTP(w, r)
-	})
+// parseByteSize lit une taille en octets, avec suffixe K, M ou G facultatif (puissances de 1024) :
+// "52428800", "50M", "50MB" et "50 mb" sont équivalents.
+func parseByteSize(v string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(v))
+	s = strings.TrimSuffix(s, "B")
+	mult := int64(1)
+	if i := strings.IndexAny(s, "KMG"); i >= 0 && i == len(s)-1 {
+		mult = map[byte]int64{'K': 1 << 10, 'M': 1 << 20, 'G': 1 << 30}[s[i]]
+		s = strings.TrimSpace(s[:i])
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("taille invalide : %q", v)
+	}
+	return n * mult, nil
+}
+
+// limitUpload borne le body de r à maxUploadSize : 413 d'emblée si Content-Length le dépasse, sans
+// rien lire, sinon MaxBytesReader coupe la lecture (envoi chunked, Content-Length mensonger).
+// Parse le formulaire multipart ; une autre erreur de parsing est laissée au handler (image manquante).
+func limitUpload(w http.ResponseWriter, r *http.Request) bool {
+	if r.ContentLength > maxUploadSize {
+		writeError(w, http.StatusRequestEntityTooLarge, codeImageTooLarge, fmt.Sprintf("Fichier trop volumineux (max %s)", formatBytes(int(maxUploadSize))))
+		return false
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	if err := r.ParseMultipartForm(32 << 20); errors.As(err, new(*http.MaxBytesError)) { // au-delà de 32 Mo, les parts vont sur disque
+		writeError(w, http.StatusRequestEntityTooLarge, codeImageTooLarge, fmt.Sprintf("Fichier trop volumineux (max %s)", formatBytes(int(maxUploadSize))))
+		return false
+	}
+	return true
 }
 
 // fmtMs convertit une durée en millisecondes avec 3 décimales (ex: "12.345").
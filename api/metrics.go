@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// ── Métriques Prometheus ──────────────────────────────────────────────────────
+// GET /metrics (hors /v1, sans authentification, comme les sondes — à filtrer à l'ingress) expose :
+//   - watermark_api_requests_total{route,method,status} et watermark_api_request_duration_seconds ;
+//   - watermark_api_bytes_total{route,direction} : octets reçus (in) et envoyés (out) ;
+//   - watermark_api_stage_duration_seconds{stage} : lecture de l'upload (read), appel optimizer
+//     (optimizer) — les durées des headers X-T-*, agrégées ;
+//   - les métriques Go et processus du client Prometheus.
+// route est le motif du mux (/image/{hash}/meta), pas le chemin : cardinalité bornée. Les détails
+// du pipeline (décodage, resize, encodage) et le pool de workers sont dans les métriques de l'optimizer.
+
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "watermark", Subsystem: "api", Name: "requests_total",
+		Help: "Requêtes HTTP par route, méthode et statut.",
+	}, []string{"route", "method", "status"})
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "watermark", Subsystem: "api", Name: "request_duration_seconds",
+		Help:    "Durée des requêtes HTTP par route et méthode.",
+		Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"route", "method"})
+	bytesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "watermark", Subsystem: "api", Name: "bytes_total",
+		Help: "Octets reçus (in) et envoyés (out) par route.",
+	}, []string{"route", "direction"})
+	stageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "watermark", Subsystem: "api", Name: "stage_duration_seconds",
+		Help:    "Durée des étapes d'un upload : read, optimizer.",
+		Buckets: []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"stage"})
+)
+
+// versionedPath : préfixe de version retiré pour retrouver la route dans le mux.
+var versionedPath = regexp.MustCompile(`^/v[0-9]+/`)
+
+// observeStage ajoute la durée d'une étape à watermark_api_stage_duration_seconds.
+func observeStage(stage string, d time.Duration) {
+	stageDuration.WithLabelValues(stage).Observe(d.Seconds())
+}
+
+// metricsMiddleware compte chaque requête sous la route de routes qui la sert — y compris les refus
+// des middlewares suivants (401, 429) : la route est cherchée avant de passer la main.
+func metricsMiddleware(routes *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		route := routeOf(routes, r)
+		body := &countingReader{r: r.Body}
+		r.Body = body
+		mw := &metricsWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(mw, r)
+
+		requestsTotal.WithLabelValues(route, r.Method, strconv.Itoa(mw.status)).Inc()
+		requestDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+		bytesTotal.WithLabelValues(route, "in").Add(float64(body.n))
+		bytesTotal.WithLabelValues(route, "out").Add(float64(mw.n))
+	})
+}
+
+// routeOf retourne le chemin du motif de routes qui sert r, sans préfixe de version — "unmatched" sinon.
+func routeOf(routes *http.ServeMux, r *http.Request) string {
+	r2 := r.WithContext(r.Context()) // copie : seul le chemin change
+	u := *r.URL
+	if loc := versionedPath.FindStringIndex(u.Path); loc != nil {
+		u.Path = u.Path[loc[1]-1:]
+	}
+	r2.URL = &u
+	_, pattern := routes.Handler(r2)
+	if pattern == "" {
+		return "unmatched"
+	}
+	_, path, _ := strings.Cut(pattern, " ") // "POST /upload" → "/upload" (la méthode est un label à part)
+	return path
+}
+
+// metricsWriter retient le statut et compte les octets de la réponse.
+type metricsWriter struct {
+	http.ResponseWriter
+	status int
+	n      int64
+}
+
+func (m *metricsWriter) WriteHeader(status int) {
+	m.status = status
+	m.ResponseWriter.WriteHeader(status)
+}
+
+func (m *metricsWriter) Write(p []byte) (int, error) {
+	n, err := m.ResponseWriter.Write(p)
+	m.n += int64(n)
+	return n, err
+}
+
+// Unwrap expose le ResponseWriter d'origine à http.ResponseController (Flush, délais).
+func (m *metricsWriter) Unwrap() http.ResponseWriter { return m.ResponseWriter }
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ── OpenAPI ───────────────────────────────────────────────────────────────────
+// GET /openapi.json décrit l'API en OpenAPI 3.1, document public même avec JWKS_URL. Les schémas
+// des réponses JSON sont dérivés par réflexion des structs Go qui les encodent (imageRecord,
+// batchResult…), et les paramètres de /upload des listes wmPassthrough / outputPassthrough :
+// un champ ajouté au code apparaît dans la spec sans autre modification. Seules les descriptions
+// sont écrites à la main, dans paramDocs — un paramètre sans description y est quand même listé.
+
+// paramDocs : description des champs de formulaire de /upload, /upload/batch et POST /presets.
+var paramDocs = map[string]string{
+	"image":            "Image à traiter : JPEG, PNG, WebP, GIF ou TIFF.",
+	"upload_id":        "Identifiant d'un upload tus terminé (POST /uploads), à la place de image.",
+	"wm_preset":        "Preset enregistré par POST /presets ; les champs envoyés restent prioritaires.",
+	"wm_text":          "Texte du watermark (défaut : « NWS © 2026 »).",
+	"wm_position":      "top-left, top-center, top-right, center, bottom-left, bottom-center, bottom-right (défaut) ou auto.",
+	"wm_format":        "png pour une sortie sans perte ; sinon WebP ou JPEG selon le header Accept.",
+	"wm_logo_scale":    "Largeur du logo en % de la largeur de l'image (1-100).",
+	"wm_size":          "Taille du texte en px, ou auto (proportionnelle à la largeur de sortie).",
+	"wm_font":          "Police intégrée du texte.",
+	"wm_opacity":       "Opacité du watermark en %, 0-100.",
+	"wm_outline":       "Épaisseur du contour contrasté autour des lettres, en px.",
+	"wm_shadow":        "true pour une ombre portée sous le texte.",
+	"wm_x":             "Abscisse libre du watermark, prioritaire sur wm_position.",
+	"wm_y":             "Ordonnée libre du watermark, prioritaire sur wm_position.",
+	"wm_color":         "Couleur imposée du texte (#RRGGBB) ; par défaut, adaptée au fond.",
+	"wm_angle":         "Inclinaison du texte en degrés.",
+	"wm_plate":         "Plaque semi-transparente sous le texte.",
+	"wm_plate_padding": "Marge intérieure de la plaque, en px.",
+	"wm_qr":            "Contenu d'un QR code ajouté au watermark.",
+	"wm_qr_size":       "Côté du QR code, en px.",
+	"wm_blend":         "Mode de fusion du watermark avec l'image.",
+	"wm_layers":        "Calques du watermark en JSON (textes, logos wm_logo_2…), à la place des champs simples.",
+	"max_w":            "Largeur maximale de sortie, proportions conservées.",
+	"max_h":            "Hauteur maximale de sortie, proportions conservées.",
+	"w":                "Largeur exacte de sortie.",
+	"h":                "Hauteur exacte de sortie.",
+	"upscale":          "true pour autoriser l'agrandissement (borné par MAX_UPSCALE).",
+	"interp":           "Interpolation du redimensionnement : nearest, bilinear, catmullrom…",
+	"sizes":            "Largeurs de variantes séparées par des virgules — réponse multipart.",
+	"crop":             "Recadrage à un ratio (16:9) ou à un rectangle.",
+	"crop_gravity":     "Ancrage du recadrage.",
+	"rotate":           "Rotation en degrés (90, 180, 270).",
+	"flip":             "Miroir : h (gauche-droite) ou v (haut-bas).",
+	"filters":          "Filtres de couleur séparés par des virgules.",
+	"blur_regions":     "Zones à flouter (x,y,w,h;…).",
+	"auto_faces":       "true pour flouter les visages détectés.",
+	"sharpen":          "Intensité de la netteté.",
+	"keep_icc":         "true pour conserver le profil ICC.",
+	"keep_exif":        "true pour conserver les EXIF (sans GPS).",
+	"set_copyright":    "Copyright inscrit dans les métadonnées.",
+	"bg_color":         "Couleur de fond des zones transparentes pour une sortie JPEG.",
+	"fit":              "Ajustement aux dimensions w×h.",
+	"progressive":      "true pour un JPEG progressif.",
+	"quality":          "Qualité d'encodage 1-100, à la place de la courbe automatique.",
+	"max_bytes":        "Taille maximale du résultat : la qualité est ajustée pour la tenir.",
+	"subsampling":      "Sous-échantillonnage chroma du JPEG.",
+}
+
+func handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(openAPIDoc()) //nolint:errcheck — erreur réseau côté client, pas récupérable
+}
+
+// openAPIDoc construit le document — à chaque appel, il suit la configuration courante.
+func openAPIDoc() map[string]any {
+	ref := func(name string) map[string]any { return map[string]any{"$ref": "#/components/schemas/" + name} }
+	jsonBody := func(schema map[string]any) map[string]any {
+		return map[string]any{"content": map[string]any{"application/json": map[string]any{"schema": schema}}}
+	}
+	failure := func(desc string) map[string]any { // réponse d'erreur : corps JSON commun (errors.go)
+		return map[string]any{"description": desc, "content": jsonBody(ref("Error"))["content"]}
+	}
+	query := func(name, typ, desc string) map[string]any {
+		return map[string]any{"name": name, "in": "query", "description": desc, "schema": map[string]any{"type": typ}}
+	}
+	imageResponse := map[string]any{
+		"description": "Image optimisée ; multipart/mixed avec sizes. Métadonnées dans les headers X-Image-*.",
+		"headers":     imageHeaders(),
+		"content": map[string]any{
+			"image/webp": map[string]any{"schema": map[string]any{"type": "string", "format": "binary"}},
+			"image/jpeg": map[string]any{"schema": map[string]any{"type": "string", "format": "binary"}},
+			"image/png":  map[string]any{"schema": map[string]any{"type": "string", "format": "binary"}},
+			"image/gif":  map[string]any{"schema": map[string]any{"type": "string", "format": "binary"}},
+		},
+	}
+	relayOp := func(summary string) map[string]any {
+		return map[string]any{"post": map[string]any{
+			"summary":     summary,
+			"requestBody": map[string]any{"content": map[string]any{"multipart/form-data": map[string]any{"schema": map[string]any{"type": "object"}}}},
+			"responses":   map[string]any{"200": map[string]any{"description": "Résultat de l'optimizer, relayé tel quel."}, "502": failure("Optimizer indisponible.")},
+		}}
+	}
+	errs := map[string]any{
+		"400": failure("Paramètre invalide ou image manquante."),
+		"413": failure("Fichier trop volumineux (MAX_UPLOAD_SIZE)."),
+		"415": failure("Fichier qui n'est pas une image acceptée."),
+		"422": failure("Image refusée par l'optimizer (dimensions, politique GPS)."),
+		"429": failure("Débit ou quota de requêtes dépassé (Retry-After)."),
+		"502": failure("Optimizer indisponible."),
+	}
+	withErrs := func(ok map[string]any, codes ...string) map[string]any {
+		out := map[string]any{"200": ok}
+		for _, c := range codes {
+			out[c] = errs[c]
+		}
+		return out
+	}
+
+	doc := map[string]any{
+		"openapi": "3.1.0",
+		"servers": []any{map[string]any{"url": "/v1", "description": "Version 1 — aussi servie sans préfixe (header API-Version facultatif)."}},
+		"info": map[string]any{
+			"title":       "Watermark API",
+			"version":     "1.0.0",
+			"description": "Upload, watermark et optimisation d'images. Les erreurs ont un corps JSON commun (Error) : code stable, message, request_id (header X-Request-Id), retryable.",
+		},
+		"paths": map[string]any{
+			"/upload": map[string]any{"post": map[string]any{
+				"summary":     "Optimise et watermarke une image",
+				"requestBody": map[string]any{"required": true, "content": map[string]any{"multipart/form-data": map[string]any{"schema": uploadForm(false)}}},
+				"responses":   withErrs(imageResponse, "400", "413", "415", "422", "429", "502"),
+			}},
+			"/upload/batch": map[string]any{"post": map[string]any{
+				"summary":     "Optimise plusieurs images avec les mêmes réglages",
+				"requestBody": map[string]any{"required": true, "content": map[string]any{"multipart/form-data": map[string]any{"schema": uploadForm(true)}}},
+				"responses":   withErrs(map[string]any{"description": "Un résultat par image, dans l'ordre d'envoi.", "content": jsonBody(map[string]any{"type": "array", "items": ref("BatchResult")})["content"]}, "400", "413", "429"), // refus par image : dans le résultat
+			}},
+			"/images": map[string]any{"get": map[string]any{
+				"summary": "Liste les images traitées, les plus récentes d'abord",
+				"parameters": []any{
+					query("from", "string", "Borne basse, RFC 3339 ou AAAA-MM-JJ."),
+					query("to", "string", "Borne haute incluse, RFC 3339 ou AAAA-MM-JJ."),
+					query("format", "string", "Format de sortie : jpeg, webp, png, gif."),
+					query("min_bytes", "integer", "Taille minimale du résultat."),
+					query("max_bytes", "integer", "Taille maximale du résultat."),
+					query("limit", "integer", "Taille de page (50 par défaut, 500 max)."),
+					query("offset", "integer", "Décalage dans la liste filtrée."),
+				},
+				"responses": map[string]any{
+					"200": map[string]any{"description": "Page d'images.", "content": jsonBody(map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"total": map[string]any{"type": "integer"}, "offset": map[string]any{"type": "integer"}, "limit": map[string]any{"type": "integer"},
+							"items": map[string]any{"type": "array", "items": ref("ImageRecord")},
+						},
+					})["content"]},
+					"400": failure("Filtre invalide."),
+				},
+			}},
+			"/image/{hash}/meta": map[string]any{"get": map[string]any{
+				"summary":    "Métadonnées d'une image traitée",
+				"parameters": []any{map[string]any{"name": "hash", "in": "path", "required": true, "description": "SHA-256 hexadécimal de l'original.", "schema": map[string]any{"type": "string"}}},
+				"responses": map[string]any{
+					"200": map[string]any{"description": "Entrée d'index.", "content": jsonBody(ref("ImageRecord"))["content"]},
+					"404": failure("Image inconnue ou sortie de l'index."),
+				},
+			}},
+			"/usage": map[string]any{"get": map[string]any{
+				"summary":    "Consommation du mois et quotas",
+				"parameters": []any{query("month", "string", "Mois AAAA-MM (défaut : mois en cours)."), query("all", "boolean", "Tous les clients — scope admin, ou ADMIN_TOKEN sans authentification.")},
+				"responses": map[string]any{
+					"200": map[string]any{"description": "Consommation du client appelant, ou de tous (clients).", "content": jsonBody(map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"month": map[string]any{"type": "string"}, "client": map[string]any{"type": "string"},
+							"quota":   map[string]any{"type": "object", "additionalProperties": map[string]any{"type": "integer"}},
+							"usage":   ref("UsageCounters"),
+							"clients": map[string]any{"type": "object", "additionalProperties": ref("UsageCounters")},
+						},
+					})["content"]},
+					"400": failure("month invalide."),
+					"403": failure("all=true sans accès administration (scope admin ou ADMIN_TOKEN)."),
+				},
+			}},
+			"/presets": map[string]any{
+				"get": map[string]any{
+					"summary":   "Liste les presets watermark du tenant",
+					"responses": map[string]any{"200": map[string]any{"description": "Presets par nom.", "content": jsonBody(map[string]any{"type": "object", "additionalProperties": ref("PresetInfo")})["content"]}},
+				},
+				"post": map[string]any{
+					"summary":     "Enregistre ou remplace un preset watermark du tenant",
+					"requestBody": map[string]any{"required": true, "content": map[string]any{"multipart/form-data": map[string]any{"schema": presetForm()}}},
+					"responses":   map[string]any{"201": map[string]any{"description": "Preset créé."}, "200": map[string]any{"description": "Preset remplacé."}, "400": failure("Nom ou champs invalides."), "507": failure("Trop de presets, pour le tenant ou au total.")},
+				},
+			},
+			"/uploads": map[string]any{
+				"options": map[string]any{"summary": "Découverte tus (Tus-Version, Tus-Max-Size)", "responses": map[string]any{"204": map[string]any{"description": "Capacités du serveur tus."}}},
+				"post":    map[string]any{"summary": "Crée un upload reprenable tus (Upload-Length, Upload-Metadata)", "responses": map[string]any{"201": map[string]any{"description": "Upload créé, URL dans Location."}, "413": failure("Upload-Length au-delà de MAX_UPLOAD_SIZE."), "429": failure("TUS_MAX_UPLOADS_PER_CLIENT uploads en cours pour ce client."), "507": failure("TUS_MAX_UPLOADS ou TUS_MAX_BYTES atteint.")}},
+			},
+			"/uploads/{id}": map[string]any{
+				"parameters": []any{map[string]any{"name": "id", "in": "path", "required": true, "schema": map[string]any{"type": "string"}}},
+				"head":       map[string]any{"summary": "Offset courant d'un upload tus", "responses": map[string]any{"200": map[string]any{"description": "Upload-Offset, Upload-Length."}, "404": map[string]any{"description": "Upload inconnu ou expiré."}}},
+				"patch":      map[string]any{"summary": "Envoie un morceau (application/offset+octet-stream)", "responses": map[string]any{"204": map[string]any{"description": "Morceau écrit, nouvel Upload-Offset."}, "409": failure("Upload-Offset différent de l'offset courant.")}},
+				"delete":     map[string]any{"summary": "Abandonne un upload tus", "responses": map[string]any{"204": map[string]any{"description": "Upload supprimé."}, "409": failure("Un PATCH est en cours sur l'upload.")}},
+			},
+			"/graphql": map[string]any{"post": map[string]any{
+				"summary":     "Requête GraphQL (images, usage, upload) — JSON, ou multipart pour un fichier",
+				"requestBody": map[string]any{"required": true, "content": jsonBody(map[string]any{"type": "object", "properties": map[string]any{"query": map[string]any{"type": "string"}, "operationName": map[string]any{"type": "string"}, "variables": map[string]any{"type": "object"}}})["content"]},
+				"responses":   map[string]any{"200": map[string]any{"description": "Réponse GraphQL (data, errors)."}},
+			}},
+			"/sprite":         relayOp("Planche de miniatures (relayée à l'optimizer)"),
+			"/thumbnail":      relayOp("Aperçu rapide (relayé à l'optimizer)"),
+			"/optimize-pdf":   relayOp("Watermark sur chaque page d'un PDF (relayé à l'optimizer)"),
+			"/optimize-video": relayOp("Watermark incrusté dans une vidéo (relayé à l'optimizer)"),
+			"/admin/stats": map[string]any{"get": map[string]any{
+				"summary":   "État des magasins : index, uploads tus, consommation, mémoire — scope admin ou ADMIN_TOKEN",
+				"responses": map[string]any{"200": map[string]any{"description": "Compteurs par magasin.", "content": jsonBody(map[string]any{"type": "object"})["content"]}, "403": failure("Ni scope admin ni ADMIN_TOKEN.")},
+			}},
+			"/admin/purge": map[string]any{"post": map[string]any{
+				"summary": "Supprime les entrées plus anciennes qu'une durée — scope admin ou ADMIN_TOKEN",
+				"parameters": []any{
+					map[string]any{"name": "older_than", "in": "query", "required": true, "description": "Durée : 72h, 30m, 7d ; 0 purge tout.", "schema": map[string]any{"type": "string"}},
+					query("target", "string", "images (défaut), uploads ou all."),
+				},
+				"responses": map[string]any{"200": map[string]any{"description": "Nombre d'entrées supprimées par magasin.", "content": jsonBody(map[string]any{"type": "object"})["content"]}, "400": failure("older_than ou target invalide."), "403": failure("Ni scope admin ni ADMIN_TOKEN.")},
+			}},
+			"/openapi.json": map[string]any{"get": map[string]any{
+				"summary":   "Ce document",
+				"responses": map[string]any{"200": map[string]any{"description": "Document OpenAPI 3.1."}},
+			}},
+		},
+		"components": map[string]any{
+			"schemas": map[string]any{
+				"ImageRecord":   schemaOf(reflect.TypeFor[imageRecord]()),
+				"BatchResult":   schemaOf(reflect.TypeFor[batchResult]()),
+				"UsageCounters": schemaOf(reflect.TypeFor[usageCounters]()),
+				"PresetInfo":    schemaOf(reflect.TypeFor[presetInfo]()),
+				"Error":         schemaOf(reflect.TypeFor[apiError]()),
+			},
+		},
+	}
+	if jwksURL != "" {
+		doc["components"].(map[string]any)["securitySchemes"] = map[string]any{"bearer": map[string]any{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"}}
+		doc["security"] = []any{map[string]any{"bearer": []any{}}}
+	}
+	return doc
+}
+
+// uploadForm décrit le formulaire de /upload — ou de /upload/batch (plusieurs images, pas d'upload_id ni de sizes).
+func uploadForm(batch bool) map[string]any {
+	props := map[string]any{}
+	field := func(k string, schema map[string]any) {
+		if d := paramDocs[k]; d != "" {
+			schema["description"] = d
+		}
+		props[k] = schema
+	}
+	binary := func() map[string]any { return map[string]any{"type": "string", "format": "binary"} }
+	if batch {
+		field("image", map[string]any{"type": "array", "items": binary(), "maxItems": maxBatchFiles})
+	} else {
+		field("image", binary())
+		field("upload_id", map[string]any{"type": "string"})
+	}
+	for _, k := range append([]string{"wm_preset", "wm_text", "wm_position", "wm_format"}, wmPassthrough...) {
+		field(k, map[string]any{"type": "string"})
+	}
+	for _, k := range outputPassthrough {
+		if batch && k == "sizes" {
+			continue
+		}
+		field(k, map[string]any{"type": "string"})
+	}
+	field("wm_logo", binary())
+	field("wm_font_file", binary())
+	return map[string]any{
+		"type":                 "object",
+		"properties":           props,
+		"additionalProperties": binary(), // fichiers wm_* référencés par wm_layers (wm_logo_2…)
+	}
+}
+
+// presetForm décrit le formulaire de POST /presets : un nom et les champs watermark de presetFields.
+func presetForm() map[string]any {
+	props := map[string]any{"name": map[string]any{"type": "string", "pattern": presetName.String()}}
+	for _, k := range presetFields {
+		props[k] = map[string]any{"type": "string", "description": paramDocs[k]}
+	}
+	props["wm_logo"] = map[string]any{"type": "string", "format": "binary"}
+	return map[string]any{"type": "object", "required": []string{"name"}, "properties": props}
+}
+
+// imageHeaders décrit les headers de la réponse de /upload, d'après la liste exposée par CORS.
+func imageHeaders() map[string]any {
+	headers := map[string]any{}
+	for _, h := range strings.Split(corsExposeHeaders, ", ") {
+		if strings.HasPrefix(h, "X-") {
+			headers[h] = map[string]any{"schema": map[string]any{"type": "string"}}
+		}
+	}
+	return headers
+}
+
+// schemaOf dérive le schéma JSON d'un type Go d'après ses tags json : un champ omitempty est
+// facultatif, les autres sont requis.
+func schemaOf(t reflect.Type) map[string]any {
+	switch {
+	case t == reflect.TypeFor[time.Time]():
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t == reflect.TypeFor[errorCode](): // taxonomie des erreurs : liste fermée
+		return map[string]any{"type": "string", "enum": slices.Sorted(maps.Keys(errorCodes))}
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		return map[string]any{"type": "string", "contentEncoding": "base64"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaOf(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": schemaOf(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaOf(t.Elem())}
+	case reflect.Struct:
+		props := map[string]any{}
+		var required []string
+		for i := range t.NumField() {
+			f := t.Field(i)
+			name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if !f.IsExported() || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			props[name] = schemaOf(f.Type)
+			if !slices.Contains(strings.Split(opts, ","), "omitempty") {
+				required = append(required, name)
+			}
+		}
+		s := map[string]any{"type": "object", "properties": props}
+		if len(required) > 0 {
+			s["required"] = required
+		}
+		return s
+	}
+	return map[string]any{}
+}
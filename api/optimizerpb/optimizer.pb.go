@@ -0,0 +1,245 @@
+// Interface gRPC de l'optimizer, servie à côté de l'HTTP — même pipeline que POST /optimize.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        (unknown)
+// source: optimizer.proto
+
+// Régénérer les deux copies (chaque service est un module Go autonome) depuis la racine du dépôt :
+//
+//   protoc -I optimizer/optimizerpb \
+//     --go_out=optimizer/optimizerpb --go_opt=paths=source_relative \
+//     --go-grpc_out=optimizer/optimizerpb --go-grpc_opt=paths=source_relative \
+//     optimizer.proto
+//   protoc -I optimizer/optimizerpb \
+//     --go_out=api/optimizerpb --go_opt=paths=source_relative,Moptimizer.proto=api/optimizerpb \
+//     --go-grpc_out=api/optimizerpb --go-grpc_opt=paths=source_relative,Moptimizer.proto=api/optimizerpb \
+//     optimizer.proto
+
+package optimizerpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// ImageChunk est un morceau d'image, en entrée comme en sortie.
+type ImageChunk struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Octets de l'image, à concaténer dans l'ordre de réception.
+	Data []byte `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
+	// Nom du fichier d'origine — requête, premier message.
+	Filename string `protobuf:"bytes,2,opt,name=filename,proto3" json:"filename,omitempty"`
+	// Requête : champs de formulaire de /optimize (wm_text, wm_format, max_w…).
+	// Réponse : headers de /optimize (Content-Type, X-Image-*).
+	Fields map[string]string `protobuf:"bytes,3,rep,name=fields,proto3" json:"fields,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// Fichiers annexes de la requête (wm_logo, wm_font_file…) — premier message.
+	Files         []*FormFile `protobuf:"bytes,4,rep,name=files,proto3" json:"files,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ImageChunk) Reset() {
+	*x = ImageChunk{}
+	mi := &file_optimizer_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ImageChunk) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ImageChunk) ProtoMessage() {}
+
+func (x *ImageChunk) ProtoReflect() protoreflect.Message {
+	mi := &file_optimizer_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ImageChunk.ProtoReflect.Descriptor instead.
+func (*ImageChunk) Descriptor() ([]byte, []int) {
+	return file_optimizer_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *ImageChunk) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *ImageChunk) GetFilename() string {
+	if x != nil {
+		return x.Filename
+	}
+	return ""
+}
+
+func (x *ImageChunk) GetFields() map[string]string {
+	if x != nil {
+		return x.Fields
+	}
+	return nil
+}
+
+func (x *ImageChunk) GetFiles() []*FormFile {
+	if x != nil {
+		return x.Files
+	}
+	return nil
+}
+
+// FormFile est un fichier annexe, transmis en une fois : logos et polices restent petits.
+type FormFile struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Field         string                 `protobuf:"bytes,1,opt,name=field,proto3" json:"field,omitempty"`
+	Filename      string                 `protobuf:"bytes,2,opt,name=filename,proto3" json:"filename,omitempty"`
+	Data          []byte                 `protobuf:"bytes,3,opt,name=data,proto3" json:"data,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FormFile) Reset() {
+	*x = FormFile{}
+	mi := &file_optimizer_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FormFile) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FormFile) ProtoMessage() {}
+
+func (x *FormFile) ProtoReflect() protoreflect.Message {
+	mi := &file_optimizer_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FormFile.ProtoReflect.Descriptor instead.
+func (*FormFile) Descriptor() ([]byte, []int) {
+	return file_optimizer_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *FormFile) GetField() string {
+	if x != nil {
+		return x.Field
+	}
+	return ""
+}
+
+func (x *FormFile) GetFilename() string {
+	if x != nil {
+		return x.Filename
+	}
+	return ""
+}
+
+func (x *FormFile) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+var File_optimizer_proto protoreflect.FileDescriptor
+
+const file_optimizer_proto_rawDesc = "" +
+	"\n" +
+	"\x0foptimizer.proto\x12\x16watermark.optimizer.v1\"\xf7\x01\n" +
+	"\n" +
+	"ImageChunk\x12\x12\n" +
+	"\x04data\x18\x01 \x01(\fR\x04data\x12\x1a\n" +
+	"\bfilename\x18\x02 \x01(\tR\bfilename\x12F\n" +
+	"\x06fields\x18\x03 \x03(\v2..watermark.optimizer.v1.ImageChunk.FieldsEntryR\x06fields\x126\n" +
+	"\x05files\x18\x04 \x03(\v2 .watermark.optimizer.v1.FormFileR\x05files\x1a9\n" +
+	"\vFieldsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"P\n" +
+	"\bFormFile\x12\x14\n" +
+	"\x05field\x18\x01 \x01(\tR\x05field\x12\x1a\n" +
+	"\bfilename\x18\x02 \x01(\tR\bfilename\x12\x12\n" +
+	"\x04data\x18\x03 \x01(\fR\x04data2c\n" +
+	"\tOptimizer\x12V\n" +
+	"\bOptimize\x12\".watermark.optimizer.v1.ImageChunk\x1a\".watermark.optimizer.v1.ImageChunk(\x010\x01B\x17Z\x15optimizer/optimizerpbb\x06proto3"
+
+var (
+	file_optimizer_proto_rawDescOnce sync.Once
+	file_optimizer_proto_rawDescData []byte
+)
+
+func file_optimizer_proto_rawDescGZIP() []byte {
+	file_optimizer_proto_rawDescOnce.Do(func() {
+		file_optimizer_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_optimizer_proto_rawDesc), len(file_optimizer_proto_rawDesc)))
+	})
+	return file_optimizer_proto_rawDescData
+}
+
+var file_optimizer_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_optimizer_proto_goTypes = []any{
+	(*ImageChunk)(nil), // 0: watermark.optimizer.v1.ImageChunk
+	(*FormFile)(nil),   // 1: watermark.optimizer.v1.FormFile
+	nil,                // 2: watermark.optimizer.v1.ImageChunk.FieldsEntry
+}
+var file_optimizer_proto_depIdxs = []int32{
+	2, // 0: watermark.optimizer.v1.ImageChunk.fields:type_name -> watermark.optimizer.v1.ImageChunk.FieldsEntry
+	1, // 1: watermark.optimizer.v1.ImageChunk.files:type_name -> watermark.optimizer.v1.FormFile
+	0, // 2: watermark.optimizer.v1.Optimizer.Optimize:input_type -> watermark.optimizer.v1.ImageChunk
+	0, // 3: watermark.optimizer.v1.Optimizer.Optimize:output_type -> watermark.optimizer.v1.ImageChunk
+	3, // [3:4] is the sub-list for method output_type
+	2, // [2:3] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
+}
+
+func init() { file_optimizer_proto_init() }
+func file_optimizer_proto_init() {
+	if File_optimizer_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_optimizer_proto_rawDesc), len(file_optimizer_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   3,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_optimizer_proto_goTypes,
+		DependencyIndexes: file_optimizer_proto_depIdxs,
+		MessageInfos:      file_optimizer_proto_msgTypes,
+	}.Build()
+	File_optimizer_proto = out.File
+	file_optimizer_proto_goTypes = nil
+	file_optimizer_proto_depIdxs = nil
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// ── Presets watermark ─────────────────────────────────────────────────────────
+// Un preset est une configuration watermark nommée (texte, police, position, opacité, logo…)
+// enregistrée une fois via POST /presets, puis réutilisée par /upload avec wm_preset=brandA :
+// le front n'a plus à renvoyer toute la configuration — ni le logo — à chaque image.
+// Les champs envoyés avec l'image restent prioritaires sur ceux du preset.
+//
+// Les presets sont cloisonnés par tenant : avec l'authentification, chaque client (tenant du JWT, à
+// défaut son sujet — la clé de clientKey) a ses propres noms, invisibles des autres ; sans
+// authentification, un seul espace partagé, comme avant.
+//
+// Stockage en mémoire, sauvegardé dans PRESETS_FILE s'il est défini (sinon perdu au redémarrage).
+// Un PRESETS_FILE d'avant le cloisonnement (presets par nom) est chargé dans l'espace partagé.
+
+const (
+	maxPresets       = 256     // protège la mémoire de l'API — un preset embarque son logo
+	maxTenantPresets = 32      // un tenant ne remplit pas seul le stock commun
+	maxPresetBytes   = 8 << 20 // formulaire POST /presets, logo compris
+)
+
+// presetName : le nom apparaît dans les URLs et les logs — pas d'espace ni de caractère spécial.
+var presetName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// preset est une configuration watermark : champs texte et fichiers (logo, police) du formulaire.
+type preset struct {
+	Fields map[string]string      `json:"fields"`
+	Files  map[string]*presetFile `json:"files,omitempty"`
+}
+
+// presetFile est la forme sérialisable d'un formFile — encoding/json ignore les champs non exportés.
+type presetFile struct {
+	Name string `json:"name"`
+	Data []byte `json:"data"` // base64 dans PRESETS_FILE
+}
+
+// presetFields : réglages qu'un preset peut fixer — tous les champs watermark relayés à l'optimizer.
+var presetFields = append([]string{"wm_text", "wm_position", "wm_format"}, wmPassthrough...)
+
+var (
+	presetsMu sync.RWMutex
+	presets   = map[string]map[string]*preset{} // tenant (presetOwner) → nom → preset
+	nPresets  int                               // tous tenants confondus
+)
+
+// presetsFile : contenu de PRESETS_FILE.
+type presetsFile struct {
+	Tenants map[string]map[string]*preset `json:"tenants"`
+}
+
+// presetOwner retourne l'espace de presets de r : clientKey avec l'authentification, "" sans.
+func presetOwner(r *http.Request) string {
+	if claimsFrom(r.Context()) == nil {
+		return ""
+	}
+	return clientKey(r)
+}
+
+// loadPresets relit PRESETS_FILE au démarrage. Un fichier absent n'est pas une erreur (premier lancement).
+func loadPresets() error {
+	path := os.Getenv("PRESETS_FILE")
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var file presetsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("%s : %w", path, err)
+	}
+	if file.Tenants == nil { // ancien format : nom → preset, sans tenant
+		legacy := map[string]*preset{}
+		if err := json.Unmarshal(data, &legacy); err != nil {
+			return fmt.Errorf("%s : %w", path, err)
+		}
+		file.Tenants = map[string]map[string]*preset{"": legacy}
+		logger.Warn().Str("component", "init").Str("file", path).Int("presets", len(legacy)).Msg("presets sans tenant : chargés dans l'espace partagé (sans authentification)")
+	}
+	presets, nPresets = file.Tenants, 0
+	for _, m := range presets {
+		nPresets += len(m)
+	}
+	logger.Info().Str("component", "init").Str("file", path).Int("presets", nPresets).Int("tenants", len(presets)).Msg("presets chargés")
+	return nil
+}
+
+// savePresets réécrit PRESETS_FILE — écriture dans un fichier temporaire puis rename, pour ne
+// jamais laisser un fichier tronqué si l'API s'arrête au milieu. Appelé sous presetsMu.
+func savePresets() error {
+	path := os.Getenv("PRESETS_FILE")
+	if path == "" {
+		return nil
+	}
+	data, err := json.Marshal(presetsFile{Tenants: presets})
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".tmp", data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// handleCreatePreset enregistre (ou remplace) un preset : champ "name" + champs wm_* et fichiers wm_*.
+func handleCreatePreset(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxPresetBytes)
+	if err := r.ParseMultipartForm(maxPresetBytes); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Formulaire invalide")
+		return
+	}
+	name := r.FormValue("name")
+	if !presetName.MatchString(name) {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Nom de preset invalide (lettres, chiffres, - et _, 64 caractères max)")
+		return
+	}
+
+	p := &preset{Fields: map[string]string{}, Files: map[string]*presetFile{}}
+	for _, k := range presetFields {
+		if v := r.FormValue(k); v != "" {
+			p.Fields[k] = v
+		}
+	}
+	for k := range r.MultipartForm.File {
+		if !strings.HasPrefix(k, wmFilePrefix) {
+			continue
+		}
+		f, err := optionalFile(r, k)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Fichier illisible : "+k)
+			return
+		}
+		p.Files[k] = &presetFile{Name: f.name, Data: f.data}
+	}
+	if len(p.Fields) == 0 && len(p.Files) == 0 {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Preset vide : aucun champ wm_*")
+		return
+	}
+
+	owner := presetOwner(r)
+	presetsMu.Lock()
+	defer presetsMu.Unlock()
+	mine := presets[owner]
+	_, replaced := mine[name]
+	if !replaced && len(mine) >= maxTenantPresets {
+		writeError(w, http.StatusInsufficientStorage, codeStorageFull, fmt.Sprintf("Trop de presets (max %d par tenant)", maxTenantPresets))
+		return
+	}
+	if !replaced && nPresets >= maxPresets {
+		writeError(w, http.StatusInsufficientStorage, codeStorageFull, fmt.Sprintf("Trop de presets (max %d)", maxPresets))
+		return
+	}
+	if mine == nil {
+		mine = map[string]*preset{}
+		presets[owner] = mine
+	}
+	mine[name] = p
+	if !replaced {
+		nPresets++
+	}
+	if err := savePresets(); err != nil { // le preset reste utilisable en mémoire — seule la persistance a échoué
+		logger.Error().Str("step", "preset").Str("name", name).Err(err).Msg("sauvegarde presets KO")
+	}
+	logger.Info().Str("step", "preset").Str("tenant", owner).Str("name", name).Bool("replaced", replaced).Strs("fields", slices.Sorted(maps.Keys(p.Fields))).Strs("files", slices.Sorted(maps.Keys(p.Files))).Msg("preset enregistré")
+
+	status := http.StatusCreated
+	if replaced {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+}
+
+// presetInfo : un preset tel que listé par GET /presets.
+type presetInfo struct {
+	Fields map[string]string `json:"fields"`
+	Files  []string          `json:"files"` // noms des champs fichier (wm_logo…), sans leur contenu
+}
+
+// handleListPresets retourne les presets du tenant et leurs champs texte — les fichiers sont listés
+// par nom de champ.
+func handleListPresets(w http.ResponseWriter, r *http.Request) {
+	presetsMu.RLock()
+	mine := presets[presetOwner(r)]
+	list := make(map[string]presetInfo, len(mine))
+	for name, p := range mine {
+		list[name] = presetInfo{Fields: p.Fields, Files: slices.Sorted(maps.Keys(p.Files))}
+	}
+	presetsMu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list) //nolint:errcheck — erreur réseau côté client, pas récupérable
+}
+
+// lookupPreset retourne le preset nommé du tenant de r, nil s'il n'existe pas.
+// Le preset est en lecture seule : il est remplacé en bloc, jamais modifié sur place.
+func lookupPreset(r *http.Request, name string) *preset {
+	presetsMu.RLock()
+	defer presetsMu.RUnlock()
+	return presets[presetOwner(r)][name]
+}
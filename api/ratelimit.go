@@ -0,0 +1,130 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ── Limitation de débit ───────────────────────────────────────────────────────
+// Seau à jetons par client : RATE_LIMIT_RPS jetons par seconde, au plus RATE_LIMIT_BURST en réserve.
+// Une requête coûte un jeton, un lot /upload/batch un par image (plafonné au burst). Seau vide :
+// 429 avec Retry-After — un intégrateur trop bavard ne monopolise plus les slots de l'optimizer,
+// dont le worker pool est borné au nombre de CPU. Sans RATE_LIMIT_RPS, pas de limite.
+//
+// Le client est le tenant du JWT, à défaut son sujet, à défaut l'adresse IP. Derrière un reverse
+// proxy, RATE_LIMIT_TRUST_PROXY=true prend la dernière adresse de X-Forwarded-For (celle qu'a vue
+// le proxy) — jamais sans proxy : le header serait choisi par le client.
+//
+// Les seaux sont en mémoire : chaque réplique de l'API applique la limite de son côté.
+
+const defaultRateBurst = 10
+
+var (
+	rateRPS    float64 // 0 : limitation désactivée
+	rateBurst  float64
+	trustProxy bool
+
+	rateMu    sync.Mutex
+	buckets   = map[string]*bucket{}
+	lastSweep time.Time
+)
+
+// bucket : jetons disponibles à l'instant last.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// initRateLimit lit RATE_LIMIT_* — appelé au démarrage.
+func initRateLimit() {
+	rps, _ := strconv.ParseFloat(os.Getenv("RATE_LIMIT_RPS"), 64)
+	if rps <= 0 {
+		return
+	}
+	rateRPS = rps
+	rateBurst = defaultRateBurst
+	if n, err := strconv.Atoi(os.Getenv("RATE_LIMIT_BURST")); err == nil && n >= 1 {
+		rateBurst = float64(n)
+	}
+	trustProxy = os.Getenv("RATE_LIMIT_TRUST_PROXY") == "true"
+	logger.Info().Str("component", "init").Float64("rps", rateRPS).Float64("burst", rateBurst).Bool("trust_proxy", trustProxy).Msg("limitation de débit activée")
+}
+
+// rateLimitMiddleware refuse en 429 les requêtes d'un client dont le seau est vide.
+// Placé après authMiddleware : la clé du client vient des claims du JWT.
+func rateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rateRPS > 0 && !allowRequest(w, r, 1) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowRequest prélève cost jetons pour le client de r. Seau insuffisant : répond 429 et retourne false.
+func allowRequest(w http.ResponseWriter, r *http.Request, cost float64) bool {
+	key := clientKey(r)
+	ok, retry := takeTokens(key, min(cost, rateBurst), time.Now())
+	if ok {
+		return true
+	}
+	logger.Warn().Str("step", "rate_limit").Str("client", key).Str("path", r.URL.Path).Dur("retry_after", retry).Msg("débit dépassé")
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+	writeError(w, http.StatusTooManyRequests, codeRateLimited, "Trop de requêtes, réessayer plus tard")
+	return false
+}
+
+// takeTokens prélève cost jetons du seau key. Sinon, retourne le délai avant qu'il y en ait assez.
+func takeTokens(key string, cost float64, now time.Time) (bool, time.Duration) {
+	rateMu.Lock()
+	defer rateMu.Unlock()
+	if now.Sub(lastSweep) > time.Minute { // seaux pleins depuis longtemps : inutiles, on les oublie
+		full := time.Duration(rateBurst / rateRPS * float64(time.Second))
+		for k, b := range buckets {
+			if now.Sub(b.last) > full {
+				delete(buckets, k)
+			}
+		}
+		lastSweep = now
+	}
+	b := buckets[key]
+	if b == nil {
+		b = &bucket{tokens: rateBurst, last: now}
+		buckets[key] = b
+	}
+	b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*rateRPS, rateBurst)
+	b.last = now
+	if b.tokens < cost {
+		return false, time.Duration((cost - b.tokens) / rateRPS * float64(time.Second))
+	}
+	b.tokens -= cost
+	return true, 0
+}
+
+// clientKey identifie le client de r pour la limitation : tenant, sujet du JWT, ou adresse IP.
+func clientKey(r *http.Request) string {
+	if c := claimsFrom(r.Context()); c != nil {
+		if c.tenant != "" {
+			return "tenant:" + c.tenant
+		}
+		if c.subject != "" {
+			return "sub:" + c.subject
+		}
+	}
+	if trustProxy {
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			return "ip:" + strings.TrimSpace(xff[strings.LastIndex(xff, ",")+1:])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
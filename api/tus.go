@@ -0,0 +1,484 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ── Uploads reprenables (tus 1.0) ─────────────────────────────────────────────
+// Un original de 40 Mo envoyé depuis un mobile en 4G échoue souvent en route, et /upload repart
+// de zéro. Le protocole tus (https://tus.io/protocols/resumable-upload) découpe l'envoi :
+//   POST   /uploads        Upload-Length (+ Upload-Metadata: filename …) → 201, Location /uploads/{id}
+//   HEAD   /uploads/{id}   → Upload-Offset : ce que le serveur a déjà reçu
+//   PATCH  /uploads/{id}   Upload-Offset + octets suivants → 204, nouvel Upload-Offset
+//   DELETE /uploads/{id}   abandon (extension termination)
+// Une fois complet, l'original entre dans le pipeline normal : POST /upload avec upload_id=<id> à la
+// place de la part "image", et les champs habituels. L'upload reste réutilisable (autres réglages)
+// jusqu'à son expiration, tusExpiry après sa création.
+//
+// Les morceaux sont assemblés sur disque dans UPLOAD_DIR (défaut : répertoire temporaire) — une
+// seule réplique de l'API doit donc recevoir tous les morceaux d'un upload (affinité de session).
+// Avec l'authentification, un upload n'est visible que du tenant qui l'a créé.
+//
+// Place disque bornée : au plus TUS_MAX_UPLOADS_PER_CLIENT uploads vivants (non expirés, complets
+// ou non) par client — même clé que la limitation de débit — et, tous clients confondus, au plus
+// TUS_MAX_UPLOADS uploads et TUS_MAX_BYTES octets annoncés (Upload-Length, réservés à la création).
+// À la limite, les uploads expirés sont nettoyés avant de refuser : 429 pour le client, 507 au total.
+
+const (
+	tusVersion    = "1.0.0"
+	tusExpiry     = 24 * time.Hour
+	tusSweepEvery = time.Hour
+
+	defaultTusMaxPerClient = 20
+	defaultTusMaxUploads   = 1000
+	defaultTusMaxBytes     = 10 << 30
+)
+
+// tusID : identifiant d'upload, 128 bits aléatoires en hexadécimal — utilisé tel quel comme nom de fichier.
+var tusID = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// tusInfo : état d'un upload, sauvegardé à côté des données (<id>.json).
+type tusInfo struct {
+	Length   int64             `json:"length"`
+	Offset   int64             `json:"offset"`
+	Metadata map[string]string `json:"metadata"`
+	Tenant   string            `json:"tenant,omitempty"`
+	Client   string            `json:"client,omitempty"` // clientKey du créateur, pour TUS_MAX_UPLOADS_PER_CLIENT
+	Expires  time.Time         `json:"expires"`
+}
+
+var (
+	uploadDir       string
+	tusMaxPerClient = defaultTusMaxPerClient
+	tusMaxUploads   = defaultTusMaxUploads
+	tusMaxBytes     = int64(defaultTusMaxBytes)
+
+	tusMu      sync.Mutex
+	tusBusy    = map[string]bool{}     // uploads dont un PATCH est en cours — un seul à la fois
+	tusLive    = map[string]*tusInfo{} // uploads comptés dans les limites, par id
+	tusClients = map[string]int{}      // client → uploads comptés
+	tusBytes   int64                   // Upload-Length cumulés des uploads comptés
+)
+
+// initTus prépare UPLOAD_DIR et lance le nettoyage des uploads expirés — appelé au démarrage.
+func initTus() error {
+	uploadDir = os.Getenv("UPLOAD_DIR")
+	if uploadDir == "" {
+		uploadDir = filepath.Join(os.TempDir(), "watermark-uploads")
+	}
+	if err := os.MkdirAll(uploadDir, 0o700); err != nil {
+		return err
+	}
+	if n, err := strconv.Atoi(os.Getenv("TUS_MAX_UPLOADS_PER_CLIENT")); err == nil && n >= 1 {
+		tusMaxPerClient = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("TUS_MAX_UPLOADS")); err == nil && n >= 1 {
+		tusMaxUploads = n
+	}
+	if n, err := strconv.ParseInt(os.Getenv("TUS_MAX_BYTES"), 10, 64); err == nil && n >= 1 {
+		tusMaxBytes = n
+	}
+	go func() {
+		for ; ; time.Sleep(tusSweepEvery) { // premier passage au démarrage : compte aussi les uploads existants
+			sweepUploads(time.Now())
+		}
+	}()
+	logger.Info().Str("component", "init").Str("upload_dir", uploadDir).Int("max_per_client", tusMaxPerClient).Int("max_uploads", tusMaxUploads).
+		Str("max_bytes", formatBytes(int(tusMaxBytes))).Msg("uploads reprenables (tus)")
+	return nil
+}
+
+// checkUploadLimits vérifie qu'un nouvel upload de length octets tient dans les limites de client —
+// sinon, retourne le statut, le code et le message d'erreur. tusMu est tenu par l'appelant.
+func checkUploadLimits(client string, length int64) (int, errorCode, string) {
+	switch {
+	case tusClients[client] >= tusMaxPerClient:
+		return http.StatusTooManyRequests, codeRateLimited, fmt.Sprintf("Trop d'uploads en cours (max %d par client)", tusMaxPerClient)
+	case len(tusLive) >= tusMaxUploads:
+		return http.StatusInsufficientStorage, codeStorageFull, fmt.Sprintf("Trop d'uploads en cours (max %d)", tusMaxUploads)
+	case tusBytes+length > tusMaxBytes:
+		return http.StatusInsufficientStorage, codeStorageFull, fmt.Sprintf("Espace d'upload plein (max %s)", formatBytes(int(tusMaxBytes)))
+	}
+	return 0, "", ""
+}
+
+// countUpload compte l'upload id dans les limites — tusMu est tenu par l'appelant.
+func countUpload(id string, info *tusInfo) {
+	tusLive[id] = info
+	tusClients[info.Client]++
+	tusBytes += info.Length
+}
+
+// uncountUpload retire l'upload id des limites, s'il y était — tusMu est tenu par l'appelant.
+func uncountUpload(id string) {
+	info, ok := tusLive[id]
+	if !ok {
+		return
+	}
+	delete(tusLive, id)
+	if tusClients[info.Client]--; tusClients[info.Client] <= 0 {
+		delete(tusClients, info.Client)
+	}
+	tusBytes -= info.Length
+}
+
+// tryLockUpload marque l'upload id occupé par un PATCH — false si un autre est déjà en cours.
+func tryLockUpload(id string) bool {
+	tusMu.Lock()
+	defer tusMu.Unlock()
+	if tusBusy[id] {
+		return false
+	}
+	tusBusy[id] = true
+	return true
+}
+
+func unlockUpload(id string) {
+	tusMu.Lock()
+	delete(tusBusy, id)
+	tusMu.Unlock()
+}
+
+func tusPath(id string) string { return filepath.Join(uploadDir, id) }
+
+// readTusInfo relit l'état de l'upload id — os.ErrNotExist s'il est inconnu ou expiré.
+func readTusInfo(id string) (*tusInfo, error) {
+	if !tusID.MatchString(id) {
+		return nil, os.ErrNotExist
+	}
+	data, err := os.ReadFile(tusPath(id) + ".json")
+	if err != nil {
+		return nil, err
+	}
+	info := &tusInfo{}
+	if err := json.Unmarshal(data, info); err != nil {
+		return nil, err
+	}
+	if time.Now().After(info.Expires) {
+		return nil, os.ErrNotExist
+	}
+	return info, nil
+}
+
+// writeTusInfo sauvegarde l'état de l'upload id — fichier temporaire puis rename, comme savePresets.
+func writeTusInfo(id string, info *tusInfo) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(tusPath(id)+".json.tmp", data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tusPath(id)+".json.tmp", tusPath(id)+".json")
+}
+
+// sweepUploads supprime les uploads expirés et les fichiers orphelins, puis recompte les uploads
+// vivants — sous tusMu : une création attend la fin du passage.
+func sweepUploads(now time.Time) {
+	tusMu.Lock()
+	defer tusMu.Unlock()
+	entries, err := os.ReadDir(uploadDir)
+	if err != nil {
+		logger.Error().Str("step", "tus").Err(err).Msg("nettoyage uploads KO")
+		return
+	}
+	removed := 0
+	tusLive, tusClients, tusBytes = map[string]*tusInfo{}, map[string]int{}, 0
+	for _, e := range entries {
+		id, ext, _ := strings.Cut(e.Name(), ".")
+		if !tusID.MatchString(id) {
+			continue
+		}
+		if info, err := readTusInfo(id); err == nil {
+			if ext == "json" {
+				countUpload(id, info)
+			}
+			continue
+		}
+		if tusBusy[id] {
+			continue // PATCH en cours : l'état se réécrit, pas encore expiré pour lui
+		}
+		os.Remove(filepath.Join(uploadDir, e.Name())) //nolint:errcheck — retenté au prochain passage
+		removed++
+	}
+	if removed > 0 {
+		logger.Info().Str("step", "tus").Int("files", removed).Msg("uploads expirés supprimés")
+	}
+}
+
+// lookupUpload retourne l'état de l'upload de la requête, ou répond 404 (inconnu, expiré, autre tenant).
+func lookupUpload(w http.ResponseWriter, r *http.Request, id string) (*tusInfo, bool) {
+	info, err := readTusInfo(id)
+	if err == nil && canAccessUpload(r, info) {
+		return info, true
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.Error().Str("step", "tus").Str("id", id).Err(err).Msg("état d'upload illisible")
+	}
+	writeError(w, http.StatusNotFound, codeNotFound, "Upload inconnu ou expiré")
+	return nil, false
+}
+
+// canAccessUpload : même règle que l'index des images — le tenant du créateur, ou le scope admin.
+func canAccessUpload(r *http.Request, info *tusInfo) bool {
+	claims := claimsFrom(r.Context())
+	return claims == nil || claims.hasScope(adminScope) || info.Tenant == claims.tenant
+}
+
+// tusHeaders pose les headers communs aux réponses tus.
+func tusHeaders(w http.ResponseWriter) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Cache-Control", "no-store") // Upload-Offset change à chaque PATCH
+}
+
+// checkTusVersion refuse en 412 un client qui ne parle pas tus 1.0.0.
+func checkTusVersion(w http.ResponseWriter, r *http.Request) bool {
+	if r.Header.Get("Tus-Resumable") == tusVersion {
+		return true
+	}
+	w.Header().Set("Tus-Version", tusVersion)
+	writeError(w, http.StatusPreconditionFailed, codeUnsupportedVersion, "Tus-Resumable: 1.0.0 attendu")
+	return false
+}
+
+// handleTusOptions décrit le serveur tus (découverte). Un preflight CORS est traité avant, par corsMiddleware.
+func handleTusOptions(w http.ResponseWriter, r *http.Request) {
+	tusHeaders(w)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", "creation,termination,expiration")
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(maxUploadSize, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleTusCreate crée un upload vide de Upload-Length octets.
+func handleTusCreate(w http.ResponseWriter, r *http.Request) {
+	tusHeaders(w)
+	if !checkTusVersion(w, r) {
+		return
+	}
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Upload-Length manquant ou invalide (Upload-Defer-Length non supporté)")
+		return
+	}
+	if length > maxUploadSize { // même limite que /upload, qui recevra l'original complet
+		writeError(w, http.StatusRequestEntityTooLarge, codeImageTooLarge, fmt.Sprintf("Upload trop volumineux (max %s)", formatBytes(int(maxUploadSize))))
+		return
+	}
+	meta, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Upload-Metadata invalide")
+		return
+	}
+
+	var raw [16]byte
+	rand.Read(raw[:]) //nolint:errcheck — crypto/rand ne retourne pas d'erreur depuis Go 1.24
+	id := hex.EncodeToString(raw[:])
+	info := &tusInfo{Length: length, Metadata: meta, Client: clientKey(r), Expires: time.Now().Add(tusExpiry).UTC()}
+	if c := claimsFrom(r.Context()); c != nil {
+		info.Tenant = c.tenant
+	}
+	if status, code, msg := createUpload(id, info); status != 0 {
+		writeError(w, status, code, msg)
+		return
+	}
+	logger.Info().Str("step", "tus").Str("id", id).Str("filename", meta["filename"]).Str("size", formatBytes(int(length))).Msg("upload créé")
+
+	w.Header().Set("Location", versionPrefix(r)+"/uploads/"+id) // /v1/uploads/… si le client est passé par /v1
+	w.Header().Set("Upload-Expires", info.Expires.Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// createUpload réserve la place de l'upload et crée ses fichiers, sous tusMu : un passage de
+// sweepUploads ne le voit jamais à moitié créé. Limite atteinte : un nettoyage, puis un second essai.
+// Retourne le statut d'erreur à répondre, 0 en cas de succès.
+func createUpload(id string, info *tusInfo) (int, errorCode, string) {
+	tusMu.Lock()
+	status, code, msg := checkUploadLimits(info.Client, info.Length)
+	if status != 0 {
+		tusMu.Unlock()
+		sweepUploads(time.Now()) // des uploads expirés occupent peut-être encore la place
+		tusMu.Lock()
+		status, code, msg = checkUploadLimits(info.Client, info.Length)
+	}
+	defer tusMu.Unlock()
+	if status != 0 {
+		logger.Warn().Str("step", "tus").Str("client", info.Client).Int("uploads", len(tusLive)).Int64("bytes", tusBytes).Msg(msg)
+		return status, code, msg
+	}
+	err := os.WriteFile(tusPath(id), nil, 0o600)
+	if err == nil {
+		err = writeTusInfo(id, info)
+	}
+	if err != nil {
+		os.Remove(tusPath(id)) //nolint:errcheck — le sweep finira le ménage
+		logger.Error().Str("step", "tus").Err(err).Msg("création upload KO")
+		return http.StatusInternalServerError, codeInternal, "Erreur création upload"
+	}
+	countUpload(id, info)
+	return 0, "", ""
+}
+
+// handleTusHead indique où reprendre l'envoi.
+func handleTusHead(w http.ResponseWriter, r *http.Request) {
+	tusHeaders(w)
+	if !checkTusVersion(w, r) {
+		return
+	}
+	info, ok := lookupUpload(w, r, r.PathValue("id"))
+	if !ok {
+		return
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(info.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(info.Length, 10))
+	w.Header().Set("Upload-Expires", info.Expires.Format(http.TimeFormat))
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleTusPatch ajoute le corps de la requête à l'upload, à partir de Upload-Offset. Une connexion
+// coupée garde ce qui a été reçu : le client relit l'offset par HEAD et reprend de là.
+func handleTusPatch(w http.ResponseWriter, r *http.Request) {
+	tusHeaders(w)
+	if !checkTusVersion(w, r) {
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		writeError(w, http.StatusUnsupportedMediaType, codeInvalidRequest, "Content-Type: application/offset+octet-stream attendu")
+		return
+	}
+	id := r.PathValue("id")
+	if !tryLockUpload(id) { // un autre PATCH est en cours sur cet upload
+		writeError(w, http.StatusConflict, codeUploadBusy, "Envoi déjà en cours pour cet upload")
+		return
+	}
+	defer unlockUpload(id)
+	info, ok := lookupUpload(w, r, id)
+	if !ok {
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset != info.Offset {
+		writeError(w, http.StatusConflict, codeUploadConflict, fmt.Sprintf("Upload-Offset %q ne correspond pas à l'offset courant %d", r.Header.Get("Upload-Offset"), info.Offset))
+		return
+	}
+
+	f, err := os.OpenFile(tusPath(id), os.O_WRONLY, 0)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Erreur écriture upload")
+		return
+	}
+	n, copyErr := io.Copy(&offsetWriter{f: f, off: offset}, io.LimitReader(r.Body, info.Length-offset))
+	if err := f.Close(); copyErr == nil {
+		copyErr = err
+	}
+	info.Offset += n // octets reçus avant une coupure : conservés
+	if err := writeTusInfo(id, info); err != nil {
+		logger.Error().Str("step", "tus").Str("id", id).Err(err).Msg("sauvegarde offset KO")
+		writeError(w, http.StatusInternalServerError, codeInternal, "Erreur écriture upload")
+		return
+	}
+	if copyErr != nil {
+		logger.Warn().Str("step", "tus").Str("id", id).Int64("offset", info.Offset).Err(copyErr).Msg("envoi interrompu")
+		return // client déjà parti : il reprendra après un HEAD
+	}
+	logger.Info().Str("step", "tus").Str("id", id).Int64("offset", info.Offset).Int64("length", info.Length).Bool("complete", info.Offset == info.Length).Msg("morceau reçu")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(info.Offset, 10))
+	w.Header().Set("Upload-Expires", info.Expires.Format(http.TimeFormat))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleTusDelete abandonne un upload.
+func handleTusDelete(w http.ResponseWriter, r *http.Request) {
+	tusHeaders(w)
+	if !checkTusVersion(w, r) {
+		return
+	}
+	id := r.PathValue("id")
+	if _, ok := lookupUpload(w, r, id); !ok {
+		return
+	}
+	if !removeUpload(id) {
+		writeError(w, http.StatusConflict, codeUploadBusy, "Envoi en cours pour cet upload : réessayer après")
+		return
+	}
+	logger.Info().Str("step", "tus").Str("id", id).Msg("upload supprimé")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// removeUpload supprime l'état et les données de l'upload id — DELETE, ou purge d'administration.
+// Retourne false, sans rien supprimer, si un PATCH est en cours : tout se passe sous tusMu, un PATCH
+// ne peut pas démarrer pendant la suppression.
+func removeUpload(id string) bool {
+	tusMu.Lock()
+	defer tusMu.Unlock()
+	if tusBusy[id] {
+		return false
+	}
+	os.Remove(tusPath(id) + ".json") //nolint:errcheck — sans état, l'upload est inconnu ; le sweep finira le ménage
+	os.Remove(tusPath(id))           //nolint:errcheck
+	uncountUpload(id)
+	return true
+}
+
+// completedUpload retourne le contenu et le nom de fichier d'un upload complet, pour /upload.
+func completedUpload(r *http.Request, id string) ([]byte, string, error) {
+	info, err := readTusInfo(id)
+	if err != nil || !canAccessUpload(r, info) {
+		return nil, "", errors.New("upload inconnu ou expiré")
+	}
+	if info.Offset < info.Length {
+		return nil, "", fmt.Errorf("upload incomplet (%d / %d octets)", info.Offset, info.Length)
+	}
+	data, err := os.ReadFile(tusPath(id))
+	if err != nil {
+		return nil, "", err
+	}
+	name := info.Metadata["filename"]
+	if name == "" {
+		name = id
+	}
+	return data, name, nil
+}
+
+// parseTusMetadata lit Upload-Metadata : paires "clé valeur-base64" séparées par des virgules.
+func parseTusMetadata(h string) (map[string]string, error) {
+	meta := map[string]string{}
+	for pair := range strings.SplitSeq(h, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if k == "" {
+			continue
+		}
+		val, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, err
+		}
+		meta[k] = string(val)
+	}
+	return meta, nil
+}
+
+// offsetWriter écrit séquentiellement dans f à partir de off.
+type offsetWriter struct {
+	f   *os.File
+	off int64
+}
+
+func (o *offsetWriter) Write(p []byte) (int, error) {
+	n, err := o.f.WriteAt(p, o.off)
+	o.off += int64(n)
+	return n, err
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ── Quotas et consommation ────────────────────────────────────────────────────
+// Chaque requête POST (les traitements : upload, lot, PDF, vidéo…) est comptée par client — même
+// clé que la limitation de débit : tenant du JWT, sujet, ou IP — et par mois calendaire UTC :
+// requêtes, octets reçus et envoyés, secondes passées dans l'optimizer (durée des appels, pas du
+// temps CPU mesuré : l'optimizer ne le connaît pas par requête). Les GET ne sont pas comptés, les
+// PATCH (morceaux d'upload tus) pour leurs octets seulement.
+//
+// Quotas mensuels, identiques pour chaque client, vérifiés avant le traitement :
+//   - QUOTA_MONTHLY_REQUESTS atteint → 429, Retry-After jusqu'au 1er du mois suivant ;
+//   - QUOTA_MONTHLY_BYTES (reçus + envoyés) atteint → 402 : le volume se rachète, pas le débit.
+// Une requête en cours n'est pas coupée : le dépassement se constate à la suivante.
+//
+// GET /usage retourne la consommation du mois (ou de ?month=AAAA-MM) du client appelant ; ?all=true
+// retourne celle de tous les clients, réservé à l'administration (cf. requireAdmin). Compteurs en
+// mémoire, sauvegardés toutes les usageSaveEvery dans USAGE_FILE s'il est défini — sinon perdus au
+// redémarrage.
+//
+// Mémoire bornée : les USAGE_RETENTION_MONTHS derniers mois seulement, et au plus USAGE_MAX_CLIENTS
+// clients par mois — au-delà, les plus petits consommateurs sont oubliés (des IP de passage, loin
+// de tout quota), à chaque usageSaveEvery.
+
+const (
+	usageSaveEvery         = time.Minute
+	defaultUsageMonths     = 12
+	defaultUsageMaxClients = 100_000
+)
+
+// usageCounters : consommation d'un client sur un mois.
+type usageCounters struct {
+	Requests         int64   `json:"requests"`
+	BytesIn          int64   `json:"bytes_in"`
+	BytesOut         int64   `json:"bytes_out"`
+	OptimizerSeconds float64 `json:"optimizer_seconds"`
+}
+
+var (
+	quotaRequests   int64 // 0 : pas de quota
+	quotaBytes      int64
+	usageMonths     = defaultUsageMonths
+	usageMaxClients = defaultUsageMaxClients
+
+	usageMu    sync.Mutex
+	usage      = map[string]map[string]*usageCounters{} // mois (AAAA-MM) → client → compteurs
+	usageDirty bool                                     // modifié depuis la dernière sauvegarde
+)
+
+type usageKey struct{}
+
+// usageMonth retourne le mois de facturation de t.
+func usageMonth(t time.Time) string {
+	return t.UTC().Format("2006-01")
+}
+
+// initUsage lit les quotas, recharge USAGE_FILE et lance la sauvegarde périodique — appelé au démarrage.
+func initUsage() error {
+	quotaRequests, _ = strconv.ParseInt(os.Getenv("QUOTA_MONTHLY_REQUESTS"), 10, 64)
+	quotaBytes, _ = strconv.ParseInt(os.Getenv("QUOTA_MONTHLY_BYTES"), 10, 64)
+	if n, err := strconv.Atoi(os.Getenv("USAGE_RETENTION_MONTHS")); err == nil && n >= 1 {
+		usageMonths = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("USAGE_MAX_CLIENTS")); err == nil && n >= 1 {
+		usageMaxClients = n
+	}
+	path := os.Getenv("USAGE_FILE")
+	logger.Info().Str("component", "init").Int64("quota_requests", quotaRequests).Int64("quota_bytes", quotaBytes).Str("usage_file", path).
+		Int("retention_months", usageMonths).Int("max_clients", usageMaxClients).Msg("quotas mensuels")
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err == nil {
+			if err := json.Unmarshal(data, &usage); err != nil {
+				return fmt.Errorf("%s : %w", path, err)
+			}
+		}
+	}
+	go func() {
+		for now := range time.Tick(usageSaveEvery) {
+			pruneUsage(now)
+			if path == "" {
+				continue
+			}
+			if err := saveUsage(path); err != nil {
+				logger.Error().Str("step", "usage").Err(err).Msg("sauvegarde consommation KO")
+			}
+		}
+	}()
+	return nil
+}
+
+// pruneUsage oublie les mois hors rétention et, dans chaque mois, les plus petits consommateurs
+// au-delà de usageMaxClients.
+func pruneUsage(now time.Time) {
+	y, m, _ := now.UTC().Date()
+	oldest := usageMonth(time.Date(y, m-time.Month(usageMonths-1), 1, 0, 0, 0, 0, time.UTC))
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	for month, clients := range usage {
+		if month < oldest { // AAAA-MM : l'ordre des chaînes est celui des mois
+			delete(usage, month)
+			usageDirty = true
+			logger.Info().Str("step", "usage").Str("month", month).Int("clients", len(clients)).Msg("mois hors rétention oublié")
+			continue
+		}
+		if len(clients) <= usageMaxClients {
+			continue
+		}
+		keys := slices.SortedFunc(maps.Keys(clients), func(a, b string) int {
+			ca, cb := clients[a], clients[b]
+			return cmp.Or(cmp.Compare(ca.Requests, cb.Requests), cmp.Compare(ca.BytesIn+ca.BytesOut, cb.BytesIn+cb.BytesOut))
+		})
+		for _, k := range keys[:len(keys)-usageMaxClients] {
+			delete(clients, k)
+		}
+		usageDirty = true
+		logger.Warn().Str("step", "usage").Str("month", month).Int("dropped", len(keys)-usageMaxClients).Msg("trop de clients : petits consommateurs oubliés")
+	}
+}
+
+// saveUsage réécrit path s'il y a eu des requêtes depuis la dernière sauvegarde — fichier
+// temporaire puis rename, comme savePresets.
+func saveUsage(path string) error {
+	usageMu.Lock()
+	if !usageDirty {
+		usageMu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(usage)
+	usageDirty = false
+	usageMu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".tmp", data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// counters retourne les compteurs de client pour month, créés au besoin — usageMu est tenu par l'appelant.
+func counters(month, client string) *usageCounters {
+	m := usage[month]
+	if m == nil {
+		m = map[string]*usageCounters{}
+		usage[month] = m
+	}
+	c := m[client]
+	if c == nil {
+		c = &usageCounters{}
+		m[client] = c
+	}
+	return c
+}
+
+// usageMiddleware applique les quotas et compte la consommation des requêtes POST et PATCH.
+// Placé après authMiddleware : la clé du client vient des claims du JWT.
+func usageMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodPatch {
+			next.ServeHTTP(w, r)
+			return
+		}
+		client, now := clientKey(r), time.Now()
+		month := usageMonth(now)
+		usageMu.Lock()
+		c := usageOf(month, client) // sans créer d'entrée : une requête refusée ne coûte rien en mémoire
+		usageMu.Unlock()
+		if quotaRequests > 0 && c.Requests >= quotaRequests {
+			y, m, _ := now.UTC().Date()
+			reset := time.Date(y, m+1, 1, 0, 0, 0, 0, time.UTC)
+			logger.Warn().Str("step", "usage").Str("client", client).Int64("requests", c.Requests).Msg("quota de requêtes atteint")
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(reset.Sub(now).Seconds()))))
+			writeError(w, http.StatusTooManyRequests, codeQuotaExceeded, fmt.Sprintf("Quota mensuel atteint (%d requêtes)", quotaRequests))
+			return
+		}
+		if quotaBytes > 0 && c.BytesIn+c.BytesOut >= quotaBytes {
+			logger.Warn().Str("step", "usage").Str("client", client).Int64("bytes", c.BytesIn+c.BytesOut).Msg("quota de volume atteint")
+			writeError(w, http.StatusPaymentRequired, codeQuotaExceeded, fmt.Sprintf("Quota mensuel atteint (%s transférés)", formatBytes(int(quotaBytes))))
+			return
+		}
+
+		delta := &usageCounters{}
+		if r.Method == http.MethodPost { // PATCH : morceau d'un upload tus, compté en octets seulement
+			delta.Requests = 1
+		}
+		body := &countingReader{r: r.Body}
+		r.Body = body
+		cw := &countingWriter{ResponseWriter: w}
+		next.ServeHTTP(cw, r.WithContext(context.WithValue(r.Context(), usageKey{}, delta)))
+		delta.BytesIn, delta.BytesOut = body.n, cw.n
+
+		usageMu.Lock()
+		c2 := counters(month, client) // mois du début de la requête : une requête n'est comptée qu'une fois
+		c2.Requests += delta.Requests
+		c2.BytesIn += delta.BytesIn
+		c2.BytesOut += delta.BytesOut
+		c2.OptimizerSeconds += delta.OptimizerSeconds
+		usageDirty = true
+		usageMu.Unlock()
+	})
+}
+
+// meterOptimizer ajoute d au temps optimizer de la requête en cours (sans effet hors usageMiddleware)
+// et à la métrique de l'étape optimizer.
+// Sûr depuis plusieurs goroutines : les images d'un lot sont traitées en parallèle.
+func meterOptimizer(ctx context.Context, d time.Duration) {
+	observeStage("optimizer", d)
+	if delta, ok := ctx.Value(usageKey{}).(*usageCounters); ok {
+		usageMu.Lock()
+		delta.OptimizerSeconds += d.Seconds()
+		usageMu.Unlock()
+	}
+}
+
+// chargeRequest compte une requête de plus dans la requête en cours (mutation upload GraphQL au-delà
+// de la première), refusée si le quota mensuel de requêtes est atteint. Sans effet hors usageMiddleware.
+func chargeRequest(ctx context.Context, client string) error {
+	delta, ok := ctx.Value(usageKey{}).(*usageCounters)
+	if !ok {
+		return nil
+	}
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	if quotaRequests > 0 && usageOf(usageMonth(time.Now()), client).Requests+delta.Requests >= quotaRequests {
+		return &apiError{Code: codeQuotaExceeded, Message: fmt.Sprintf("Quota mensuel atteint (%d requêtes)", quotaRequests)}
+	}
+	delta.Requests++
+	return nil
+}
+
+// usageOf retourne une copie des compteurs de client pour month (zéro s'il n'a rien consommé) —
+// usageMu est tenu par l'appelant.
+func usageOf(month, client string) usageCounters {
+	if c := usage[month][client]; c != nil {
+		return *c
+	}
+	return usageCounters{}
+}
+
+// countingReader compte les octets lus dans le body de la requête.
+type countingReader struct {
+	r io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReader) Close() error { return c.r.Close() }
+
+// countingWriter compte les octets écrits dans la réponse (compressés, si gzip).
+type countingWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.ResponseWriter.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Unwrap expose le ResponseWriter d'origine à http.ResponseController (Flush, délais).
+func (c *countingWriter) Unwrap() http.ResponseWriter { return c.ResponseWriter }
+
+// handleUsage retourne la consommation du mois du client appelant, ou de tous avec ?all=true (administration).
+func handleUsage(w http.ResponseWriter, r *http.Request) {
+	month := r.URL.Query().Get("month")
+	if month == "" {
+		month = usageMonth(time.Now())
+	} else if _, err := time.Parse("2006-01", month); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "month invalide (AAAA-MM)")
+		return
+	}
+	all := r.URL.Query().Get("all") == "true"
+	if all {
+		if msg := adminDenied(r); msg != "" { // scope admin, ou ADMIN_TOKEN sans authentification
+			writeError(w, http.StatusForbidden, codeForbidden, "all=true : "+msg)
+			return
+		}
+	}
+
+	quota := map[string]int64{"requests": quotaRequests, "bytes": quotaBytes} // 0 : illimité
+	w.Header().Set("Content-Type", "application/json")
+	if all {
+		usageMu.Lock() // copie sous le verrou, encodage après : un client lent ne bloque pas les compteurs
+		clients := make(map[string]usageCounters, len(usage[month]))
+		for k, c := range usage[month] {
+			clients[k] = *c
+		}
+		usageMu.Unlock()
+		json.NewEncoder(w).Encode(map[string]any{"month": month, "quota": quota, "clients": clients}) //nolint:errcheck — erreur réseau côté client, pas récupérable
+		return
+	}
+	client := clientKey(r)
+	usageMu.Lock()
+	c := usageOf(month, client)
+	usageMu.Unlock()
+	json.NewEncoder(w).Encode(map[string]any{"month": month, "client": client, "quota": quota, "usage": c}) //nolint:errcheck — erreur réseau côté client, pas récupérable
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// ── Versions de l'API ─────────────────────────────────────────────────────────
+// Chaque version majeure a son mux, monté sous /vN/ : /v1/upload, /v1/images… Les chemins sans
+// version (/upload, /images…) restent servis pour les intégrations existantes : la version vient
+// alors du header API-Version, 1 par défaut — et ce défaut ne changera pas. Une v2 (enveloppes
+// JSON au lieu des octets bruts, par exemple) s'ajoutera dans main sans toucher aux clients v1.
+// Chaque réponse porte le header API-Version de la version qui l'a servie.
+
+const defaultAPIVersion = "1"
+
+type versionPrefixKey struct{}
+
+// versionedMux sert chaque mux de versions sous /v<clé>/, et les chemins sans version selon API-Version.
+func versionedMux(versions map[string]*http.ServeMux) http.Handler {
+	known := strings.Join(slices.Sorted(maps.Keys(versions)), ", ")
+	root := http.NewServeMux()
+	for v, h := range versions {
+		prefix := "/v" + v
+		root.Handle(prefix+"/", http.StripPrefix(prefix, serveVersion(v, prefix, h)))
+	}
+	root.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) { // chemin sans version
+		v := r.Header.Get("API-Version")
+		if v == "" {
+			v = defaultAPIVersion
+		}
+		h := versions[v]
+		if h == nil {
+			writeError(w, http.StatusBadRequest, codeUnsupportedVersion, "API-Version inconnue : "+v+" (versions : "+known+")")
+			return
+		}
+		serveVersion(v, "", h).ServeHTTP(w, r)
+	})
+	return root
+}
+
+// serveVersion pose API-Version et retient le préfixe d'URL utilisé par le client (versionPrefix).
+// Route inconnue ou méthode non servie : erreur JSON (routeErrors).
+func serveVersion(v, prefix string, mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("API-Version", v)
+		routeErrors(mux, w, r.WithContext(context.WithValue(r.Context(), versionPrefixKey{}, prefix)))
+	})
+}
+
+// versionPrefix retourne le préfixe de version de l'URL de la requête ("/v1", ou "" sans version) —
+// pour construire des URLs dans la même forme que celle du client (Location de tus).
+func versionPrefix(r *http.Request) string {
+	p, _ := r.Context().Value(versionPrefixKey{}).(string)
+	return p
+}
@@ -0,0 +1,640 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"io"
+	"net/http"
+	"slices"
+
+	"golang.org/x/image/webp"
+)
+
+// ── Animations (GIF, WebP animé) ──────────────────────────────────────────────
+// Une animation passe image par image dans le pipeline d'une photo : chaque image est d'abord
+// recomposée en plein cadre (dispositions GIF, fusion et effacement WebP), puis orientée, recadrée,
+// réduite, retouchée et watermarkée, avant ré-encodage avec les durées et le nombre de boucles
+// d'origine. Le recadrage smart et wm_position=auto sont calculés sur la première image puis
+// figés : ni le cadre ni le watermark ne sautent d'une image à l'autre.
+// Sortie WebP animé si le client l'accepte (wm_format=webp), GIF sinon — ni JPEG ni PNG n'animent.
+// sizes et max_bytes ne s'appliquent pas aux animations (400) ; les métadonnées ne sont pas réécrites.
+
+const (
+	maxAnimFrames     = 500
+	maxAnimPixels     = 100_000_000 // images × largeur × hauteur — vérifié sur les blocs GIF avant DecodeAll
+	maxAnimFrameBytes = 32 << 20    // chunk ANMF — au-delà, le fichier est considéré corrompu
+	gifMaxColors      = 256
+	gifQuantBits      = 6 // bits par canal de l'histogramme de la coupe médiane
+)
+
+// Disposition d'une image après son affichage.
+const (
+	animKeep    = iota // laissée en place : l'image suivante se dessine par-dessus
+	animClear          // zone rendue transparente
+	animRestore        // zone remise dans l'état d'avant l'image (GIF uniquement)
+)
+
+// animFrame est une image de l'animation, telle que stockée dans le fichier source.
+type animFrame struct {
+	bounds  image.Rectangle // position sur le cadre
+	delay   int             // durée d'affichage en ms
+	over    bool            // fusion alpha avec le cadre — sinon la zone est remplacée
+	dispose int
+	decode  func() (image.Image, error) // décodage paresseux : une seule image décodée à la fois (WebP)
+}
+
+type animation struct {
+	width, height int
+	plays         int // nombre de lectures, 0 = en boucle
+	frames        []animFrame
+}
+
+// decodeAnimation retourne l'animation uploadée, ou nil si l'image n'est pas animée — un GIF d'une
+// seule image ou un WebP fixe suit le pipeline normal, qui signale aussi les formats refusés.
+func decodeAnimation(r *http.Request) (*animation, error) {
+	file, _, err := r.FormFile("image")
+	if err != nil {
+		return nil, nil // image manquante : signalée par decodeImage
+	}
+	defer file.Close()
+
+	var sig [12]byte
+	n, _ := io.ReadFull(file, sig[:])
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, nil
+	}
+	var anim *animation
+	switch {
+	case n >= 6 && string(sig[:4]) == "GIF8" && inputFormats["gif"]:
+		anim, err = readGIFAnimation(file)
+	case n == 12 && string(sig[:4]) == "RIFF" && string(sig[8:]) == "WEBP" && inputFormats["webp"]:
+		anim, err = readWebPAnimation(file)
+	}
+	if anim == nil || err != nil {
+		return nil, err
+	}
+	if anim.width > maxInputWidth || anim.height > maxInputHeight {
+		return nil, fmt.Errorf("image trop grande (max %dx%d, reçu %dx%d)", maxInputWidth, maxInputHeight, anim.width, anim.height)
+	}
+	if len(anim.frames) > maxAnimFrames || len(anim.frames)*anim.width*anim.height > maxAnimPixels {
+		return nil, errAnimTooLong
+	}
+	return anim, nil
+}
+
+var errAnimTooLong = fmt.Errorf("animation trop longue (max %d images, %d Mpx au total)", maxAnimFrames, maxAnimPixels/1_000_000)
+
+// readGIFAnimation décode toutes les images d'un GIF — nil s'il n'en a qu'une.
+func readGIFAnimation(f io.ReadSeeker) (*animation, error) {
+	cfg, err := gif.DecodeConfig(f)
+	if err != nil || cfg.Width > maxInputWidth || cfg.Height > maxInputHeight {
+		return nil, nil // refusé par decodeImage, avec son message habituel
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, nil
+	}
+	// gif.DecodeAll garde toutes les images en mémoire : les limites sont vérifiées avant, sur les blocs.
+	frames, err := countGIFFrames(f)
+	if err != nil {
+		return nil, errors.New("décodage échoué")
+	}
+	if frames < 2 {
+		return nil, nil
+	}
+	if frames > maxAnimFrames || frames*cfg.Width*cfg.Height > maxAnimPixels {
+		return nil, errAnimTooLong
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, nil
+	}
+	g, err := gif.DecodeAll(f)
+	if err != nil {
+		return nil, errors.New("décodage échoué")
+	}
+	if len(g.Image) < 2 {
+		return nil, nil
+	}
+	anim := &animation{width: g.Config.Width, height: g.Config.Height}
+	switch { // LoopCount : 0 = en boucle, -1 = une lecture, n = n répétitions
+	case g.LoopCount < 0:
+		anim.plays = 1
+	case g.LoopCount > 0:
+		anim.plays = g.LoopCount + 1
+	}
+	for i, m := range g.Image {
+		frame := animFrame{bounds: m.Bounds(), delay: g.Delay[i] * 10, over: true}
+		switch g.Disposal[i] {
+		case gif.DisposalBackground:
+			frame.dispose = animClear
+		case gif.DisposalPrevious:
+			frame.dispose = animRestore
+		}
+		frame.decode = func() (image.Image, error) { return m, nil }
+		anim.frames = append(anim.frames, frame)
+	}
+	return anim, nil
+}
+
+// countGIFFrames parcourt les blocs d'un GIF sans décompresser les images et retourne leur nombre
+// — arrêté au-delà de maxAnimFrames. Un fichier tronqué compte les images vues jusque-là.
+func countGIFFrames(f io.Reader) (int, error) {
+	br := bufio.NewReader(f)
+	var hdr [13]byte // signature + descripteur d'écran
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		return 0, err
+	}
+	if err := skipColorTable(br, hdr[10]); err != nil {
+		return 0, err
+	}
+	frames := 0
+	for frames <= maxAnimFrames {
+		kind, err := br.ReadByte()
+		if err != nil {
+			return frames, nil // pas de trailer : DecodeAll tranchera
+		}
+		switch kind {
+		case 0x21: // extension : étiquette puis sous-blocs
+			if _, err := br.ReadByte(); err != nil {
+				return frames, nil
+			}
+		case 0x2C: // descripteur d'image, table locale, taille de code LZW, sous-blocs
+			var desc [9]byte
+			if _, err := io.ReadFull(br, desc[:]); err != nil {
+				return frames, nil
+			}
+			if err := skipColorTable(br, desc[8]); err != nil {
+				return frames, nil
+			}
+			if _, err := br.ReadByte(); err != nil { // taille de code LZW
+				return frames, nil
+			}
+			frames++
+		case 0x3B: // trailer
+			return frames, nil
+		default:
+			return 0, fmt.Errorf("bloc GIF inconnu 0x%02x", kind)
+		}
+		if err := skipSubBlocks(br); err != nil {
+			return frames, nil
+		}
+	}
+	return frames, nil
+}
+
+// skipColorTable saute la table de couleurs annoncée par flags (bit 7 : présente, bits 0-2 : taille).
+func skipColorTable(br *bufio.Reader, flags byte) error {
+	if flags&0x80 == 0 {
+		return nil
+	}
+	_, err := br.Discard(3 << (flags&0x07 + 1))
+	return err
+}
+
+// skipSubBlocks saute une suite de sous-blocs GIF, jusqu'au bloc de taille nulle.
+func skipSubBlocks(br *bufio.Reader) error {
+	for {
+		n, err := br.ReadByte()
+		if err != nil || n == 0 {
+			return err
+		}
+		if _, err := br.Discard(int(n)); err != nil {
+			return err
+		}
+	}
+}
+
+// readWebPAnimation lit les chunks ANIM et ANMF d'un WebP étendu — nil si l'image n'est pas animée.
+// x/image/webp ne connaît pas les animations : chaque image est réemballée en WebP autonome pour lui.
+func readWebPAnimation(f io.Reader) (*animation, error) {
+	var hdr [12]byte
+	if _, err := io.ReadFull(f, hdr[:]); err != nil {
+		return nil, nil
+	}
+	var anim *animation
+	for {
+		if _, err := io.ReadFull(f, hdr[:8]); err != nil {
+			break // fin du fichier
+		}
+		if anim == nil && string(hdr[:4]) != "VP8X" { // VP8 ou VP8L en premier : WebP simple
+			return nil, nil
+		}
+		size := int(binary.LittleEndian.Uint32(hdr[4:8]))
+		if size < 0 || size > maxAnimFrameBytes {
+			return nil, errors.New("décodage échoué")
+		}
+		payload := make([]byte, size+size&1) // chunks alignés sur 2 octets
+		if _, err := io.ReadFull(f, payload); err != nil {
+			return nil, errors.New("décodage échoué")
+		}
+		payload = payload[:size]
+		switch string(hdr[:4]) {
+		case "VP8X":
+			if size < 10 || payload[0]&0x02 == 0 { // pas d'animation
+				return nil, nil
+			}
+			anim = &animation{width: int(u24(payload[4:])) + 1, height: int(u24(payload[7:])) + 1}
+		case "ANIM":
+			if anim == nil || size < 6 {
+				return nil, errors.New("décodage échoué")
+			}
+			anim.plays = int(binary.LittleEndian.Uint16(payload[4:]))
+		case "ANMF":
+			if anim == nil || size < 16 {
+				return nil, errors.New("décodage échoué")
+			}
+			x, y := 2*int(u24(payload)), 2*int(u24(payload[3:]))
+			w, h := int(u24(payload[6:]))+1, int(u24(payload[9:]))+1
+			data := payload[16:]
+			frame := animFrame{
+				bounds: image.Rect(x, y, x+w, y+h),
+				delay:  int(u24(payload[12:])),
+				over:   payload[15]&0x02 == 0,
+			}
+			if payload[15]&0x01 != 0 {
+				frame.dispose = animClear
+			}
+			if !frame.bounds.In(image.Rect(0, 0, anim.width, anim.height)) {
+				return nil, errors.New("décodage échoué : image hors du cadre")
+			}
+			still := webpStill(data, w, h)
+			if err := checkWebPFrame(still, w, h); err != nil {
+				return nil, fmt.Errorf("décodage échoué : image %d : %w", len(anim.frames), err)
+			}
+			frame.decode = func() (image.Image, error) { return webp.Decode(bytes.NewReader(still)) }
+			anim.frames = append(anim.frames, frame)
+		}
+	}
+	if anim == nil || len(anim.frames) == 0 {
+		return nil, nil
+	}
+	return anim, nil
+}
+
+// checkWebPFrame lit les dimensions du flux VP8/VP8L d'une image ANMF, sans la décoder : seules
+// celles du cadre (VP8X) sont bornées par maxInputWidth/Height, et un flux plus grand que son chunk
+// ANMF ferait allouer jusqu'à 16383×16383 pour quelques octets.
+func checkWebPFrame(still []byte, w, h int) error {
+	cfg, err := webp.DecodeConfig(bytes.NewReader(still))
+	if err != nil {
+		return err
+	}
+	if cfg.Width > w || cfg.Height > h {
+		return fmt.Errorf("flux %dx%d plus grand que l'image ANMF %dx%d", cfg.Width, cfg.Height, w, h)
+	}
+	return nil
+}
+
+// webpStill emballe les chunks d'une image ANMF (ALPH éventuel + VP8/VP8L) dans un WebP autonome.
+func webpStill(data []byte, w, h int) []byte {
+	var out bytes.Buffer
+	out.WriteString("RIFF\x00\x00\x00\x00WEBP")
+	if bytes.HasPrefix(data, []byte("ALPH")) { // alpha séparé : l'en-tête VP8X doit l'annoncer
+		vp8x := make([]byte, 10)
+		vp8x[0] = 0x10
+		putU24(vp8x[4:], w-1)
+		putU24(vp8x[7:], h-1)
+		writeRIFFChunk(&out, "VP8X", vp8x)
+	}
+	out.Write(data)
+	b := out.Bytes()
+	binary.LittleEndian.PutUint32(b[4:], uint32(len(b)-8))
+	return b
+}
+
+func u24(b []byte) uint32 { return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 }
+
+func putU24(b []byte, v int) { b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16) }
+
+// ── Rendu ──
+
+// animOptions : réglages du pipeline appliqués à chaque image, validés par le handler.
+type animOptions struct {
+	spec   resizeSpec
+	crop   cropSpec
+	orient orientation
+	fx     effects
+	bg     color.NRGBA
+	fixedQ int
+}
+
+// renderAnimation recompose, traite et ré-encode toutes les images. Retourne le buffer (à remettre
+// dans le pool), le content-type et la qualité WebP (0 en GIF, sans perte sur sa palette).
+// Les erreurs de paramètres watermark sont retournées telles quelles (400), les autres enveloppent errRender.
+func renderAnimation(r *http.Request, anim *animation, o animOptions) (*bytes.Buffer, string, int, error) {
+	asWebP := outputFormat(r) == "webp"
+	canvas := image.NewRGBA(image.Rect(0, 0, anim.width, anim.height)) // transparent au départ
+	var saved *image.RGBA                                              // zone sauvegardée pour animRestore
+
+	var (
+		layers   []wmOptions
+		cropZone image.Rectangle
+		q        int
+		outGIF   = &gif.GIF{LoopCount: gifLoopCount(anim.plays)}
+		outWebP  bytes.Buffer // chunks ANMF
+		outW     int
+		outH     int
+	)
+	for i, frame := range anim.frames {
+		src, err := frame.decode()
+		if err != nil {
+			return nil, "", 0, fmt.Errorf("%w : image %d : %w", errRender, i, err)
+		}
+		if frame.dispose == animRestore {
+			saved = image.NewRGBA(frame.bounds)
+			draw.Draw(saved, frame.bounds, canvas, frame.bounds.Min, draw.Src)
+		}
+		op := draw.Over
+		if !frame.over {
+			op = draw.Src
+		}
+		draw.Draw(canvas, frame.bounds, src, src.Bounds().Min, op)
+
+		// Pipeline de la photo, sur une vue du cadre recomposé.
+		var tmp []image.Image // canvases intermédiaires, libérés une fois l'image encodée
+		out := image.Image(canvas)
+		step := func(next image.Image) {
+			if next != out {
+				tmp = append(tmp, next)
+			}
+			out = next
+		}
+		if asWebP { // VP8 sans alpha : fond uni, comme une photo transparente
+			flat, _ := flatten(out, o.bg)
+			step(flat)
+		}
+		step(o.orient.apply(out))
+		if o.crop.w > 0 {
+			if i == 0 {
+				cropZone = cropRect(out, o.crop)
+			}
+			step(out.(interface {
+				SubImage(image.Rectangle) image.Image
+			}).SubImage(cropZone)) // canvases RGBA : toujours découpables
+		}
+		cropped := out.Bounds()
+		step(resize(out, o.spec))
+		if !o.fx.none() {
+			step(o.fx.apply(out, cropped))
+		}
+		if b := out.Bounds(); o.spec.pad && (b.Dx() != o.spec.w || b.Dy() != o.spec.h) {
+			step(padTo(out, o.spec.w, o.spec.h, o.bg))
+		}
+		if i == 0 {
+			outW, outH = out.Bounds().Dx(), out.Bounds().Dy()
+			if layers, err = wmLayers(r, outW); err != nil {
+				return nil, "", 0, err
+			}
+			resolveAutoPositions(out, layers) // coin figé pour toute l'animation
+			q, _ = chooseQuality(outW, outH, o.fixedQ)
+			q = formatQuality("webp", q)
+		}
+		watermarked, err := applyLayers(out, layers)
+		if err != nil {
+			releaseAll(tmp)
+			return nil, "", 0, fmt.Errorf("%w : %w", errRender, err)
+		}
+		tmp = append(tmp, watermarked)
+
+		if asWebP {
+			err = appendWebPFrame(&outWebP, watermarked, q, frame.delay)
+		} else {
+			outGIF.Image = append(outGIF.Image, quantize(watermarked))
+			outGIF.Delay = append(outGIF.Delay, (frame.delay+5)/10)
+			outGIF.Disposal = append(outGIF.Disposal, gif.DisposalBackground) // images pleines : la transparence ne laisse pas voir la précédente
+		}
+		releaseAll(tmp)
+		if err != nil {
+			return nil, "", 0, fmt.Errorf("%w : %w", errRender, err)
+		}
+
+		switch frame.dispose { // prépare le cadre de l'image suivante
+		case animClear:
+			draw.Draw(canvas, frame.bounds, image.Transparent, image.Point{}, draw.Src)
+		case animRestore:
+			draw.Draw(canvas, frame.bounds, saved, frame.bounds.Min, draw.Src)
+		}
+	}
+
+	buf := bufPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	if asWebP {
+		writeAnimatedWebP(buf, outW, outH, anim.plays, outWebP.Bytes())
+		return buf, "image/webp", q, nil
+	}
+	if err := gif.EncodeAll(buf, outGIF); err != nil {
+		bufPool.Put(buf)
+		return nil, "", 0, fmt.Errorf("%w : %w", errRender, err)
+	}
+	return buf, "image/gif", 0, nil
+}
+
+func releaseAll(imgs []image.Image) {
+	for _, m := range imgs {
+		releaseCanvas(m)
+	}
+}
+
+// gifLoopCount convertit un nombre de lectures (0 = en boucle) en LoopCount GIF.
+func gifLoopCount(plays int) int {
+	switch plays {
+	case 0:
+		return 0
+	case 1:
+		return -1 // pas d'extension NETSCAPE : une seule lecture
+	}
+	return plays - 1
+}
+
+// appendWebPFrame encode img en VP8 et l'ajoute en chunk ANMF plein cadre, sans fusion ni effacement.
+func appendWebPFrame(dst *bytes.Buffer, img image.Image, q, delay int) error {
+	var still bytes.Buffer
+	if err := encodeWebP(&still, img, q); err != nil {
+		return err
+	}
+	vp8 := still.Bytes()[12:] // chunk "VP8 " après l'en-tête RIFF/WEBP
+	b := img.Bounds()
+	head := make([]byte, 16)
+	putU24(head[6:], b.Dx()-1)
+	putU24(head[9:], b.Dy()-1)
+	putU24(head[12:], min(delay, 1<<24-1))
+	head[15] = 0x02 // pas de fusion : l'image remplace le cadre
+	writeRIFFChunk(dst, "ANMF", append(head, vp8...))
+	return nil
+}
+
+// writeAnimatedWebP assemble RIFF + VP8X + ANIM + les chunks ANMF.
+func writeAnimatedWebP(dst *bytes.Buffer, w, h, plays int, frames []byte) {
+	vp8x := make([]byte, 10)
+	vp8x[0] = 0x02 // animation
+	putU24(vp8x[4:], w-1)
+	putU24(vp8x[7:], h-1)
+	anim := make([]byte, 6) // couleur de fond (BGRA, transparente) + nombre de lectures
+	binary.LittleEndian.PutUint16(anim[4:], uint16(min(plays, 0xFFFF)))
+
+	dst.WriteString("RIFF\x00\x00\x00\x00WEBP")
+	writeRIFFChunk(dst, "VP8X", vp8x)
+	writeRIFFChunk(dst, "ANIM", anim)
+	dst.Write(frames)
+	binary.LittleEndian.PutUint32(dst.Bytes()[4:], uint32(dst.Len()-8))
+}
+
+// ── Palette GIF ──
+
+// quantize réduit img à une palette d'au plus 256 couleurs par coupe médiane sur un histogramme
+// 6 bits par canal. Chaque couleur de l'histogramme appartient à une seule boîte : le remappage est
+// une lecture de table, sans recherche du plus proche voisin. Les pixels à moins de 50 % d'opacité
+// deviennent transparents (index 0) — le GIF ne connaît que le tout ou rien.
+func quantize(img image.Image) *image.Paletted {
+	src, ok := img.(*image.RGBA)
+	if !ok {
+		src = image.NewRGBA(img.Bounds())
+		draw.Draw(src, src.Bounds(), img, img.Bounds().Min, draw.Src)
+	}
+	b := src.Bounds()
+	const shift = 8 - gifQuantBits
+	key := func(r, g, b uint8) int {
+		return int(r>>shift)<<(2*gifQuantBits) | int(g>>shift)<<gifQuantBits | int(b>>shift)
+	}
+
+	hist := make([]int32, 1<<(3*gifQuantBits))
+	transparent := false
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := src.Pix[src.PixOffset(b.Min.X, y):src.PixOffset(b.Max.X, y)]
+		for i := 0; i < len(row); i += 4 {
+			r, g, bl, a := unpremultiply(row[i : i+4])
+			if a < 128 {
+				transparent = true
+				continue
+			}
+			hist[key(r, g, bl)]++
+		}
+	}
+	var colors []qColor
+	for k, n := range hist {
+		if n > 0 {
+			colors = append(colors, qColor{c: [3]uint8{uint8(k >> (2 * gifQuantBits)), uint8(k >> gifQuantBits & (1<<gifQuantBits - 1)), uint8(k & (1<<gifQuantBits - 1))}, n: int(n), key: k})
+		}
+	}
+
+	maxColors := gifMaxColors
+	var pal color.Palette
+	if transparent {
+		pal = append(pal, color.RGBA{})
+		maxColors--
+	}
+	lut := hist // réutilisé : clé → index de palette
+	for _, box := range medianCut(colors, maxColors) {
+		var sum [3]int
+		total := 0
+		for _, c := range box {
+			for ch := range 3 {
+				sum[ch] += int(c.c[ch]) * c.n
+			}
+			total += c.n
+			lut[c.key] = int32(len(pal))
+		}
+		var avg [3]uint8
+		for ch := range 3 {
+			v := (sum[ch] + total/2) / total
+			avg[ch] = uint8(v<<shift | v>>(gifQuantBits-shift)) // 6 bits → 8 bits
+		}
+		pal = append(pal, color.RGBA{avg[0], avg[1], avg[2], 255})
+	}
+	if len(pal) == 0 {
+		pal = append(pal, color.RGBA{}) // image vide
+	}
+
+	dst := image.NewPaletted(image.Rect(0, 0, b.Dx(), b.Dy()), pal)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := src.Pix[src.PixOffset(b.Min.X, y):src.PixOffset(b.Max.X, y)]
+		out := dst.Pix[(y-b.Min.Y)*dst.Stride:]
+		for i := 0; i < len(row); i += 4 {
+			r, g, bl, a := unpremultiply(row[i : i+4])
+			if a >= 128 {
+				out[i/4] = uint8(lut[key(r, g, bl)])
+			}
+		}
+	}
+	return dst
+}
+
+// unpremultiply retourne la couleur réelle d'un pixel RGBA prémultiplié.
+func unpremultiply(p []uint8) (r, g, b, a uint8) {
+	a = p[3]
+	if a == 255 || a == 0 {
+		return p[0], p[1], p[2], a
+	}
+	return uint8(int(p[0]) * 255 / int(a)), uint8(int(p[1]) * 255 / int(a)), uint8(int(p[2]) * 255 / int(a)), a
+}
+
+// qColor est une case de l'histogramme : couleur sur gifQuantBits par canal et nombre de pixels.
+type qColor struct {
+	c   [3]uint8
+	n   int
+	key int
+}
+
+// medianCut découpe colors en au plus n boîtes : la boîte la plus étendue (sur son canal le plus
+// étalé, pondéré par son nombre de pixels) est coupée à sa médiane, jusqu'à n boîtes ou plus rien à couper.
+func medianCut(colors []qColor, n int) [][]qColor {
+	if len(colors) == 0 {
+		return nil
+	}
+	type box struct {
+		colors    []qColor
+		pixels    int
+		ch, score int // canal le plus étalé et priorité de coupe — 0 : boîte d'une seule couleur
+	}
+	newBox := func(colors []qColor) box {
+		b := box{colors: colors}
+		for _, c := range colors {
+			b.pixels += c.n
+		}
+		for ch := range 3 {
+			lo, hi := uint8(255), uint8(0)
+			for _, c := range colors {
+				lo, hi = min(lo, c.c[ch]), max(hi, c.c[ch])
+			}
+			if score := int(hi-lo) * b.pixels; hi > lo && score > b.score {
+				b.ch, b.score = ch, score
+			}
+		}
+		return b
+	}
+	boxes := []box{newBox(colors)}
+	for len(boxes) < n {
+		best := 0
+		for i, b := range boxes {
+			if b.score > boxes[best].score {
+				best = i
+			}
+		}
+		b := boxes[best]
+		if b.score == 0 {
+			break
+		}
+		slices.SortFunc(b.colors, func(x, y qColor) int { return int(x.c[b.ch]) - int(y.c[b.ch]) })
+		cut, acc := 1, 0
+		for i, c := range b.colors[:len(b.colors)-1] { // médiane pondérée, au moins une couleur de chaque côté
+			acc += c.n
+			cut = i + 1
+			if 2*acc >= b.pixels {
+				break
+			}
+		}
+		boxes[best] = newBox(b.colors[:cut])
+		boxes = append(boxes, newBox(b.colors[cut:]))
+	}
+	out := make([][]qColor, len(boxes))
+	for i, b := range boxes {
+		out[i] = b.colors
+	}
+	return out
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"image"
+	"math"
+)
+
+// ── Placement automatique ─────────────────────────────────────────────────────
+// wm_position=auto place le watermark dans le coin qui recouvre le moins le sujet de la photo.
+// Le sujet est estimé par la densité de contours : un ciel, un mur ou un fond flou (bokeh)
+// ont peu de gradients, un visage ou un produit net en concentrent beaucoup.
+// Pas de détection de visages : aucune bibliothèque de détection n'est embarquée, et la
+// densité de contours écarte déjà les coins occupés par un portrait net.
+
+const (
+	autoPosition  = "auto"
+	autoGridSide  = 160 // l'analyse se fait sur une grille d'environ 160 px de côté — assez pour juger un coin
+	autoZoneRatio = 3   // une zone de coin couvre 1/3 de la largeur et 1/3 de la hauteur
+)
+
+// autoCorners : coins candidats, dans l'ordre de préférence en cas d'égalité (le moins intrusif d'abord).
+var autoCorners = []string{"bottom-right", "bottom-left", "top-right", "top-left"}
+
+// resolveAutoPositions remplace wm_position=auto par le coin choisi, pour chaque calque concerné.
+// L'analyse porte sur l'image sans watermark, et n'est faite qu'une fois pour tous les calques.
+// Retourne les positions choisies, dans l'ordre des calques — vide si aucun calque n'est en auto.
+func resolveAutoPositions(img image.Image, layers []wmOptions) []string {
+	var chosen []string
+	var corner string
+	for i := range layers {
+		if layers[i].position != autoPosition {
+			continue
+		}
+		if corner == "" {
+			corner = quietestCorner(img)
+		}
+		layers[i].position = corner
+		chosen = append(chosen, corner)
+	}
+	return chosen
+}
+
+// quietestCorner retourne le coin dont la densité de contours est la plus faible.
+func quietestCorner(img image.Image) string {
+	g := newEdgeGrid(img, autoGridSide)
+	if g == nil {
+		return autoCorners[0] // image minuscule — rien à analyser
+	}
+
+	zw, zh := max(g.cols/autoZoneRatio, 1), max(g.rows/autoZoneRatio, 1)
+	zones := map[string]image.Rectangle{
+		"top-left":     image.Rect(0, 0, zw, zh),
+		"top-right":    image.Rect(g.cols-zw, 0, g.cols, zh),
+		"bottom-left":  image.Rect(0, g.rows-zh, zw, g.rows),
+		"bottom-right": image.Rect(g.cols-zw, g.rows-zh, g.cols, g.rows),
+	}
+	best, bestScore := autoCorners[0], math.Inf(1)
+	for _, corner := range autoCorners {
+		z := zones[corner]
+		score := g.sum(z) / float64(z.Dx()*z.Dy())
+		if score < bestScore*0.95 { // 5 % d'écart minimum : sur une image uniforme, le coin préféré l'emporte
+			best, bestScore = corner, score
+		}
+	}
+	logger.Debug().Str("step", "auto_position").Str("corner", best).Float64("edge_density", bestScore).Int("step_px", g.step).Msg("coin le plus calme")
+	return best
+}
+
+// edgeGrid est la magnitude du gradient de luminance sur une grille sous-échantillonnée de l'image :
+// une carte grossière de « ce qui attire l'œil », partagée par wm_position=auto et crop.
+type edgeGrid struct {
+	cols, rows int
+	step       int       // px source entre deux échantillons
+	edges      []float64 // cols×rows, ligne par ligne
+}
+
+// newEdgeGrid échantillonne img sur une grille d'environ side px de côté — nil si l'image est trop petite.
+func newEdgeGrid(img image.Image, side int) *edgeGrid {
+	b := img.Bounds()
+	step := max(max(b.Dx(), b.Dy())/side, 1) // un échantillon tous les step px
+	cols, rows := b.Dx()/step, b.Dy()/step
+	if cols < 2 || rows < 2 {
+		return nil
+	}
+
+	lum := make([]float64, cols*rows) // luminance BT.601 sous-échantillonnée
+	for y := range rows {
+		for x := range cols {
+			r, g, bl, _ := img.At(b.Min.X+x*step, b.Min.Y+y*step).RGBA()
+			lum[y*cols+x] = 0.299*float64(r>>8) + 0.587*float64(g>>8) + 0.114*float64(bl>>8)
+		}
+	}
+	g := &edgeGrid{cols: cols, rows: rows, step: step, edges: make([]float64, cols*rows)}
+	for y := range rows {
+		for x := range cols { // magnitude du gradient (différences avant)
+			gx := lum[y*cols+min(x+1, cols-1)] - lum[y*cols+x]
+			gy := lum[min(y+1, rows-1)*cols+x] - lum[y*cols+x]
+			g.edges[y*cols+x] = math.Hypot(gx, gy)
+		}
+	}
+	return g
+}
+
+// sum additionne les contours de la zone r, en coordonnées de grille.
+func (g *edgeGrid) sum(r image.Rectangle) float64 {
+	var total float64
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			total += g.edges[y*g.cols+x]
+		}
+	}
+	return total
+}
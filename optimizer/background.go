@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"net/http"
+)
+
+// ── Fond : transparence et bandes ─────────────────────────────────────────────
+// Ni JPEG ni l'encodeur WebP (VP8 sans chunk ALPH) ne portent d'alpha : une zone transparente
+// sortait noire (couleur prémultipliée à zéro). L'image est désormais aplatie sur bg_color
+// juste après le décodage — avant le watermark, pour que la couleur adaptative et la palette
+// voient le fond réel et non du noir. La sortie PNG garde la transparence. La même couleur
+// remplit les bandes de fit=pad.
+
+var defaultBgColor = color.NRGBA{R: 255, G: 255, B: 255, A: 255} // blanc : le fond implicite d'une page web
+
+// bgColorParam lit bg_color (#RRGGBB) — blanc si absent. Un fond semi-transparent n'aurait pas de sens.
+func bgColorParam(r *http.Request) (color.NRGBA, error) {
+	v := r.FormValue("bg_color")
+	if v == "" {
+		return defaultBgColor, nil
+	}
+	c, _, err := parseHexColor(v)
+	if err != nil || c.A != 255 {
+		return color.NRGBA{}, fmt.Errorf("bg_color invalide : %q (#RRGGBB opaque)", v)
+	}
+	return c, nil
+}
+
+// keepsAlpha indique si le format de sortie porte la transparence — sinon l'image est aplatie.
+func keepsAlpha(format string) bool { return format == "png" }
+
+// flatten compose img sur un fond uni bg. Une image déjà opaque est retournée telle quelle (false) ;
+// sinon le résultat est un canvas à libérer par l'appelant (true).
+func flatten(img image.Image, bg color.NRGBA) (image.Image, bool) {
+	if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() { // parcours de l'alpha — bien moins cher qu'une copie
+		return img, false
+	}
+	b := img.Bounds()
+	dst := newCanvas(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Over)
+	return dst, true
+}
+
+// padTo centre img sur un canvas w×h rempli de bg — format fixe exigé par les marketplaces.
+// Le résultat est un canvas à libérer par l'appelant.
+func padTo(img image.Image, w, h int, bg color.NRGBA) image.Image {
+	b := img.Bounds()
+	dst := newCanvas(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+	at := image.Pt((w-b.Dx())/2, (h-b.Dy())/2) // centré — l'écart impair va à droite / en bas
+	draw.Draw(dst, image.Rectangle{at, at.Add(b.Size())}, img, b.Min, draw.Src)
+	return dst
+}
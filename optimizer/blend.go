@@ -0,0 +1,60 @@
+package main
+
+import (
+	"image"
+	"image/draw"
+)
+
+// ── Modes de fusion ───────────────────────────────────────────────────────────
+// wm_blend choisit comment le watermark se mélange à la photo. "normal" est l'alpha
+// blending classique (draw.Over) ; les autres modes suivent les formules de la spec
+// W3C Compositing and Blending, puis l'alpha du watermark dose le résultat.
+// Seul le watermark lui-même (glyphes, logo) est fusionné : plaque, ombre et contour
+// restent en mode normal pour garder leur rôle de contraste.
+
+// blendModes : fonction de fusion par canal, valeurs normalisées 0-1 (b = fond, s = watermark).
+var blendModes = map[string]func(b, s float64) float64{
+	"normal":   nil, // draw.Over — chemin optimisé de la stdlib
+	"multiply": func(b, s float64) float64 { return b * s },
+	"screen":   func(b, s float64) float64 { return b + s - b*s },
+	"overlay": func(b, s float64) float64 { // multiply sur les tons sombres du fond, screen sur les clairs
+		if b <= 0.5 {
+			return 2 * b * s
+		}
+		return 1 - 2*(1-b)*(1-s)
+	},
+}
+
+// compose dessine src sur dst à travers mask, comme draw.DrawMask(…, draw.Over), avec le mode de fusion demandé.
+func compose(dst *image.RGBA, r image.Rectangle, src image.Image, sp image.Point, mask image.Image, mp image.Point, mode string) {
+	blend := blendModes[mode]
+	if blend == nil { // normal ou absent
+		draw.DrawMask(dst, r, src, sp, mask, mp, draw.Over)
+		return
+	}
+	r = r.Intersect(dst.Bounds())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			sx, sy := sp.X+x-r.Min.X, sp.Y+y-r.Min.Y
+			_, _, _, ma := mask.At(mp.X+x-r.Min.X, mp.Y+y-r.Min.Y).RGBA()
+			sr, sg, sb, sa := src.At(sx, sy).RGBA() // prémultiplié, 16 bits
+			a := float64(sa) / 0xffff * float64(ma) / 0xffff
+			if a == 0 {
+				continue
+			}
+			i := dst.PixOffset(x, y)
+			pix := dst.Pix[i : i+4 : i+4]
+			ba := float64(pix[3]) / 255
+			for c, sc := range [3]uint32{sr, sg, sb} {
+				s := float64(sc) / float64(sa) // couleur du watermark non prémultipliée
+				var b float64
+				if ba > 0 {
+					b = float64(pix[c]) / 255 / ba // couleur du fond non prémultipliée
+				}
+				mixed := (1-ba)*s + ba*blend(b, s) // fond transparent : la couleur source reste telle quelle
+				pix[c] = uint8((mixed*a+float64(pix[c])/255*(1-a))*255 + 0.5)
+			}
+			pix[3] = uint8((a+ba*(1-a))*255 + 0.5)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// ── Budget de taille ──────────────────────────────────────────────────────────
+// max_bytes=200000 garantit un fichier sous le budget (pièce jointe d'e-mail, messagerie) :
+// l'image est d'abord encodée à la qualité habituelle, puis, si elle dépasse, la plus haute
+// qualité qui tient est cherchée par dichotomie. La taille mesurée est celle du fichier complet,
+// métadonnées comprises. Les dimensions ne sont jamais réduites d'office : si même la qualité
+// plancher dépasse, la requête est refusée (422) — au client de baisser max_w.
+
+const (
+	minMaxBytes      = 1024 // en dessous, même une miniature ne tient pas avec ses en-têtes
+	budgetMinQuality = 10   // plancher de la recherche — en dessous, les blocs JPEG sautent aux yeux
+)
+
+// errBudget : budget max_bytes inatteignable aux dimensions demandées (422).
+var errBudget = errors.New("max_bytes inatteignable à ces dimensions : réduire max_w/max_h ou augmenter max_bytes")
+
+// maxBytesParam lit max_bytes — 0 si absent : pas de budget.
+func maxBytesParam(r *http.Request) (int, error) {
+	v := r.FormValue("max_bytes")
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < minMaxBytes {
+		return 0, fmt.Errorf("max_bytes invalide : %q (entier ≥ %d)", v, minMaxBytes)
+	}
+	return n, nil
+}
+
+// fitBudget encode à la qualité q et, si la sortie dépasse maxBytes (> 0), cherche la plus haute
+// qualité inférieure qui tient — 3 à 7 encodages de plus. encode produit le fichier complet ;
+// les buffers écartés retournent au pool. Retourne le buffer et la qualité retenue.
+// PNG est sans perte : sa taille ne dépend pas de q, il n'y a rien à chercher.
+func fitBudget(format string, q, maxBytes int, encode func(q int) (*bytes.Buffer, error)) (*bytes.Buffer, int, error) {
+	buf, err := encode(q)
+	if err != nil || maxBytes == 0 || buf.Len() <= maxBytes {
+		return buf, q, err
+	}
+	bufPool.Put(buf)
+	if format == "png" || q <= budgetMinQuality {
+		return nil, 0, errBudget
+	}
+
+	var best *bytes.Buffer
+	bestQ := 0
+	lo, hi := budgetMinQuality, q-1 // invariant : toute qualité > hi dépasse
+	for lo <= hi {
+		mid := (lo + hi + 1) / 2
+		buf, err := encode(mid)
+		if err != nil {
+			if best != nil {
+				bufPool.Put(best)
+			}
+			return nil, 0, err
+		}
+		if buf.Len() > maxBytes {
+			bufPool.Put(buf)
+			hi = mid - 1
+			continue
+		}
+		if best != nil {
+			bufPool.Put(best)
+		}
+		best, bestQ = buf, mid
+		lo = mid + 1
+	}
+	if best == nil {
+		return nil, 0, errBudget
+	}
+	logger.Debug().Str("step", "budget").Int("max_bytes", maxBytes).Int("quality", bestQ).Int("bytes", best.Len()).Msg("qualité ajustée au budget")
+	return best, bestQ, nil
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// ── JPEG CMYK ─────────────────────────────────────────────────────────────────
+// Les visuels d'agence destinés à l'impression arrivent en JPEG CMYK. Ils sont convertis en RGB dès
+// le décodage : resize, luminance adaptative et encodeurs ne voient que du RGB. Deux écueils :
+//   - image/jpeg refuse un JPEG 4 canaux sans segment Adobe APP14 : un APP14 est inséré et, comme
+//     image/jpeg suppose alors l'inversion Adobe (255 = pas d'encre), les canaux sont ré-inversés ;
+//   - la conversion naïve de color.CMYK ignore le comportement réel des encres (couleurs ternes ou
+//     trop sombres) : si un profil CMYK est embarqué (SWOP, FOGRA…), sa table A2B0 est appliquée.
+// Sans profil, la conversion naïve reste celle des navigateurs. Les profils v4 (lutAtoBType) ne
+// sont pas lus : conversion naïve, comme sans profil.
+
+// adobeAPP14 : segment Adobe minimal, transform 0 (CMYK sans conversion YCC).
+var adobeAPP14 = []byte{0xFF, 0xEE, 0x00, 0x0E, 'A', 'd', 'o', 'b', 'e', 0x00, 0x64, 0x00, 0x00, 0x00, 0x00, 0x00}
+
+// decodeCMYK décode un JPEG 4 canaux et le convertit en RGB. file est positionné au début.
+func decodeCMYK(file io.Reader) (image.Image, error) {
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	m, err := jpeg.Decode(bytes.NewReader(data))
+	inverted := false
+	if err != nil && len(data) > 2 { // pas d'APP14 : CMYK non inversé, la convention hors Adobe
+		fixed := append(append(data[:2:2], adobeAPP14...), data[2:]...)
+		if m, err = jpeg.Decode(bytes.NewReader(fixed)); err != nil {
+			return nil, err
+		}
+		inverted = true
+	}
+	src, ok := m.(*image.CMYK)
+	if !ok {
+		return m, err
+	}
+	if inverted {
+		for i := range src.Pix {
+			src.Pix[i] = 255 - src.Pix[i]
+		}
+	}
+	t := newCMYKTransform(readICC(bytes.NewReader(data)))
+	logger.Debug().Str("step", "cmyk").Bool("adobe", !inverted).Bool("profile", t != nil).Msg("JPEG CMYK converti en RGB")
+	return cmykToRGBA(src, t), nil
+}
+
+// cmykToRGBA convertit src par bandes parallèles, avec t ou, à défaut, la formule naïve.
+func cmykToRGBA(src *image.CMYK, t *cmykTransform) *image.RGBA {
+	b := src.Bounds()
+	dst := image.NewRGBA(b)
+	numWorkers := runtime.NumCPU()
+	bandH := max((b.Dy()+numWorkers-1)/numWorkers, 1)
+
+	var wg sync.WaitGroup
+	for y0 := b.Min.Y; y0 < b.Max.Y; y0 += bandH {
+		wg.Add(1)
+		go func(y0, y1 int) {
+			defer wg.Done()
+			for y := y0; y < y1; y++ {
+				in := src.Pix[src.PixOffset(b.Min.X, y):src.PixOffset(b.Max.X, y)]
+				out := dst.Pix[dst.PixOffset(b.Min.X, y):dst.PixOffset(b.Max.X, y)]
+				for i := 0; i < len(in); i += 4 {
+					if t != nil {
+						t.apply(in[i:i+4], out[i:i+4])
+					} else {
+						out[i], out[i+1], out[i+2] = color.CMYKToRGB(in[i], in[i+1], in[i+2], in[i+3])
+					}
+					out[i+3] = 255
+				}
+			}
+		}(y0, min(y0+bandH, b.Max.Y))
+	}
+	wg.Wait()
+	return dst
+}
+
+// iccSpace retourne l'espace colorimétrique d'un profil ("RGB ", "CMYK", "GRAY"…) — "" si illisible.
+func iccSpace(profile []byte) string {
+	if len(profile) < 132 {
+		return ""
+	}
+	return string(profile[16:20])
+}
+
+// ── Table A2B0 ──
+
+// cmykTransform applique la table A2B0 (lut8Type ou lut16Type) d'un profil CMYK :
+// courbes d'entrée, CLUT 4D interpolée, courbes de sortie, puis PCS (Lab ou XYZ D50) → sRGB.
+type cmykTransform struct {
+	in   [4][256]float64 // valeur 8 bits → position dans la grille, en pas de grille
+	grid int             // points par dimension de la CLUT
+	clut []float64       // grid⁴ × 3, valeurs dans [0,1]
+	out  [3][]float64    // courbes de sortie échantillonnées, valeurs dans [0,1]
+	lab  bool            // PCS Lab — sinon XYZ
+	wide bool            // lut16Type : encodage Lab v2 sur 16 bits (100 = 0xFF00)
+}
+
+// newCMYKTransform lit la table A2B0 d'un profil CMYK — nil si le profil est absent, n'est pas
+// CMYK, ou si la table est d'un type non géré.
+func newCMYKTransform(profile []byte) *cmykTransform {
+	if iccSpace(profile) != "CMYK" {
+		return nil
+	}
+	pcs := string(profile[20:24])
+	if pcs != "Lab " && pcs != "XYZ " {
+		return nil
+	}
+	var tag []byte
+	count := int(binary.BigEndian.Uint32(profile[128:]))
+	for i := range count {
+		e := 132 + i*12
+		if e+12 > len(profile) {
+			return nil
+		}
+		off, size := int(binary.BigEndian.Uint32(profile[e+4:])), int(binary.BigEndian.Uint32(profile[e+8:]))
+		if string(profile[e:e+4]) == "A2B0" && off >= 0 && size >= 0 && off+size <= len(profile) {
+			tag = profile[off : off+size]
+		}
+	}
+	if len(tag) < 52 || tag[8] != 4 || tag[9] != 3 || tag[10] < 2 {
+		return nil
+	}
+
+	t := &cmykTransform{grid: int(tag[10]), lab: pcs == "Lab "}
+	var width, inN, outN int // octets par valeur, entrées des courbes d'entrée et de sortie
+	pos := 48
+	switch string(tag[:4]) {
+	case "mft1":
+		width, inN, outN = 1, 256, 256
+	case "mft2":
+		width, inN, outN = 2, int(binary.BigEndian.Uint16(tag[48:])), int(binary.BigEndian.Uint16(tag[50:]))
+		pos = 52
+		t.wide = true
+	default: // lutAtoBType (v4)
+		return nil
+	}
+	cells := t.grid * t.grid * t.grid * t.grid * 3
+	if inN < 2 || outN < 2 || len(tag) < pos+width*(4*inN+cells+3*outN) {
+		return nil
+	}
+	read := func(i int) float64 { // i-ème valeur à partir de pos, normalisée dans [0,1]
+		if width == 1 {
+			return float64(tag[pos+i]) / 255
+		}
+		return float64(binary.BigEndian.Uint16(tag[pos+2*i:])) / 65535
+	}
+
+	for c := range 4 {
+		for v := range 256 {
+			x := float64(v) / 255 * float64(inN-1)
+			j := min(int(x), inN-2)
+			a, b := read(c*inN+j), read(c*inN+j+1)
+			t.in[c][v] = (a + (b-a)*(x-float64(j))) * float64(t.grid-1)
+		}
+	}
+	pos += width * 4 * inN
+	t.clut = make([]float64, cells)
+	for i := range t.clut {
+		t.clut[i] = read(i)
+	}
+	pos += width * cells
+	for c := range 3 {
+		t.out[c] = make([]float64, outN)
+		for i := range outN {
+			t.out[c][i] = read(c*outN + i)
+		}
+	}
+	return t
+}
+
+// apply convertit un pixel CMYK (4 octets) en RGB (3 premiers octets de dst).
+func (t *cmykTransform) apply(src, dst []uint8) {
+	var base [4]int     // coin inférieur de la cellule
+	var frac [4]float64 // position dans la cellule
+	var stride [4]int   // pas de chaque dimension dans la CLUT
+	stride[3] = 3
+	for c := 2; c >= 0; c-- {
+		stride[c] = stride[c+1] * t.grid
+	}
+	off := 0
+	for c := range 4 {
+		x := t.in[c][src[c]]
+		base[c] = min(int(x), t.grid-2)
+		frac[c] = x - float64(base[c])
+		off += base[c] * stride[c]
+	}
+
+	var pcs [3]float64
+	for corner := range 16 { // interpolation quadrilinéaire : 16 sommets de la cellule
+		w, o := 1.0, off
+		for c := range 4 {
+			if corner>>c&1 != 0 {
+				w *= frac[c]
+				o += stride[c]
+			} else {
+				w *= 1 - frac[c]
+			}
+		}
+		if w == 0 {
+			continue
+		}
+		for k := range 3 {
+			pcs[k] += w * t.clut[o+k]
+		}
+	}
+	for k := range 3 {
+		curve := t.out[k]
+		x := min(max(pcs[k], 0), 1) * float64(len(curve)-1)
+		j := min(int(x), len(curve)-2)
+		pcs[k] = curve[j] + (curve[j+1]-curve[j])*(x-float64(j))
+	}
+
+	var xyz [3]float64
+	if t.lab {
+		if t.wide {
+			for k := range 3 {
+				pcs[k] *= 65535.0 / 65280
+			}
+		}
+		L, a, b := pcs[0]*100, pcs[1]*255-128, pcs[2]*255-128
+		fy := (L + 16) / 116
+		for k, f := range [3]float64{fy + a/500, fy, fy - b/200} {
+			v := f * f * f
+			if f <= 6.0/29 {
+				v = 3 * (6.0 / 29) * (6.0 / 29) * (f - 4.0/29)
+			}
+			xyz[k] = v * [3]float64{0.9642, 1, 0.8249}[k] // blanc D50
+		}
+	} else {
+		for k := range 3 {
+			xyz[k] = pcs[k] * 65535 / 32768 // u1Fixed15
+		}
+	}
+	for c := range 3 {
+		m := xyzToSRGB[c]
+		lin := min(max(m[0]*xyz[0]+m[1]*xyz[1]+m[2]*xyz[2], 0), 1) // hors gamut sRGB : écrêté
+		dst[c] = srgbEncode[int(lin*4095+0.5)]
+	}
+}
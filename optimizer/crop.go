@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ── Recadrage au ratio ────────────────────────────────────────────────────────
+// crop=16:9 (ou 1:1, 4:5…) recadre l'image au ratio demandé avant le resize, au lieu de
+// seulement la faire tenir dans le cadre. La fenêtre de recadrage glisse le long de l'axe
+// en trop et s'arrête là où la densité de contours est la plus forte — le sujet net
+// plutôt que le ciel ou le fond flou. crop_gravity=center force un recadrage centré.
+
+const (
+	cropGridSide = 200 // résolution de la carte de contours — la fenêtre se déplace par pas de grille
+	maxCropRatio = 20  // 20:1 — au-delà, ce n'est plus un recadrage mais une bande
+)
+
+// cropSpec est le recadrage demandé : ratio w:h, et centré ou guidé par le contenu.
+type cropSpec struct {
+	w, h   int
+	center bool
+}
+
+// cropParams lit crop (ratio "w:h") et crop_gravity ("smart" par défaut, ou "center").
+// Un cropSpec nul (w == 0) signifie « pas de recadrage ».
+func cropParams(r *http.Request) (spec cropSpec, err error) {
+	v := r.FormValue("crop")
+	if v == "" {
+		return spec, nil
+	}
+	ws, hs, ok := strings.Cut(v, ":")
+	spec.w, err = strconv.Atoi(ws)
+	if err == nil {
+		spec.h, err = strconv.Atoi(hs)
+	}
+	if !ok || err != nil || spec.w < 1 || spec.h < 1 || spec.w > maxCropRatio*spec.h || spec.h > maxCropRatio*spec.w {
+		return cropSpec{}, fmt.Errorf("crop invalide : %q (ratio largeur:hauteur, ex: 16:9)", v)
+	}
+	switch g := r.FormValue("crop_gravity"); g {
+	case "", "smart":
+	case "center":
+		spec.center = true
+	default:
+		return cropSpec{}, fmt.Errorf("crop_gravity invalide : %q (smart ou center)", g)
+	}
+	return spec, nil
+}
+
+// cropImage retourne la zone de img au ratio demandé. Pas de copie : une SubImage partage les
+// pixels de la source — le resize qui suit lit directement dans la zone retenue.
+func cropImage(img image.Image, spec cropSpec) image.Image {
+	b := img.Bounds()
+	r := cropRect(img, spec)
+	if r == b {
+		return img
+	}
+	logger.Info().Str("step", "crop").Str("ratio", fmt.Sprintf("%d:%d", spec.w, spec.h)).Bool("center", spec.center).
+		Int("x", r.Min.X-b.Min.X).Int("y", r.Min.Y-b.Min.Y).Int("width", r.Dx()).Int("height", r.Dy()).Msg("recadrage")
+	if sub, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok { // tous les types de image/ et les canvases RGBA
+		return sub.SubImage(r)
+	}
+	return cropCopy(img, r)
+}
+
+// cropRect calcule la fenêtre de recadrage : la plus grande zone au ratio demandé, placée au centre
+// ou sur la bande la plus riche en contours.
+func cropRect(img image.Image, spec cropSpec) image.Rectangle {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	cw, ch := w, w*spec.h/spec.w // largeur pleine, hauteur déduite…
+	if w*spec.h > h*spec.w {     // …sauf si l'image est plus large que le ratio : hauteur pleine
+		cw, ch = h*spec.w/spec.h, h
+	}
+	cw, ch = max(min(cw, w), 1), max(min(ch, h), 1)
+	if cw == w && ch == h {
+		return b
+	}
+
+	// Décalage le long de l'axe en trop : centré par défaut.
+	horizontal := cw < w
+	slack := w - cw
+	if !horizontal {
+		slack = h - ch
+	}
+	offset := slack / 2
+	if !spec.center {
+		if g := newEdgeGrid(img, cropGridSide); g != nil {
+			offset = bestWindow(g, horizontal, cw, ch, slack)
+		}
+	}
+	if horizontal {
+		return image.Rect(b.Min.X+offset, b.Min.Y, b.Min.X+offset+cw, b.Max.Y)
+	}
+	return image.Rect(b.Min.X, b.Min.Y+offset, b.Max.X, b.Min.Y+offset+ch)
+}
+
+// bestWindow fait glisser la fenêtre de recadrage sur la grille de contours et retourne le décalage
+// (en px source) de la position la plus riche. À score égal, la position la plus centrée l'emporte.
+func bestWindow(g *edgeGrid, horizontal bool, cw, ch, slack int) int {
+	n, win := g.rows, ch/g.step // nombre de lignes de grille, taille de la fenêtre en lignes
+	if horizontal {
+		n, win = g.cols, cw/g.step
+	}
+	win = max(min(win, n), 1)
+
+	profile := make([]float64, n) // contours cumulés par colonne (ou ligne) de grille
+	for y := range g.rows {
+		for x := range g.cols {
+			if horizontal {
+				profile[x] += g.edges[y*g.cols+x]
+			} else {
+				profile[y] += g.edges[y*g.cols+x]
+			}
+		}
+	}
+
+	var sum float64
+	for i := range win {
+		sum += profile[i]
+	}
+	center := float64(n-win) / 2
+	best, bestSum := 0, sum
+	for i := 1; i+win <= n; i++ { // fenêtre glissante : une entrée, une sortie
+		sum += profile[i+win-1] - profile[i-1]
+		if sum > bestSum*1.02 || (sum >= bestSum*0.98 && math.Abs(float64(i)-center) < math.Abs(float64(best)-center)) {
+			best, bestSum = i, sum
+		}
+	}
+	return min(best*g.step, slack)
+}
+
+// cropCopy copie la zone r dans un nouveau canvas — pour les images sans SubImage.
+func cropCopy(img image.Image, r image.Rectangle) image.Image {
+	dst := newCanvas(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, r.Min, draw.Src)
+	return dst
+}
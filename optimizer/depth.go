@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/binary"
+	"image"
+	"io"
+	"math"
+	"runtime"
+	"sync"
+)
+
+// ── Entrées 16 bits (PNG, TIFF) ───────────────────────────────────────────────
+// Les exports de retouche (Lightroom, Capture One) et les scans arrivent en PNG ou TIFF 16 bits.
+// Le pipeline travaille en RGBA 8 bits : la conversion se fait une seule fois, au décodage, au lieu
+// de la troncature >>8 implicite de draw. Elle :
+//   - applique le gAMA d'un PNG linéaire (gAMA ≈ 1.0, fréquent en sortie de développeur RAW) pour
+//     l'encoder en sRGB — sinon l'image sort sombre, ombres bouchées et hautes lumières tassées ;
+//   - arrondit avec un tramage ordonné (Bayer 4×4) : les dégradés lisses (ciel, studio) gardent
+//     leurs 256 niveaux sans bandes visibles après compression.
+// La luminance adaptative et le compositing travaillent ensuite sur ce RGBA 8 bits.
+
+// bayer4 : seuils du tramage ordonné 4×4, en seizièmes de pas 8 bits.
+var bayer4 = [4][4]uint32{
+	{0, 8, 2, 10},
+	{12, 4, 14, 6},
+	{3, 11, 1, 9},
+	{15, 7, 13, 5},
+}
+
+// is16bit indique si img porte plus de 8 bits par canal.
+func is16bit(img image.Image) bool {
+	switch img.(type) {
+	case *image.RGBA64, *image.NRGBA64, *image.Gray16:
+		return true
+	}
+	return false
+}
+
+// pngGamma retourne l'exposant du chunk gAMA d'un PNG (0.45455 pour du sRGB) — 0 s'il est absent,
+// ou si un chunk sRGB ou iCCP le rend caduc (cf. icc.go). f est repositionné au début par l'appelant.
+func pngGamma(f io.Reader) float64 {
+	var hdr [8]byte
+	if _, err := io.ReadFull(f, hdr[:]); err != nil || string(hdr[:]) != "\x89PNG\r\n\x1a\n" {
+		return 0
+	}
+	gamma := 0.0
+	for {
+		if _, err := io.ReadFull(f, hdr[:]); err != nil {
+			return gamma
+		}
+		size := int64(binary.BigEndian.Uint32(hdr[:4]))
+		switch string(hdr[4:]) {
+		case "gAMA":
+			var v [4]byte
+			if size != 4 {
+				return 0
+			}
+			if _, err := io.ReadFull(f, v[:]); err != nil {
+				return 0
+			}
+			gamma = float64(binary.BigEndian.Uint32(v[:])) / 100000
+			size = 0
+		case "sRGB", "iCCP":
+			return 0
+		case "IDAT", "IEND": // gAMA doit précéder les données image
+			return gamma
+		}
+		if _, err := io.CopyN(io.Discard, f, size+4); err != nil { // données + CRC
+			return gamma
+		}
+	}
+}
+
+// to8bit convertit une image 16 bits en RGBA 8 bits tramé. gamma est l'exposant d'encodage du fichier
+// (cf. pngGamma) : 0, ou proche de celui du sRGB, laisse les valeurs telles quelles.
+func to8bit(img image.Image, gamma float64) *image.RGBA {
+	var lut []uint16 // valeur 16 bits du fichier → valeur 16 bits sRGB, nil si identité
+	if gamma > 0 && math.Abs(gamma-1/2.2) > 0.01 {
+		lut = make([]uint16, 65536)
+		for i := range lut {
+			lin := math.Pow(float64(i)/65535, 1/gamma) // décodage du fichier : intensité linéaire
+			y := 12.92 * lin
+			if lin > 0.0031308 {
+				y = 1.055*math.Pow(lin, 1/2.4) - 0.055
+			}
+			lut[i] = uint16(min(max(y, 0), 1)*65535 + 0.5)
+		}
+	}
+
+	b := img.Bounds()
+	dst := image.NewRGBA(b)
+	numWorkers := runtime.NumCPU()
+	bandH := max((b.Dy()+numWorkers-1)/numWorkers, 1)
+
+	var wg sync.WaitGroup
+	for y0 := b.Min.Y; y0 < b.Max.Y; y0 += bandH {
+		wg.Add(1)
+		go func(y0, y1 int) {
+			defer wg.Done()
+			for y := y0; y < y1; y++ {
+				out := dst.Pix[dst.PixOffset(b.Min.X, y):dst.PixOffset(b.Max.X, y)]
+				for x := b.Min.X; x < b.Max.X; x++ {
+					c, a := nrgba16(img, x, y)
+					t := bayer4[y&3][x&3]*65535/16 + 2048 // seuil dans [0, 65535), centré sur la moitié d'un pas
+					i := 4 * (x - b.Min.X)
+					out[i+3] = uint8((uint32(a)*255 + 32767) / 65535) // alpha arrondi, non tramé : bords nets
+					for k := range 3 {
+						v := uint32(c[k])
+						if lut != nil {
+							v = uint32(lut[v])
+						}
+						v = v * uint32(a) / 65535 // prémultiplié, comme image.RGBA : jamais au-dessus de l'alpha
+						out[i+k] = min(uint8((v*255+t)/65535), out[i+3])
+					}
+				}
+			}
+		}(y0, min(y0+bandH, b.Max.Y))
+	}
+	wg.Wait()
+	return dst
+}
+
+// nrgba16 lit un pixel 16 bits en couleur non prémultipliée et alpha.
+func nrgba16(img image.Image, x, y int) (c [3]uint16, a uint16) {
+	switch m := img.(type) {
+	case *image.NRGBA64:
+		p := m.Pix[m.PixOffset(x, y):]
+		return [3]uint16{be16(p), be16(p[2:]), be16(p[4:])}, be16(p[6:])
+	case *image.RGBA64:
+		p := m.Pix[m.PixOffset(x, y):]
+		c, a = [3]uint16{be16(p), be16(p[2:]), be16(p[4:])}, be16(p[6:])
+		if a != 0 && a != 0xFFFF {
+			for k := range c {
+				c[k] = uint16(min(uint32(c[k])*65535/uint32(a), 65535))
+			}
+		}
+		return c, a
+	case *image.Gray16:
+		v := be16(m.Pix[m.PixOffset(x, y):])
+		return [3]uint16{v, v, v}, 0xFFFF
+	}
+	return c, 0 // non atteint : types filtrés par is16bit
+}
+
+func be16(p []byte) uint16 { return uint16(p[0])<<8 | uint16(p[1]) }
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"slices"
+	"strings"
+)
+
+// ── Backends d'encodage ───────────────────────────────────────────────────────
+// encodeToBuffer délègue l'écriture des octets à un encoder. Le backend "go" (image/jpeg, jpeg.go,
+// webp.go, image/png) est le défaut et le seul livré : pas de cgo, image Docker minimale.
+// Un backend natif (libvips, mozjpeg) se branche dans un fichier à part, sous build tag (cgo),
+// en s'enregistrant dans encoders depuis son init() ; ENCODER=<nom> le sélectionne au démarrage.
+// Un backend demandé mais absent du binaire empêche le démarrage.
+
+// encoder écrit une image au format jpeg, webp ou png. q est déjà sur l'échelle du codec (cf. formatQuality).
+type encoder interface {
+	encode(w io.Writer, img image.Image, format string, q int, opts encodeOptions) (contentType string, err error)
+}
+
+// encoders : backends compilés dans le binaire, par nom.
+var encoders = map[string]encoder{"go": goEncoder{}}
+
+// activeEncoder : backend choisi par ENCODER au démarrage.
+var (
+	activeEncoder encoder = goEncoder{}
+	encoderName           = "go"
+)
+
+// selectEncoder active le backend name ("" = défaut).
+func selectEncoder(name string) error {
+	if name == "" {
+		return nil
+	}
+	e, ok := encoders[name]
+	if !ok {
+		names := make([]string, 0, len(encoders))
+		for n := range encoders {
+			names = append(names, n)
+		}
+		slices.Sort(names)
+		return fmt.Errorf("ENCODER=%s non compilé dans ce binaire (disponibles : %s)", name, strings.Join(names, ", "))
+	}
+	activeEncoder, encoderName = e, name
+	return nil
+}
+
+// goEncoder : encodeurs pur Go.
+type goEncoder struct{}
+
+func (goEncoder) encode(w io.Writer, img image.Image, format string, q int, opts encodeOptions) (string, error) {
+	switch format {
+	case "webp":
+		return "image/webp", encodeWebP(w, img, q)
+	case "png":
+		return "image/png", pngEncoder.Encode(w, img) // sans perte : pas de halo de compression autour du texte
+	}
+	if opts.progressive {
+		return "image/jpeg", encodeProgressiveJPEG(w, img, q, opts.chroma444) // aperçu complet dès les premiers Ko
+	}
+	return "image/jpeg", jpeg.Encode(w, img, &jpeg.Options{Quality: q})
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+// ── EXIF / XMP en sortie ──────────────────────────────────────────────────────
+// Le ré-encodage supprime toutes les métadonnées. Trois options les réécrivent :
+//   - keep_icc=true : profil ICC source (cf. icc.go) ;
+//   - keep_exif=true : appareil, objectif et exposition de la source — jamais le GPS, ni la
+//     miniature, ni les tags constructeur (MakerNote, qui peut contenir un numéro de série) ;
+//   - set_copyright=true : le texte du watermark dans EXIF Copyright et XMP dc:rights —
+//     la mention survit au recadrage du watermark par un tiers.
+
+const (
+	tagExifIFD   = 0x8769 // pointeur IFD0 → IFD Exif
+	tagCopyright = 0x8298
+	maxIFDCount  = 512 // au-delà, l'IFD est considéré corrompu
+)
+
+// exifKeep : tags conservés par keep_exif, par IFD. Tout le reste est abandonné.
+var exifKeep = struct{ ifd0, exif []uint16 }{
+	ifd0: []uint16{
+		0x010F, // Make
+		0x0110, // Model
+		0x0131, // Software
+		0x0132, // DateTime
+		0x013B, // Artist
+		0x8298, // Copyright
+	},
+	exif: []uint16{
+		0x829A, // ExposureTime
+		0x829D, // FNumber
+		0x8822, // ExposureProgram
+		0x8827, // ISOSpeedRatings
+		0x9003, // DateTimeOriginal
+		0x9004, // DateTimeDigitized
+		0x9201, // ShutterSpeedValue
+		0x9202, // ApertureValue
+		0x9204, // ExposureBiasValue
+		0x9207, // MeteringMode
+		0x9209, // Flash
+		0x920A, // FocalLength
+		0xA403, // WhiteBalance
+		0xA405, // FocalLengthIn35mmFilm
+		0xA433, // LensMake
+		0xA434, // LensModel
+	},
+}
+
+// tiffTypeSize : taille d'un composant par type TIFF (BYTE, ASCII, SHORT, LONG, RATIONAL,
+// SBYTE, UNDEFINED, SSHORT, SLONG, SRATIONAL, FLOAT, DOUBLE).
+var tiffTypeSize = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+// metaParams : options de métadonnées de sortie, validées avant le décodage.
+type metaParams struct {
+	keepICC, keepExif, copyright bool
+}
+
+// metaParamsFrom lit keep_icc, keep_exif et set_copyright — false par défaut.
+func metaParamsFrom(r *http.Request) (p metaParams, err error) {
+	for _, f := range []struct {
+		name string
+		dst  *bool
+	}{{"keep_icc", &p.keepICC}, {"keep_exif", &p.keepExif}, {"set_copyright", &p.copyright}} {
+		v := r.FormValue(f.name)
+		if v == "" {
+			continue
+		}
+		if *f.dst, err = strconv.ParseBool(v); err != nil {
+			return metaParams{}, fmt.Errorf("%s invalide : %q (true ou false)", f.name, v)
+		}
+	}
+	return p, nil
+}
+
+// buildOutMeta assemble les métadonnées à réécrire. Le fichier source est relu (en-têtes seulement),
+// comme pour le GPS et l'ICC. Le copyright est le texte du premier calque texte du watermark.
+func buildOutMeta(r *http.Request, p metaParams, layers []wmOptions) outMeta {
+	var m outMeta
+	if p.keepICC {
+		if m.icc = imageICC(r); iccSpace(m.icc) == "CMYK" { // pixels convertis en RGB au décodage : profil caduc
+			m.icc = nil
+		}
+	}
+	var src []byte
+	if p.keepExif {
+		if file, _, err := r.FormFile("image"); err == nil {
+			src = readExif(file)
+			file.Close()
+		}
+	}
+	var copyright string
+	if p.copyright {
+		for _, opts := range layers {
+			if opts.logo == nil {
+				copyright = opts.text
+				break
+			}
+		}
+	}
+	m.exif = buildExif(src, copyright)
+	if copyright != "" {
+		m.xmp = rightsXMP(copyright)
+	}
+	return m
+}
+
+// tiffEntry est une entrée d'IFD, valeur brute dans l'ordre d'octets du bloc d'origine.
+type tiffEntry struct {
+	tag, typ uint16
+	count    uint32
+	value    []byte
+}
+
+// buildExif construit un bloc TIFF avec les tags conservés de src (nil = aucun) et, si copyright
+// n'est pas vide, le tag Copyright. L'ordre d'octets de la source est conservé : les valeurs sont
+// recopiées telles quelles. Retourne nil s'il n'y a rien à écrire.
+func buildExif(src []byte, copyright string) []byte {
+	var order binary.ByteOrder = binary.BigEndian
+	var ifd0, exif []tiffEntry
+	if o := tiffOrder(src); o != nil {
+		order = o
+		ifd0 = readIFD(src, order, int(order.Uint32(src[4:8])))
+		for _, e := range ifd0 {
+			if e.tag == tagExifIFD && e.typ == 4 && e.count == 1 {
+				exif = readIFD(src, order, int(order.Uint32(e.value)))
+			}
+		}
+		ifd0 = keepTags(ifd0, exifKeep.ifd0)
+		exif = keepTags(exif, exifKeep.exif)
+	}
+	if copyright != "" {
+		ifd0 = slices.DeleteFunc(ifd0, func(e tiffEntry) bool { return e.tag == tagCopyright })
+		v := append([]byte(copyright), 0) // ASCII terminé par NUL — UTF-8 accepté par les lecteurs courants
+		ifd0 = append(ifd0, tiffEntry{tag: tagCopyright, typ: 2, count: uint32(len(v)), value: v})
+	}
+	if len(ifd0) == 0 && len(exif) == 0 {
+		return nil
+	}
+	if len(exif) > 0 {
+		ifd0 = append(ifd0, tiffEntry{tag: tagExifIFD, typ: 4, count: 1, value: make([]byte, 4)}) // offset rempli à l'écriture
+	}
+	return writeTIFF(order, ifd0, exif)
+}
+
+// tiffOrder retourne l'ordre d'octets d'un bloc TIFF, ou nil s'il est invalide.
+func tiffOrder(tiff []byte) binary.ByteOrder {
+	if len(tiff) < 8 {
+		return nil
+	}
+	switch string(tiff[:2]) {
+	case "II":
+		return binary.LittleEndian
+	case "MM":
+		return binary.BigEndian
+	}
+	return nil
+}
+
+// readIFD lit les entrées de l'IFD à l'offset off. Une entrée hors limites arrête la lecture.
+func readIFD(tiff []byte, order binary.ByteOrder, off int) []tiffEntry {
+	if off < 8 || off+2 > len(tiff) {
+		return nil
+	}
+	count := int(order.Uint16(tiff[off:]))
+	if count > maxIFDCount {
+		return nil
+	}
+	entries := make([]tiffEntry, 0, count)
+	for i := range count {
+		e := off + 2 + i*12 // entrée : tag(2) type(2) count(4) valeur/offset(4)
+		if e+12 > len(tiff) {
+			break
+		}
+		typ, n := order.Uint16(tiff[e+2:]), order.Uint32(tiff[e+4:])
+		unit, ok := tiffTypeSize[typ]
+		if !ok || n > maxExifSize {
+			continue
+		}
+		size := unit * int(n)
+		var value []byte
+		if size <= 4 {
+			value = tiff[e+8 : e+8+size]
+		} else {
+			vo := int(order.Uint32(tiff[e+8:]))
+			if vo < 0 || vo+size > len(tiff) {
+				continue
+			}
+			value = tiff[vo : vo+size]
+		}
+		entries = append(entries, tiffEntry{tag: order.Uint16(tiff[e:]), typ: typ, count: n, value: value})
+	}
+	return entries
+}
+
+// keepTags filtre les entrées sur la liste tags.
+func keepTags(entries []tiffEntry, tags []uint16) []tiffEntry {
+	return slices.DeleteFunc(entries, func(e tiffEntry) bool { return !slices.Contains(tags, e.tag) })
+}
+
+// writeTIFF écrit l'en-tête, IFD0, l'IFD Exif éventuel, puis les valeurs de plus de 4 octets.
+func writeTIFF(order binary.ByteOrder, ifd0, exif []tiffEntry) []byte {
+	byTag := func(a, b tiffEntry) int { return int(a.tag) - int(b.tag) } // la spec impose l'ordre croissant
+	slices.SortFunc(ifd0, byTag)
+	slices.SortFunc(exif, byTag)
+	ifdSize := func(n int) int { return 2 + 12*n + 4 }
+	exifOff := 8 + ifdSize(len(ifd0))
+	dataOff := exifOff
+	if len(exif) > 0 {
+		dataOff += ifdSize(len(exif))
+	}
+
+	var head, data bytes.Buffer
+	if order == binary.LittleEndian {
+		head.WriteString("II")
+	} else {
+		head.WriteString("MM")
+	}
+	binary.Write(&head, order, uint16(42)) //nolint:errcheck — bytes.Buffer
+	binary.Write(&head, order, uint32(8))  //nolint:errcheck
+	writeIFD := func(entries []tiffEntry) {
+		binary.Write(&head, order, uint16(len(entries))) //nolint:errcheck
+		for _, e := range entries {
+			binary.Write(&head, order, e.tag)   //nolint:errcheck
+			binary.Write(&head, order, e.typ)   //nolint:errcheck
+			binary.Write(&head, order, e.count) //nolint:errcheck
+			switch {
+			case e.tag == tagExifIFD:
+				binary.Write(&head, order, uint32(exifOff)) //nolint:errcheck
+			case len(e.value) <= 4:
+				var v [4]byte // valeur inline, complétée à droite par des zéros
+				copy(v[:], e.value)
+				head.Write(v[:])
+			default:
+				binary.Write(&head, order, uint32(dataOff+data.Len())) //nolint:errcheck
+				data.Write(e.value)
+				if data.Len()&1 == 1 {
+					data.WriteByte(0) // offsets alignés sur un mot
+				}
+			}
+		}
+		binary.Write(&head, order, uint32(0)) //nolint:errcheck — pas d'IFD suivant (pas de miniature)
+	}
+	writeIFD(ifd0)
+	if len(exif) > 0 {
+		writeIFD(exif)
+	}
+	head.Write(data.Bytes())
+	return head.Bytes()
+}
+
+// rightsXMP retourne un paquet XMP minimal portant dc:rights.
+func rightsXMP(rights string) []byte {
+	var esc bytes.Buffer
+	xml.EscapeText(&esc, []byte(rights)) //nolint:errcheck — bytes.Buffer
+	return []byte(`<?xpacket begin="` + "\ufeff" + `" id="W5M0MpCehiHzreSzNTczkc9d"?>` +
+		`<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` +
+		`<rdf:Description rdf:about="" xmlns:dc="http://purl.org/dc/elements/1.1/">` +
+		`<dc:rights><rdf:Alt><rdf:li xml:lang="x-default">` + esc.String() + `</rdf:li></rdf:Alt></dc:rights>` +
+		`</rdf:Description></rdf:RDF></x:xmpmeta><?xpacket end="w"?>`)
+}
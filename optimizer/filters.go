@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ── Filtres ───────────────────────────────────────────────────────────────────
+// filters=grayscale,sepia,brightness:10,contrast:-20 applique un rendu homogène côté serveur,
+// après le resize (moins de pixels à traiter) et avant le watermark (qui garde ses couleurs).
+// Les filtres s'enchaînent dans l'ordre de la liste.
+
+const maxFilters = 8 // une chaîne plus longue n'a pas d'usage réaliste
+
+// filter transforme un pixel RGB non prémultiplié (0-255), en place.
+type filter func(r, g, b *float64)
+
+// filterNames : filtres sans argument. brightness et contrast prennent un pourcentage -100..100.
+var filterNames = map[string]filter{
+	"grayscale": func(r, g, b *float64) {
+		l := 0.299**r + 0.587**g + 0.114**b // luminance BT.601, comme adaptiveColor
+		*r, *g, *b = l, l, l
+	},
+	"sepia": func(r, g, b *float64) { // matrice sépia classique (Microsoft / W3C filter effects)
+		*r, *g, *b = 0.393**r+0.769**g+0.189**b, 0.349**r+0.686**g+0.168**b, 0.272**r+0.534**g+0.131**b
+	},
+}
+
+// filtersParam lit la liste de filtres — nil si le champ est absent.
+func filtersParam(r *http.Request) ([]filter, error) {
+	v := r.FormValue("filters")
+	if v == "" {
+		return nil, nil
+	}
+	var filters []filter
+	for _, s := range strings.Split(v, ",") {
+		name, arg, hasArg := strings.Cut(strings.TrimSpace(s), ":")
+		if f, ok := filterNames[name]; ok && !hasArg {
+			filters = append(filters, f)
+			continue
+		}
+		if name != "brightness" && name != "contrast" {
+			return nil, fmt.Errorf("filtre inconnu : %q (grayscale, sepia, brightness:N, contrast:N)", s)
+		}
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < -100 || n > 100 {
+			return nil, fmt.Errorf("filtre %s invalide : %q (pourcentage -100 à 100)", name, arg)
+		}
+		filters = append(filters, adjustFilter(name, float64(n)/100))
+	}
+	if len(filters) > maxFilters {
+		return nil, fmt.Errorf("filters : %d filtres au maximum", maxFilters)
+	}
+	return filters, nil
+}
+
+// adjustFilter construit brightness (décalage de ±255×k) ou contrast (pente autour du gris moyen :
+// -100 % donne un gris uni, +100 % double l'écart au milieu).
+func adjustFilter(name string, k float64) filter {
+	if name == "brightness" {
+		d := 255 * k
+		return func(r, g, b *float64) { *r, *g, *b = *r+d, *g+d, *b+d }
+	}
+	f := 1 + k
+	return func(r, g, b *float64) {
+		*r, *g, *b = (*r-128)*f+128, (*g-128)*f+128, (*b-128)*f+128
+	}
+}
+
+// effects regroupe les retouches appliquées après le resize et avant le watermark : conversion
+// sRGB (cf. icc.go), netteté (cf. sharpen.go), filtres de rendu puis zones floutées (cf. privacy.go) — le flou
+// vient en dernier pour qu'aucune retouche ne redonne du détail à une zone anonymisée.
+type effects struct {
+	color   *iccTransform // profil source → sRGB, nil si rien à convertir
+	sharpen int           // 0-100, 0 = désactivé
+	filters []filter
+	regions []image.Rectangle // repère de l'image avant resize (après rotation et recadrage)
+}
+
+// effectsParams lit sharpen, filters, blur_regions et auto_faces.
+func effectsParams(r *http.Request) (fx effects, err error) {
+	if fx.sharpen, err = sharpenParam(r); err != nil {
+		return effects{}, err
+	}
+	if fx.filters, err = filtersParam(r); err != nil {
+		return effects{}, err
+	}
+	if fx.regions, err = blurRegionsParam(r); err != nil {
+		return effects{}, err
+	}
+	return fx, nil
+}
+
+func (fx effects) none() bool {
+	return fx.color == nil && fx.sharpen == 0 && fx.filters == nil && fx.regions == nil
+}
+
+// apply retourne une copie retouchée de img, réduite depuis une source de bornes src — la source
+// (éventuellement l'image décodée ou une SubImage du recadrage) n'est jamais modifiée.
+// Le résultat est un canvas à libérer par l'appelant.
+func (fx effects) apply(img image.Image, src image.Rectangle) *image.RGBA {
+	b := img.Bounds()
+	dst := newCanvas(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+	if fx.color != nil {
+		fx.color.apply(dst)
+	}
+	if fx.sharpen > 0 {
+		unsharpMask(dst, fx.sharpen)
+	}
+	if fx.filters != nil {
+		applyFilters(dst, fx.filters)
+	}
+	if fx.regions != nil {
+		blurRegions(dst, scaleRegions(fx.regions, src, b.Dx(), b.Dy()))
+	}
+	return dst
+}
+
+// applyFilters applique la chaîne de filtres au canvas, en place.
+func applyFilters(dst *image.RGBA, filters []filter) {
+	pix := dst.Pix
+	for i := 0; i+3 < len(pix); i += 4 {
+		a := float64(pix[i+3])
+		if a == 0 {
+			continue // pixel transparent — rien de visible à filtrer
+		}
+		un := 255 / a // le canvas est prémultiplié : les filtres travaillent sur la couleur réelle
+		r, g, bl := float64(pix[i])*un, float64(pix[i+1])*un, float64(pix[i+2])*un
+		for _, f := range filters {
+			f(&r, &g, &bl)
+		}
+		pm := a / 255
+		pix[i] = clamp8(r, pm)
+		pix[i+1] = clamp8(g, pm)
+		pix[i+2] = clamp8(bl, pm)
+	}
+}
+
+// clamp8 borne v à 0-255 puis le reprémultiplie par pm (alpha / 255) — un canal ne dépasse jamais l'alpha.
+func clamp8(v, pm float64) uint8 {
+	return uint8(min(max(v, 0), 255)*pm + 0.5)
+}
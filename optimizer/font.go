@@ -0,0 +1,307 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goitalic"
+	"golang.org/x/image/font/gofont/gomedium"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/gomonobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/gofont/gosmallcaps"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// ── Polices ───────────────────────────────────────────────────────────────────
+// Une police est choisie par nom parmi les polices embarquées (wm_font) ou envoyée
+// par le client (wm_font_file, TTF/OTF). Chaque police est parsée une seule fois et
+// identifiée par le SHA-256 de son fichier : un client qui renvoie la même police à
+// chaque requête ne paie le parsing qu'au premier envoi.
+//
+// Les polices de secours (FALLBACK_FONTS_DIR) complètent n'importe quelle police : un
+// caractère absent (CJK, emoji, diacritiques rares) est tracé avec la première police de
+// secours qui le contient, au lieu d'un rectangle « tofu ».
+
+const (
+	defaultFontName  = "regular"
+	maxFontBytes     = 4 << 20 // une police TTF dépasse rarement 1 Mo — au-delà c'est suspect
+	maxUploadedFonts = 32      // polices client gardées en cache — les plus anciennes sont évincées
+)
+
+// embeddedTTF : polices Go compilées dans le binaire — aucun fichier externe requis,
+// ce qui simplifie le déploiement Docker (pas de dépendance apk ou de montage de volume).
+// La famille Go n'a pas de serif : une police serif passe par wm_font_file.
+var embeddedTTF = map[string][]byte{
+	"regular":   goregular.TTF,
+	"bold":      gobold.TTF,
+	"italic":    goitalic.TTF,
+	"medium":    gomedium.TTF,
+	"mono":      gomono.TTF,
+	"mono-bold": gomonobold.TTF,
+	"smallcaps": gosmallcaps.TTF,
+}
+
+// cachedFace associe une font.Face à son verrou : opentype.Face réutilise son rasteriseur et le
+// masque retourné par Glyph — une même face ne peut servir qu'à un seul tracé à la fois.
+type cachedFace struct {
+	sync.Mutex
+	font.Face
+}
+
+// fontEntry est une police parsée et ses faces, créées à la demande (une par taille en px).
+type fontEntry struct {
+	font  *opentype.Font
+	mu    sync.Mutex
+	faces map[int]*cachedFace
+}
+
+var (
+	embeddedFonts = map[string]*fontEntry{} // rempli par loadFont, en lecture seule ensuite
+
+	fontsMu      sync.Mutex
+	fonts        = map[[sha256.Size]byte]*fontEntry{} // toutes les polices parsées, par empreinte
+	uploadedKeys [][sha256.Size]byte                  // ordre d'arrivée des polices client — éviction FIFO
+
+	fallbackFonts []*opentype.Font // chaîne de secours, dans l'ordre des fichiers — en lecture seule après loadFont
+)
+
+// loadFont parse les polices embarquées et pré-crée la face par défaut.
+func loadFont() error {
+	t := time.Now()
+	total := 0
+	for name, ttf := range embeddedTTF {
+		entry, err := registerFont(ttf, false)
+		if err != nil {
+			return fmt.Errorf("police %s : %w", name, err)
+		}
+		embeddedFonts[name] = entry
+		total += len(ttf)
+	}
+	if dir := os.Getenv("FALLBACK_FONTS_DIR"); dir != "" { // ex: un volume monté avec Noto Sans CJK et Noto Emoji
+		if err := loadFallbackFonts(dir); err != nil {
+			return fmt.Errorf("polices de secours : %w", err)
+		}
+	}
+	_, err := embeddedFonts[defaultFontName].face(defaultFontSize) // la face par défaut sert à presque toutes les requêtes
+
+	logger.Info().Str("component", "init").Strs("fonts", fontNames()).Str("size", formatBytes(total)).Dur("duration", time.Since(t)).Msg("polices chargées")
+	return err
+}
+
+// loadFallbackFonts parse les polices de secours du dossier, triées par nom de fichier :
+// l'ordre de la chaîne se règle en préfixant les fichiers (10-noto-cjk.ttc, 20-noto-emoji.ttf…).
+// Les collections .ttc apportent toutes leurs polices, dans l'ordre de la collection.
+func loadFallbackFonts(dir string) error {
+	entries, err := os.ReadDir(dir) // ReadDir trie par nom
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		ext := strings.ToLower(filepath.Ext(e.Name()))
+		if e.IsDir() || (ext != ".ttf" && ext != ".otf" && ext != ".ttc") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return err
+		}
+		c, err := opentype.ParseCollection(data) // accepte aussi un TTF/OTF seul (collection d'une police)
+		if err != nil {
+			return fmt.Errorf("%s : %w", e.Name(), err)
+		}
+		for i := range c.NumFonts() {
+			f, err := c.Font(i)
+			if err != nil {
+				return fmt.Errorf("%s : %w", e.Name(), err)
+			}
+			fallbackFonts = append(fallbackFonts, f)
+		}
+	}
+	logger.Info().Str("component", "init").Str("dir", dir).Int("fallback_fonts", len(fallbackFonts)).Msg("polices de secours chargées")
+	return nil
+}
+
+// fontParams retourne la police demandée : fichier wm_font_file en priorité, sinon le nom wm_font.
+// Le second résultat identifie la police dans les logs.
+func fontParams(r wmSource) (*fontEntry, string, error) {
+	file, header, err := r.FormFile("wm_font_file")
+	if err == nil {
+		defer file.Close()
+		ttf, err := io.ReadAll(io.LimitReader(file, maxFontBytes+1)) // +1 pour détecter le dépassement
+		if err != nil {
+			return nil, "", fmt.Errorf("police illisible")
+		}
+		if len(ttf) > maxFontBytes {
+			return nil, "", fmt.Errorf("police trop lourde (max %s)", formatBytes(maxFontBytes))
+		}
+		entry, err := registerFont(ttf, true)
+		if err != nil {
+			return nil, "", fmt.Errorf("police illisible (TTF ou OTF attendu)")
+		}
+		return entry, "upload:" + header.Filename, nil
+	}
+	if !errors.Is(err, http.ErrMissingFile) {
+		return nil, "", fmt.Errorf("police illisible")
+	}
+
+	name := r.FormValue("wm_font")
+	if name == "" {
+		name = defaultFontName
+	}
+	entry, ok := embeddedFonts[name]
+	if !ok {
+		return nil, "", fmt.Errorf("wm_font inconnue : %q (disponibles : %v)", name, fontNames())
+	}
+	return entry, name, nil
+}
+
+// registerFont retourne la police correspondant au fichier, parsée au premier envoi seulement.
+func registerFont(ttf []byte, uploaded bool) (*fontEntry, error) {
+	key := sha256.Sum256(ttf)
+	fontsMu.Lock()
+	entry, ok := fonts[key]
+	fontsMu.Unlock()
+	if uploaded { // les polices embarquées, chargées au démarrage, ne comptent pas
+		result := "miss"
+		if ok {
+			result = "hit"
+		}
+		fontCacheLookups.WithLabelValues(result).Inc()
+	}
+	if ok {
+		return entry, nil // cache hit — cas nominal d'un client qui envoie toujours la même police
+	}
+
+	f, err := opentype.Parse(ttf) // hors verrou : parser une grosse police ne bloque pas les autres requêtes
+	if err != nil {
+		return nil, err
+	}
+
+	fontsMu.Lock()
+	defer fontsMu.Unlock()
+	if entry, ok := fonts[key]; ok { // parsée entre-temps par une requête concurrente
+		return entry, nil
+	}
+	entry = &fontEntry{font: f, faces: map[int]*cachedFace{}}
+	fonts[key] = entry
+	if uploaded {
+		uploadedKeys = append(uploadedKeys, key)
+		if len(uploadedKeys) > maxUploadedFonts { // les requêtes en cours gardent leur *fontEntry
+			delete(fonts, uploadedKeys[0])
+			uploadedKeys = uploadedKeys[1:]
+		}
+		logger.Debug().Str("step", "font").Int("cached_uploads", len(uploadedKeys)).Msg("police client parsée")
+	}
+	return entry, nil
+}
+
+// face retourne la face de la taille demandée, créée au premier usage puis gardée en cache.
+func (e *fontEntry) face(size int) (*cachedFace, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if f, ok := e.faces[size]; ok {
+		return f, nil
+	}
+	face, err := newFace(e.font, size)
+	if err != nil {
+		return nil, err
+	}
+	if len(fallbackFonts) > 0 { // chaque face a ses propres faces de secours — un seul verrou couvre toute la chaîne
+		chain := &chainFace{fonts: []*opentype.Font{e.font}, faces: []font.Face{face}}
+		for _, fb := range fallbackFonts {
+			fbFace, err := newFace(fb, size)
+			if err != nil {
+				return nil, err
+			}
+			chain.fonts = append(chain.fonts, fb)
+			chain.faces = append(chain.faces, fbFace)
+		}
+		face = chain
+	}
+	f := &cachedFace{Face: face}
+	e.faces[size] = f
+	logger.Debug().Str("step", "font").Int("size", size).Int("cached", len(e.faces)).Msg("face créée")
+	return f, nil
+}
+
+// newFace crée une face opentype de size px.
+func newFace(f *opentype.Font, size int) (font.Face, error) {
+	return opentype.NewFace(f, &opentype.FaceOptions{
+		Size: float64(size), // en pt — égal aux px à 72 DPI
+		DPI:  72,            // 72 DPI = convention écran (1pt = 1px)
+	})
+}
+
+// fontNames liste les polices embarquées, triées — pour les logs et les messages d'erreur.
+func fontNames() []string {
+	return slices.Sorted(maps.Keys(embeddedTTF))
+}
+
+// ── Chaîne de secours ─────────────────────────────────────────────────────────
+
+// chainFace est une font.Face qui choisit, caractère par caractère, la première police de la
+// chaîne contenant le glyphe : police demandée d'abord, puis polices de secours dans l'ordre.
+// Mesure et tracé passent par la même sélection — largeur et rendu restent cohérents.
+// Les métriques verticales sont celles de la police demandée : la mise en page ne bouge pas
+// quand un caractère de secours apparaît. Les emoji couleur (CBDT, SVG) ne sont pas rendus :
+// x/image ne trace que les contours, une police emoji monochrome est nécessaire.
+type chainFace struct {
+	fonts []*opentype.Font // fonts[i] est la police de faces[i]
+	faces []font.Face
+	buf   sfnt.Buffer // tampon de GlyphIndex — protégé par le verrou de cachedFace
+}
+
+// pick retourne la face qui trace r — la police demandée si aucune ne le contient (glyphe .notdef).
+func (c *chainFace) pick(r rune) font.Face {
+	for i, f := range c.fonts {
+		if idx, err := f.GlyphIndex(&c.buf, r); err == nil && idx != 0 { // index 0 = glyphe manquant
+			return c.faces[i]
+		}
+	}
+	return c.faces[0]
+}
+
+func (c *chainFace) Glyph(dot fixed.Point26_6, r rune) (image.Rectangle, image.Image, image.Point, fixed.Int26_6, bool) {
+	return c.pick(r).Glyph(dot, r)
+}
+
+func (c *chainFace) GlyphBounds(r rune) (fixed.Rectangle26_6, fixed.Int26_6, bool) {
+	return c.pick(r).GlyphBounds(r)
+}
+
+func (c *chainFace) GlyphAdvance(r rune) (fixed.Int26_6, bool) {
+	return c.pick(r).GlyphAdvance(r)
+}
+
+// Kern n'a de sens qu'entre deux glyphes d'une même police.
+func (c *chainFace) Kern(r0, r1 rune) fixed.Int26_6 {
+	if f := c.pick(r0); f == c.pick(r1) {
+		return f.Kern(r0, r1)
+	}
+	return 0
+}
+
+func (c *chainFace) Metrics() font.Metrics { return c.faces[0].Metrics() }
+
+func (c *chainFace) Close() error {
+	for _, f := range c.faces {
+		f.Close()
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"optimizer/optimizerpb"
+)
+
+// ── gRPC ──────────────────────────────────────────────────────────────────────
+// Optimize (optimizerpb/optimizer.proto) est servi sur GRPC_ADDR (défaut :3002, "off" pour le
+// couper) à côté de l'HTTP ; l'API l'utilise si OPTIMIZER_GRPC_ADDR est défini. L'image arrive par
+// morceaux dans un flux HTTP/2 — pas de boundary multipart à produire côté API — et l'échéance de
+// l'appel suit le contexte gRPC : un appel expiré ou annulé en attente d'un slot n'est pas traité.
+//
+// Le traitement reste celui de handleOptimize, appelé avec une requête reconstituée en mémoire :
+// un seul pipeline à maintenir, mêmes limites, mêmes headers X-Image-* (renvoyés dans fields).
+// Une connexion HTTP/2 multiplexe les appels : derrière un load balancer, l'équilibrage se fait
+// par appel côté client (round_robin de l'API), pas par connexion.
+
+const grpcChunkSize = 64 << 10 // taille des morceaux de la réponse
+
+// optimizerServer implémente optimizerpb.OptimizerServer.
+type optimizerServer struct {
+	optimizerpb.UnimplementedOptimizerServer
+}
+
+// initGRPC démarre le serveur gRPC en arrière-plan — appelé au démarrage, avant l'écoute HTTP.
+func initGRPC() {
+	addr := os.Getenv("GRPC_ADDR")
+	switch addr {
+	case "off":
+		return
+	case "":
+		addr = ":3002"
+	}
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		logger.Fatal().Err(err).Str("grpc_addr", addr).Msg("écoute gRPC impossible")
+	}
+	// Le premier message porte les fichiers annexes (logo, police) : la limite par message est
+	// celle du body complet de /optimize (plus 1 Mo pour les champs), pas les 4 Mo par défaut de gRPC.
+	// recoveryInterceptor à l'intérieur : un panic est compté comme une erreur 500 par metricsInterceptor.
+	srv := grpc.NewServer(grpc.MaxRecvMsgSize(int(maxUploadSize)+1<<20), grpc.ChainStreamInterceptor(metricsInterceptor, recoveryInterceptor))
+	optimizerpb.RegisterOptimizerServer(srv, optimizerServer{})
+	go func() {
+		if err := srv.Serve(lis); err != nil {
+			logger.Fatal().Err(err).Msg("serveur gRPC arrêté")
+		}
+	}()
+	logger.Info().Str("component", "init").Str("grpc_addr", addr).Msg("gRPC activé")
+}
+
+// Optimize reçoit l'image, la fait traiter par handleOptimize et renvoie le résultat par morceaux.
+func (optimizerServer) Optimize(stream optimizerpb.Optimizer_OptimizeServer) error {
+	ctx := stream.Context()
+	head, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	var data bytes.Buffer
+	data.Write(head.GetData())
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if int64(data.Len()+len(chunk.GetData())) > maxUploadSize { // même limite que limitUpload, vérifiée au fil des morceaux
+			return grpcError(stream, http.StatusRequestEntityTooLarge, fmt.Sprintf("fichier trop volumineux (max %s)", formatBytes(int(maxUploadSize))))
+		}
+		data.Write(chunk.GetData())
+	}
+
+	r, err := grpcRequest(ctx, head, data.Bytes())
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	rec := &bufferedResponse{header: http.Header{}, status: http.StatusOK}
+	handleOptimize(rec, r)
+	if err := ctx.Err(); err != nil { // abandonné avant traitement : rien d'utile dans rec
+		return status.FromContextError(err).Err()
+	}
+	if rec.status != http.StatusOK {
+		return grpcError(stream, rec.status, strings.TrimSpace(rec.body.String()))
+	}
+
+	fields := make(map[string]string, len(rec.header))
+	for k, v := range rec.header {
+		fields[k] = strings.Join(v, ", ")
+	}
+	out := rec.body.Bytes()
+	for first := true; first || len(out) > 0; first = false { // au moins un message : il porte les headers
+		n := min(len(out), grpcChunkSize)
+		msg := &optimizerpb.ImageChunk{Data: out[:n]}
+		if first {
+			msg.Fields = fields
+		}
+		if err := stream.Send(msg); err != nil {
+			return err
+		}
+		out = out[n:]
+	}
+	return nil
+}
+
+// grpcRequest reconstitue la requête multipart que handleOptimize attend, en mémoire.
+func grpcRequest(ctx context.Context, head *optimizerpb.ImageChunk, data []byte) (*http.Request, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, err := mw.CreateFormFile("image", head.GetFilename())
+	if err != nil {
+		return nil, err
+	}
+	part.Write(data) //nolint:errcheck — écriture dans un bytes.Buffer, ne peut pas échouer
+	for k, v := range head.GetFields() {
+		mw.WriteField(k, v) //nolint:errcheck — idem
+	}
+	for _, f := range head.GetFiles() {
+		fp, err := mw.CreateFormFile(f.GetField(), f.GetFilename())
+		if err != nil {
+			return nil, err
+		}
+		fp.Write(f.GetData()) //nolint:errcheck — idem
+	}
+	mw.Close()
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, "/optimize", &body)
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	if p, ok := peer.FromContext(ctx); ok {
+		r.RemoteAddr = p.Addr.String() // tracé par l'audit GPS, comme pour une requête HTTP
+	}
+	return r, nil
+}
+
+// grpcError termine l'appel en erreur : code gRPC équivalent, et code HTTP exact dans le trailer
+// "http-status" pour que l'API réponde à son client comme avec /optimize.
+func grpcError(stream grpc.ServerStream, httpStatus int, msg string) error {
+	stream.SetTrailer(metadata.Pairs("http-status", strconv.Itoa(httpStatus)))
+	code := codes.Internal
+	switch httpStatus {
+	case http.StatusBadRequest, http.StatusUnsupportedMediaType, http.StatusUnprocessableEntity:
+		code = codes.InvalidArgument
+	case http.StatusRequestEntityTooLarge:
+		code = codes.ResourceExhausted
+	case http.StatusNotImplemented:
+		code = codes.Unimplemented
+	case http.StatusServiceUnavailable:
+		code = codes.Unavailable
+	case http.StatusGatewayTimeout:
+		code = codes.DeadlineExceeded
+	}
+	return status.Error(code, msg)
+}
+
+// metricsInterceptor compte les appels gRPC dans les métriques HTTP : route "grpc:<méthode>",
+// statut HTTP équivalent (trailer http-status, 500 pour une autre erreur), octets des morceaux d'image.
+func metricsInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	ms := &metricsStream{ServerStream: ss, status: http.StatusOK}
+	err := handler(srv, ms)
+	if err != nil && ms.status == http.StatusOK { // flux coupé, client parti
+		ms.status = http.StatusInternalServerError
+	}
+	observeRequest("grpc:"+path.Base(info.FullMethod), http.MethodPost, ms.status, time.Since(start), ms.in, ms.out)
+	return err
+}
+
+// recoveryInterceptor transforme un panic du traitement en erreur codes.Internal — sans lui, un
+// panic dans la goroutine de l'appel arrête tout le process (net/http, lui, récupère ceux de l'HTTP).
+func recoveryInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			logger.Error().Str("step", "grpc").Str("method", info.FullMethod).Interface("panic", p).Bytes("stack", debug.Stack()).Msg("panic dans un appel gRPC")
+			err = status.Error(codes.Internal, "erreur interne")
+		}
+	}()
+	return handler(srv, ss)
+}
+
+// metricsStream relève le statut posé par grpcError et compte les octets d'image échangés.
+type metricsStream struct {
+	grpc.ServerStream
+	status  int
+	in, out int64
+}
+
+func (m *metricsStream) SetTrailer(md metadata.MD) {
+	if v := md.Get("http-status"); len(v) > 0 {
+		m.status, _ = strconv.Atoi(v[0])
+	}
+	m.ServerStream.SetTrailer(md)
+}
+
+func (m *metricsStream) RecvMsg(msg any) error {
+	err := m.ServerStream.RecvMsg(msg)
+	if c, ok := msg.(*optimizerpb.ImageChunk); ok && err == nil {
+		m.in += int64(len(c.GetData()))
+	}
+	return err
+}
+
+func (m *metricsStream) SendMsg(msg any) error {
+	if c, ok := msg.(*optimizerpb.ImageChunk); ok {
+		m.out += int64(len(c.GetData()))
+	}
+	return m.ServerStream.SendMsg(msg)
+}
+
+// bufferedResponse garde en mémoire la réponse de handleOptimize.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header         { return b.header }
+func (b *bufferedResponse) Write(p []byte) (int, error) { return b.body.Write(p) }
+func (b *bufferedResponse) WriteHeader(status int)      { b.status = status }
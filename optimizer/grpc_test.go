@@ -0,0 +1,21 @@
+package main
+
+import (
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRecoveryInterceptor(t *testing.T) {
+	info := &grpc.StreamServerInfo{FullMethod: "/optimizer.Optimizer/Optimize"}
+	err := recoveryInterceptor(nil, nil, info, func(any, grpc.ServerStream) error {
+		var m map[string]int
+		m["x"]++ // panic : assignation dans une map nil
+		return nil
+	})
+	if status.Code(err) != codes.Internal {
+		t.Errorf("erreur %v, attendu codes.Internal", err)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// ── Sondes ────────────────────────────────────────────────────────────────────
+// GET /livez et /healthz : le processus répond. GET /readyz : l'optimizer peut traiter des
+// images — SPILL_DIR inscriptible si le spill disque est activé ; 503 sinon. Police et gRPC sont
+// vérifiés au démarrage (fatals) : le serveur HTTP n'écoute qu'une fois tout prêt.
+// Le corps donne aussi l'occupation du pool de workers et la disponibilité de ffmpeg, à titre
+// d'information : un pool plein ou l'absence de vidéo ne retirent pas la réplique du trafic.
+// Dans l'image (FROM scratch), "service healthcheck" interroge /readyz et sort en 0 ou 1.
+
+const probeTimeout = 2 * time.Second
+
+func handleLive(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
+
+// handleReady retourne {"status": "ok"|"degraded", "checks": {…}, "workers": {…}, "video": bool}.
+func handleReady(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{}
+	status, code := "ok", http.StatusOK
+	check := func(name string, err error) {
+		if err != nil {
+			checks[name] = err.Error()
+			status, code = "degraded", http.StatusServiceUnavailable
+			return
+		}
+		checks[name] = "ok"
+	}
+	if spillThreshold > 0 {
+		check("spill_dir", checkSpillDir())
+	}
+	if code != http.StatusOK {
+		logger.Warn().Str("step", "readyz").Interface("checks", checks).Msg("optimizer pas prêt")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]any{ //nolint:errcheck — erreur réseau côté client, pas récupérable
+		"status":  status,
+		"checks":  checks,
+		"workers": map[string]int{"busy": len(sem), "total": cap(sem)},
+		"video":   ffmpegPath != "",
+	})
+}
+
+// checkSpillDir vérifie que SPILL_DIR (os.TempDir() par défaut) est inscriptible.
+func checkSpillDir() error {
+	f, err := os.CreateTemp(os.Getenv("SPILL_DIR"), ".readyz-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// runHealthcheck interroge /readyz sur addr et retourne le code de sortie — sonde Docker sans curl.
+func runHealthcheck(addr string) int {
+	resp, err := (&http.Client{Timeout: probeTimeout}).Get("http://127.0.0.1" + addr + "/readyz")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintln(os.Stderr, resp.Status)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"image"
+	"io"
+	"math"
+	"net/http"
+)
+
+// ── Profils ICC ───────────────────────────────────────────────────────────────
+// Le ré-encodage supprime le profil ICC de la source : une photo Display P3 (iPhone, appareils
+// récents) est alors lue comme du sRGB par le navigateur, et ses couleurs sont désaturées.
+// Deux issues, au choix du client :
+//   - keep_icc=true : le profil source est ré-embarqué tel quel dans la sortie JPEG (APP2) ou
+//     WebP (chunk ICCP) — les pixels ne sont pas touchés (cf. embedMetadata) ;
+//   - par défaut : les pixels sont convertis en sRGB et la sortie part sans profil.
+// La conversion ne gère que les profils matriciels (primaires rXYZ/gXYZ/bXYZ + courbes TRC),
+// soit Display P3, Adobe RGB, ProPhoto… Un profil à tables (LUT) est laissé de côté, comme avant.
+
+const (
+	maxICCSize     = 4 << 20 // un profil matriciel fait quelques Ko, un profil LUT rarement plus de 1 Mo
+	iccIdentityTol = 0.01    // écart max à la matrice identité pour considérer le profil comme du sRGB
+)
+
+var iccJPEGTag = []byte("ICC_PROFILE\x00")
+
+// xyzToSRGB convertit XYZ (illuminant D50, espace de connexion ICC) en sRGB linéaire —
+// inverse de la matrice sRGB adaptée en D50 par Bradford.
+var xyzToSRGB = [3][3]float64{
+	{3.1338561, -1.6168667, -0.4906146},
+	{-0.9787684, 1.9161415, 0.0334540},
+	{0.0719453, -0.2289914, 1.4052427},
+}
+
+// imageICC retourne le profil ICC de l'image uploadée, ou nil. Relit le fichier multipart comme imageHasGPS.
+func imageICC(r *http.Request) []byte {
+	file, _, err := r.FormFile("image")
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	return readICC(file)
+}
+
+// readICC retourne le profil ICC brut d'un JPEG (segments APP2), PNG (chunk iCCP) ou WebP (chunk ICCP),
+// ou nil. Même parcours que readExif : seuls les en-têtes sont lus, les données image sont sautées.
+func readICC(f io.ReadSeeker) []byte {
+	var sig [12]byte
+	if _, err := io.ReadFull(f, sig[:]); err != nil {
+		return nil
+	}
+
+	switch {
+	case sig[0] == 0xFF && sig[1] == 0xD8: // JPEG : le profil peut être découpé en plusieurs APP2 numérotés
+		f.Seek(2, io.SeekStart) //nolint:errcheck — une erreur fera échouer la lecture suivante
+		var chunks [][]byte
+		var hdr [4]byte
+		for {
+			if _, err := io.ReadFull(f, hdr[:]); err != nil || hdr[0] != 0xFF {
+				break
+			}
+			marker := hdr[1]
+			if marker == 0xDA || marker == 0xD9 {
+				break
+			}
+			size := int(binary.BigEndian.Uint16(hdr[2:])) - 2
+			if size < 0 {
+				break
+			}
+			if marker == 0xE2 && size > len(iccJPEGTag)+2 { // APP2 — ICC_PROFILE, numéro de séquence, nombre total
+				payload, err := readICCN(f, size)
+				if err != nil {
+					break
+				}
+				if bytes.HasPrefix(payload, iccJPEGTag) {
+					seq, total := int(payload[12]), int(payload[13])
+					if chunks == nil && total > 0 {
+						chunks = make([][]byte, total)
+					}
+					if seq >= 1 && seq <= len(chunks) {
+						chunks[seq-1] = payload[14:]
+					}
+				}
+				continue
+			}
+			if _, err := f.Seek(int64(size), io.SeekCurrent); err != nil {
+				break
+			}
+		}
+		var profile []byte
+		for _, c := range chunks {
+			if c == nil { // segment manquant — profil inutilisable
+				return nil
+			}
+			profile = append(profile, c...)
+		}
+		return profile
+
+	case bytes.Equal(sig[:8], []byte("\x89PNG\r\n\x1a\n")): // PNG : iCCP = nom\0 + méthode (0 = zlib) + profil compressé
+		f.Seek(8, io.SeekStart) //nolint:errcheck
+		var hdr [8]byte
+		for {
+			if _, err := io.ReadFull(f, hdr[:]); err != nil {
+				return nil
+			}
+			size := int64(binary.BigEndian.Uint32(hdr[:4]))
+			switch string(hdr[4:]) {
+			case "iCCP":
+				payload, err := readICCN(f, int(size))
+				if err != nil {
+					return nil
+				}
+				i := bytes.IndexByte(payload, 0)
+				if i < 0 || i+2 > len(payload) {
+					return nil
+				}
+				zr, err := zlib.NewReader(bytes.NewReader(payload[i+2:]))
+				if err != nil {
+					return nil
+				}
+				profile, err := io.ReadAll(io.LimitReader(zr, maxICCSize))
+				if err != nil {
+					return nil
+				}
+				return profile
+			case "IDAT", "IEND": // iCCP doit précéder les données image
+				return nil
+			}
+			if _, err := f.Seek(size+4, io.SeekCurrent); err != nil {
+				return nil
+			}
+		}
+
+	case string(sig[:4]) == "RIFF" && string(sig[8:12]) == "WEBP": // WebP étendu : chunk ICCP juste après VP8X
+		var hdr [8]byte
+		for {
+			if _, err := io.ReadFull(f, hdr[:]); err != nil {
+				return nil
+			}
+			size := int64(binary.LittleEndian.Uint32(hdr[4:]))
+			if string(hdr[:4]) == "ICCP" {
+				profile, err := readICCN(f, int(size))
+				if err != nil {
+					return nil
+				}
+				return profile
+			}
+			if _, err := f.Seek(size+size&1, io.SeekCurrent); err != nil {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// readICCN lit exactement n octets, en refusant les blocs plus grands que maxICCSize.
+func readICCN(r io.Reader, n int) ([]byte, error) {
+	if n > maxICCSize {
+		return nil, io.ErrShortBuffer
+	}
+	buf := make([]byte, n)
+	_, err := io.ReadFull(r, buf)
+	return buf, err
+}
+
+// ── Conversion vers sRGB ──────────────────────────────────────────────────────
+
+// iccTransform convertit les pixels d'un profil matriciel vers sRGB.
+type iccTransform struct {
+	trc    [3][256]float64 // valeur 8 bits → intensité linéaire, par canal
+	matrix [3][3]float64   // RGB linéaire source → sRGB linéaire
+}
+
+// newICCTransform analyse un profil et retourne la conversion vers sRGB — nil si le profil est
+// déjà du sRGB (ou assez proche), n'est pas un profil RGB matriciel, ou est illisible.
+func newICCTransform(profile []byte) *iccTransform {
+	if len(profile) < 132 || string(profile[16:20]) != "RGB " {
+		return nil
+	}
+	tags := map[string][]byte{}
+	count := int(binary.BigEndian.Uint32(profile[128:]))
+	for i := range count {
+		e := 132 + i*12 // entrée : signature(4) offset(4) taille(4)
+		if e+12 > len(profile) {
+			return nil
+		}
+		off, size := int(binary.BigEndian.Uint32(profile[e+4:])), int(binary.BigEndian.Uint32(profile[e+8:]))
+		if off < 0 || size < 0 || off+size > len(profile) {
+			return nil
+		}
+		tags[string(profile[e:e+4])] = profile[off : off+size]
+	}
+
+	var t iccTransform
+	var src [3][3]float64 // colonnes : XYZ des primaires rouge, verte, bleue
+	for c, name := range []string{"r", "g", "b"} {
+		xyz, ok := iccXYZ(tags[name+"XYZ"])
+		if !ok {
+			return nil
+		}
+		for row := range 3 {
+			src[row][c] = xyz[row]
+		}
+		if !iccCurve(tags[name+"TRC"], &t.trc[c]) {
+			return nil
+		}
+	}
+	identity := true
+	for i := range 3 {
+		for j := range 3 {
+			for k := range 3 {
+				t.matrix[i][j] += xyzToSRGB[i][k] * src[k][j]
+			}
+			want := 0.0
+			if i == j {
+				want = 1
+			}
+			identity = identity && math.Abs(t.matrix[i][j]-want) < iccIdentityTol
+		}
+	}
+	if identity { // profil sRGB embarqué (fréquent) : rien à convertir
+		return nil
+	}
+	return &t
+}
+
+// iccXYZ lit un tag de type XYZ : trois s15Fixed16.
+func iccXYZ(tag []byte) (xyz [3]float64, ok bool) {
+	if len(tag) < 20 || string(tag[:4]) != "XYZ " {
+		return xyz, false
+	}
+	for i := range 3 {
+		xyz[i] = float64(int32(binary.BigEndian.Uint32(tag[8+4*i:]))) / 65536
+	}
+	return xyz, true
+}
+
+// iccCurve remplit lut depuis un tag TRC de type curv (gamma ou table) ou para (fonction paramétrique).
+func iccCurve(tag []byte, lut *[256]float64) bool {
+	if len(tag) < 12 {
+		return false
+	}
+	switch string(tag[:4]) {
+	case "curv":
+		n := int(binary.BigEndian.Uint32(tag[8:]))
+		if len(tag) < 12+2*n {
+			return false
+		}
+		for i := range lut {
+			x := float64(i) / 255
+			switch n {
+			case 0: // identité
+				lut[i] = x
+			case 1: // gamma u8Fixed8
+				lut[i] = math.Pow(x, float64(binary.BigEndian.Uint16(tag[12:]))/256)
+			default: // table échantillonnée, interpolée linéairement
+				pos := x * float64(n-1)
+				j := min(int(pos), n-2)
+				a, b := float64(binary.BigEndian.Uint16(tag[12+2*j:])), float64(binary.BigEndian.Uint16(tag[14+2*j:]))
+				lut[i] = (a + (b-a)*(pos-float64(j))) / 65535
+			}
+		}
+		return true
+	case "para":
+		kind := int(binary.BigEndian.Uint16(tag[8:]))
+		nparams := [5]int{1, 3, 4, 5, 7}
+		if kind > 4 || len(tag) < 12+4*nparams[kind] {
+			return false
+		}
+		var p [7]float64 // g, a, b, c, d, e, f
+		for i := range nparams[kind] {
+			p[i] = float64(int32(binary.BigEndian.Uint32(tag[12+4*i:]))) / 65536
+		}
+		g, a, b, c, d, e, f := p[0], p[1], p[2], p[3], p[4], p[5], p[6]
+		for i := range lut {
+			x := float64(i) / 255
+			var y float64
+			switch kind {
+			case 0:
+				y = math.Pow(x, g)
+			case 1:
+				if x >= -b/a {
+					y = math.Pow(a*x+b, g)
+				}
+			case 2:
+				y = c
+				if x >= -b/a {
+					y = math.Pow(a*x+b, g) + c
+				}
+			case 3: // forme de sRGB et Display P3
+				y = c * x
+				if x >= d {
+					y = math.Pow(a*x+b, g)
+				}
+			case 4:
+				y = c*x + f
+				if x >= d {
+					y = math.Pow(a*x+b, g) + e
+				}
+			}
+			if math.IsNaN(y) { // base négative (a < 0) ou 0·∞ : courbe indéfinie, profil ignoré
+				return false
+			}
+			lut[i] = min(max(y, 0), 1)
+		}
+		return true
+	}
+	return false
+}
+
+// srgbEncode : intensité linéaire (sur 4096 pas) → valeur sRGB 8 bits.
+var srgbEncode = func() (lut [4096]uint8) {
+	for i := range lut {
+		x := float64(i) / 4095
+		y := 12.92 * x
+		if x > 0.0031308 {
+			y = 1.055*math.Pow(x, 1/2.4) - 0.055
+		}
+		lut[i] = uint8(y*255 + 0.5)
+	}
+	return lut
+}()
+
+// apply convertit le canvas en sRGB, en place.
+func (t *iccTransform) apply(dst *image.RGBA) {
+	pix := dst.Pix
+	for i := 0; i+3 < len(pix); i += 4 {
+		a := pix[i+3]
+		if a == 0 {
+			continue
+		}
+		var in [3]float64
+		for c := range 3 {
+			v := pix[i+c]
+			if a != 255 { // canvas prémultiplié : les courbes s'appliquent à la couleur réelle
+				v = uint8(min(int(v)*255/int(a), 255))
+			}
+			in[c] = t.trc[c][v]
+		}
+		for c := range 3 {
+			m := t.matrix[c]
+			lin := min(max(m[0]*in[0]+m[1]*in[1]+m[2]*in[2], 0), 1) // hors gamut sRGB : écrêté
+			v := srgbEncode[int(lin*4095+0.5)]
+			if a != 255 {
+				v = uint8(int(v) * int(a) / 255)
+			}
+			pix[i+c] = v
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/binary"
+	"image"
+	"math"
+	"testing"
+)
+
+type iccTag struct {
+	sig  string
+	data []byte
+}
+
+// buildICC assemble un profil RGB minimal : en-tête de 128 octets, table des tags, données.
+func buildICC(tags ...iccTag) []byte {
+	p := make([]byte, 132+12*len(tags))
+	copy(p[12:], "mntr")
+	copy(p[16:], "RGB ")
+	copy(p[20:], "XYZ ")
+	binary.BigEndian.PutUint32(p[128:], uint32(len(tags)))
+	for i, t := range tags {
+		e := 132 + 12*i
+		copy(p[e:], t.sig)
+		binary.BigEndian.PutUint32(p[e+4:], uint32(len(p)))
+		binary.BigEndian.PutUint32(p[e+8:], uint32(len(t.data)))
+		p = append(p, t.data...)
+	}
+	binary.BigEndian.PutUint32(p, uint32(len(p)))
+	return p
+}
+
+func s15(v float64) []byte {
+	return binary.BigEndian.AppendUint32(nil, uint32(int32(math.Round(v*65536))))
+}
+
+func iccXYZTag(x, y, z float64) []byte {
+	b := append([]byte("XYZ \x00\x00\x00\x00"), s15(x)...)
+	return append(append(b, s15(y)...), s15(z)...)
+}
+
+// iccParaTag : courbe paramétrique de type kind.
+func iccParaTag(kind uint16, params ...float64) []byte {
+	b := binary.BigEndian.AppendUint16([]byte("para\x00\x00\x00\x00"), kind)
+	b = append(b, 0, 0)
+	for _, p := range params {
+		b = append(b, s15(p)...)
+	}
+	return b
+}
+
+// iccProfile : primaires (XYZ D50, colonnes r, g, b) et une même courbe pour les trois canaux.
+func iccProfile(prim [3][3]float64, trc []byte) []byte {
+	return buildICC(
+		iccTag{"rXYZ", iccXYZTag(prim[0][0], prim[0][1], prim[0][2])},
+		iccTag{"gXYZ", iccXYZTag(prim[1][0], prim[1][1], prim[1][2])},
+		iccTag{"bXYZ", iccXYZTag(prim[2][0], prim[2][1], prim[2][2])},
+		iccTag{"rTRC", trc}, iccTag{"gTRC", trc}, iccTag{"bTRC", trc},
+	)
+}
+
+var (
+	srgbPrimaries = [3][3]float64{{0.436066, 0.222488, 0.013916}, {0.385147, 0.716873, 0.097076}, {0.143066, 0.060608, 0.714096}}
+	p3Primaries   = [3][3]float64{{0.515121, 0.241196, -0.001053}, {0.291977, 0.692245, 0.041885}, {0.157104, 0.066574, 0.784073}}
+	srgbTRC       = iccParaTag(3, 2.4, 1/1.055, 0.055/1.055, 1/12.92, 0.04045)
+)
+
+func TestICCTransform(t *testing.T) {
+	if newICCTransform(iccProfile(srgbPrimaries, srgbTRC)) != nil {
+		t.Error("profil sRGB : conversion inutile")
+	}
+	tr := newICCTransform(iccProfile(p3Primaries, srgbTRC))
+	if tr == nil {
+		t.Fatal("profil Display P3 ignoré")
+	}
+	img := image.NewRGBA(image.Rect(0, 0, 3, 1))
+	copy(img.Pix, []uint8{
+		128, 128, 128, 255, // gris : même point blanc, inchangé
+		255, 0, 0, 255, // rouge P3 : hors gamut sRGB, écrêté au rouge pur
+		64, 64, 64, 128, // gris semi-transparent, prémultiplié
+	})
+	tr.apply(img)
+	for i, want := range []uint8{128, 128, 128, 255, 255, 0, 0, 255, 64, 64, 64, 128} {
+		if abs(int(img.Pix[i])-int(want)) > 2 {
+			t.Fatalf("pixels %v, attendu ≈ %v", img.Pix, []uint8{128, 128, 128, 255, 255, 0, 0, 255, 64, 64, 64, 128})
+		}
+	}
+}
+
+// Profils mal formés : nil (pas de conversion), jamais de panique.
+func TestICCTransformMalformed(t *testing.T) {
+	valid := iccProfile(p3Primaries, srgbTRC)
+	set := func(off int, v uint32) []byte {
+		p := append([]byte(nil), valid...)
+		binary.BigEndian.PutUint32(p[off:], v)
+		return p
+	}
+	gray := append([]byte(nil), valid...)
+	copy(gray[16:], "GRAY")
+	curvTable := append([]byte("curv\x00\x00\x00\x00"), binary.BigEndian.AppendUint32(nil, 1<<30)...)
+
+	for _, tc := range []struct {
+		name    string
+		profile []byte
+	}{
+		{"vide", nil},
+		{"tronqué", valid[:131]},
+		{"pas RGB", gray},
+		{"table des tags démesurée", set(128, math.MaxUint32)},
+		{"tag hors du profil", set(132+4, uint32(len(valid)))},
+		{"taille de tag qui déborde", set(132+8, math.MaxUint32)},
+		{"sans primaires (profil LUT)", buildICC(iccTag{"A2B0", make([]byte, 64)})},
+		{"XYZ tronqué", buildICC(iccTag{"rXYZ", iccXYZTag(1, 1, 1)[:12]})},
+		{"table curv tronquée", iccProfile(p3Primaries, curvTable)},
+		{"type de courbe inconnu", iccProfile(p3Primaries, []byte("mAB \x00\x00\x00\x00\x00\x00\x00\x00"))},
+		{"para de type inconnu", iccProfile(p3Primaries, iccParaTag(5, 2.2))},
+		{"para tronqué", iccProfile(p3Primaries, iccParaTag(3, 2.4, 1))},
+		{"courbe indéfinie", iccProfile(p3Primaries, iccParaTag(1, 0.5, -1, 0))}, // (−x)^0.5 : NaN
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if newICCTransform(tc.profile) != nil {
+				t.Error("profil mal formé accepté")
+			}
+		})
+	}
+}
+
+// FuzzICCTransform : go test -fuzz=FuzzICCTransform. La conversion d'un profil accepté ne doit
+// pas paniquer, quels que soient les pixels.
+func FuzzICCTransform(f *testing.F) {
+	f.Add(iccProfile(p3Primaries, srgbTRC))
+	f.Add(iccProfile(p3Primaries, iccParaTag(4, 2.2, 1, 0, 0.1, 0.05, 0.01, 0.02)))
+	f.Add(iccProfile(p3Primaries, append([]byte("curv\x00\x00\x00\x00\x00\x00\x00\x03"), 0, 0, 0x80, 0, 0xff, 0xff)))
+	f.Add(iccProfile(p3Primaries, []byte("curv\x00\x00\x00\x00\x00\x00\x00\x01\x02\x33")))
+	img := image.NewRGBA(image.Rect(0, 0, 256, 4))
+	f.Fuzz(func(t *testing.T, profile []byte) {
+		tr := newICCTransform(profile)
+		if tr == nil {
+			return
+		}
+		for i := range img.Pix {
+			img.Pix[i] = uint8(i / 4 % 256)
+			if i%4 == 3 {
+				img.Pix[i] = uint8(255 - i/1024*64)
+			}
+		}
+		tr.apply(img)
+	})
+}
@@ -0,0 +1,557 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// ── Encodeur JPEG progressif ─────────────────────────────────────────────────
+// image/jpeg n'écrit que du baseline : le navigateur affiche l'image bande par bande, de haut
+// en bas. Un JPEG progressif (SOF2) transmet d'abord une version grossière de toute l'image,
+// puis l'affine : un aperçu complet s'affiche dès les premiers Ko. Périmètre :
+//   - sélection spectrale seule, sans approximations successives : les DC des trois plans,
+//     puis les basses fréquences luma, la chroma, et enfin le reste de la luma — le script
+//     de libjpeg sans ses passes de raffinement ;
+//   - tables de Huffman optimisées pour chaque passe (T.81 annexe K.2) : c'est ce qui rend le
+//     fichier souvent un peu plus petit que le baseline de la stdlib, aux tables standard ;
+//   - mêmes tables de quantification, même échelle de qualité et même 4:2:0 que image/jpeg :
+//     à qualité égale, les pixels décodés sont les mêmes à l'arrondi de la DCT près ;
+//   - subsampling=444 garde la chroma en pleine résolution : le 4:2:0 bave autour du texte
+//     du watermark et des bords de logo colorés, au prix d'un fichier nettement plus lourd.
+
+const jpegMaxDim = 65535 // largeur/hauteur codées sur 16 bits dans SOF
+
+// jpegUnzig : position naturelle (ligne×8 + colonne) du k-ième coefficient de l'ordre zigzag.
+var jpegUnzig = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10, 17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34, 27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36, 29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46, 53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+// jpegBaseQuant : tables de quantification de référence (T.81 annexe K.1), luma puis chroma, ordre naturel.
+var jpegBaseQuant = [2][64]int{
+	{
+		16, 11, 10, 16, 24, 40, 51, 61,
+		12, 12, 14, 19, 26, 58, 60, 55,
+		14, 13, 16, 24, 40, 57, 69, 56,
+		14, 17, 22, 29, 51, 87, 80, 62,
+		18, 22, 37, 56, 68, 109, 103, 77,
+		24, 35, 55, 64, 81, 104, 113, 92,
+		49, 64, 78, 87, 103, 121, 120, 101,
+		72, 92, 95, 98, 112, 100, 103, 99,
+	},
+	{
+		17, 18, 24, 47, 99, 99, 99, 99,
+		18, 21, 26, 66, 99, 99, 99, 99,
+		24, 26, 56, 99, 99, 99, 99, 99,
+		47, 66, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+	},
+}
+
+// jpegScan : une passe du script progressif — plan comp (-1 : passe DC entrelacée), coefficients ss..se.
+type jpegScan struct{ comp, ss, se int }
+
+var jpegScript = []jpegScan{
+	{-1, 0, 0}, // DC des trois plans : aperçu au 1/8
+	{0, 1, 5},  // basses fréquences luma : les contours apparaissent
+	{1, 1, 63}, // chroma Cb
+	{2, 1, 63}, // chroma Cr
+	{0, 6, 63}, // reste de la luma : détails fins
+}
+
+// jpegDCTCos[u][x] = C(u)/2 · cos((2x+1)uπ/16) — DCT 1D, appliquée aux lignes puis aux colonnes.
+var jpegDCTCos = func() (c [8][8]float64) {
+	for u := range 8 {
+		cu := 0.5
+		if u == 0 {
+			cu = 0.5 / math.Sqrt2
+		}
+		for x := range 8 {
+			c[u][x] = cu * math.Cos(float64(2*x+1)*float64(u)*math.Pi/16)
+		}
+	}
+	return c
+}()
+
+// encodeOptions : réglages d'encodage indépendants de la qualité, lus une fois par requête.
+// Sans effet en WebP (toujours 4:2:0) et PNG.
+type encodeOptions struct {
+	progressive bool // JPEG progressif plutôt que baseline
+	chroma444   bool // chroma pleine résolution — seul l'encodeur progressif sait l'écrire
+}
+
+// encodeParams lit progressive (true par défaut) et subsampling (420 par défaut, ou 444).
+func encodeParams(r *http.Request) (encodeOptions, error) {
+	opts := encodeOptions{progressive: true}
+	if v := r.FormValue("progressive"); v != "" {
+		on, err := strconv.ParseBool(v)
+		if err != nil {
+			return encodeOptions{}, fmt.Errorf("progressive invalide : %q (true ou false)", v)
+		}
+		opts.progressive = on
+	}
+	switch v := r.FormValue("subsampling"); v {
+	case "", "420":
+	case "444":
+		if !opts.progressive { // image/jpeg impose le 4:2:0
+			return encodeOptions{}, errors.New("subsampling=444 requiert progressive=true")
+		}
+		opts.chroma444 = true
+	default:
+		return encodeOptions{}, fmt.Errorf("subsampling invalide : %q (420 ou 444)", v)
+	}
+	return opts, nil
+}
+
+// encodeProgressiveJPEG écrit img en JPEG progressif à la qualité q (1..100, échelle de image/jpeg),
+// chroma en pleine résolution si chroma444, sinon en 4:2:0.
+func encodeProgressiveJPEG(w io.Writer, img image.Image, q int, chroma444 bool) error {
+	b := img.Bounds()
+	if b.Empty() {
+		return errors.New("image vide")
+	}
+	if b.Dx() > jpegMaxDim || b.Dy() > jpegMaxDim {
+		return errors.New("image trop grande pour JPEG (max 65535px)")
+	}
+	src, ok := img.(*image.RGBA)
+	if !ok { // le pipeline produit des *image.RGBA — conversion seulement pour les autres appelants
+		src = image.NewRGBA(b)
+		draw.Draw(src, b, img, b.Min, draw.Src)
+	}
+
+	sub := 2 // facteur de sous-échantillonnage de la chroma, dans les deux directions
+	if chroma444 {
+		sub = 1
+	}
+	e := newJPEGEncoder(w, src, q, sub)
+	e.writeHeaders()
+	for _, s := range jpegScript {
+		e.writeScan(s)
+	}
+	e.marker(0xD9, 0) // EOI
+	return e.w.Flush()
+}
+
+// jpegComp : un plan (Y, Cb ou Cr) et ses coefficients quantifiés.
+type jpegComp struct {
+	h, v   int         // facteurs d'échantillonnage
+	bw, bh int         // grille de blocs complète, arrondie au MCU — parcours de la passe entrelacée
+	cw, ch int         // blocs couvrant réellement le plan — parcours des passes à un seul plan
+	tq     int         // table de quantification (0 luma, 1 chroma)
+	coef   [][64]int16 // blocs bw×bh, coefficients en ordre naturel
+}
+
+type jpegEncoder struct {
+	w             *bufio.Writer
+	width, height int
+	sub           int // sous-échantillonnage chroma : 2 (4:2:0) ou 1 (4:4:4)
+	mcuW, mcuH    int // nombre de MCU (16×16 px en 4:2:0, 8×8 en 4:4:4)
+	quant         [2][64]int
+	comps         [3]jpegComp
+
+	// Huffman : la passe est parcourue deux fois — comptage des symboles, puis écriture.
+	counting bool
+	freq     [2][257]int // fréquences par table ; le symbole 256 est réservé (cf. huffmanTable)
+	code     [2][256]uint16
+	size     [2][256]uint8
+
+	acc  uint32 // bits en attente d'écriture
+	nacc uint8
+}
+
+func newJPEGEncoder(w io.Writer, src *image.RGBA, q, sub int) *jpegEncoder {
+	b := src.Bounds()
+	mcu := 8 * sub
+	e := &jpegEncoder{
+		w:      bufio.NewWriter(w),
+		width:  b.Dx(),
+		height: b.Dy(),
+		sub:    sub,
+		mcuW:   (b.Dx() + mcu - 1) / mcu,
+		mcuH:   (b.Dy() + mcu - 1) / mcu,
+	}
+
+	// Échelle de qualité de libjpeg, reprise par image/jpeg.
+	q = min(max(q, 1), 100)
+	scale := 200 - 2*q
+	if q < 50 {
+		scale = 5000 / q
+	}
+	for t := range e.quant {
+		for i, u := range jpegBaseQuant[t] {
+			e.quant[t][i] = min(max((u*scale+50)/100, 1), 255)
+		}
+	}
+
+	for c := range e.comps {
+		comp := &e.comps[c]
+		comp.h, comp.v = 1, 1
+		if c == 0 {
+			comp.h, comp.v, comp.tq = sub, sub, 0
+		} else {
+			comp.tq = 1
+		}
+		comp.bw, comp.bh = e.mcuW*comp.h, e.mcuH*comp.v
+		comp.cw = ((e.width*comp.h+sub-1)/sub + 7) / 8 // largeur du plan = ⌈largeur × h / hmax⌉
+		comp.ch = ((e.height*comp.v+sub-1)/sub + 7) / 8
+		comp.coef = make([][64]int16, comp.bw*comp.bh)
+	}
+	e.transform(src)
+	return e
+}
+
+// transform convertit en YCbCr, sous-échantillonne la chroma et calcule les coefficients de tous les blocs.
+// Le progressif impose de tout garder en mémoire : chaque passe relit tous les blocs.
+func (e *jpegEncoder) transform(src *image.RGBA) {
+	pw, ph := e.mcuW*8*e.sub, e.mcuH*8*e.sub // plans arrondis au MCU, bords répétés
+	planes := [3][]uint8{make([]uint8, pw*ph), make([]uint8, pw*ph), make([]uint8, pw*ph)}
+	for y := range ph {
+		row := src.Pix[min(y, e.height-1)*src.Stride:]
+		for x := range pw {
+			o := min(x, e.width-1) * 4
+			yy, cb, cr := color.RGBToYCbCr(row[o], row[o+1], row[o+2]) // conversion de image/jpeg
+			planes[0][y*pw+x], planes[1][y*pw+x], planes[2][y*pw+x] = yy, cb, cr
+		}
+	}
+	for c := 1; c < 3 && e.sub == 2; c++ { // 4:2:0 : moyenne de chaque carré 2×2
+		half := make([]uint8, pw/2*ph/2)
+		p := planes[c]
+		for y := range ph / 2 {
+			for x := range pw / 2 {
+				i := 2*y*pw + 2*x
+				half[y*pw/2+x] = uint8((int(p[i]) + int(p[i+1]) + int(p[i+pw]) + int(p[i+pw+1]) + 2) / 4)
+			}
+		}
+		planes[c] = half
+	}
+
+	var blk [64]float64
+	for c := range e.comps {
+		comp := &e.comps[c]
+		stride := comp.bw * 8
+		q := &e.quant[comp.tq]
+		for by := range comp.bh {
+			for bx := range comp.bw {
+				for y := range 8 {
+					row := planes[c][(by*8+y)*stride+bx*8:]
+					for x := range 8 {
+						blk[y*8+x] = float64(row[x]) - 128
+					}
+				}
+				fdct(&blk)
+				out := &comp.coef[by*comp.bw+bx]
+				for i := range 64 {
+					out[i] = int16(math.Round(blk[i] / float64(q[i])))
+				}
+			}
+		}
+	}
+}
+
+// fdct applique la DCT 2D en place (séparable : lignes puis colonnes).
+func fdct(blk *[64]float64) {
+	var tmp [64]float64
+	for y := range 8 {
+		for u := range 8 {
+			var s float64
+			for x := range 8 {
+				s += jpegDCTCos[u][x] * blk[y*8+x]
+			}
+			tmp[y*8+u] = s
+		}
+	}
+	for u := range 8 {
+		for v := range 8 {
+			var s float64
+			for y := range 8 {
+				s += jpegDCTCos[v][y] * tmp[y*8+u]
+			}
+			blk[v*8+u] = s
+		}
+	}
+}
+
+// ── Écriture ──
+
+// marker écrit un marqueur, suivi de la longueur du segment si n > 0 (longueur comprise).
+func (e *jpegEncoder) marker(m byte, n int) {
+	e.w.Write([]byte{0xFF, m}) //nolint:errcheck — bufio : l'erreur ressort au Flush
+	if n > 0 {
+		e.w.Write([]byte{byte((n + 2) >> 8), byte(n + 2)}) //nolint:errcheck
+	}
+}
+
+// writeHeaders écrit SOI, les tables de quantification (ordre zigzag) et SOF2.
+func (e *jpegEncoder) writeHeaders() {
+	e.marker(0xD8, 0) // SOI
+	e.marker(0xDB, 2*65)
+	for t := range e.quant {
+		e.w.WriteByte(byte(t)) //nolint:errcheck — précision 8 bits, table t
+		for k := range 64 {
+			e.w.WriteByte(byte(e.quant[t][jpegUnzig[k]])) //nolint:errcheck
+		}
+	}
+	// SOF2 : DCT progressive, Huffman — précision 8 bits, hauteur, largeur, nombre de plans.
+	e.marker(0xC2, 6+3*len(e.comps))
+	e.w.Write([]byte{8, byte(e.height >> 8), byte(e.height), byte(e.width >> 8), byte(e.width), byte(len(e.comps))}) //nolint:errcheck
+	for c, comp := range e.comps {
+		e.w.Write([]byte{byte(c + 1), byte(comp.h<<4 | comp.v), byte(comp.tq)}) //nolint:errcheck
+	}
+}
+
+// writeScan écrit une passe : comptage des symboles, tables optimales (DHT), en-tête SOS, puis les données.
+func (e *jpegEncoder) writeScan(s jpegScan) {
+	e.counting = true
+	e.freq = [2][257]int{}
+	e.encodeScan(s)
+
+	comps := []int{s.comp}
+	tables := 1
+	class := byte(1) // AC
+	if s.comp < 0 {
+		comps, tables, class = []int{0, 1, 2}, 2, 0 // DC : table 0 pour la luma, 1 pour la chroma
+	}
+	var dht []byte
+	for t := range tables {
+		bits, vals := huffmanTable(&e.freq[t])
+		dht = append(dht, class<<4|byte(t))
+		dht = append(dht, bits[:]...)
+		dht = append(dht, vals...)
+		e.code[t], e.size[t] = huffmanCodes(bits, vals)
+	}
+	e.marker(0xC4, len(dht))
+	e.w.Write(dht) //nolint:errcheck
+
+	e.marker(0xDA, 4+2*len(comps))
+	e.w.WriteByte(byte(len(comps))) //nolint:errcheck
+	for _, c := range comps {
+		td := 0 // table DC : luma 0, chroma 1 — sans objet dans une passe AC, qui utilise la table AC 0
+		if s.comp < 0 {
+			td = min(c, 1)
+		}
+		e.w.Write([]byte{byte(c + 1), byte(td << 4)}) //nolint:errcheck
+	}
+	e.w.Write([]byte{byte(s.ss), byte(s.se), 0}) //nolint:errcheck — Ah = Al = 0 : pas d'approximation successive
+
+	e.counting = false
+	e.encodeScan(s)
+	if e.nacc > 0 { // fin de passe : octet complété par des 1
+		e.bits(1<<(8-e.nacc)-1, 8-e.nacc)
+	}
+}
+
+// encodeScan parcourt les blocs de la passe et émet ses symboles.
+func (e *jpegEncoder) encodeScan(s jpegScan) {
+	if s.comp < 0 { // DC entrelacé : MCU par MCU, blocs de chaque plan dans l'ordre
+		var pred [3]int
+		for my := range e.mcuH {
+			for mx := range e.mcuW {
+				for c := range e.comps {
+					comp := &e.comps[c]
+					for v := range comp.v {
+						for h := range comp.h {
+							dc := int(comp.coef[(my*comp.v+v)*comp.bw+mx*comp.h+h][0])
+							e.value(min(c, 1), 0, dc-pred[c])
+							pred[c] = dc
+						}
+					}
+				}
+			}
+		}
+		return
+	}
+
+	comp := &e.comps[s.comp]
+	eobrun := 0 // blocs consécutifs sans coefficient restant dans la bande — codés en un seul symbole
+	flushEOB := func() {
+		if eobrun == 0 {
+			return
+		}
+		n := bitLen(eobrun) - 1
+		e.symbol(0, byte(n<<4))
+		if n > 0 {
+			e.bits(uint32(eobrun)&(1<<n-1), uint8(n))
+		}
+		eobrun = 0
+	}
+	for by := range comp.ch {
+		for bx := range comp.cw {
+			blk := &comp.coef[by*comp.bw+bx]
+			run := 0
+			for k := s.ss; k <= s.se; k++ {
+				v := int(blk[jpegUnzig[k]])
+				if v == 0 {
+					run++
+					continue
+				}
+				flushEOB()
+				for run > 15 {
+					e.symbol(0, 0xF0) // ZRL : 16 zéros
+					run -= 16
+				}
+				e.value(0, run, v)
+				run = 0
+			}
+			if run > 0 {
+				eobrun++
+				if eobrun == 0x7FFF { // EOBRUN sur 15 bits au plus
+					flushEOB()
+				}
+			}
+		}
+	}
+	flushEOB()
+}
+
+// value émet le symbole (run, catégorie de v) de la table t puis les bits de v.
+func (e *jpegEncoder) value(t, run, v int) {
+	mag := v
+	if v < 0 {
+		mag = -v
+	}
+	n := bitLen(mag)
+	e.symbol(t, byte(run<<4|n))
+	if n > 0 {
+		if v < 0 { // négatif : complément à un sur n bits
+			v += 1<<n - 1
+		}
+		e.bits(uint32(v), uint8(n))
+	}
+}
+
+func (e *jpegEncoder) symbol(t int, s byte) {
+	if e.counting {
+		e.freq[t][s]++
+		return
+	}
+	e.bits(uint32(e.code[t][s]), e.size[t][s])
+}
+
+// bits écrit les n bits de poids faible de v, avec l'octet 0x00 de bourrage après chaque 0xFF.
+func (e *jpegEncoder) bits(v uint32, n uint8) {
+	if e.counting {
+		return
+	}
+	e.acc = e.acc<<n | v
+	e.nacc += n
+	for e.nacc >= 8 {
+		b := byte(e.acc >> (e.nacc - 8))
+		e.w.WriteByte(b) //nolint:errcheck
+		if b == 0xFF {
+			e.w.WriteByte(0) //nolint:errcheck
+		}
+		e.nacc -= 8
+	}
+	e.acc &= 1<<e.nacc - 1
+}
+
+// ── Huffman ──
+
+// huffmanTable construit la table optimale pour freq (T.81 annexe K.2, comme libjpeg) : nombre de codes
+// par longueur 1..16 et symboles par longueur croissante. Le symbole réservé 256 garantit qu'aucun
+// code n'est fait que de 1 — un tel code se confondrait avec le bourrage de fin de passe.
+func huffmanTable(freq *[257]int) (bits [16]byte, vals []byte) {
+	f := *freq
+	f[256] = 1
+	var codesize [257]int
+	var others [257]int
+	for i := range others {
+		others[i] = -1
+	}
+	for {
+		c1, c2 := -1, -1 // les deux fréquences non nulles les plus faibles (à égalité : le plus grand symbole)
+		for i := range f {
+			if f[i] > 0 && (c1 < 0 || f[i] <= f[c1]) {
+				c1 = i
+			}
+		}
+		for i := range f {
+			if f[i] > 0 && i != c1 && (c2 < 0 || f[i] <= f[c2]) {
+				c2 = i
+			}
+		}
+		if c2 < 0 {
+			break
+		}
+		f[c1] += f[c2]
+		f[c2] = 0
+		for codesize[c1]++; others[c1] >= 0; codesize[c1]++ {
+			c1 = others[c1]
+		}
+		others[c1] = c2
+		for codesize[c2]++; others[c2] >= 0; codesize[c2]++ {
+			c2 = others[c2]
+		}
+	}
+
+	var count [33]int
+	for _, n := range codesize {
+		if n > 0 {
+			count[min(n, 32)]++
+		}
+	}
+	for i := 32; i > 16; i-- { // longueurs limitées à 16 bits
+		for count[i] > 0 {
+			j := i - 2
+			for count[j] == 0 {
+				j--
+			}
+			count[i] -= 2
+			count[i-1]++
+			count[j+1] += 2
+			count[j]--
+		}
+	}
+	i := 16
+	for count[i] == 0 {
+		i--
+	}
+	count[i]-- // retire le symbole réservé, qui a le code le plus long
+	for n := 1; n <= 16; n++ {
+		bits[n-1] = byte(count[n])
+	}
+	for n := 1; n <= 32; n++ {
+		for s := range 256 {
+			if codesize[s] == n {
+				vals = append(vals, byte(s))
+			}
+		}
+	}
+	return bits, vals
+}
+
+// huffmanCodes attribue les codes canoniques (T.81 annexe C).
+func huffmanCodes(bits [16]byte, vals []byte) (code [256]uint16, size [256]uint8) {
+	c, k := 0, 0
+	for n := 1; n <= 16; n++ {
+		for range bits[n-1] {
+			code[vals[k]], size[vals[k]] = uint16(c), uint8(n)
+			c++
+			k++
+		}
+		c <<= 1
+	}
+	return code, size
+}
+
+// bitLen : nombre de bits de v > 0 — la catégorie du coefficient.
+func bitLen(v int) int {
+	n := 0
+	for ; v > 0; v >>= 1 {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"math"
+	"testing"
+)
+
+// jpegDiff : écarts moyens entre src et le JPEG décodé — luma (JFIF, pleine échelle) et RGB.
+func jpegDiff(t *testing.T, src *image.RGBA, got image.Image) (luma, rgb float64) {
+	t.Helper()
+	ycc, ok := got.(*image.YCbCr)
+	if !ok {
+		t.Fatalf("image décodée %T, attendu *image.YCbCr", got)
+	}
+	b := src.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			p, q := src.RGBAAt(x, y), ycc.YCbCrAt(x, y)
+			want := 0.299*float64(p.R) + 0.587*float64(p.G) + 0.114*float64(p.B)
+			luma += math.Abs(want - float64(q.Y))
+			r, g, bl, _ := q.RGBA()
+			rgb += float64(abs(int(p.R)-int(r>>8))+abs(int(p.G)-int(g>>8))+abs(int(p.B)-int(bl>>8))) / 3
+		}
+	}
+	n := float64(b.Dx() * b.Dy())
+	return luma / n, rgb / n
+}
+
+// Les JPEG progressifs d'encodeProgressiveJPEG se relisent avec image/jpeg, aux dimensions
+// impaires (MCU partiels) et aux qualités extrêmes, en 4:2:0 comme en 4:4:4.
+func TestEncodeProgressiveJPEGRoundTrip(t *testing.T) {
+	for _, size := range []image.Point{{1, 1}, {17, 9}, {3, 255}, {33, 65}, {130, 47}} {
+		for _, chroma444 := range []bool{false, true} {
+			for _, tc := range []struct {
+				q       int
+				maxLuma float64
+			}{{1, 30}, {50, 4}, {100, 1}} {
+				t.Run(fmt.Sprintf("%dx%d_444=%v_q%d", size.X, size.Y, chroma444, tc.q), func(t *testing.T) {
+					src := gradient(size.X, size.Y)
+					var buf bytes.Buffer
+					if err := encodeProgressiveJPEG(&buf, src, tc.q, chroma444); err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Contains(buf.Bytes(), []byte{0xFF, 0xC2}) {
+						t.Error("pas de marqueur SOF2 : JPEG non progressif")
+					}
+					got, err := jpeg.Decode(bytes.NewReader(buf.Bytes()))
+					if err != nil {
+						t.Fatalf("décodage image/jpeg : %v", err)
+					}
+					if got.Bounds() != src.Bounds() {
+						t.Fatalf("dimensions %v, attendu %v", got.Bounds(), src.Bounds())
+					}
+					luma, rgb := jpegDiff(t, src, got)
+					if luma > tc.maxLuma {
+						t.Errorf("écart moyen de luma %.2f, attendu ≤ %g", luma, tc.maxLuma)
+					}
+					if chroma444 && tc.q == 100 && rgb > 2 { // chroma pleine résolution : les couleurs aussi
+						t.Errorf("écart moyen RGB %.2f en 4:4:4 à q=100", rgb)
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestEncodeProgressiveJPEGLimits(t *testing.T) {
+	if err := encodeProgressiveJPEG(&bytes.Buffer{}, image.NewRGBA(image.Rectangle{}), 80, false); err == nil {
+		t.Error("image vide acceptée")
+	}
+	if err := encodeProgressiveJPEG(&bytes.Buffer{}, image.NewRGBA(image.Rect(0, 0, jpegMaxDim+1, 1)), 80, false); err == nil {
+		t.Error("image plus large que 65535 px acceptée")
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"mime/multipart"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ── Calques de watermark ──────────────────────────────────────────────────────
+// wm_layers décrit plusieurs watermarks appliqués dans l'ordre sur la même image, ex :
+//
+//	[{"text": "© Studio", "position": "bottom-right"},
+//	 {"text": "ÉPREUVE", "position": "center", "size": 160, "opacity": 25, "angle": -30},
+//	 {"logo": "wm_logo", "position": "top-left", "logo_scale": 10}]
+//
+// Chaque calque accepte les mêmes réglages que le formulaire, sans le préfixe wm_.
+// Les fichiers (logo, font_file) désignent un champ fichier du formulaire multipart.
+
+const maxLayers = 8 // au-delà, le coût de composition dépasse l'intérêt — et c'est probablement une erreur client
+
+// layerKeys liste les réglages acceptés dans un calque — une faute de frappe est refusée
+// plutôt qu'ignorée silencieusement.
+var layerKeys = []string{
+	"type", "text", "position", "size", "font", "font_file", "opacity", "outline",
+	"shadow", "color", "angle", "plate", "plate_padding", "x", "y", "logo", "logo_scale", "qr", "qr_size", "blend",
+}
+
+// wmSource est la source des paramètres d'un watermark : *http.Request pour le formulaire,
+// layerSource pour un calque de wm_layers.
+type wmSource interface {
+	FormValue(key string) string
+	FormFile(key string) (multipart.File, *multipart.FileHeader, error)
+}
+
+// layerSource expose un calque JSON avec l'interface du formulaire : wm_size → "size".
+type layerSource struct {
+	r      *http.Request // pour les fichiers référencés par le calque
+	fields map[string]string
+}
+
+func (l layerSource) FormValue(key string) string {
+	return l.fields[strings.TrimPrefix(key, "wm_")]
+}
+
+// FormFile résout la référence du calque (ex: "logo": "wm_logo_2") vers le fichier du formulaire.
+func (l layerSource) FormFile(key string) (multipart.File, *multipart.FileHeader, error) {
+	field := l.FormValue(key)
+	if field == "" {
+		return nil, nil, http.ErrMissingFile // même convention que le formulaire : absent n'est pas une erreur
+	}
+	return l.r.FormFile(field)
+}
+
+// wmLayers retourne les calques à appliquer : ceux de wm_layers s'il est présent,
+// sinon un calque unique lu depuis les champs wm_* du formulaire.
+func wmLayers(r *http.Request, width int) ([]wmOptions, error) {
+	v := r.FormValue("wm_layers")
+	if v == "" {
+		opts, err := wmParams(r, width)
+		return []wmOptions{opts}, err
+	}
+
+	var raw []map[string]any
+	if err := json.Unmarshal([]byte(v), &raw); err != nil {
+		return nil, fmt.Errorf("wm_layers invalide : tableau JSON attendu")
+	}
+	if len(raw) == 0 || len(raw) > maxLayers {
+		return nil, fmt.Errorf("wm_layers invalide : 1 à %d calques", maxLayers)
+	}
+	layers := make([]wmOptions, 0, len(raw))
+	for i, layer := range raw {
+		fields := make(map[string]string, len(layer))
+		for k, val := range layer {
+			if !slices.Contains(layerKeys, k) {
+				return nil, fmt.Errorf("wm_layers[%d] : réglage inconnu %q (acceptés : %v)", i, k, layerKeys)
+			}
+			switch val := val.(type) { // nombres et booléens JSON ramenés à leur forme texte du formulaire
+			case string:
+				fields[k] = val
+			case float64:
+				fields[k] = strconv.FormatFloat(val, 'f', -1, 64)
+			case bool:
+				fields[k] = strconv.FormatBool(val)
+			default:
+				return nil, fmt.Errorf("wm_layers[%d] : %s doit être une chaîne, un nombre ou un booléen", i, k)
+			}
+		}
+		opts, err := wmParams(layerSource{r: r, fields: fields}, width)
+		if err != nil {
+			return nil, fmt.Errorf("wm_layers[%d] : %w", i, err)
+		}
+		switch fields["type"] {
+		case "", "text", "logo", "qr":
+		default:
+			return nil, fmt.Errorf("wm_layers[%d] : type inconnu %q (text, logo ou qr)", i, fields["type"])
+		}
+		if (fields["type"] == "logo" || fields["type"] == "qr") && opts.logo == nil {
+			return nil, fmt.Errorf("wm_layers[%d] : calque %s sans contenu", i, fields["type"])
+		}
+		if fields["type"] == "text" {
+			opts.logo = nil // type explicite : le texte prime sur un logo référencé par erreur
+		}
+		layers = append(layers, opts)
+	}
+	return layers, nil
+}
+
+// applyLayers compose les calques dans l'ordre sur une copie RGBA de l'image source.
+// Un seul canvas pour tous les calques : chaque calque voit les précédents comme fond.
+func applyLayers(img image.Image, layers []wmOptions) (image.Image, error) {
+	canvas := newCanvas(img.Bounds())                                // copie RGBA pour rendre l'image modifiable (img source peut être read-only)
+	draw.Draw(canvas, canvas.Bounds(), img, image.Point{}, draw.Src) // copier les pixels source sur le canvas avant de dessiner par-dessus
+	for _, opts := range layers {
+		if opts.logo != nil { // logo client ou QR code — remplace le texte du calque
+			drawLogo(canvas, opts)
+			continue
+		}
+		if err := drawText(canvas, opts); err != nil {
+			releaseCanvas(canvas)
+			return nil, err
+		}
+	}
+	return canvas, nil
+}
+
+// renderOverlay compose les calques wm_* sur un canvas transparent w×h, pour les supports que le
+// pipeline ne décode pas (PDF, vidéo). Sans fond à mesurer, la couleur adaptative est remplacée
+// par def ; les modes de fusion n'ont rien avec quoi fusionner et sont refusés. support nomme le
+// support dans les messages d'erreur. Le canvas retourné est à libérer par l'appelant.
+// Les erreurs de paramètres sont retournées telles quelles (400), celles du rendu enveloppent errRender.
+func renderOverlay(r *http.Request, w, h int, def color.NRGBA, support string) (*image.RGBA, error) {
+	layers, err := wmLayers(r, w)
+	if err != nil {
+		return nil, err
+	}
+	for i := range layers {
+		if layers[i].blend != "normal" {
+			return nil, fmt.Errorf("wm_blend=%s non supporté pour %s (normal uniquement)", layers[i].blend, support)
+		}
+		if layers[i].color == nil && layers[i].plate == nil {
+			c := def
+			c.A = wmAlpha(layers[i].opacity, wmTextAlpha)
+			layers[i].color = &c
+		}
+	}
+	canvas := image.NewRGBA(image.Rect(0, 0, w, h))
+	resolveAutoPositions(canvas, layers)
+	out, err := applyLayers(canvas, layers)
+	if err != nil {
+		return nil, fmt.Errorf("%w : %w", errRender, err)
+	}
+	return out.(*image.RGBA), nil // applyLayers compose toujours sur un canvas RGBA
+}
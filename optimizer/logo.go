@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"net/http"
+	"strconv"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// ── Watermark logo ────────────────────────────────────────────────────────────
+
+const (
+	maxLogoSide      = 4000 // au-delà, ce n'est plus un logo — protège contre les bombes de décompression
+	defaultLogoScale = 15   // largeur du logo en % de la largeur de l'image de sortie
+)
+
+// logoFormats : un logo doit porter un canal alpha, le JPEG n'en a pas. Le SVG est reconnu à part.
+var logoFormats = map[string]bool{"png": true, "webp": true}
+
+// logoParams lit le logo optionnel (champ wm_logo) et son échelle (wm_logo_scale, en % de la largeur).
+// Un logo nil sans erreur signifie « pas de logo » : le watermark texte prend le relais.
+// Un logo SVG est rasterisé ici, à sa taille finale pour une sortie de width px : presized le signale.
+func logoParams(r wmSource, width int) (logo image.Image, scale int, presized bool, err error) {
+	file, _, err := r.FormFile("wm_logo")
+	if errors.Is(err, http.ErrMissingFile) {
+		return nil, 0, false, nil // champ absent — cas nominal
+	}
+	if err != nil {
+		return nil, 0, false, fmt.Errorf("logo illisible")
+	}
+	defer file.Close()
+
+	scale = defaultLogoScale
+	if v := r.FormValue("wm_logo_scale"); v != "" {
+		if scale, err = strconv.Atoi(v); err != nil || scale < 1 || scale > 100 {
+			return nil, 0, false, fmt.Errorf("wm_logo_scale invalide : %q (entier 1-100)", v)
+		}
+	}
+
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(file, head) // un logo plus court que 512 octets est lu en entier
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, 0, false, err
+	}
+	if isSVG(head[:n]) {
+		data, err := io.ReadAll(io.LimitReader(file, maxSVGBytes+1)) // +1 pour détecter le dépassement
+		if err != nil || len(data) > maxSVGBytes {
+			return nil, 0, false, fmt.Errorf("logo SVG illisible ou trop lourd (max %s)", formatBytes(maxSVGBytes))
+		}
+		if logo, err = rasterizeSVG(data, max(width*scale/100, 1)); err != nil {
+			return nil, 0, false, fmt.Errorf("logo SVG illisible : %w", err)
+		}
+		return logo, scale, true, nil
+	}
+
+	// Même validation lazy que l'image principale : dimensions lues avant d'allouer les pixels.
+	config, format, err := image.DecodeConfig(file)
+	if err != nil {
+		return nil, 0, false, fmt.Errorf("logo illisible")
+	}
+	if !logoFormats[format] {
+		return nil, 0, false, fmt.Errorf("format de logo non supporté : %s (acceptés : %s, svg)", format, formatList(logoFormats))
+	}
+	if config.Width > maxLogoSide || config.Height > maxLogoSide {
+		return nil, 0, false, fmt.Errorf("logo trop grand : %dx%d (max %dx%d)", config.Width, config.Height, maxLogoSide, maxLogoSide)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, 0, false, err
+	}
+	if logo, _, err = image.Decode(file); err != nil {
+		return nil, 0, false, fmt.Errorf("logo illisible")
+	}
+	return logo, scale, false, nil
+}
+
+// drawLogo compose le logo (alpha respecté) sur le canvas, à la position demandée.
+// Le logo est mis à l'échelle pour occuper logoScale % de la largeur, sans jamais dépasser l'image.
+// Sans wm_opacity, le logo garde sa propre transparence (opacité 100 %).
+func drawLogo(canvas *image.RGBA, opts wmOptions) {
+	logo := opts.logo
+
+	w, h := canvas.Bounds().Dx(), canvas.Bounds().Dy()
+	lb := logo.Bounds()
+	maxW := max(w*opts.logoScale/100, 1)
+	maxH := max(h-2*wmMargin, 1) // un logo très haut sur une image panoramique reste dans le cadre
+	lw, lh := fitInside(lb.Dx(), lb.Dy(), maxW, maxH)
+	var interp xdraw.Interpolator = xdraw.CatmullRom // un logo réduit garde des bords nets, et sa petite taille rend le coût négligeable
+	if opts.crisp {
+		interp = xdraw.NearestNeighbor // modules de QR : des aplats, pas de demi-teintes
+	}
+	if opts.presized && lb.Dx() <= maxW && lb.Dy() <= maxH {
+		lw, lh = lb.Dx(), lb.Dy() // déjà tracé à la taille finale (SVG, QR) — la copie 1:1 ne floute rien
+	}
+
+	x, y := logoCoords(lw, lh, w, h, opts.position)
+	x, y = opts.x.resolve(w, lw, x), opts.y.resolve(h, lh, y) // wm_x / wm_y prioritaires sur la position
+	scaled := image.NewRGBA(image.Rect(0, 0, lw, lh))
+	interp.Scale(scaled, scaled.Bounds(), logo, lb, xdraw.Src, nil)
+	// Masque uniforme : multiplie l'alpha du logo par l'opacité ; la fusion avec le fond suit wm_blend.
+	opacity := image.NewUniform(color.Alpha{A: wmAlpha(opts.opacity, 255)})
+	compose(canvas, image.Rect(x, y, x+lw, y+lh), scaled, image.Point{}, opacity, image.Point{}, opts.blend)
+
+	logger.Debug().Str("step", "logo").Int("logo_w", lw).Int("logo_h", lh).Int("x", x).Int("y", y).Msg("logo composé")
+}
+
+// logoCoords calcule le coin haut-gauche du logo — mêmes positions et marges que le texte.
+func logoCoords(lw, lh, w, h int, position string) (x, y int) {
+	switch position {
+	case "top-left":
+		return wmMargin, wmMargin
+	case "top-center":
+		return (w - lw) / 2, wmMargin
+	case "top-right":
+		return w - lw - wmMargin, wmMargin
+	case "center":
+		return (w - lw) / 2, (h - lh) / 2
+	case "bottom-left":
+		return wmMargin, h - lh - wmMargin
+	case "bottom-center":
+		return (w - lw) / 2, h - lh - wmMargin
+	default: // bottom-right
+		return w - lw - wmMargin, h - lh - wmMargin
+	}
+}
@@ -2,42 +2,70 @@ package main
 
 import (
 	"bytes"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
-	"image/jpeg"
-	_ "image/png"             // enregistre le décodeur PNG dans le registre image.Decode
+	_ "image/jpeg" // décodeur JPEG (registre image.Decode) — l'encodage passe par encoder.go
+	"image/png" // décodeur PNG (registre image.Decode) et sortie sans perte wm_format=png
+	_ "golang.org/x/image/tiff" // enregistre le décodeur TIFF (exports 16 bits, scans)
 	_ "golang.org/x/image/webp" // enregistre le décodeur WebP pour accepter les images WebP en entrée
 	"io"
+	"maps"
+	"math"
+	"math/rand/v2"
 	"net/http"
 	"os"
 	"runtime"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog"
 	xdraw "golang.org/x/image/draw"
 	"golang.org/x/image/font"
-	"golang.org/x/image/font/gofont/goregular"
-	"golang.org/x/image/font/opentype"
-	"golang.org/x/image/math/fixed"
 )
 
 const (
-	maxWidth  = 1920 // largeur maximale après resize
-	maxHeight = 1080 // hauteur maximale après resize
-
-	maxInputWidth  = 8000 // validation: on refuse les images absurdement grandes
-	maxInputHeight = 8000
-
-	wmMargin     = 20 // marge entre le bord de l'image et le texte du watermark (px)
-	wmLineHeight = 52 // hauteur de ligne pour la police taille 48 (font size + marge interne)
-
-	// Zone d'échantillonnage pour le calcul de luminosité (pixels autour du watermark).
-	// Plus la zone est grande, plus la couleur adaptative est représentative du fond.
-	sampleW = 200
-	sampleH = 50
+	maxWidth      = 1920 // largeur maximale après resize — max_w la remplace
+	maxHeight     = 1080 // hauteur maximale après resize — max_h la remplace
+	maxOutputSide = 8000 // borne de max_w/max_h/w/h : au-delà, le canvas de sortie coûte plus que l'image d'entrée max
+
+	defaultMaxUpscale = 2 // upscale=true : facteur d'agrandissement max — au-delà, l'image n'apporte plus de détail, seulement du flou
+
+	// Limites d'entrée par défaut — surchargées par MAX_INPUT_WIDTH / MAX_INPUT_HEIGHT.
+	defaultMaxInputWidth  = 8000 // validation: on refuse les images absurdement grandes
+	defaultMaxInputHeight = 8000
+	defaultMaxUploadSize  = 50 << 20 // body de /optimize — surchargé par MAX_UPLOAD_SIZE, la même variable que l'API
+
+	// Au-delà de maxInputWidth×maxInputHeight, les panoramas passent en mode strip
+	// (décodage et réduction par bandes horizontales, cf. strip.go).
+	maxStripWidth  = 40000
+	maxStripHeight = 40000
+	maxStripPixels = 120_000_000 // la mémoire ne dépend que de la largeur — borne le temps de décodage
+
+	wmMargin    = 20  // marge entre le bord de l'image et le texte du watermark (px)
+	wmTextAlpha = 210 // opacité par défaut du texte (~82 %) — wm_opacity la remplace
+
+	// Plaque sous le texte (wm_plate) : rectangle arrondi semi-transparent.
+	defaultPlateAlpha = 140 // ~55 % — assez dense pour le contraste, le fond reste devinable
+	maxPlatePadding   = 200
+
+	// Taille du texte (wm_size) : fixe en px, ou "auto" = proportionnelle à la largeur de sortie.
+	defaultFontSize = 48   // 48pt @ 72 DPI = 48px — visible sur des images jusqu'à 1920px de large
+	minFontSize     = 8    // en dessous, le texte n'est plus lisible
+	maxFontSize     = 400  // borne aussi le nombre de faces en cache
+	autoFontRatio   = 0.04 // mode auto : 4% de la largeur de l'image
+
+	// Écart-type de luminance (0-255) au-delà duquel le fond sous le texte est jugé trop contrasté
+	// pour qu'une seule couleur reste lisible partout (ciel et immeubles, rayures…) : la couleur
+	// adaptative est alors complétée d'un contour opposé. Un dégradé doux reste bien en dessous.
+	busyLumStdDev = 48
 )
 
 // sem limite la concurrence à un slot par coeur CPU pour éviter la saturation mémoire
@@ -49,16 +77,48 @@ var bufPool = sync.Pool{
 	New: func() any { return new(bytes.Buffer) },
 }
 
-// fontFace est la police chargée une seule fois au démarrage et partagée entre toutes les requêtes.
-// opentype.Face est thread-safe en lecture.
-var fontFace font.Face
+// pngEncoder encode la sortie wm_format=png. Compression par défaut : BestCompression coûte ~3×
+// le temps d'encodage pour quelques % sur une capture d'écran.
+var pngEncoder = png.Encoder{CompressionLevel: png.DefaultCompression}
 
 // logger est le logger structuré partagé entre toutes les fonctions.
 var logger zerolog.Logger
 
+// maxInputWidth/maxInputHeight sont les dimensions d'entrée acceptées sans mode strip,
+// et inputFormats les formats décodables autorisés (noms retournés par image.DecodeConfig).
+// Fixés au démarrage depuis l'environnement, en lecture seule ensuite.
+var (
+	maxInputWidth  = defaultMaxInputWidth
+	maxInputHeight = defaultMaxInputHeight
+	inputFormats   = map[string]bool{"jpeg": true, "png": true, "webp": true, "gif": true, "tiff": true}
+	maxUpscale     = defaultMaxUpscale // MAX_UPSCALE
+	maxUploadSize  = int64(defaultMaxUploadSize)
+)
+
+// qualityCurve donne la qualité JPEG par palier de surface (miniature, HD, Full HD+).
+// experimentCurve est la courbe alternative servie à experimentPct % des requêtes
+// (EXPERIMENT_QUALITY_PCT / EXPERIMENT_QUALITY_CURVE) — 0 % = pas d'expérience en cours.
+var (
+	qualityCurve    = [3]int{80, 85, 90}
+	experimentCurve = [3]int{75, 80, 85}
+	experimentPct   int
+)
+
+// gpsPolicy décide du sort des images portant des coordonnées GPS (GPS_POLICY) :
+// "strip" (défaut) les supprime silencieusement, "reject" refuse l'image en 422,
+// "audit" les supprime et émet un événement d'audit dans les logs.
+var gpsPolicy = "strip"
+
+// spillThreshold est la taille (octets) au-delà de laquelle un canvas RGBA est mappé
+// sur un fichier temporaire plutôt qu'alloué en RAM. 0 = spill désactivé.
+var spillThreshold int64
+
 // ── Main ──────────────────────────────────────────────────────────────────────
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "healthcheck" { // sonde Docker : l'image n'a ni curl ni wget
+		os.Exit(runHealthcheck(":3001"))
+	}
 	zerolog.TimeFieldFormat = time.RFC3339 // RFC3339 est plus lisible que l'epoch dans les logs structurés
 	// champ "service" identifie ce service dans une stack multi-conteneurs
 	logger = zerolog.New(os.Stdout).With().Timestamp().Str("service", "optimizer").Logger()
@@ -66,14 +126,77 @@ func main() {
 	numCPU := runtime.NumCPU()                                                                     // loggé au démarrage pour tracer la capacité maximale du worker pool
 	logger.Info().Str("addr", ":3001").Int("worker_slots", numCPU).Msg("démarrage")
 
+	// SPILL_THRESHOLD_MB : à régler sous la mémoire du conteneur divisée par le nombre de slots,
+	// pour que numCPU canvases simultanés ne suffisent pas à déclencher l'OOM killer.
+	if v := envInt("SPILL_THRESHOLD_MB", 0); v > 0 {
+		spillThreshold = int64(v) << 20
+		logger.Info().Str("component", "init").Int("spill_threshold_mb", v).Str("spill_dir", os.Getenv("SPILL_DIR")).Msg("spill disque activé") // vide = os.TempDir()
+	}
+
+	// Limites d'entrée par déploiement : un service interne peut refuser le WebP ou les images > 4000px.
+	maxInputWidth = envInt("MAX_INPUT_WIDTH", defaultMaxInputWidth)
+	maxInputHeight = envInt("MAX_INPUT_HEIGHT", defaultMaxInputHeight)
+	maxUpscale = max(envInt("MAX_UPSCALE", defaultMaxUpscale), 1) // 1 = agrandissement désactivé
+	// MAX_UPLOAD_SIZE : octets, ou suffixe K/M/G ("50M") — même variable que l'API.
+	if v := os.Getenv("MAX_UPLOAD_SIZE"); v != "" {
+		n, err := parseByteSize(v)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("MAX_UPLOAD_SIZE invalide")
+		}
+		maxUploadSize = n
+	}
+	if v := os.Getenv("INPUT_FORMATS"); v != "" { // ex: "jpeg,png" — liste séparée par des virgules
+		inputFormats = map[string]bool{}
+		for _, f := range strings.Split(v, ",") {
+			inputFormats[strings.ToLower(strings.TrimSpace(f))] = true
+		}
+	}
+	switch v := os.Getenv("GPS_POLICY"); v {
+	case "strip", "reject", "audit":
+		gpsPolicy = v
+	case "":
+	default:
+		logger.Fatal().Str("gps_policy", v).Msg("GPS_POLICY invalide (strip, reject ou audit)") // mieux vaut ne pas démarrer qu'appliquer une politique non voulue
+	}
+	logger.Info().Str("component", "init").Str("gps_policy", gpsPolicy).Msg("politique GPS")
+
+	// A/B test de la courbe de qualité — ex: EXPERIMENT_QUALITY_PCT=10 EXPERIMENT_QUALITY_CURVE=75,82,88
+	experimentPct = min(envInt("EXPERIMENT_QUALITY_PCT", 0), 100)
+	if v := os.Getenv("EXPERIMENT_QUALITY_CURVE"); v != "" {
+		parts := strings.Split(v, ",")
+		for i := 0; i < len(experimentCurve) && i < len(parts); i++ {
+			if q, err := strconv.Atoi(strings.TrimSpace(parts[i])); err == nil && q >= 1 && q <= 100 { // valeur invalide → palier par défaut conservé
+				experimentCurve[i] = q
+			}
+		}
+	}
+	if experimentPct > 0 {
+		logger.Info().Str("component", "init").Int("pct", experimentPct).Ints("curve", experimentCurve[:]).Ints("control", qualityCurve[:]).Msg("expérience qualité active")
+	}
+	if err := selectEncoder(os.Getenv("ENCODER")); err != nil { // go par défaut ; les backends natifs exigent leur build tag
+		logger.Fatal().Err(err).Msg("backend d'encodage indisponible")
+	}
+	logger.Info().Str("component", "init").Str("encoder", encoderName).Msg("backend d'encodage")
+	logger.Info().Str("component", "init").Int("max_input_w", maxInputWidth).Int("max_input_h", maxInputHeight).Strs("input_formats", formatList(inputFormats)).Int("max_upscale", maxUpscale).Str("max_upload_size", formatBytes(int(maxUploadSize))).Msg("limites d'entrée")
+
 	if err := loadFont(); err != nil { // la police est critique — impossible de watermarker sans elle
 		logger.Fatal().Err(err).Msg("chargement police échoué")
 	}
+	initVideo() // optionnel : /optimize-video répond 501 sans ffmpeg
+	initGRPC()  // Optimize en gRPC à côté de l'HTTP, pour l'API
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("POST /optimize", handleOptimize) // seule route exposée — le reste est géré par l'API
-
-	http.ListenAndServe(":3001", mux) //nolint:errcheck — une erreur ici est fatale, le conteneur redémarre
+	mux.HandleFunc("POST /optimize", handleOptimize) // pipeline principal : resize + watermark + encodage
+	mux.HandleFunc("POST /sprite", handleSprite)     // planche de miniatures pour les galeries
+	mux.HandleFunc("POST /thumbnail", handleThumbnail) // aperçu rapide, hors pipeline complet
+	mux.HandleFunc("POST /optimize-pdf", handlePDF)    // watermark sur chaque page d'un PDF
+	mux.HandleFunc("POST /optimize-video", handleVideo) // watermark incrusté par ffmpeg, si installé
+	mux.HandleFunc("GET /livez", handleLive)
+	mux.HandleFunc("GET /healthz", handleLive) // nom historique, même réponse que /livez
+	mux.HandleFunc("GET /readyz", handleReady) // sondes : cf. health.go
+	mux.Handle("GET /metrics", promhttp.Handler()) // Prometheus : cf. metrics.go
+
+	http.ListenAndServe(":3001", metricsMiddleware(mux)) //nolint:errcheck — une erreur ici est fatale, le conteneur redémarre
 }
 
 // ── Handler ───────────────────────────────────────────────────────────────────
@@ -82,63 +205,332 @@ func main() {
 func handleOptimize(w http.ResponseWriter, r *http.Request) {
 	start := time.Now() // point de référence pour mesurer la durée totale du pipeline
 
+	// Taille vérifiée avant de prendre un slot : un body trop gros n'attend pas son tour pour être refusé.
+	if !limitUpload(w, r) {
+		return
+	}
+
 	// ── ① Worker Pool ────────────────────────────────────
 	slotsUsed := len(sem) + 1  // +1 car on va acquérir juste après — utile pour détecter la saturation
 	totalSlots := cap(sem)     // mis en cache pour le réutiliser dans le defer sans recalcul
 	logger.Info().Str("step", "worker_pool").Int("used", slotsUsed).Int("total", totalSlots).Msg("slot acquis")
 
-	sem <- struct{}{} // bloque si tous les slots sont pris — backpressure naturelle sur le client
+	poolWaiting.Inc()
+	select {
+	case sem <- struct{}{}: // bloque si tous les slots sont pris — backpressure naturelle sur le client
+		poolWaiting.Dec()
+	case <-r.Context().Done(): // client parti ou échéance gRPC dépassée pendant l'attente : le slot reste aux suivants
+		poolWaiting.Dec()
+		logger.Warn().Str("step", "worker_pool").Err(r.Context().Err()).Msg("requête abandonnée avant traitement")
+		return
+	}
 	defer func() {
 		<-sem // libère le slot pour la prochaine requête en attente
 		logger.Info().Str("step", "worker_pool").Int("used", len(sem)).Int("total", totalSlots).Msg("slot libéré")
 	}()
 
-	// ── ② Décodage (lazy validation + full decode) ────────
+	// ── ② Métadonnées (politique GPS) ────────────────────
+	// Le ré-encodage supprime toujours l'EXIF ; l'étape décide seulement si la présence
+	// de coordonnées GPS est tolérée, refusée, ou tracée pour l'audit.
 	t := time.Now()
+	gps, filename := imageHasGPS(r)
+	if gps {
+		switch gpsPolicy {
+		case "reject":
+			logger.Warn().Str("step", "metadata").Str("policy", gpsPolicy).Str("filename", filename).Msg("image avec GPS refusée")
+			http.Error(w, "image contenant des coordonnées GPS refusée (politique de confidentialité)", http.StatusUnprocessableEntity)
+			return
+		case "audit":
+			// Événement d'audit : prouve a posteriori que la localisation a été reçue puis supprimée.
+			logger.Info().Str("step", "metadata").Bool("audit", true).Str("event", "gps_stripped").Str("policy", gpsPolicy).
+				Str("filename", filename).Str("remote_addr", r.RemoteAddr).Msg("coordonnées GPS supprimées")
+		}
+		w.Header().Set("X-Image-Gps-Stripped", "true")
+	}
+	logger.Debug().Str("step", "metadata").Bool("gps", gps).Dur("duration", time.Since(t)).Msg("métadonnées inspectées")
+
+	// Dimensions de sortie validées avant le décodage : une requête invalide ne coûte rien.
+	spec, err := resizeParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sizes, err := sizesParam(r, spec)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	crop, err := cropParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	orient, err := orientParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fx, err := effectsParams(r)
+	if errors.Is(err, errNoFaceDetector) {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	bg, err := bgColorParam(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	metaOpts, err := metaParamsFrom(r) // keep_icc, keep_exif, set_copyright
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	encOpts, err := encodeParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fixedQ, err := qualityParam(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	maxBytes, err := maxBytesParam(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !metaOpts.keepICC { // sans keep_icc, un profil large gamut est converti en sRGB
+		fx.color = newICCTransform(imageICC(r))
+	}
+	logger.Debug().Str("step", "icc").Bool("keep", metaOpts.keepICC).Bool("convert", fx.color != nil).Msg("profil ICC inspecté")
+
+	// GIF ou WebP animé : chaque image passe dans le pipeline, rien n'est réduit à la première.
+	anim, err := decodeAnimation(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if anim != nil {
+		if len(sizes) > 0 || maxBytes > 0 {
+			http.Error(w, "sizes et max_bytes ne s'appliquent pas aux animations", http.StatusBadRequest)
+			return
+		}
+		t = time.Now()
+		buf, contentType, q, err := renderAnimation(r, anim, animOptions{spec: spec, crop: crop, orient: orient, fx: fx, bg: bg, fixedQ: fixedQ})
+		if errors.Is(err, errRender) {
+			logger.Error().Str("step", "animation").Err(err).Msg("rendu échoué")
+			http.Error(w, "Erreur rendu", http.StatusInternalServerError)
+			return
+		}
+		if err != nil {
+			logger.Warn().Str("step", "watermark").Err(err).Msg("paramètres refusés")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer bufPool.Put(buf)
+		logger.Info().Str("step", "animation").Int("frames", len(anim.frames)).Int("plays", anim.plays).Str("content_type", contentType).Str("size", formatBytes(buf.Len())).Dur("duration", time.Since(t)).Msg("animation traitée")
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("X-Image-Frames", strconv.Itoa(len(anim.frames)))
+		if q > 0 {
+			w.Header().Set("X-Image-Quality", strconv.Itoa(q))
+		}
+		w.Write(buf.Bytes()) //nolint:errcheck — flush vers le client
+		return
+	}
+
+	// ── ③ Décodage (lazy validation + full decode) ────────
+	t = time.Now()
 	// decodeImage valide d'abord les dimensions via DecodeConfig (sans décoder les pixels),
 	// puis effectue le décodage complet. Le ré-encodage ultérieur supprime automatiquement
 	// les métadonnées EXIF (GPS, miniature, profil ICC) — gain de 5-15% sur les photos iPhone.
-	img, format, err := decodeImage(r)
+	img, format, src, err := decodeImage(r)
 	if err != nil { // image manquante, format invalide ou dimensions hors limites
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	defer releaseCanvas(img) // image de travail d'un panorama (strip.go) — sans effet sinon
+	strip := src.X > maxInputWidth || src.Y > maxInputHeight
+	if strip && fx.regions != nil { // zones en pixels du fichier : ramenées à l'image de travail
+		full, work := src, img.Bounds().Size()
+		if orient.rotate%180 != 0 { // zones exprimées dans le repère de l'image tournée
+			full.X, full.Y, work.X, work.Y = full.Y, full.X, work.Y, work.X
+		}
+		fx.regions = scaleRegions(fx.regions, image.Rectangle{Max: full}, work.X, work.Y)
+	}
+
+	origW, origH := src.X, src.Y // conservés pour loguer le delta après resize
+	logger.Info().Str("step", "decode").Str("format", format).Int("width", origW).Int("height", origH).Bool("strip", strip).Dur("duration", time.Since(t)).Msg("décodage + strip EXIF")
+	observeStage("decode", t)
+
+	if !keepsAlpha(outputFormat(r)) { // PNG/WebP transparent vers JPEG/WebP : aplati sur bg_color
+		if flat, ok := flatten(img, bg); ok {
+			img = flat
+			defer releaseCanvas(img)
+			logger.Info().Str("step", "flatten").Str("bg_color", hexColor(color.RGBA(bg))).Msg("transparence aplatie")
+		}
+	}
+	if orient != (orientation{}) { // rotation / miroir avant tout le reste : le recadrage voit l'image redressée
+		t = time.Now()
+		img = orient.apply(img)
+		defer releaseCanvas(img)
+		logger.Info().Str("step", "orient").Int("rotate", orient.rotate).Str("flip", orient.flip).Dur("duration", time.Since(t)).Msg("orientation corrigée")
+	}
+	if crop.w > 0 { // recadrage au ratio avant resize — le resize ne lit que la zone retenue
+		img = cropImage(img, crop)
+	}
 
-	origW, origH := img.Bounds().Dx(), img.Bounds().Dy() // conservés pour loguer le delta après resize
-	logger.Info().Str("step", "decode").Str("format", format).Int("width", origW).Int("height", origH).Dur("duration", time.Since(t)).Msg("décodage + strip EXIF")
+	if len(sizes) > 0 { // variantes responsive : resize + watermark + encodage par largeur, une seule réponse
+		variants, err := renderVariants(r, img, spec, sizes, fx)
+		if errors.Is(err, errBudget) { // budget appliqué à chaque variante
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		if errors.Is(err, errRender) {
+			logger.Error().Str("step", "variant").Err(err).Msg("rendu échoué")
+			http.Error(w, "Erreur rendu", http.StatusInternalServerError)
+			return
+		}
+		if err != nil {
+			logger.Warn().Str("step", "watermark").Err(err).Msg("paramètres refusés")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logger.Info().Str("step", "total").Int("variants", len(variants)).Dur("duration", time.Since(start)).Msg("variantes traitées")
+		writeVariants(w, variants)
+		return
+	}
 
-	// ── ③ Resize ─────────────────────────────────────────
+	// ── ④ Resize ─────────────────────────────────────────
 	t = time.Now()
-	resized := resize(img)
+	resized := resize(img, spec)
+	defer releaseCanvas(resized) // no-op si le canvas est en RAM ou si resize a retourné l'original
 	newW, newH := resized.Bounds().Dx(), resized.Bounds().Dy() // nécessaires pour loguer les nouvelles dimensions
 	if origW == newW && origH == newH {                         // pas de resize — évite un log trompeur avec durée ~0
-		logger.Info().Str("step", "resize").Bool("resized", false).Int("max_w", maxWidth).Int("max_h", maxHeight).Msg("resize ignoré")
+		logger.Info().Str("step", "resize").Bool("resized", false).Int("max_w", spec.maxW).Int("max_h", spec.maxH).Msg("resize ignoré")
 	} else {
-		logger.Info().Str("step", "resize").Bool("resized", true).Int("from_w", origW).Int("from_h", origH).Int("to_w", newW).Int("to_h", newH).Dur("duration", time.Since(t)).Msg("resize")
+		logger.Info().Str("step", "resize").Bool("resized", true).Int("from_w", origW).Int("from_h", origH).Int("to_w", newW).Int("to_h", newH).Bool("exact", spec.exact()).Str("interp", spec.interp).Dur("duration", time.Since(t)).Msg("resize")
+		observeStage("resize", t)
+	}
+	if !fx.none() { // netteté, filtres et flous sur l'image réduite — moins de pixels, et la palette reflète le rendu final
+		t = time.Now()
+		resized = fx.apply(resized, img.Bounds())
+		defer releaseCanvas(resized)
+		logger.Info().Str("step", "effects").Int("sharpen", fx.sharpen).Str("filters", r.FormValue("filters")).Int("blur_regions", len(fx.regions)).Dur("duration", time.Since(t)).Msg("retouches appliquées")
 	}
+	if spec.pad && (newW != spec.w || newH != spec.h) { // bandes après les retouches : filtres et flous ne touchent que la photo
+		resized = padTo(resized, spec.w, spec.h, bg)
+		defer releaseCanvas(resized)
+		logger.Info().Str("step", "pad").Int("from_w", newW).Int("from_h", newH).Int("to_w", spec.w).Int("to_h", spec.h).Msg("bandes ajoutées")
+		newW, newH = spec.w, spec.h
+	}
+
+	// Palette extraite avant le watermark : le texte ajouté ne doit pas peser dans la couleur dominante.
+	t = time.Now()
+	palette := dominantPalette(resized)
+	logger.Debug().Str("step", "palette").Int("colors", len(palette)).Dur("duration", time.Since(t)).Msg("palette extraite")
 
-	// ── ④ Watermark ──────────────────────────────────────
+	// ── ⑤ Watermark ──────────────────────────────────────
 	t = time.Now()
-	wmText, wmPosition := wmParams(r) // extraire les 2 paramètres depuis le formulaire multipart
-	watermarked, err := applyWatermark(resized, wmText, wmPosition)
+	layers, err := wmLayers(r, newW) // un calque par défaut, ou la liste wm_layers
+	if err != nil {
+		logger.Warn().Str("step", "watermark").Err(err).Msg("paramètres refusés")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	autoPositions := resolveAutoPositions(resized, layers) // wm_position=auto → coin le plus calme de la photo
+	watermarked, err := applyLayers(resized, layers)
 	if err != nil { // échec rare — police corrompue ou canvas non-initialisé
 		http.Error(w, "Erreur watermark", http.StatusInternalServerError)
 		return
 	}
-	logger.Info().Str("step", "watermark").Str("text", wmText).Str("position", wmPosition).Dur("duration", time.Since(t)).Msg("watermark appliqué")
+	defer releaseCanvas(watermarked) // libéré après l'encodage et l'écriture de la réponse
+	for i, opts := range layers {
+		if opts.logo != nil {
+			kind := "logo"
+			if opts.crisp {
+				kind = "qr"
+			}
+			logger.Info().Str("step", "watermark").Int("layer", i).Str("kind", kind).Int("scale", opts.logoScale).Int("opacity", opts.opacity).Str("position", opts.position).Str("blend", opts.blend).Msg("watermark appliqué")
+		} else {
+			logger.Info().Str("step", "watermark").Int("layer", i).Str("kind", "text").Str("text", opts.text).Str("font", opts.fontName).Int("font_size", opts.size).Int("opacity", opts.opacity).Int("outline", opts.outline).Bool("shadow", opts.shadow).Float64("angle", opts.angle).Str("position", opts.position).Str("blend", opts.blend).Msg("watermark appliqué")
+		}
+	}
+	logger.Debug().Str("step", "watermark").Int("layers", len(layers)).Dur("duration", time.Since(t)).Msg("calques composés")
+	observeStage("watermark", t)
+
+	// ── ⑥ Placeholder ────────────────────────────────────
+	// Calculé sur l'image watermarkée pour que le placeholder corresponde à ce que le client recevra.
+	t = time.Now()
+	hash := blurHash(watermarked)
+	logger.Debug().Str("step", "blurhash").Str("hash", hash).Dur("duration", time.Since(t)).Msg("blurhash calculé")
 
-	// ── ⑤ Encodage ────────────────────────────────────────
+	// ── ⑦ Encodage ────────────────────────────────────────
 	t = time.Now()
-	buf, contentType, q, err := encodeToBuffer(watermarked)
+	outFormat := outputFormat(r)                // négocié par l'API depuis le header Accept du navigateur
+	q, arm := chooseQuality(newW, newH, fixedQ) // qualité adaptée à la surface de sortie — ou courbe expérimentale, ou imposée
+	q = formatQuality(outFormat, q)             // échelle propre au codec : WebP atteint la même qualité perçue plus bas
+	meta := buildOutMeta(r, metaOpts, layers)
+	var contentType string
+	var metaErr error
+	buf, q, err := fitBudget(outFormat, q, maxBytes, func(q int) (*bytes.Buffer, error) { // métadonnées comprises dans le budget
+		buf, ct, err := encodeToBuffer(watermarked, outFormat, q, encOpts)
+		if err != nil {
+			return nil, err
+		}
+		contentType = ct
+		if !meta.empty() {
+			metaErr = embedMetadata(buf, ct, newW, newH, meta) // image servie sans métadonnées plutôt que refusée
+		}
+		return buf, nil
+	})
+	if errors.Is(err, errBudget) {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
 	if err != nil { // échec d'encodage — OOM ou codec indisponible
 		http.Error(w, "Erreur encodage", http.StatusInternalServerError)
 		return
 	}
 	defer bufPool.Put(buf) // remettre le buffer dans le pool après que Write() l'ait consommé
-	logger.Info().Str("step", "encode").Str("format", "jpeg").Int("quality", q).Str("size", formatBytes(buf.Len())).Dur("duration", time.Since(t)).Msg("encodage")
+	if !meta.empty() {
+		if metaErr != nil {
+			logger.Warn().Str("step", "metadata").Err(metaErr).Msg("métadonnées non écrites")
+		} else if meta.icc != nil {
+			w.Header().Set("X-Image-Icc", "kept")
+		}
+		logger.Debug().Str("step", "metadata").Int("icc_bytes", len(meta.icc)).Int("exif_bytes", len(meta.exif)).Bool("xmp", meta.xmp != nil).Msg("métadonnées réécrites")
+	}
+	if fx.color != nil {
+		w.Header().Set("X-Image-Icc", "converted")
+	}
+	logger.Info().Str("step", "encode").Str("format", outFormat).Int("quality", q).Str("experiment", arm).Str("size", formatBytes(buf.Len())).Dur("duration", time.Since(t)).Msg("encodage")
+	observeStage("encode", t)
 	logger.Info().Str("step", "total").Dur("duration", time.Since(start)).Msg("image traitée")
 
 	w.Header().Set("Content-Type", contentType) // indique au client comment décoder la réponse (JPEG ou WebP)
+	w.Header().Set("X-Image-Quality", strconv.Itoa(q))
+	w.Header().Set("X-Image-Experiment", arm) // permet de corréler taille/latence côté client avec le bras
+	w.Header().Set("X-Image-Blurhash", hash)  // placeholder flou affiché par le front avant le chargement
+	w.Header().Set("X-Image-Width", strconv.Itoa(newW))
+	w.Header().Set("X-Image-Height", strconv.Itoa(newH))
+	w.Header().Set("X-Image-Source-Width", strconv.Itoa(origW)) // dimensions décodées, avant rotation et recadrage
+	w.Header().Set("X-Image-Source-Height", strconv.Itoa(origH))
+	if len(autoPositions) > 0 {
+		w.Header().Set("X-Image-Wm-Position", strings.Join(autoPositions, ",")) // coin retenu par wm_position=auto, un par calque
+	}
+	if len(palette) > 0 {
+		hexes := make([]string, len(palette))
+		for i, c := range palette {
+			hexes[i] = hexColor(c)
+		}
+		w.Header().Set("X-Image-Dominant-Color", hexes[0]) // fond de placeholder / thème de l'UI autour de l'image
+		w.Header().Set("X-Image-Palette", strings.Join(hexes, ","))
+	}
 	w.Write(buf.Bytes())                         //nolint:errcheck — flush vers le client
 }
 
@@ -147,123 +539,513 @@ func handleOptimize(w http.ResponseWriter, r *http.Request) {
 // decodeImage valide les dimensions via DecodeConfig (sans décoder les pixels),
 // puis effectue le décodage complet. Le ré-encodage ultérieur supprime automatiquement
 // les métadonnées EXIF (GPS, miniature, profil ICC) — gain de 5-15% sur les photos iPhone.
-func decodeImage(r *http.Request) (image.Image, string, error) {
+//
+// Les images au-delà de maxInputWidth×maxInputHeight mais dans les limites strip sont
+// décodées en mode strip : img est alors l'image de travail réduite (cf. strip.go), à libérer
+// par releaseCanvas, et src garde les dimensions du fichier.
+func decodeImage(r *http.Request) (img image.Image, format string, src image.Point, err error) {
 	file, _, err := r.FormFile("image") // on ignore le FileHeader (nom, taille) — on valide via DecodeConfig
 	if err != nil {
-		return nil, "", fmt.Errorf("image manquante")
+		return nil, "", src, fmt.Errorf("image manquante")
 	}
 	defer file.Close() // libérer la mémoire multipart dès que la fonction retourne
+	return decodeFile(file, true)
+}
 
+// decodeFile applique la validation lazy puis le décodage complet sur un fichier déjà ouvert.
+// allowStrip=false refuse les panoramas au lieu de basculer en mode strip (endpoints multi-images).
+func decodeFile(file io.ReadSeeker, allowStrip bool) (img image.Image, format string, src image.Point, err error) {
 	// ① Lazy decode : lit uniquement le header (quelques Ko) pour valider les dimensions
 	// sans décompresser les ~25 millions de pixels d'une image 4K.
 	config, format, err := image.DecodeConfig(file)
 	if err != nil {
-		return nil, "", fmt.Errorf("format invalide")
+		if isHEIF(file) { // photo iPhone : message explicite plutôt que « format invalide »
+			return nil, "", src, fmt.Errorf("format HEIC/HEIF non supporté : exporter en JPEG (iPhone : Réglages > Appareil photo > Formats > « Le plus compatible »)")
+		}
+		return nil, "", src, fmt.Errorf("format invalide")
+	}
+	if !inputFormats[format] { // décodeur enregistré mais format désactivé pour ce déploiement
+		return nil, "", src, fmt.Errorf("format %s non autorisé (autorisés : %s)", format, strings.Join(formatList(inputFormats), ", "))
 	}
-	if config.Width > maxInputWidth || config.Height > maxInputHeight { // refuse avant décompression pour ne pas saturer la mémoire
-		return nil, "", fmt.Errorf("image trop grande (max %dx%d, reçu %dx%d)", maxInputWidth, maxInputHeight, config.Width, config.Height)
+	strip := false
+	if config.Width > maxInputWidth || config.Height > maxInputHeight {
+		// refuse avant décompression pour ne pas saturer la mémoire — sauf panorama éligible au mode strip
+		if !allowStrip || config.Width > maxStripWidth || config.Height > maxStripHeight || config.Width*config.Height > maxStripPixels {
+			return nil, "", src, fmt.Errorf("image trop grande (max %dx%d, reçu %dx%d)", maxInputWidth, maxInputHeight, config.Width, config.Height)
+		}
+		strip = true
 	}
-	logger.Debug().Str("step", "lazy_decode").Str("format", format).Int("width", config.Width).Int("height", config.Height).Msg("dimensions validées sans décodage pixels")
+	logger.Debug().Str("step", "lazy_decode").Str("format", format).Int("width", config.Width).Int("height", config.Height).Bool("strip", strip).Msg("dimensions validées sans décodage pixels")
 
 	// ② Seek back to start before full decode — DecodeConfig a consommé le reader.
 	if _, err := file.Seek(0, io.SeekStart); err != nil { // DecodeConfig a avancé le curseur — on revient au début
-		return nil, "", fmt.Errorf("seek échoué")
+		return nil, "", src, fmt.Errorf("seek échoué")
 	}
+	src = image.Pt(config.Width, config.Height)
 
-	img, _, err := image.Decode(file) // décodage complet — le second retour (format) est ignoré, déjà lu
+	if strip { // panorama : lu et réduit par bandes, jamais décodé en entier
+		work, err := decodeStrip(file, format)
+		if errors.Is(err, errStripUnsupported) {
+			return nil, "", src, fmt.Errorf("image trop grande (max %dx%d, reçu %dx%d) — %w", maxInputWidth, maxInputHeight, config.Width, config.Height, err)
+		}
+		if err != nil {
+			return nil, "", src, fmt.Errorf("décodage échoué")
+		}
+		logger.Debug().Str("step", "strip").Int("work_w", work.Rect.Dx()).Int("work_h", work.Rect.Dy()).Msg("panorama réduit par bandes")
+		return work, format, src, nil
+	}
+	if config.ColorModel == color.CMYKModel { // JPEG CMYK (impression) : converti en RGB dès le décodage (cf. cmyk.go)
+		img, err = decodeCMYK(file)
+	} else {
+		img, _, err = image.Decode(file) // décodage complet — le second retour (format) est ignoré, déjà lu
+	}
 	if err != nil {
-		return nil, "", fmt.Errorf("décodage échoué")
+		return nil, "", src, fmt.Errorf("décodage échoué")
+	}
+	if is16bit(img) { // PNG/TIFF 16 bits : ramené une fois en 8 bits tramé (cf. depth.go)
+		gamma := 0.0
+		if _, err := file.Seek(0, io.SeekStart); err == nil && format == "png" {
+			gamma = pngGamma(file)
+		}
+		img = to8bit(img, gamma)
+		logger.Debug().Str("step", "depth").Str("format", format).Float64("gamma", gamma).Msg("image 16 bits convertie en 8 bits")
 	}
-	return img, format, nil
+	return img, format, src, nil
 }
 
-// wmParams lit les paramètres de watermark depuis le formulaire multipart.
+// isHEIF reconnaît un conteneur HEIF (HEIC, AVIF exclu) à sa boîte ftyp : aucun décodeur HEVC
+// pur Go n'existe et libheif imposerait cgo — l'upload est refusé avec un message qui l'explique.
+func isHEIF(file io.ReadSeeker) bool {
+	var hdr [12]byte
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return false
+	}
+	if _, err := io.ReadFull(file, hdr[:]); err != nil || string(hdr[4:8]) != "ftyp" {
+		return false
+	}
+	switch string(hdr[8:]) { // marque principale
+	case "heic", "heix", "heim", "heis", "hevc", "hevx", "mif1", "msf1":
+		return true
+	}
+	return false
+}
+
+// imageHasGPS indique si l'image uploadée porte des coordonnées GPS dans son EXIF.
+// Relit le fichier multipart (déjà bufferisé par net/http) — seuls les en-têtes sont parcourus.
+func imageHasGPS(r *http.Request) (bool, string) {
+	file, header, err := r.FormFile("image")
+	if err != nil { // image manquante : signalée par decodeImage
+		return false, ""
+	}
+	defer file.Close()
+	return exifHasGPS(readExif(file)), header.Filename
+}
+
+// wmOptions regroupe les réglages d'un watermark (un calque) lus depuis le formulaire multipart.
+type wmOptions struct {
+	text, position string
+	font           *fontEntry
+	fontName       string       // identifiant de la police pour les logs
+	size           int          // taille du texte en px
+	opacity        int          // wm_opacity en % — -1 si absent : défaut propre au texte ou au logo
+	outline        int          // épaisseur du contour en px — 0 : pas de contour
+	shadow         bool         // ombre portée floutée sous le texte
+	x, y           *wmCoord     // wm_x / wm_y — nil : l'axe suit wm_position
+	color          *color.NRGBA // wm_color — nil : couleur adaptative
+	angle          float64      // rotation du texte en degrés, sens horaire
+	plate          *color.NRGBA // couleur de la plaque sous le texte — nil : pas de plaque
+	platePadding   int          // marge entre le texte et le bord de la plaque, en px
+	logo           image.Image  // logo client ou QR code — nil : watermark texte
+	logoScale      int          // largeur du logo en % de l'image
+	presized       bool         // logo déjà tracé à sa taille finale (SVG, QR) — pas de rééchantillonnage
+	crisp          bool         // logo pixel-art (QR) : rééchantillonnage au plus proche, sans demi-teintes
+	blend          string       // mode de fusion avec la photo — voir blendModes
+}
+
+// wmParams lit les paramètres d'un watermark depuis le formulaire multipart ou un calque wm_layers.
 // Les valeurs par défaut garantissent un comportement cohérent même si le front
 // n'envoie pas ces champs (appels directs à l'API, retry RabbitMQ, etc.).
-func wmParams(r *http.Request) (text, position string) {
-	text = r.FormValue("wm_text")
-	if text == "" {
-		text = "NWS © 2026" // fallback si le champ est absent ou vide
+// width est la largeur de sortie, nécessaire au mode wm_size=auto.
+func wmParams(r wmSource, width int) (opts wmOptions, err error) {
+	opts.text = r.FormValue("wm_text")
+	if opts.text == "" {
+		opts.text = "NWS © 2026" // fallback si le champ est absent ou vide
 	}
-	position = r.FormValue("wm_position")
-	if position == "" {
-		position = "bottom-right" // position la moins intrusive par défaut
+	opts.position = r.FormValue("wm_position")
+	if opts.position == "" {
+		opts.position = "bottom-right" // position la moins intrusive par défaut
+	}
+	if opts.size, err = fontSize(r, width); err != nil { // taille fixe ou proportionnelle à la largeur
+		return
 	}
-	return
+	if opts.font, opts.fontName, err = fontParams(r); err != nil { // police embarquée ou envoyée par le client
+		return
+	}
+	if opts.opacity, err = opacityParam(r); err != nil {
+		return
+	}
+	if v := r.FormValue("wm_outline"); v != "" { // contour contrasté autour des lettres
+		if opts.outline, err = strconv.Atoi(v); err != nil || opts.outline < 0 || opts.outline > maxOutline {
+			return opts, fmt.Errorf("wm_outline invalide : %q (épaisseur 0-%d px)", v, maxOutline)
+		}
+	}
+	if v := r.FormValue("wm_shadow"); v != "" { // ombre portée — garde un texte blanc lisible sur une photo claire
+		if opts.shadow, err = strconv.ParseBool(v); err != nil {
+			return opts, fmt.Errorf("wm_shadow invalide : %q (true ou false)", v)
+		}
+	}
+	if v := r.FormValue("wm_color"); v != "" { // couleur imposée (charte graphique) — court-circuite adaptiveColor
+		c, hasAlpha, err := parseHexColor(v)
+		if err != nil {
+			return opts, fmt.Errorf("wm_color invalide : %q (#RRGGBB ou #RRGGBBAA)", v)
+		}
+		if !hasAlpha { // #RRGGBB : opacité de wm_opacity, comme la couleur adaptative — #RRGGBBAA prime
+			c.A = wmAlpha(opts.opacity, wmTextAlpha)
+		}
+		opts.color = &c
+	}
+	if v := r.FormValue("wm_angle"); v != "" { // texte incliné — typiquement une diagonale au centre
+		if opts.angle, err = strconv.ParseFloat(v, 64); err != nil || opts.angle < -180 || opts.angle > 180 {
+			return opts, fmt.Errorf("wm_angle invalide : %q (degrés, -180 à 180)", v)
+		}
+	}
+	opts.blend = r.FormValue("wm_blend")
+	if opts.blend == "" {
+		opts.blend = "normal"
+	}
+	if _, ok := blendModes[opts.blend]; !ok {
+		return opts, fmt.Errorf("wm_blend inconnu : %q (acceptés : %v)", opts.blend, slices.Sorted(maps.Keys(blendModes)))
+	}
+	if opts.plate, opts.platePadding, err = plateParams(r, opts.size); err != nil {
+		return
+	}
+	if opts.x, err = coordParam(r, "wm_x"); err != nil { // placement libre, prioritaire sur wm_position
+		return
+	}
+	if opts.y, err = coordParam(r, "wm_y"); err != nil {
+		return
+	}
+	if opts.logo, opts.logoScale, opts.presized, err = logoParams(r, width); err != nil { // logo client optionnel
+		return
+	}
+	qr, qrSize, err := qrParams(r, width) // QR code généré — composé comme un logo
+	if err != nil || qr == nil {
+		return opts, err
+	}
+	if opts.logo != nil {
+		return opts, fmt.Errorf("wm_logo et wm_qr sont exclusifs (utiliser wm_layers pour les combiner)")
+	}
+	opts.logo, opts.logoScale, opts.presized, opts.crisp = qr, qrSize, true, true
+	return opts, nil
 }
 
-// encodeToBuffer encode l'image en JPEG dans un buffer recyclé depuis le sync.Pool.
-// La qualité est adaptée dynamiquement aux dimensions de l'image de sortie.
-// Retourne le buffer, le content-type et la qualité utilisée (pour le log).
-// Le caller est responsable de remettre le buffer dans le pool (defer bufPool.Put(buf)).
-func encodeToBuffer(img image.Image) (*bytes.Buffer, string, int, error) {
-	w, h := img.Bounds().Dx(), img.Bounds().Dy() // dimensions utilisées pour choisir la qualité adaptive
-	q := adaptiveQuality(w, h)                    // qualité calculée en fonction de la surface en pixels
+// plateParams lit wm_plate (true, false ou couleur #RRGGBBAA) et wm_plate_padding (px).
+// Le padding par défaut suit la taille du texte, comme l'ombre.
+func plateParams(r wmSource, size int) (plate *color.NRGBA, padding int, err error) {
+	v := r.FormValue("wm_plate")
+	if v == "" {
+		return nil, 0, nil
+	}
+	if on, err := strconv.ParseBool(v); err == nil {
+		if !on {
+			return nil, 0, nil
+		}
+		plate = &color.NRGBA{A: defaultPlateAlpha} // noire semi-transparente : texte blanc lisible sur tout fond
+	} else {
+		c, hasAlpha, err := parseHexColor(v)
+		if err != nil {
+			return nil, 0, fmt.Errorf("wm_plate invalide : %q (true, false ou #RRGGBBAA)", v)
+		}
+		if !hasAlpha {
+			c.A = defaultPlateAlpha
+		}
+		plate = &c
+	}
+
+	padding = max(size/4, 4)
+	if v := r.FormValue("wm_plate_padding"); v != "" {
+		if padding, err = strconv.Atoi(v); err != nil || padding < 0 || padding > maxPlatePadding {
+			return nil, 0, fmt.Errorf("wm_plate_padding invalide : %q (0-%d px)", v, maxPlatePadding)
+		}
+	}
+	return plate, padding, nil
+}
+
+// wmCoord est une coordonnée libre du coin haut-gauche du watermark, en px ou en % de l'image.
+type wmCoord struct {
+	value   int
+	percent bool
+}
+
+// coordParam lit une coordonnée « 120 » (px) ou « 85% » — nil si le champ est absent.
+func coordParam(r wmSource, field string) (*wmCoord, error) {
+	v := r.FormValue(field)
+	if v == "" {
+		return nil, nil
+	}
+	num, percent := strings.CutSuffix(v, "%")
+	n, err := strconv.Atoi(num)
+	if err != nil || n < 0 || (percent && n > 100) {
+		return nil, fmt.Errorf("%s invalide : %q (px ou pourcentage 0-100%%)", field, v)
+	}
+	return &wmCoord{value: n, percent: percent}, nil
+}
+
+// resolve convertit la coordonnée en px sur un axe de longueur total, pour un watermark de
+// longueur size sur cet axe. Le résultat est clampé : le watermark ne déborde jamais du canvas.
+// Sans coordonnée (nil), fallback — la valeur issue de wm_position — est retournée telle quelle.
+func (c *wmCoord) resolve(total, size, fallback int) int {
+	if c == nil {
+		return fallback
+	}
+	v := c.value
+	if c.percent {
+		v = total * c.value / 100
+	}
+	return min(v, max(total-size, 0)) // un watermark plus grand que l'image reste collé au bord haut-gauche
+}
 
+// parseHexColor lit une couleur #RRGGBB ou #RRGGBBAA (le # est facultatif).
+// Sans composante alpha, la couleur est opaque et hasAlpha vaut false.
+func parseHexColor(v string) (c color.NRGBA, hasAlpha bool, err error) {
+	b, err := hex.DecodeString(strings.TrimPrefix(v, "#"))
+	if err != nil || (len(b) != 3 && len(b) != 4) {
+		return color.NRGBA{}, false, fmt.Errorf("couleur hexadécimale invalide : %q", v)
+	}
+	c = color.NRGBA{R: b[0], G: b[1], B: b[2], A: 255}
+	if len(b) == 4 {
+		c.A, hasAlpha = b[3], true
+	}
+	return c, hasAlpha, nil
+}
+
+// opacityParam lit wm_opacity (0-100 %) — -1 si absent.
+func opacityParam(r wmSource) (int, error) {
+	v := r.FormValue("wm_opacity")
+	if v == "" {
+		return -1, nil
+	}
+	o, err := strconv.Atoi(v)
+	if err != nil || o < 0 || o > 100 {
+		return 0, fmt.Errorf("wm_opacity invalide : %q (entier 0-100)", v)
+	}
+	return o, nil
+}
+
+// wmAlpha convertit l'opacité en % vers un alpha 8 bits, def si wm_opacity est absent.
+func wmAlpha(opacity int, def uint8) uint8 {
+	if opacity < 0 {
+		return def
+	}
+	return uint8((opacity*255 + 50) / 100) // arrondi au plus proche
+}
+
+// outputFormat lit le champ wm_format : "webp" si l'API l'a négocié, "png" si le client l'a demandé
+// (captures d'écran, graphiques), JPEG pour tout le reste.
+func outputFormat(r *http.Request) string {
+	switch f := r.FormValue("wm_format"); f {
+	case "webp", "png":
+		return f
+	}
+	return "jpeg" // absent ou inconnu : JPEG reste lisible partout
+}
+
+// formatQuality ramène la qualité JPEG sur l'échelle du codec de sortie.
+// WebP 80 ≈ JPEG 85 en qualité perçue — même écart que le réglage historique de libwebp.
+// PNG est sans perte : la qualité est ignorée par l'encodeur. Le résultat reste ≥ 1 (quality=1..5 en WebP).
+func formatQuality(format string, q int) int {
+	if format == "webp" {
+		return max(q-5, 1)
+	}
+	return q
+}
+
+// fontSize lit wm_size : absent → taille par défaut, "auto" → autoFontRatio de la largeur, sinon des px.
+func fontSize(r wmSource, width int) (int, error) {
+	v := r.FormValue("wm_size")
+	switch v {
+	case "":
+		return defaultFontSize, nil
+	case "auto":
+		return min(max(int(float64(width)*autoFontRatio+0.5), minFontSize), maxFontSize), nil
+	}
+	size, err := strconv.Atoi(v)
+	if err != nil || size < minFontSize || size > maxFontSize {
+		return 0, fmt.Errorf("wm_size invalide : %q (auto ou %d-%d)", v, minFontSize, maxFontSize)
+	}
+	return size, nil
+}
+
+// encodeToBuffer encode l'image au format demandé (JPEG, WebP ou PNG) à la qualité q dans un buffer recyclé depuis le sync.Pool.
+// Retourne le buffer et le content-type.
+// Le caller est responsable de remettre le buffer dans le pool (defer bufPool.Put(buf)).
+func encodeToBuffer(img image.Image, format string, q int, opts encodeOptions) (*bytes.Buffer, string, error) {
 	buf := bufPool.Get().(*bytes.Buffer) // type assertion nécessaire car Pool retourne any
 	buf.Reset()                          // vider sans réallouer — le buffer a peut-être servi pour une requête précédente
 	logger.Debug().Str("step", "pool").Msg("buffer récupéré depuis sync.Pool")
 
-	if err := jpeg.Encode(buf, img, &jpeg.Options{Quality: q}); err != nil {
+	contentType, err := activeEncoder.encode(buf, img, format, q, opts) // backend choisi au démarrage (ENCODER)
+	if err != nil {
 		bufPool.Put(buf) // remettre le buffer même en cas d'erreur pour ne pas le perdre
-		return nil, "", 0, err
+		return nil, "", err
 	}
-	return buf, "image/jpeg", q, nil
+	return buf, contentType, nil
+}
+
+// chooseQuality retourne la qualité d'encodage et le bras d'expérience de la requête.
+// Une fraction experimentPct des requêtes utilise experimentCurve au lieu de qualityCurve,
+// pour mesurer l'effet d'une nouvelle courbe en production avant de la généraliser.
+// Une qualité imposée par le client (fixed > 0) l'emporte, hors expérience.
+func chooseQuality(w, h, fixed int) (q int, arm string) {
+	if fixed > 0 {
+		return fixed, "fixed"
+	}
+	if experimentPct > 0 && rand.IntN(100) < experimentPct { // tirage indépendant par requête
+		return experimentCurve[qualityTier(w, h)], "quality-b"
+	}
+	return adaptiveQuality(w, h), "control"
+}
+
+// qualityParam lit quality (1..100, échelle JPEG) — 0 si absent : qualité adaptative.
+// Archivage (95) ou compression agressive (70) selon le client ; formatQuality s'applique ensuite
+// comme pour la courbe, la qualité perçue ne dépend donc pas du format négocié.
+func qualityParam(r *http.Request) (int, error) {
+	v := r.FormValue("quality")
+	if v == "" {
+		return 0, nil
+	}
+	q, err := strconv.Atoi(v)
+	if err != nil || q < 1 || q > 100 {
+		return 0, fmt.Errorf("quality invalide : %q (entier 1-100)", v)
+	}
+	return q, nil
 }
 
 // adaptiveQuality choisit la qualité JPEG en fonction du nombre de pixels de l'image de sortie.
 // Plus l'image est grande, plus elle mérite une qualité élevée pour préserver les détails.
 func adaptiveQuality(w, h int) int {
+	return qualityCurve[qualityTier(w, h)]
+}
+
+// qualityTier classe l'image de sortie par surface : 0 miniature, 1 HD, 2 Full HD et au-delà.
+// Index commun à qualityCurve et experimentCurve.
+func qualityTier(w, h int) int {
 	pixels := w * h // surface totale — critère plus pertinent que la largeur seule
 	switch {
-	case pixels < 500*500:   // miniature (< 250K pixels) — la compression artefact est moins visible
-		return 80
+	case pixels < 500*500: // miniature (< 250K pixels) — la compression artefact est moins visible
+		return 0
 	case pixels < 1920*1080: // HD (< 2M pixels)
-		return 85
+		return 1
 	default: // Full HD et au-delà — chaque pixel compte davantage
-		return 90
+		return 2
 	}
 }
 
 // ── Watermark ─────────────────────────────────────────────────────────────────
 
-// applyWatermark dessine le texte sur une copie RGBA de l'image source.
+// drawText dessine le texte du watermark sur le canvas.
 // La couleur du texte est choisie dynamiquement en fonction de la luminosité
 // du fond à l'endroit où sera positionné le watermark.
-func applyWatermark(img image.Image, text, position string) (image.Image, error) {
-	canvas := image.NewRGBA(img.Bounds())                           // copie RGBA pour rendre l'image modifiable (img source peut être read-only)
-	draw.Draw(canvas, canvas.Bounds(), img, image.Point{}, draw.Src) // copier les pixels source sur le canvas avant de dessiner par-dessus
+func drawText(canvas *image.RGBA, opts wmOptions) error {
+	face, err := opts.font.face(opts.size)
+	if err != nil {
+		return err
+	}
 
-	textWidth := font.MeasureString(fontFace, text).Ceil()                                         // largeur en pixels pour positionner le texte à droite sans déborder
-	wmX, wmY := wmCoords(textWidth, canvas.Bounds().Max.X, canvas.Bounds().Max.Y, position)        // coordonnées du coin bas-gauche du texte
-	wmColor := adaptiveColor(img, wmX, wmY)                                                        // blanc ou gris foncé selon la luminosité du fond
+	// Mesure et rasterisation sous le même verrou — la face est partagée entre les requêtes.
+	face.Lock()
+	textWidth := font.MeasureString(face, opts.text).Ceil() // largeur en pixels pour positionner le texte à droite sans déborder
+	metrics := face.Metrics()                               // ascent/descent : hauteurs au-dessus et au-dessous de la baseline — dépendent de la taille
+	ascent, descent := metrics.Ascent.Ceil(), metrics.Descent.Ceil()
+	wmX, wmY := wmCoords(textWidth, ascent, descent, canvas.Bounds().Max.X, canvas.Bounds().Max.Y, opts.position) // coordonnées du coin bas-gauche du texte
+	wmX = opts.x.resolve(canvas.Bounds().Max.X, textWidth, wmX)
+	wmY = opts.y.resolve(canvas.Bounds().Max.Y, ascent+descent, wmY-ascent) + ascent // wm_y vise le haut de la ligne, pas la baseline
+
+	pad := opts.outline // marge autour des glyphes pour les effets qui en débordent
+	if opts.color == nil && opts.plate == nil {
+		pad = max(pad, autoOutline(opts.size)) // couleur adaptative : un contour peut être ajouté selon le fond
+	}
+	shadowOffset, shadowRadius := shadowGeometry(opts.size)
+	if opts.shadow {
+		pad = max(pad, 3*shadowRadius) // le flou s'étale sur 3 rayons ; le décalage est appliqué à la composition
+	}
+	mask := textMask(face, opts.text, wmX, wmY, pad) // couverture des glyphes en coordonnées image
+	face.Unlock()
 
-	d := &font.Drawer{
-		Dst:  canvas,
-		Src:  image.NewUniform(wmColor), // couleur uniforme sur toute la surface du texte
-		Face: fontFace,
-		// Dot est la baseline du texte (coin bas-gauche du premier glyphe).
-		Dot: fixed.Point26_6{
-			X: fixed.I(wmX), // fixed.I convertit un entier en fixed-point 26.6 (format requis par x/image/font)
-			Y: fixed.I(wmY),
-		},
+	line := image.Rect(wmX, wmY-ascent, wmX+textWidth, wmY+descent) // boîte de la ligne de texte
+	var plate *image.Alpha
+	if opts.plate != nil { // plaque arrondie sous le texte — rayon égal au padding, coins concentriques à la ligne
+		plate = plateMask(line.Inset(-opts.platePadding), opts.platePadding)
+	}
+	if opts.angle != 0 { // rotation autour du centre de la ligne — plaque, contour et ombre suivent le masque tourné
+		cx, cy := float64(line.Min.X+line.Max.X)/2, float64(line.Min.Y+line.Max.Y)/2
+		mask = rotateMask(mask, opts.angle, cx, cy)
+		if plate != nil {
+			plate = rotateMask(plate, opts.angle, cx, cy)
+		}
+	}
+	if opts.angle != 0 || plate != nil { // la plaque ou le texte tourné peuvent dépasser la marge : recadrage
+		outer := mask.Rect
+		if plate != nil {
+			outer = plate.Rect
+		}
+		frame := canvas.Bounds().Inset(wmMargin) // même marge que le texte seul
+		if opts.x != nil || opts.y != nil {      // coordonnées explicites : seul le bord du canvas compte
+			frame = canvas.Bounds()
+		}
+		d := insideCanvas(outer, frame)
+		mask.Rect = mask.Rect.Add(d)
+		if plate != nil {
+			plate.Rect = plate.Rect.Add(d)
+		}
+		wmX, wmY = wmX+d.X, wmY+d.Y
+	}
+	// Échantillonne le canvas sous les glyphes (boîte du masque sans sa marge) : les calques précédents comptent dans le fond.
+	wmColor, busy := textColor(canvas, mask.Rect.Inset(pad), opts)
+	outline := opts.outline
+	if busy && outline == 0 { // fond trop contrasté pour une seule couleur : le contour assure la lisibilité
+		outline = autoOutline(opts.size)
+	}
+
+	if plate != nil {
+		draw.DrawMask(canvas, plate.Rect, image.NewUniform(*opts.plate), image.Point{}, plate, plate.Rect.Min, draw.Over)
 	}
-	d.DrawString(text) // rasterise le texte sur le canvas
 
-	return canvas, nil
+	if opts.shadow { // ombre portée : copie floutée et décalée du texte, sous tout le reste
+		shadow := blurMask(mask, shadowRadius)
+		shadowColor := color.NRGBA{A: uint8(uint32(wmColor.A) * 3 / 4)} // noire, un peu plus légère que le texte
+		dst := mask.Rect.Add(image.Pt(shadowOffset, shadowOffset))      // vers le bas à droite — lumière venant du haut à gauche
+		draw.DrawMask(canvas, dst, image.NewUniform(shadowColor), image.Point{}, shadow, mask.Rect.Min, draw.Over)
+	}
+	if outline > 0 { // contour de couleur opposée : le texte reste lisible sur un fond mi-clair mi-sombre
+		ring := outlineMask(mask, outline)
+		draw.DrawMask(canvas, mask.Rect, image.NewUniform(contrastColor(wmColor)), image.Point{}, ring, mask.Rect.Min, draw.Over)
+	}
+	compose(canvas, mask.Rect, image.NewUniform(wmColor), image.Point{}, mask, mask.Rect.Min, opts.blend) // couleur uniforme sur toute la surface du texte
+
+	return nil
 }
 
 // wmCoords calcule les coordonnées (x, y) du point d'ancrage du watermark
 // en fonction de la position demandée et des dimensions de l'image.
 // (x, y) correspond à la baseline bas-gauche du texte dans le repère font.Drawer.
-func wmCoords(textWidth, w, h int, position string) (x, y int) {
+// Le centrage vertical se fait sur la hauteur de ligne (ascent + descent), pas sur la baseline :
+// la ligne de texte entière est centrée, jambages compris.
+func wmCoords(textWidth, ascent, descent, w, h int, position string) (x, y int) {
+	centerX := (w - textWidth) / 2
 	switch position {
 	case "top-left":
-		return wmMargin, wmLineHeight + wmMargin // wmLineHeight décale vers le bas pour que le texte ne soit pas coupé en haut
+		return wmMargin, ascent + wmMargin // ascent décale vers le bas pour que le texte ne soit pas coupé en haut
+	case "top-center":
+		return centerX, ascent + wmMargin
 	case "top-right":
-		return w - textWidth - wmMargin, wmLineHeight + wmMargin // symétrique top-left, ancré à droite
+		return w - textWidth - wmMargin, ascent + wmMargin // symétrique top-left, ancré à droite
+	case "center":
+		return centerX, (h + ascent - descent) / 2 // baseline = milieu + demi-hauteur de ligne - descent
 	case "bottom-left":
 		return wmMargin, h - wmMargin // h - margin = juste au-dessus du bord bas
+	case "bottom-center":
+		return centerX, h - wmMargin
 	default: // bottom-right
 		return w - textWidth - wmMargin, h - wmMargin // position par défaut — la moins intrusive pour les photos
 	}
@@ -271,24 +1053,60 @@ func wmCoords(textWidth, w, h int, position string) (x, y int) {
 
 // ── Couleur adaptative ────────────────────────────────────────────────────────
 
+// textColor retourne la couleur du texte : wm_color si imposée, sinon l'opposée de la plaque,
+// sinon la couleur adaptative au fond de la zone r. busy signale un fond trop contrasté pour
+// la couleur adaptative seule — toujours faux quand la couleur est imposée ou posée sur une plaque.
+func textColor(img image.Image, r image.Rectangle, opts wmOptions) (c color.NRGBA, busy bool) {
+	if opts.color != nil {
+		return *opts.color, false
+	}
+	if opts.plate != nil { // la plaque garantit le contraste — le fond de l'image n'importe plus
+		c := contrastColor(*opts.plate)
+		c.A = wmAlpha(opts.opacity, wmTextAlpha)
+		return c, false
+	}
+	return adaptiveColor(img, r, wmAlpha(opts.opacity, wmTextAlpha)) // blanc ou gris foncé selon la luminosité du fond
+}
+
 // adaptiveColor choisit blanc ou gris foncé selon la luminosité moyenne du fond
-// à l'endroit où sera tracé le watermark, afin de garantir la lisibilité
+// dans la zone r où sera tracé le watermark, afin de garantir la lisibilité
 // sur n'importe quelle image (claire ou sombre).
-func adaptiveColor(img image.Image, x, y int) color.RGBA {
-	avg := sampleLuminance(img, x, y) // luminance moyenne de la zone où le watermark sera dessiné
-	darkBg := avg <= 128              // seuil mi-chemin entre noir (0) et blanc (255)
+// La moyenne ne suffit pas sur un fond mi-clair mi-sombre : la couleur choisie disparaît sur
+// une moitié du texte. busy signale ce cas, détecté par l'écart-type de luminance.
+// alpha vient de wm_opacity ; NRGBA (non prémultiplié) pour que l'opacité soit un vrai mélange avec le fond.
+func adaptiveColor(img image.Image, r image.Rectangle, alpha uint8) (c color.NRGBA, busy bool) {
+	avg, stdDev := sampleLuminance(img, r) // luminance de la zone où le watermark sera dessiné
+	darkBg := avg <= 128                   // seuil mi-chemin entre noir (0) et blanc (255)
+	busy = stdDev > busyLumStdDev
 
 	// En dessous : fond sombre → texte blanc. Au-dessus : fond clair → texte sombre.
-	logger.Debug().Str("step", "adaptive_color").Float64("luminance", avg).Bool("dark_bg", darkBg).Msg("couleur adaptative")
+	logger.Debug().Str("step", "adaptive_color").Float64("luminance", avg).Float64("std_dev", stdDev).Bool("dark_bg", darkBg).Bool("busy", busy).Msg("couleur adaptative")
 
 	if darkBg {
-		return color.RGBA{R: 255, G: 255, B: 255, A: 210} // blanc semi-transparent sur fond sombre
+		return color.NRGBA{R: 255, G: 255, B: 255, A: alpha}, busy // blanc semi-transparent sur fond sombre
 	}
-	return color.RGBA{R: 30, G: 30, B: 30, A: 210} // gris foncé semi-transparent sur fond clair
+	return color.NRGBA{R: 30, G: 30, B: 30, A: alpha}, busy // gris foncé semi-transparent sur fond clair
+}
+
+// autoOutline est l'épaisseur du contour ajouté sur un fond contrasté, proportionnelle à la taille du texte.
+func autoOutline(size int) int {
+	return min(max(size/24, 1), maxOutline)
 }
 
-// sampleLuminance calcule la luminance perceptuelle moyenne d'une zone de sampleW×sampleH px
-// à partir du coin (x, y). Les bords sont clampés aux limites de l'image.
+// lumStats accumule la luminance d'une tranche de lignes : somme et somme des carrés (pour la variance).
+type lumStats struct {
+	sum, sq float64
+}
+
+func (s *lumStats) add(img image.Image, px, py int) {
+	r, g, b, _ := img.At(px, py).RGBA()                                  // RGBA retourne des valeurs 16 bits (0-65535)
+	l := 0.299*float64(r>>8) + 0.587*float64(g>>8) + 0.114*float64(b>>8) // >>8 ramène en 8 bits (0-255)
+	s.sum += l
+	s.sq += l * l
+}
+
+// sampleLuminance calcule la luminance perceptuelle moyenne et son écart-type sur la zone r —
+// la boîte englobante mesurée des glyphes. La zone est clampée aux limites de l'image.
 //
 // Parallélisation : les lignes sont découpées en numCPU chunks, chaque goroutine écrit
 // dans son index de totals[i] — sans mutex, sans false sharing (indices indépendants).
@@ -296,118 +1114,247 @@ func adaptiveColor(img image.Image, x, y int) color.RGBA {
 //
 // Formule ITU-R BT.601 : L = 0.299·R + 0.587·G + 0.114·B
 // Les coefficients reflètent la sensibilité de l'œil humain : vert > rouge > bleu.
-func sampleLuminance(img image.Image, x, y int) float64 {
-	bounds := img.Bounds() // limites de l'image pour clamper la zone d'échantillonnage
-
-	startX := x
-	startY := max(y-sampleH, bounds.Min.Y) // on remonte de sampleH pixels au-dessus de la baseline du texte
-	endX := min(startX+sampleW, bounds.Max.X) // clamp à droite — évite de lire hors de l'image
-	endY := min(startY+sampleH, bounds.Max.Y) // clamp en bas
+func sampleLuminance(img image.Image, r image.Rectangle) (avg, stdDev float64) {
+	r = r.Intersect(img.Bounds()) // clamp aux limites — évite de lire hors de l'image
+	startX, startY, endX, endY := r.Min.X, r.Min.Y, r.Max.X, r.Max.Y
 
-	rows := endY - startY // nombre réel de lignes après clamp (peut être < sampleH aux bords de l'image)
+	rows := endY - startY // nombre réel de lignes après clamp
 	cols := endX - startX
-	if rows == 0 || cols == 0 { // zone vide si le watermark est positionné hors image
-		return 0
+	if rows <= 0 || cols <= 0 { // zone vide si le watermark est positionné hors image
+		return 0, 0
 	}
 
 	numWorkers := runtime.NumCPU() // autant de workers que de cœurs — cohérent avec le sémaphore global
 
+	// Chaque worker accumule ses lignes dans totals[i] — pas de contention, pas de mutex.
+	totals := make([]lumStats, numWorkers) // un accumulateur par worker — indices distincts → lock-free
+
 	// Sous ce seuil l'overhead de création des goroutines dépasse le gain de parallélisme.
 	if rows < numWorkers {
-		var total float64
 		for py := startY; py < endY; py++ {
 			for px := startX; px < endX; px++ {
-				r, g, b, _ := img.At(px, py).RGBA()                                    // RGBA retourne des valeurs 16 bits (0-65535)
-				total += 0.299*float64(r>>8) + 0.587*float64(g>>8) + 0.114*float64(b>>8) // >>8 ramène en 8 bits (0-255)
+				totals[0].add(img, px, py)
 			}
 		}
-		return total / float64(rows*cols) // moyenne sur tous les pixels de la zone
-	}
-
-	// Chaque worker somme ses lignes dans totals[i] — pas de contention, pas de mutex.
-	totals := make([]float64, numWorkers)                  // un accumulateur par worker — indices distincts → lock-free
-	chunkSize := (rows + numWorkers - 1) / numWorkers // division ceiling pour que le dernier chunk couvre toutes les lignes
-
-	var wg sync.WaitGroup
-	for i := 0; i < numWorkers; i++ {
-		rowStart := startY + i*chunkSize          // début de la tranche de lignes pour ce worker
-		rowEnd := min(rowStart+chunkSize, endY)   // fin clampée — le dernier chunk peut être plus court
-		if rowStart >= endY {                     // arrive si rows < numWorkers (déjà géré, mais gardé en sécurité)
-			break
-		}
-		wg.Add(1)
-		go func(rStart, rEnd, idx int) { // bornes passées par valeur pour éviter la capture par référence dans la boucle
-			defer wg.Done()
-			var t float64
-			for py := rStart; py < rEnd; py++ {
-				for px := startX; px < endX; px++ {
-					r, g, b, _ := img.At(px, py).RGBA()                                  // RGBA retourne des valeurs 16 bits (0-65535)
-					t += 0.299*float64(r>>8) + 0.587*float64(g>>8) + 0.114*float64(b>>8) // >>8 ramène en 8 bits (0-255)
-				}
+	} else {
+		chunkSize := (rows + numWorkers - 1) / numWorkers // division ceiling pour que le dernier chunk couvre toutes les lignes
+
+		var wg sync.WaitGroup
+		for i := 0; i < numWorkers; i++ {
+			rowStart := startY + i*chunkSize        // début de la tranche de lignes pour ce worker
+			rowEnd := min(rowStart+chunkSize, endY) // fin clampée — le dernier chunk peut être plus court
+			if rowStart >= endY {                   // arrive si rows < numWorkers (déjà géré, mais gardé en sécurité)
+				break
 			}
-			totals[idx] = t // écriture dans l'index exclusif du worker — aucune autre goroutine ne touche cet index
-		}(rowStart, rowEnd, i)
+			wg.Add(1)
+			go func(rStart, rEnd, idx int) { // bornes passées par valeur pour éviter la capture par référence dans la boucle
+				defer wg.Done()
+				var t lumStats
+				for py := rStart; py < rEnd; py++ {
+					for px := startX; px < endX; px++ {
+						t.add(img, px, py)
+					}
+				}
+				totals[idx] = t // écriture dans l'index exclusif du worker — aucune autre goroutine ne touche cet index
+			}(rowStart, rowEnd, i)
+		}
+		wg.Wait() // attendre que tous les workers aient terminé avant d'agréger
 	}
-	wg.Wait() // attendre que tous les workers aient terminé avant d'agréger
 
-	var total float64
+	var total lumStats
 	for _, t := range totals { // sommation séquentielle des sous-totaux — rapide car numWorkers entrées max
-		total += t
+		total.sum += t.sum
+		total.sq += t.sq
 	}
-	return total / float64(rows*cols) // moyenne sur tous les pixels de la zone
+	n := float64(rows * cols)
+	avg = total.sum / n                               // moyenne sur tous les pixels de la zone
+	return avg, math.Sqrt(max(total.sq/n-avg*avg, 0)) // Var = E[L²] − E[L]² ; max(…, 0) absorbe les erreurs d'arrondi
 }
 
 // ── Resize ────────────────────────────────────────────────────────────────────
 
-// resize redimensionne l'image si elle dépasse maxWidth×maxHeight,
-// en préservant le ratio. L'interpolation BiLinear offre un bon compromis
-// entre qualité visuelle et vitesse (meilleur que NearestNeighbor, moins coûteux que CatmullRom).
-func resize(img image.Image) image.Image {
-	w := img.Bounds().Dx() // largeur source
-	h := img.Bounds().Dy() // hauteur source
+// resizeSpec décrit les dimensions de sortie demandées : un cadre max_w×max_h dans lequel l'image
+// est réduite (jamais agrandie), ou une largeur et/ou hauteur exacte (w, h).
+type resizeSpec struct {
+	maxW, maxH int    // cadre de réduction — 1920×1080 par défaut
+	w, h       int    // dimensions exactes — 0 : non imposée
+	pad        bool   // fit=pad : l'image tient dans w×h, ratio préservé, complétée par bg_color
+	upscale    bool   // upscale=true : une image plus petite que le cadre est agrandie pour le remplir
+	interp     string // interp : algorithme imposé — vide : BiLinear en réduction, CatmullRom en agrandissement
+}
 
-	if w <= maxWidth && h <= maxHeight { // déjà dans les limites — retourner l'original évite une copie inutile
-		return img
+// interpolators : algorithmes proposés par interp, du plus rapide au plus fin.
+// NearestNeighbor crénèle mais préserve le pixel art ; CatmullRom coûte ~3× BiLinear mais
+// évite le moiré des fortes réductions.
+var interpolators = map[string]xdraw.Interpolator{
+	"nearest":    xdraw.NearestNeighbor,
+	"bilinear":   xdraw.BiLinear,
+	"catmullrom": xdraw.CatmullRom,
+}
+
+// exact indique le mode w/h : la sortie a exactement la taille demandée, agrandissement compris.
+func (s resizeSpec) exact() bool { return s.w > 0 || s.h > 0 }
+
+// resizeParams lit max_w/max_h (cadre de réduction), upscale, interp, w/h (taille exacte) et fit.
+// Avec w seul (ou h seul), l'autre dimension suit le ratio ; avec les deux, l'image est étirée
+// (fit=stretch, par défaut) ou réduite dans le cadre puis complétée par des bandes (fit=pad).
+func resizeParams(r *http.Request) (spec resizeSpec, err error) {
+	if spec.interp = r.FormValue("interp"); spec.interp != "" && interpolators[spec.interp] == nil {
+		return spec, fmt.Errorf("interp inconnu : %q (acceptés : %v)", spec.interp, slices.Sorted(maps.Keys(interpolators)))
+	}
+	if v := r.FormValue("upscale"); v != "" {
+		if spec.upscale, err = strconv.ParseBool(v); err != nil {
+			return spec, fmt.Errorf("upscale invalide : %q (true ou false)", v)
+		}
 	}
+	spec.maxW, spec.maxH = maxWidth, maxHeight
+	for _, p := range []struct {
+		field string
+		dst   *int
+	}{{"max_w", &spec.maxW}, {"max_h", &spec.maxH}, {"w", &spec.w}, {"h", &spec.h}} {
+		v := r.FormValue(p.field)
+		if v == "" {
+			continue
+		}
+		if *p.dst, err = strconv.Atoi(v); err != nil || *p.dst < 1 || *p.dst > maxOutputSide {
+			return spec, fmt.Errorf("%s invalide : %q (entier 1-%d)", p.field, v, maxOutputSide)
+		}
+	}
+	if spec.exact() && (r.FormValue("max_w") != "" || r.FormValue("max_h") != "") {
+		return spec, fmt.Errorf("w/h et max_w/max_h sont exclusifs")
+	}
+	switch fit := r.FormValue("fit"); fit {
+	case "", "stretch":
+	case "pad":
+		if spec.w == 0 || spec.h == 0 {
+			return spec, fmt.Errorf("fit=pad exige w et h")
+		}
+		spec.pad = true
+	default:
+		return spec, fmt.Errorf("fit invalide : %q (stretch ou pad)", fit)
+	}
+	return spec, nil
+}
 
+// target calcule les dimensions de sortie d'une image w×h, ratio préservé hors étirement explicite.
+// Avec w seul (ou h seul), le côté déduit du ratio est borné par maxOutputSide : une source de
+// 100×8000 avec w=8000 donnerait 640000 px de haut. Au-delà, l'image tient dans w×maxOutputSide
+// (ou maxOutputSide×h) — le côté imposé est alors réduit, comme le fait max_h pour renderVariants.
+func (s resizeSpec) target(w, h int) (newW, newH int) {
 	ratio := float64(w) / float64(h) // ratio à préserver pour ne pas déformer l'image
-	newW, newH := maxWidth, maxHeight // cibles initiales — l'une sera réduite pour respecter le ratio
-	if float64(maxWidth)/float64(maxHeight) > ratio { // l'image est plus "portrait" que la cible
-		newW = int(float64(maxHeight) * ratio) // contrainte hauteur — réduire la largeur
+	switch {
+	case s.pad: // l'image tient dans le cadre — les bandes sont ajoutées par padTo
+		return fitInside(w, h, s.w, s.h)
+	case s.w > 0 && s.h > 0: // les deux imposées — étirement assumé par le client
+		return s.w, s.h
+	case s.w > 0:
+		if newH = max(1, int(float64(s.w)/ratio+0.5)); newH > maxOutputSide {
+			return fitInside(w, h, s.w, maxOutputSide)
+		}
+		return s.w, newH
+	case s.h > 0:
+		if newW = max(1, int(float64(s.h)*ratio+0.5)); newW > maxOutputSide {
+			return fitInside(w, h, maxOutputSide, s.h)
+		}
+		return newW, s.h
+	}
+	if w <= s.maxW && h <= s.maxH && !s.upscale { // déjà dans les limites
+		return w, h
+	}
+	newW, newH = s.maxW, s.maxH                  // cibles initiales — l'une sera réduite pour respecter le ratio
+	if float64(s.maxW)/float64(s.maxH) > ratio { // l'image est plus "portrait" que la cible
+		newW = max(1, int(float64(s.maxH)*ratio)) // contrainte hauteur — réduire la largeur
 	} else {
-		newH = int(float64(maxWidth) / ratio) // contrainte largeur — réduire la hauteur
+		newH = max(1, int(float64(s.maxW)/ratio)) // contrainte largeur — réduire la hauteur
+	}
+	if newW > w*maxUpscale { // agrandissement plafonné — le ratio reste celui de la source
+		newW, newH = w*maxUpscale, h*maxUpscale
 	}
+	return newW, newH
+}
 
-	dst := image.NewRGBA(image.Rect(0, 0, newW, newH))                              // canvas destination aux nouvelles dimensions
-	xdraw.BiLinear.Scale(dst, dst.Bounds(), img, img.Bounds(), xdraw.Over, nil) // BiLinear : meilleur compromis qualité/vitesse pour le redimensionnement
-	return dst
+// interpolator retourne l'algorithme imposé par interp, ou le choix par défaut selon le sens du resize.
+func (s resizeSpec) interpolator(enlarge bool) xdraw.Interpolator {
+	if s.interp != "" {
+		return interpolators[s.interp]
+	}
+	if enlarge {
+		return xdraw.CatmullRom
+	}
+	return xdraw.BiLinear
 }
 
-// ── Font ──────────────────────────────────────────────────────────────────────
+// resize redimensionne l'image aux dimensions demandées par spec.
+// En réduction, l'interpolation BiLinear offre un bon compromis entre qualité visuelle et vitesse
+// (meilleur que NearestNeighbor, moins coûteux que CatmullRom). En agrandissement, CatmullRom :
+// BiLinear y étale les bords en flou, et l'image source étant petite, le surcoût reste faible.
+func resize(img image.Image, spec resizeSpec) image.Image {
+	w := img.Bounds().Dx() // largeur source
+	h := img.Bounds().Dy() // hauteur source
 
-// loadFont charge la police Go Regular embarquée dans le binaire et crée le font.Face global.
-// La police est compilée dans l'exécutable via goregular.TTF — aucun fichier externe requis,
-// ce qui simplifie le déploiement Docker (pas de dépendance apk ou de montage de volume).
-func loadFont() error {
-	t := time.Now()
-	fontBytes := goregular.TTF // police embarquée — zéro I/O disque au démarrage
+	newW, newH := spec.target(w, h)
+	if newW == w && newH == h { // déjà aux bonnes dimensions — retourner l'original évite une copie inutile
+		return img
+	}
 
-	f, err := opentype.Parse(fontBytes) // .ttf simple (pas une collection) → Parse suffit
-	if err != nil {
-		return err
+	dst := newCanvas(image.Rect(0, 0, newW, newH)) // canvas destination aux nouvelles dimensions
+	spec.interpolator(newW > w || newH > h).Scale(dst, dst.Bounds(), img, img.Bounds(), xdraw.Over, nil)
+	return dst
+}
+
+// ── Utilitaires ───────────────────────────────────────────────────────────────
+
+// envInt lit une variable d'environnement entière, avec fallback si absente ou invalide.
+func envInt(key string, def int) int {
+	v, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || v < 0 { // absente, mal formée ou négative → valeur par défaut
+		return def
 	}
+	return v
+}
 
-	// Taille 48pt @ 72 DPI = 48px — visible sur des images jusqu'à 1920px de large.
-	fontFace, err = opentype.NewFace(f, &opentype.FaceOptions{
-		Size: 48, // 48pt — visible sans écraser le sujet de la photo
-		DPI:  72, // 72 DPI = convention écran (1pt = 1px)
-	})
+// parseByteSize lit une taille en octets, avec suffixe K, M ou G facultatif (puissances de 1024) :
+// "52428800", "50M", "50MB" et "50 mb" sont équivalents.
+func parseByteSize(v string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(v))
+	s = strings.TrimSuffix(s, "B")
+	mult := int64(1)
+	if i := strings.IndexAny(s, "KMG"); i >= 0 && i == len(s)-1 {
+		mult = map[byte]int64{'K': 1 << 10, 'M': 1 << 20, 'G': 1 << 30}[s[i]]
+		s = strings.TrimSpace(s[:i])
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("taille invalide : %q", v)
+	}
+	return n * mult, nil
+}
 
-	logger.Info().Str("component", "init").Str("path", "embedded:go-regular").Str("size", formatBytes(len(fontBytes))).Dur("duration", time.Since(t)).Msg("police chargée")
-	return err
+// limitUpload borne le body de r à maxUploadSize : 413 d'emblée si Content-Length le dépasse,
+// sinon MaxBytesReader coupe la lecture en cours de route. Parse le formulaire multipart ;
+// une autre erreur de parsing est laissée au handler (image manquante).
+func limitUpload(w http.ResponseWriter, r *http.Request) bool {
+	if r.ContentLength > maxUploadSize {
+		http.Error(w, fmt.Sprintf("fichier trop volumineux (max %s)", formatBytes(int(maxUploadSize))), http.StatusRequestEntityTooLarge)
+		return false
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	if err := r.ParseMultipartForm(32 << 20); errors.As(err, new(*http.MaxBytesError)) { // au-delà de 32 Mo, les parts vont sur disque
+		http.Error(w, fmt.Sprintf("fichier trop volumineux (max %s)", formatBytes(int(maxUploadSize))), http.StatusRequestEntityTooLarge)
+		return false
+	}
+	return true
 }
 
-// ── Utilitaires ───────────────────────────────────────────────────────────────
+// formatList retourne les formats autorisés triés — ordre stable pour les logs et les messages d'erreur.
+func formatList(formats map[string]bool) []string {
+	list := make([]string, 0, len(formats))
+	for f := range formats {
+		list = append(list, f)
+	}
+	slices.Sort(list)
+	return list
+}
 
 func formatBytes(b int) string {
 	if b < 1024 { // en dessous d'un Ko — afficher en octets bruts
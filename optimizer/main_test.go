@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func TestFormatQuality(t *testing.T) {
+	for _, tc := range []struct {
+		format  string
+		q, want int
+	}{
+		{"jpeg", 85, 85},
+		{"webp", 85, 80},
+		{"webp", 5, 1},
+		{"webp", 1, 1},
+		{"png", 1, 1},
+	} {
+		if got := formatQuality(tc.format, tc.q); got != tc.want {
+			t.Errorf("formatQuality(%q, %d) = %d, attendu %d", tc.format, tc.q, got, tc.want)
+		}
+	}
+}
+
+// Le côté déduit du ratio reste dans maxOutputSide, même pour un ratio extrême.
+func TestResizeTarget(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		spec         resizeSpec
+		w, h         int
+		wantW, wantH int
+	}{
+		{"w seul", resizeSpec{w: 800}, 1600, 900, 800, 450},
+		{"h seul", resizeSpec{h: 450}, 1600, 900, 800, 450},
+		{"w sur source très haute", resizeSpec{w: maxOutputSide}, 100, 8000, 100, maxOutputSide},
+		{"w sur source d'1 px de large", resizeSpec{w: maxOutputSide}, 1, 8000, 1, maxOutputSide},
+		{"h sur source très large", resizeSpec{h: maxOutputSide}, 8000, 100, maxOutputSide, 100},
+		{"h sur source d'1 px de haut", resizeSpec{h: maxOutputSide}, 65535, 1, maxOutputSide, 1},
+		{"w et h : étirement", resizeSpec{w: maxOutputSide, h: 10}, 1, 8000, maxOutputSide, 10},
+		{"pad", resizeSpec{w: maxOutputSide, h: maxOutputSide, pad: true}, 1, 8000, 1, maxOutputSide},
+		{"cadre avec upscale", resizeSpec{maxW: maxOutputSide, maxH: maxOutputSide, upscale: true}, 1, 8000, 1, 8000},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if w, h := tc.spec.target(tc.w, tc.h); w != tc.wantW || h != tc.wantH {
+				t.Errorf("target(%d, %d) = %d×%d, attendu %d×%d", tc.w, tc.h, w, h, tc.wantW, tc.wantH)
+			}
+		})
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+const maxExifSize = 1 << 20 // un bloc EXIF dépasse rarement 64 Ko (limite d'un segment APP1 JPEG) — au-delà on ignore
+
+// readExif retourne le bloc EXIF brut (structure TIFF) d'un JPEG, PNG ou WebP, ou nil s'il n'y en a pas.
+// Seuls les en-têtes de segments/chunks sont lus : les données image sont sautées via Seek.
+// Le curseur du fichier est laissé à une position quelconque — le caller doit le replacer.
+func readExif(f io.ReadSeeker) []byte {
+	var sig [12]byte
+	if _, err := io.ReadFull(f, sig[:]); err != nil {
+		return nil
+	}
+
+	switch {
+	case sig[0] == 0xFF && sig[1] == 0xD8: // JPEG : suite de segments FF xx + longueur big-endian
+		f.Seek(2, io.SeekStart) //nolint:errcheck — une erreur fera échouer la lecture suivante
+		var hdr [4]byte
+		for {
+			if _, err := io.ReadFull(f, hdr[:]); err != nil || hdr[0] != 0xFF {
+				return nil
+			}
+			marker := hdr[1]
+			if marker == 0xDA || marker == 0xD9 { // SOS / EOI : les métadonnées sont toujours avant les données compressées
+				return nil
+			}
+			size := int(binary.BigEndian.Uint16(hdr[2:])) - 2 // la longueur inclut ses propres 2 octets
+			if size < 0 {
+				return nil
+			}
+			if marker == 0xE1 && size >= 6 { // APP1 — EXIF ou XMP
+				payload, err := readN(f, size)
+				if err != nil {
+					return nil
+				}
+				if bytes.HasPrefix(payload, []byte("Exif\x00\x00")) {
+					return payload[6:]
+				}
+				continue
+			}
+			if _, err := f.Seek(int64(size), io.SeekCurrent); err != nil {
+				return nil
+			}
+		}
+
+	case bytes.Equal(sig[:8], []byte("\x89PNG\r\n\x1a\n")): // PNG : chunks longueur(4) + type(4) + données + CRC(4)
+		f.Seek(8, io.SeekStart) //nolint:errcheck
+		var hdr [8]byte
+		for {
+			if _, err := io.ReadFull(f, hdr[:]); err != nil {
+				return nil
+			}
+			size := int64(binary.BigEndian.Uint32(hdr[:4]))
+			switch string(hdr[4:]) {
+			case "eXIf":
+				payload, err := readN(f, int(size))
+				if err != nil {
+					return nil
+				}
+				return payload
+			case "IDAT", "IEND": // eXIf doit précéder les données image (spec PNG 1.5)
+				return nil
+			}
+			if _, err := f.Seek(size+4, io.SeekCurrent); err != nil { // +4 : CRC
+				return nil
+			}
+		}
+
+	case string(sig[:4]) == "RIFF" && string(sig[8:12]) == "WEBP": // WebP : chunks fourcc(4) + taille little-endian(4)
+		var hdr [8]byte
+		for {
+			if _, err := io.ReadFull(f, hdr[:]); err != nil {
+				return nil
+			}
+			size := int64(binary.LittleEndian.Uint32(hdr[4:]))
+			if string(hdr[:4]) == "EXIF" { // en WebP étendu, le chunk EXIF est souvent après les données image
+				payload, err := readN(f, int(size))
+				if err != nil {
+					return nil
+				}
+				return bytes.TrimPrefix(payload, []byte("Exif\x00\x00")) // certains encodeurs gardent le préfixe JPEG
+			}
+			if _, err := f.Seek(size+size&1, io.SeekCurrent); err != nil { // les chunks RIFF sont alignés sur 2 octets
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// readN lit exactement n octets, en refusant les blocs plus grands que maxExifSize.
+func readN(r io.Reader, n int) ([]byte, error) {
+	if n > maxExifSize {
+		return nil, io.ErrShortBuffer
+	}
+	buf := make([]byte, n)
+	_, err := io.ReadFull(r, buf)
+	return buf, err
+}
+
+// exifHasGPS indique si un bloc EXIF (structure TIFF) référence un IFD GPS non vide.
+// Seul l'IFD0 est parcouru : le tag GPSInfo (0x8825) y pointe vers l'IFD GPS.
+func exifHasGPS(tiff []byte) bool {
+	if len(tiff) < 8 {
+		return false
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return false
+	}
+
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd+2 > len(tiff) {
+		return false
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		e := ifd + 2 + i*12 // entrée : tag(2) type(2) count(4) valeur/offset(4)
+		if e+12 > len(tiff) {
+			return false
+		}
+		if order.Uint16(tiff[e:]) != 0x8825 {
+			continue
+		}
+		gps := int(order.Uint32(tiff[e+8:]))
+		return gps+2 <= len(tiff) && order.Uint16(tiff[gps:]) > 0 // IFD GPS présent et non vide
+	}
+	return false
+}
+
+// ── Métadonnées de sortie ─────────────────────────────────────────────────────
+
+const jpegSegmentMax = 65533 // charge utile max d'un segment APPn : 65535 − 2 (longueur)
+
+var xmpJPEGTag = []byte("http://ns.adobe.com/xap/1.0/\x00")
+
+// outMeta regroupe les métadonnées ré-écrites dans l'image encodée — tout est optionnel.
+type outMeta struct {
+	icc  []byte // profil ICC source (keep_icc)
+	exif []byte // bloc TIFF (keep_exif, set_copyright)
+	xmp  []byte // paquet XMP (set_copyright)
+}
+
+func (m outMeta) empty() bool { return m.icc == nil && m.exif == nil && m.xmp == nil }
+
+// embedMetadata insère les métadonnées dans l'image encodée, en place. JPEG : segments APP1 (EXIF,
+// XMP) puis APP2 (ICC) juste après le SOI. WebP : le format simple produit par encodeWebP est
+// converti en format étendu — VP8X, ICCP, bitstream, puis EXIF et XMP (ordre imposé par la spec).
+// PNG : chunks iCCP, eXIf et iTXt juste après IHDR, avant les données image.
+// En cas d'erreur, buf n'est pas modifié.
+func embedMetadata(buf *bytes.Buffer, contentType string, w, h int, m outMeta) error {
+	data := buf.Bytes()
+	var out bytes.Buffer
+	out.Grow(len(data) + len(m.icc) + len(m.exif) + len(m.xmp) + 128)
+	switch contentType {
+	case "image/jpeg":
+		if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+			return fmt.Errorf("JPEG invalide")
+		}
+		out.Write(data[:2])
+		if m.exif != nil {
+			if err := writeJPEGSegment(&out, 0xE1, []byte("Exif\x00\x00"), m.exif); err != nil {
+				return err
+			}
+		}
+		if m.xmp != nil {
+			if err := writeJPEGSegment(&out, 0xE1, xmpJPEGTag, m.xmp); err != nil {
+				return err
+			}
+		}
+		if m.icc != nil { // découpé en segments numérotés : un profil peut dépasser 64 Ko
+			size := jpegSegmentMax - len(iccJPEGTag) - 2
+			total := (len(m.icc) + size - 1) / size
+			if total > 255 {
+				return fmt.Errorf("profil ICC trop grand (%s)", formatBytes(len(m.icc)))
+			}
+			for i := range total {
+				chunk := m.icc[i*size : min((i+1)*size, len(m.icc))]
+				writeJPEGSegment(&out, 0xE2, append(iccJPEGTag[:len(iccJPEGTag):len(iccJPEGTag)], byte(i+1), byte(total)), chunk) //nolint:errcheck — taille déjà bornée
+			}
+		}
+		out.Write(data[2:])
+	case "image/webp":
+		if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+			return fmt.Errorf("WebP invalide")
+		}
+		var flags byte
+		var chunks bytes.Buffer // chunks après VP8X, dans l'ordre de la spec
+		if m.icc != nil {
+			flags |= 0x20
+			writeRIFFChunk(&chunks, "ICCP", m.icc)
+		}
+		chunks.Write(data[12:]) // chunk VP8 déjà formé
+		if m.exif != nil {
+			flags |= 0x08
+			writeRIFFChunk(&chunks, "EXIF", m.exif)
+		}
+		if m.xmp != nil {
+			flags |= 0x04
+			writeRIFFChunk(&chunks, "XMP ", m.xmp)
+		}
+		out.WriteString("RIFF")
+		binary.Write(&out, binary.LittleEndian, uint32(4+8+10+chunks.Len())) //nolint:errcheck — bytes.Buffer
+		out.WriteString("WEBP")
+		writeRIFFChunk(&out, "VP8X", []byte{flags, 0, 0, 0,
+			byte(w - 1), byte((w - 1) >> 8), byte((w - 1) >> 16), // largeur et hauteur du canvas − 1, sur 24 bits
+			byte(h - 1), byte((h - 1) >> 8), byte((h - 1) >> 16)})
+		out.Write(chunks.Bytes())
+	case "image/png":
+		const ihdrEnd = 8 + 8 + 13 + 4 // signature, puis IHDR : longueur + type, 13 octets, CRC
+		if len(data) < ihdrEnd || string(data[12:16]) != "IHDR" {
+			return fmt.Errorf("PNG invalide")
+		}
+		out.Write(data[:ihdrEnd])
+		if m.icc != nil { // nom du profil, méthode 0 (zlib), profil compressé
+			var z bytes.Buffer
+			zw := zlib.NewWriter(&z)
+			zw.Write(m.icc) //nolint:errcheck — bytes.Buffer
+			zw.Close()      //nolint:errcheck
+			writePNGChunk(&out, "iCCP", append([]byte("ICC profile\x00\x00"), z.Bytes()...))
+		}
+		if m.exif != nil {
+			writePNGChunk(&out, "eXIf", m.exif)
+		}
+		if m.xmp != nil { // iTXt non compressé, sans langue ni traduction du mot-clé
+			writePNGChunk(&out, "iTXt", append([]byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00"), m.xmp...))
+		}
+		out.Write(data[ihdrEnd:])
+	default:
+		return fmt.Errorf("métadonnées non supportées en %s", contentType)
+	}
+	buf.Reset()
+	buf.Write(out.Bytes())
+	return nil
+}
+
+// writeJPEGSegment écrit un segment APPn : marqueur, longueur big-endian, en-tête puis données.
+func writeJPEGSegment(out *bytes.Buffer, marker byte, header, payload []byte) error {
+	size := 2 + len(header) + len(payload)
+	if size > jpegSegmentMax+2 {
+		return fmt.Errorf("segment JPEG trop grand (%s)", formatBytes(size))
+	}
+	out.Write([]byte{0xFF, marker})
+	binary.Write(out, binary.BigEndian, uint16(size)) //nolint:errcheck — bytes.Buffer
+	out.Write(header)
+	out.Write(payload)
+	return nil
+}
+
+// writePNGChunk écrit un chunk PNG : longueur big-endian, type, données, CRC32 du type et des données.
+func writePNGChunk(out *bytes.Buffer, typ string, payload []byte) {
+	binary.Write(out, binary.BigEndian, uint32(len(payload))) //nolint:errcheck — bytes.Buffer
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(typ))
+	crc.Write(payload)
+	out.WriteString(typ)
+	out.Write(payload)
+	binary.Write(out, binary.BigEndian, crc.Sum32()) //nolint:errcheck
+}
+
+// writeRIFFChunk écrit un chunk RIFF : fourcc, taille little-endian, données alignées sur 2 octets.
+func writeRIFFChunk(out *bytes.Buffer, fourcc string, payload []byte) {
+	out.WriteString(fourcc)
+	binary.Write(out, binary.LittleEndian, uint32(len(payload))) //nolint:errcheck — bytes.Buffer
+	out.Write(payload)
+	if len(payload)&1 == 1 {
+		out.WriteByte(0)
+	}
+}
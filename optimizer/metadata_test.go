@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// testTIFF : bloc EXIF dont l'IFD0 ne contient que le tag GPSInfo, vers un IFD GPS de gpsTags entrées.
+func testTIFF(order binary.AppendByteOrder, gpsTags int) []byte {
+	b := []byte("II*\x00")
+	if order == binary.AppendByteOrder(binary.BigEndian) {
+		b = []byte("MM\x00*")
+	}
+	b = order.AppendUint32(b, 8)
+	b = order.AppendUint16(b, 1)
+	b = order.AppendUint16(b, 0x8825)
+	b = order.AppendUint16(b, 4) // LONG
+	b = order.AppendUint32(b, 1)
+	b = order.AppendUint32(b, 26) // 8 + 2 + 12 + 4 (IFD suivant)
+	b = order.AppendUint32(b, 0)
+	b = order.AppendUint16(b, uint16(gpsTags))
+	for i := range gpsTags {
+		b = order.AppendUint16(b, uint16(i+1))
+		b = append(b, make([]byte, 10)...)
+	}
+	return order.AppendUint32(b, 0)
+}
+
+func jpegSegment(marker byte, payload []byte) []byte {
+	return append([]byte{0xFF, marker, byte((len(payload) + 2) >> 8), byte(len(payload) + 2)}, payload...)
+}
+
+func pngChunk(typ string, payload []byte) []byte {
+	var b bytes.Buffer
+	writePNGChunk(&b, typ, payload)
+	return b.Bytes()
+}
+
+func webpFile(chunks ...[]byte) []byte {
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+	for _, c := range chunks {
+		body.Write(c)
+	}
+	return append(binary.LittleEndian.AppendUint32([]byte("RIFF"), uint32(body.Len())), body.Bytes()...)
+}
+
+func riffChunk(fourcc string, payload []byte) []byte {
+	var b bytes.Buffer
+	writeRIFFChunk(&b, fourcc, payload)
+	return b.Bytes()
+}
+
+func concat(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+
+func TestReadExif(t *testing.T) {
+	tiff := testTIFF(binary.LittleEndian, 2)
+	exif := append([]byte("Exif\x00\x00"), tiff...)
+	soi := []byte{0xFF, 0xD8}
+	pngSig := []byte("\x89PNG\r\n\x1a\n")
+	ihdr := pngChunk("IHDR", make([]byte, 13))
+
+	for _, tc := range []struct {
+		name string
+		data []byte
+	}{
+		{"jpeg", concat(soi, jpegSegment(0xE0, []byte("JFIF\x00\x01\x01")), jpegSegment(0xE1, []byte("http://ns.adobe.com/xap/1.0/\x00<x/>")), jpegSegment(0xE1, exif), jpegSegment(0xDA, nil))},
+		{"png", concat(pngSig, ihdr, pngChunk("eXIf", tiff), pngChunk("IDAT", []byte{1}))},
+		{"webp", webpFile(riffChunk("VP8X", make([]byte, 10)), riffChunk("VP8L", []byte{1, 2, 3}), riffChunk("EXIF", tiff))},
+		{"webp préfixé", webpFile(riffChunk("VP8L", []byte{1}), riffChunk("EXIF", exif))},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := readExif(bytes.NewReader(tc.data))
+			if !bytes.Equal(got, tiff) {
+				t.Fatalf("EXIF %x, attendu %x", got, tiff)
+			}
+			if !exifHasGPS(got) {
+				t.Error("GPS non détecté")
+			}
+		})
+	}
+}
+
+// Entrées mal formées : nil, jamais de panique ni de lecture démesurée.
+func TestReadExifMalformed(t *testing.T) {
+	tiff := testTIFF(binary.BigEndian, 1)
+	soi := []byte{0xFF, 0xD8}
+	pngSig := []byte("\x89PNG\r\n\x1a\n")
+	huge := binary.BigEndian.AppendUint32(nil, 1<<31)
+
+	for _, tc := range []struct {
+		name string
+		data []byte
+	}{
+		{"vide", nil},
+		{"inconnu", []byte("GIF89a\x01\x00\x01\x00\x00\x00")},
+		{"jpeg sans exif", concat(soi, jpegSegment(0xE0, []byte("JFIF")), []byte{0xFF, 0xD9})},
+		{"jpeg exif après SOS", concat(soi, jpegSegment(0xDA, nil), jpegSegment(0xE1, append([]byte("Exif\x00\x00"), tiff...)))},
+		{"jpeg segment tronqué", concat(soi, jpegSegment(0xE1, append([]byte("Exif\x00\x00"), tiff...)))[:20]},
+		{"jpeg longueur < 2", concat(soi, []byte{0xFF, 0xE1, 0, 1}, []byte("Exif\x00\x00"))},
+		{"jpeg sans marqueur", concat(soi, []byte{0x00, 0xE1, 0, 8}, []byte("Exif\x00\x00"))},
+		{"png exif après IDAT", concat(pngSig, pngChunk("IDAT", []byte{1}), pngChunk("eXIf", tiff))},
+		{"png chunk démesuré", concat(pngSig, huge, []byte("eXIf"), tiff)},
+		{"png chunk tronqué", concat(pngSig, pngChunk("eXIf", tiff))[:30]},
+		{"webp chunk démesuré", concat([]byte("RIFF\x00\x00\x00\x00WEBPEXIF"), binary.LittleEndian.AppendUint32(nil, 1<<31), tiff)},
+		{"webp taille de chunk qui saute la fin", concat([]byte("RIFF\x00\x00\x00\x00WEBPVP8L\xff\xff\xff\xff"), riffChunk("EXIF", tiff))},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := readExif(bytes.NewReader(tc.data)); got != nil {
+				t.Errorf("EXIF %x, attendu nil", got)
+			}
+		})
+	}
+}
+
+func TestExifHasGPS(t *testing.T) {
+	withGPS := testTIFF(binary.BigEndian, 1)
+	outOfRange := append([]byte(nil), withGPS...)
+	binary.BigEndian.PutUint32(outOfRange[18:], 0xFFFFFFF0) // offset de l'IFD GPS
+	badIFD0 := append([]byte(nil), withGPS...)
+	binary.BigEndian.PutUint32(badIFD0[4:], 0xFFFFFFFF)
+	manyEntries := append([]byte(nil), withGPS...)
+	binary.BigEndian.PutUint16(manyEntries[8:], 0xFFFF)
+
+	for _, tc := range []struct {
+		name string
+		tiff []byte
+		want bool
+	}{
+		{"big-endian", withGPS, true},
+		{"little-endian", testTIFF(binary.LittleEndian, 3), true},
+		{"IFD GPS vide", testTIFF(binary.LittleEndian, 0), false},
+		{"IFD GPS hors du bloc", outOfRange, false},
+		{"IFD0 hors du bloc", badIFD0, false},
+		{"IFD0 tronqué", manyEntries[:20], false},
+		{"ordre inconnu", append([]byte("XX"), withGPS[2:]...), false},
+		{"trop court", withGPS[:7], false},
+		{"nil", nil, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := exifHasGPS(tc.tiff); got != tc.want {
+				t.Errorf("exifHasGPS = %v, attendu %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// FuzzReadExif : go test -fuzz=FuzzReadExif. Le fichier est aussi lu comme un bloc TIFF brut
+// pour exercer exifHasGPS directement.
+func FuzzReadExif(f *testing.F) {
+	tiff := testTIFF(binary.LittleEndian, 2)
+	f.Add(concat([]byte{0xFF, 0xD8}, jpegSegment(0xE1, append([]byte("Exif\x00\x00"), tiff...))))
+	f.Add(concat([]byte("\x89PNG\r\n\x1a\n"), pngChunk("eXIf", tiff)))
+	f.Add(webpFile(riffChunk("VP8L", []byte{1}), riffChunk("EXIF", tiff)))
+	f.Add(testTIFF(binary.BigEndian, 1))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if exif := readExif(bytes.NewReader(data)); len(exif) > maxExifSize {
+			t.Fatalf("bloc EXIF de %d octets", len(exif))
+		} else {
+			exifHasGPS(exif)
+		}
+		exifHasGPS(data)
+	})
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// ── Métriques Prometheus ──────────────────────────────────────────────────────
+// GET /metrics expose, en plus des métriques Go et processus :
+//   - watermark_optimizer_requests_total{route,method,status}, ..._request_duration_seconds et
+//     ..._bytes_total{route,direction} — les appels gRPC sous la route "grpc:Optimize" ;
+//   - ..._stage_duration_seconds{stage} : decode, resize, watermark, encode — les durées des logs
+//     "step", agrégées (resize seulement quand l'image est réellement réduite) ;
+//   - ..._workers_busy, ..._workers_total et ..._workers_waiting : occupation du pool (saturation =
+//     busy / total) et requêtes en file devant lui ;
+//   - ..._font_cache_lookups_total{result} : hits et misses du cache des polices envoyées (wm_font_file).
+
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "watermark", Subsystem: "optimizer", Name: "requests_total",
+		Help: "Requêtes par route, méthode et statut.",
+	}, []string{"route", "method", "status"})
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "watermark", Subsystem: "optimizer", Name: "request_duration_seconds",
+		Help:    "Durée des requêtes par route et méthode, attente du pool comprise.",
+		Buckets: []float64{.01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 300},
+	}, []string{"route", "method"})
+	bytesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "watermark", Subsystem: "optimizer", Name: "bytes_total",
+		Help: "Octets reçus (in) et envoyés (out) par route.",
+	}, []string{"route", "direction"})
+	stageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "watermark", Subsystem: "optimizer", Name: "stage_duration_seconds",
+		Help:    "Durée des étapes du pipeline : decode, resize, watermark, encode.",
+		Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"stage"})
+	poolWaiting = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "watermark", Subsystem: "optimizer", Name: "workers_waiting",
+		Help: "Requêtes en attente d'un slot du pool de workers.",
+	})
+	fontCacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "watermark", Subsystem: "optimizer", Name: "font_cache_lookups_total",
+		Help: "Recherches dans le cache des polices envoyées : hit, miss.",
+	}, []string{"result"})
+
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "watermark", Subsystem: "optimizer", Name: "workers_busy",
+		Help: "Slots du pool de workers occupés.",
+	}, func() float64 { return float64(len(sem)) })
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "watermark", Subsystem: "optimizer", Name: "workers_total",
+		Help: "Taille du pool de workers (un slot par coeur).",
+	}, func() float64 { return float64(cap(sem)) })
+)
+
+// observeStage ajoute la durée depuis t à l'étape stage.
+func observeStage(stage string, t time.Time) {
+	stageDuration.WithLabelValues(stage).Observe(time.Since(t).Seconds())
+}
+
+// observeRequest compte une requête terminée.
+func observeRequest(route, method string, status int, d time.Duration, in, out int64) {
+	requestsTotal.WithLabelValues(route, method, strconv.Itoa(status)).Inc()
+	requestDuration.WithLabelValues(route, method).Observe(d.Seconds())
+	bytesTotal.WithLabelValues(route, "in").Add(float64(in))
+	bytesTotal.WithLabelValues(route, "out").Add(float64(out))
+}
+
+// metricsMiddleware compte les requêtes servies par mux, sous le motif qui les a servies
+// (r.Pattern, renseigné par le mux sur la requête même) — "unmatched" pour un 404 ou un 405.
+func metricsMiddleware(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		body := &countingReader{r: r.Body}
+		r.Body = body
+		mw := &metricsWriter{ResponseWriter: w, status: http.StatusOK}
+		mux.ServeHTTP(mw, r)
+
+		route := "unmatched"
+		if r.Pattern != "" {
+			_, route, _ = strings.Cut(r.Pattern, " ") // "POST /optimize" → "/optimize"
+		}
+		observeRequest(route, r.Method, mw.status, time.Since(start), body.n, mw.n)
+	})
+}
+
+// countingReader compte les octets lus dans le body de la requête.
+type countingReader struct {
+	r io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReader) Close() error { return c.r.Close() }
+
+// metricsWriter retient le statut et compte les octets de la réponse.
+type metricsWriter struct {
+	http.ResponseWriter
+	status int
+	n      int64
+}
+
+func (m *metricsWriter) WriteHeader(status int) {
+	m.status = status
+	m.ResponseWriter.WriteHeader(status)
+}
+
+func (m *metricsWriter) Write(p []byte) (int, error) {
+	n, err := m.ResponseWriter.Write(p)
+	m.n += int64(n)
+	return n, err
+}
+
+// Unwrap expose le ResponseWriter d'origine à http.ResponseController (Flush, délais).
+func (m *metricsWriter) Unwrap() http.ResponseWriter { return m.ResponseWriter }
@@ -0,0 +1,136 @@
+// Interface gRPC de l'optimizer, servie à côté de l'HTTP — même pipeline que POST /optimize.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: optimizer.proto
+
+// Régénérer les deux copies (chaque service est un module Go autonome) depuis la racine du dépôt :
+//
+//   protoc -I optimizer/optimizerpb \
+//     --go_out=optimizer/optimizerpb --go_opt=paths=source_relative \
+//     --go-grpc_out=optimizer/optimizerpb --go-grpc_opt=paths=source_relative \
+//     optimizer.proto
+//   protoc -I optimizer/optimizerpb \
+//     --go_out=api/optimizerpb --go_opt=paths=source_relative,Moptimizer.proto=api/optimizerpb \
+//     --go-grpc_out=api/optimizerpb --go-grpc_opt=paths=source_relative,Moptimizer.proto=api/optimizerpb \
+//     optimizer.proto
+
+package optimizerpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Optimizer_Optimize_FullMethodName = "/watermark.optimizer.v1.Optimizer/Optimize"
+)
+
+// OptimizerClient is the client API for Optimizer service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type OptimizerClient interface {
+	// Optimize reçoit une image par morceaux et renvoie le résultat par morceaux. Le premier
+	// message de chaque sens porte l'en-tête (filename, fields, files), les suivants les données.
+	// Une image refusée termine l'appel en erreur, avec le code HTTP qu'aurait renvoyé
+	// /optimize dans le trailer "http-status".
+	Optimize(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[ImageChunk, ImageChunk], error)
+}
+
+type optimizerClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewOptimizerClient(cc grpc.ClientConnInterface) OptimizerClient {
+	return &optimizerClient{cc}
+}
+
+func (c *optimizerClient) Optimize(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[ImageChunk, ImageChunk], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Optimizer_ServiceDesc.Streams[0], Optimizer_Optimize_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ImageChunk, ImageChunk]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Optimizer_OptimizeClient = grpc.BidiStreamingClient[ImageChunk, ImageChunk]
+
+// OptimizerServer is the server API for Optimizer service.
+// All implementations must embed UnimplementedOptimizerServer
+// for forward compatibility.
+type OptimizerServer interface {
+	// Optimize reçoit une image par morceaux et renvoie le résultat par morceaux. Le premier
+	// message de chaque sens porte l'en-tête (filename, fields, files), les suivants les données.
+	// Une image refusée termine l'appel en erreur, avec le code HTTP qu'aurait renvoyé
+	// /optimize dans le trailer "http-status".
+	Optimize(grpc.BidiStreamingServer[ImageChunk, ImageChunk]) error
+	mustEmbedUnimplementedOptimizerServer()
+}
+
+// UnimplementedOptimizerServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedOptimizerServer struct{}
+
+func (UnimplementedOptimizerServer) Optimize(grpc.BidiStreamingServer[ImageChunk, ImageChunk]) error {
+	return status.Error(codes.Unimplemented, "method Optimize not implemented")
+}
+func (UnimplementedOptimizerServer) mustEmbedUnimplementedOptimizerServer() {}
+func (UnimplementedOptimizerServer) testEmbeddedByValue()                   {}
+
+// UnsafeOptimizerServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to OptimizerServer will
+// result in compilation errors.
+type UnsafeOptimizerServer interface {
+	mustEmbedUnimplementedOptimizerServer()
+}
+
+func RegisterOptimizerServer(s grpc.ServiceRegistrar, srv OptimizerServer) {
+	// If the following call panics, it indicates UnimplementedOptimizerServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Optimizer_ServiceDesc, srv)
+}
+
+func _Optimizer_Optimize_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(OptimizerServer).Optimize(&grpc.GenericServerStream[ImageChunk, ImageChunk]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Optimizer_OptimizeServer = grpc.BidiStreamingServer[ImageChunk, ImageChunk]
+
+// Optimizer_ServiceDesc is the grpc.ServiceDesc for Optimizer service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Optimizer_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "watermark.optimizer.v1.Optimizer",
+	HandlerType: (*OptimizerServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Optimize",
+			Handler:       _Optimizer_Optimize_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "optimizer.proto",
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"net/http"
+	"strconv"
+)
+
+// ── Rotation et miroir ────────────────────────────────────────────────────────
+// rotate=90|180|270 (sens horaire) et flip=h|v corrigent l'orientation juste après le décodage,
+// avant recadrage et resize : le reste du pipeline voit l'image déjà redressée.
+// Le miroir est appliqué après la rotation.
+
+// orientation est la transformation demandée — zéro : image laissée telle quelle.
+type orientation struct {
+	rotate int    // 0, 90, 180 ou 270
+	flip   string // "", "h" (gauche-droite) ou "v" (haut-bas)
+}
+
+// orientParams lit rotate et flip.
+func orientParams(r *http.Request) (o orientation, err error) {
+	if v := r.FormValue("rotate"); v != "" {
+		o.rotate, err = strconv.Atoi(v)
+		if err != nil || (o.rotate != 0 && o.rotate != 90 && o.rotate != 180 && o.rotate != 270) {
+			return orientation{}, fmt.Errorf("rotate invalide : %q (90, 180 ou 270)", v)
+		}
+	}
+	switch o.flip = r.FormValue("flip"); o.flip {
+	case "", "h", "v":
+	default:
+		return orientation{}, fmt.Errorf("flip invalide : %q (h ou v)", o.flip)
+	}
+	return o, nil
+}
+
+// apply retourne img tournée puis retournée. L'image est d'abord copiée en RGBA : les
+// transformations se font ensuite par copie de pixels de 4 octets, quel que soit le format source.
+func (o orientation) apply(img image.Image) image.Image {
+	if o.rotate == 0 && o.flip == "" {
+		return img
+	}
+	b := img.Bounds()
+	src := newCanvas(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	w, h := b.Dx(), b.Dy()
+
+	dw, dh := w, h
+	if o.rotate == 90 || o.rotate == 270 {
+		dw, dh = h, w // quart de tour : largeur et hauteur s'échangent
+	}
+	dst := newCanvas(image.Rect(0, 0, dw, dh))
+	for y := range h {
+		for x := range w {
+			dx, dy := x, y
+			switch o.rotate { // destination du pixel (x, y) — rotation dans le sens horaire
+			case 90:
+				dx, dy = h-1-y, x
+			case 180:
+				dx, dy = w-1-x, h-1-y
+			case 270:
+				dx, dy = y, w-1-x
+			}
+			switch o.flip {
+			case "h":
+				dx = dw - 1 - dx
+			case "v":
+				dy = dh - 1 - dy
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):][:4], src.Pix[src.PixOffset(x, y):][:4])
+		}
+	}
+	releaseCanvas(src)
+	return dst
+}
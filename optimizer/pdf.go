@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"maps"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ── Watermark PDF ─────────────────────────────────────────────────────────────
+// POST /optimize-pdf pose le watermark sur chaque page d'un PDF (contrats, épreuves, devis) avec
+// les mêmes champs wm_* que /optimize : texte, police, position, opacité, angle, plaque, logo, QR,
+// wm_layers. Le calque est dessiné par le pipeline des photos sur un canvas transparent à la taille
+// de la page (pdfDPI), recadré sur les pixels visibles, puis ajouté à la page comme image avec
+// masque alpha — le texte du document reste du texte, sélectionnable et net à tout zoom.
+// Le calque est inscrit dans le contenu de la page (pas une annotation) : il s'imprime et ne se
+// supprime pas d'un clic. Un calque est rendu par format de page, partagé par toutes les pages
+// de ce format. Les PDF chiffrés sont refusés ; le fichier d'origine reste intact en tête du
+// résultat (mise à jour incrémentale, cf. pdfobj.go).
+// Sans wm_color, le texte est gris foncé : la page n'est pas rendue, une page de document est
+// présumée claire.
+
+const (
+	pdfDPI            = 150      // résolution du calque — wm_size et wm_x/wm_y sont en px à cette résolution
+	maxPDFBytes       = 64 << 20 // au-delà : 413
+	maxPDFPages       = 2000
+	maxPDFOverlaySide = 4000 // côté max du canvas du calque — une affiche A0 à 150 dpi dépasserait 7000 px
+)
+
+var defaultPDFBox = [4]float64{0, 0, 612, 792} // US Letter en points, quand ni MediaBox ni CropBox ne sont lisibles
+
+var pdfTextColor = color.NRGBA{R: 0x40, G: 0x40, B: 0x40} // gris foncé lisible sur une page blanche
+
+func handlePDF(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	sem <- struct{}{} // même worker pool que /optimize — le rendu du calque décode logos et polices
+	defer func() { <-sem }()
+
+	file, _, err := r.FormFile("pdf")
+	if err != nil {
+		http.Error(w, "pdf manquant", http.StatusBadRequest)
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(file, maxPDFBytes+1))
+	file.Close()
+	if err != nil {
+		http.Error(w, "lecture échouée", http.StatusBadRequest)
+		return
+	}
+	if len(data) > maxPDFBytes {
+		http.Error(w, fmt.Sprintf("PDF trop volumineux (max %s)", formatBytes(maxPDFBytes)), http.StatusRequestEntityTooLarge)
+		return
+	}
+	doc, err := parsePDF(data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pages, err := doc.pages()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	u := newPDFUpdate(doc)
+	overlays := map[[2]int]*pdfOverlay{} // par taille de canvas
+	var (
+		qRef   pdfRef // « q » partagé : isole l'état graphique du contenu d'origine
+		marked int
+	)
+	for _, p := range pages {
+		dw, dh := p.box[2]-p.box[0], p.box[3]-p.box[1]
+		if p.rotate%180 != 0 { // page affichée en paysage : le calque suit l'affichage
+			dw, dh = dh, dw
+		}
+		scale := min(pdfDPI/72.0, maxPDFOverlaySide/max(dw, dh))
+		key := [2]int{max(int(dw*scale+0.5), 1), max(int(dh*scale+0.5), 1)}
+		ov, ok := overlays[key]
+		if !ok {
+			if ov, err = renderPDFOverlay(r, u, key[0], key[1], scale); err != nil {
+				if errors.Is(err, errRender) {
+					http.Error(w, "Erreur watermark", http.StatusInternalServerError)
+				} else {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+				}
+				return
+			}
+			overlays[key] = ov
+		}
+		if ov == nil { // calque entièrement transparent (wm_opacity=0)
+			continue
+		}
+		if qRef.num == 0 {
+			qRef = u.putStream(pdfDict{}, []byte("q\n"))
+		}
+		if err := markPDFPage(doc, u, p, ov, qRef); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		marked++
+	}
+
+	var out bytes.Buffer
+	if err := u.finish(&out); err != nil {
+		http.Error(w, "Erreur écriture PDF", http.StatusInternalServerError)
+		return
+	}
+	logger.Info().Str("step", "pdf").Int("pages", len(pages)).Int("marked", marked).Int("overlays", len(overlays)).
+		Bool("xref_stream", doc.xrefIsStm).Str("size", formatBytes(out.Len())).Dur("duration", time.Since(start)).Msg("PDF watermarké")
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("X-Image-Pages", strconv.Itoa(marked))
+	w.Write(out.Bytes()) //nolint:errcheck — erreur réseau côté client, pas récupérable
+}
+
+// pdfOverlay est un calque rendu et écrit dans la mise à jour : image XObject et sa position,
+// en points, dans l'espace d'affichage de la page (origine en bas à gauche, rotation appliquée).
+type pdfOverlay struct {
+	image      pdfRef
+	x, y, w, h float64
+}
+
+// renderPDFOverlay dessine les calques wm_* sur un canvas transparent cw×ch (cf. renderOverlay)
+// et écrit la zone visible comme image RGB + SMask. Retourne nil si rien n'est visible.
+func renderPDFOverlay(r *http.Request, u *pdfUpdate, cw, ch int, scale float64) (*pdfOverlay, error) {
+	m, err := renderOverlay(r, cw, ch, pdfTextColor, "un PDF")
+	if err != nil {
+		return nil, err
+	}
+	defer releaseCanvas(m)
+
+	box := alphaBounds(m)
+	if box.Empty() {
+		return nil, nil
+	}
+	rgb := make([]byte, 0, 3*box.Dx()*box.Dy())
+	alpha := make([]byte, 0, box.Dx()*box.Dy())
+	for y := box.Min.Y; y < box.Max.Y; y++ {
+		row := m.Pix[m.PixOffset(box.Min.X, y):m.PixOffset(box.Max.X, y)]
+		for i := 0; i < len(row); i += 4 {
+			r, g, b, a := unpremultiply(row[i : i+4])
+			rgb = append(rgb, r, g, b)
+			alpha = append(alpha, a)
+		}
+	}
+	img := pdfDict{
+		"Type": pdfName("XObject"), "Subtype": pdfName("Image"),
+		"Width": box.Dx(), "Height": box.Dy(), "BitsPerComponent": 8,
+	}
+	smask := maps.Clone(img)
+	smask["ColorSpace"] = pdfName("DeviceGray")
+	img["ColorSpace"] = pdfName("DeviceRGB")
+	img["SMask"] = u.putStream(smask, alpha)
+	return &pdfOverlay{
+		image: u.putStream(img, rgb),
+		x:     float64(box.Min.X) / scale,
+		y:     float64(ch-box.Max.Y) / scale, // canvas : y vers le bas — PDF : y vers le haut
+		w:     float64(box.Dx()) / scale,
+		h:     float64(box.Dy()) / scale,
+	}, nil
+}
+
+// alphaBounds retourne le plus petit rectangle contenant tous les pixels non transparents.
+func alphaBounds(m *image.RGBA) image.Rectangle {
+	var box image.Rectangle
+	b := m.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := m.Pix[m.PixOffset(b.Min.X, y):m.PixOffset(b.Max.X, y)]
+		for i := 3; i < len(row); i += 4 {
+			if row[i] != 0 {
+				x := b.Min.X + i/4
+				box = box.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+	return box
+}
+
+// markPDFPage réécrit la page p : contenu d'origine encadré par q…Q, puis le calque, et l'image
+// ajoutée à ses ressources sous un nom libre.
+func markPDFPage(doc *pdfDoc, u *pdfUpdate, p pdfPage, ov *pdfOverlay, qRef pdfRef) error {
+	page := maps.Clone(p.dict)
+
+	res, err := doc.resolve(p.resources)
+	if err != nil {
+		return err
+	}
+	resources := pdfDict{}
+	if d, ok := res.(pdfDict); ok {
+		resources = maps.Clone(d)
+	}
+	xobj, err := doc.resolve(resources["XObject"])
+	if err != nil {
+		return err
+	}
+	xobjects := pdfDict{}
+	if d, ok := xobj.(pdfDict); ok {
+		xobjects = maps.Clone(d)
+	}
+	name := pdfName("Wm")
+	for i := 1; xobjects[name] != nil; i++ {
+		name = pdfName("Wm" + strconv.Itoa(i))
+	}
+	xobjects[name] = ov.image
+	resources["XObject"] = xobjects
+	page["Resources"] = resources // ressources héritées recopiées sur la page : l'arbre n'est pas touché
+
+	// Espace d'affichage → espace de la page : rotation /Rotate (sens horaire) puis origine de la boîte.
+	W, H := p.box[2]-p.box[0], p.box[3]-p.box[1]
+	rot := [6]float64{1, 0, 0, 1, 0, 0}
+	switch p.rotate {
+	case 90:
+		rot = [6]float64{0, 1, -1, 0, W, 0}
+	case 180:
+		rot = [6]float64{-1, 0, 0, -1, W, H}
+	case 270:
+		rot = [6]float64{0, -1, 1, 0, 0, H}
+	}
+	rot[4] += p.box[0]
+	rot[5] += p.box[1]
+	var content strings.Builder
+	content.WriteString("Q\nq ")
+	for _, v := range rot {
+		content.WriteString(pdfFloatString(v) + " ")
+	}
+	fmt.Fprintf(&content, "cm %s 0 0 %s %s %s cm ", pdfFloatString(ov.w), pdfFloatString(ov.h), pdfFloatString(ov.x), pdfFloatString(ov.y))
+	var nameBuf bytes.Buffer
+	writePDFObject(&nameBuf, name)
+	fmt.Fprintf(&content, "%s Do Q\n", nameBuf.String())
+
+	contents := pdfArray{qRef}
+	orig, err := doc.resolve(page["Contents"])
+	if err != nil {
+		return err
+	}
+	if a, ok := orig.(pdfArray); ok {
+		contents = append(contents, a...)
+	} else if page["Contents"] != nil {
+		contents = append(contents, page["Contents"])
+	}
+	page["Contents"] = append(contents, u.putStream(pdfDict{}, []byte(content.String())))
+	u.put(p.ref, page, nil)
+	return nil
+}
+
+// pdfFloatString écrit un réel sans exposant ni zéros inutiles.
+func pdfFloatString(v float64) string {
+	s := strconv.FormatFloat(v, 'f', 4, 64)
+	s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	if s == "-0" || s == "" {
+		return "0"
+	}
+	return s
+}
+
+// ── Arbre des pages ──
+
+// pdfPage est une page feuille, avec ses attributs hérités de l'arbre résolus.
+type pdfPage struct {
+	ref       pdfRef
+	dict      pdfDict
+	resources any        // dictionnaire ou référence — hérité
+	box       [4]float64 // CropBox, ou MediaBox à défaut — normalisée (x0 < x1, y0 < y1)
+	rotate    int        // 0, 90, 180 ou 270
+}
+
+// pages parcourt l'arbre des pages dans l'ordre du document.
+func (doc *pdfDoc) pages() ([]pdfPage, error) {
+	root, err := doc.resolve(doc.trailer["Root"])
+	if err != nil {
+		return nil, err
+	}
+	catalog, ok := root.(pdfDict)
+	if !ok {
+		return nil, errPDFSyntax
+	}
+	type inherited struct {
+		resources   any
+		media, crop any
+		rotate      any
+	}
+	var pages []pdfPage
+	seen := map[int]bool{}
+	var walk func(node any, inh inherited, depth int) error
+	walk = func(node any, inh inherited, depth int) error {
+		ref, ok := node.(pdfRef)
+		if !ok || seen[ref.num] || depth > 64 { // nœud direct, cycle ou arbre absurde : ignoré
+			return nil
+		}
+		seen[ref.num] = true
+		obj, err := doc.resolve(ref)
+		if err != nil {
+			return err
+		}
+		d, ok := obj.(pdfDict)
+		if !ok {
+			return nil
+		}
+		for k, dst := range map[pdfName]*any{"Resources": &inh.resources, "MediaBox": &inh.media, "CropBox": &inh.crop, "Rotate": &inh.rotate} {
+			if v, ok := d[k]; ok {
+				*dst = v
+			}
+		}
+		if d["Type"] == pdfName("Pages") || (d["Kids"] != nil && d["Type"] != pdfName("Page")) {
+			kids, err := doc.resolve(d["Kids"])
+			if err != nil {
+				return err
+			}
+			a, _ := kids.(pdfArray)
+			for _, kid := range a {
+				if err := walk(kid, inh, depth+1); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		if len(pages) == maxPDFPages {
+			return fmt.Errorf("PDF trop long (max %d pages)", maxPDFPages)
+		}
+		p := pdfPage{ref: ref, dict: d, resources: inh.resources, box: defaultPDFBox}
+		if box, ok := doc.pdfBox(inh.media); ok {
+			p.box = box
+		}
+		if box, ok := doc.pdfBox(inh.crop); ok { // la CropBox est la zone affichée — bornée par la MediaBox
+			p.box = [4]float64{max(box[0], p.box[0]), max(box[1], p.box[1]), min(box[2], p.box[2]), min(box[3], p.box[3])}
+		}
+		if rot, _ := doc.resolve(inh.rotate); rot != nil { // multiple de 90, éventuellement négatif
+			p.rotate = (pdfInt(rot)/90*90%360 + 360) % 360
+		}
+		pages = append(pages, p)
+		return nil
+	}
+	if err := walk(catalog["Pages"], inherited{}, 0); err != nil {
+		return nil, err
+	}
+	if len(pages) == 0 {
+		return nil, errPDFSyntax
+	}
+	return pages, nil
+}
+
+// pdfBox lit un rectangle [x0 y0 x1 y1] et le normalise.
+func (doc *pdfDoc) pdfBox(v any) ([4]float64, bool) {
+	var box [4]float64
+	obj, err := doc.resolve(v)
+	a, ok := obj.(pdfArray)
+	if err != nil || !ok || len(a) != 4 {
+		return box, false
+	}
+	for i := range box {
+		n, _ := doc.resolve(a[i])
+		box[i] = pdfFloat(n)
+	}
+	box[0], box[2] = min(box[0], box[2]), max(box[0], box[2])
+	box[1], box[3] = min(box[1], box[3]), max(box[1], box[3])
+	return box, box[2]-box[0] >= 1 && box[3]-box[1] >= 1
+}
@@ -0,0 +1,878 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+)
+
+// ── Objets PDF ────────────────────────────────────────────────────────────────
+// Lecture et réécriture incrémentale d'un PDF, juste ce qu'il faut pour poser un calque sur chaque
+// page (cf. pdf.go) : table xref classique ou en flux (PDF 1.5+), flux d'objets compressés, arbre
+// des pages. Le fichier d'origine n'est jamais réécrit : les objets modifiés sont ajoutés à la fin
+// avec une nouvelle section xref (mise à jour incrémentale) — signatures et structure intactes.
+// Seul FlateDecode est décodé (flux xref et flux d'objets) ; les PDF chiffrés sont refusés.
+
+// Types des objets PDF décodés. Les nombres gardent leur texte d'origine (pdfNum) : réécrits
+// à l'identique, sans arrondi.
+type (
+	pdfName   string
+	pdfNum    string
+	pdfString []byte
+	pdfDict   map[pdfName]any
+	pdfArray  []any
+	pdfRef    struct{ num, gen int }
+	pdfStream struct {
+		dict pdfDict
+		data []byte // données encore encodées (Filter)
+	}
+)
+
+var (
+	errPDFSyntax    = errors.New("PDF illisible")
+	errPDFEncrypted = errors.New("PDF chiffré : retirer la protection avant de le watermarker")
+)
+
+// pdfXref localise un objet : à un offset du fichier, ou à la position index d'un flux d'objets.
+type pdfXref struct {
+	offset int
+	objStm int // numéro du flux d'objets — 0 : objet stocké directement
+	index  int
+	gen    int
+}
+
+type pdfDoc struct {
+	data      []byte
+	xref      map[int]pdfXref
+	trailer   pdfDict
+	startxref int  // offset de la dernière section xref — /Prev de la mise à jour
+	xrefIsStm bool // dernière section en flux : la mise à jour suit la même forme
+	cache     map[int]any
+	objStms   map[int][]any // flux d'objets déjà décodés
+}
+
+// ── Lexer ──
+
+type pdfLexer struct {
+	data []byte
+	pos  int
+}
+
+func isPDFSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\f' || c == 0
+}
+
+func isPDFDelim(c byte) bool {
+	return bytes.IndexByte([]byte("()<>[]{}/%"), c) >= 0
+}
+
+// skip saute les blancs et les commentaires.
+func (l *pdfLexer) skip() {
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		if c == '%' {
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' && l.data[l.pos] != '\r' {
+				l.pos++
+			}
+			continue
+		}
+		if !isPDFSpace(c) {
+			return
+		}
+		l.pos++
+	}
+}
+
+// keyword lit un mot régulier (nombre, mot-clé) sans avancer au-delà.
+func (l *pdfLexer) keyword() string {
+	l.skip()
+	start := l.pos
+	for l.pos < len(l.data) && !isPDFSpace(l.data[l.pos]) && !isPDFDelim(l.data[l.pos]) {
+		l.pos++
+	}
+	return string(l.data[start:l.pos])
+}
+
+// object lit un objet direct, références indirectes « n g R » comprises.
+func (l *pdfLexer) object(depth int) (any, error) {
+	if depth > 64 { // imbrication absurde : fichier piégé
+		return nil, errPDFSyntax
+	}
+	l.skip()
+	if l.pos >= len(l.data) {
+		return nil, errPDFSyntax
+	}
+	switch c := l.data[l.pos]; {
+	case c == '/':
+		l.pos++
+		return l.name(), nil
+	case c == '<' && l.pos+1 < len(l.data) && l.data[l.pos+1] == '<':
+		l.pos += 2
+		d := pdfDict{}
+		for {
+			l.skip()
+			if l.pos+1 < len(l.data) && l.data[l.pos] == '>' && l.data[l.pos+1] == '>' {
+				l.pos += 2
+				return d, nil
+			}
+			if l.pos >= len(l.data) || l.data[l.pos] != '/' {
+				return nil, errPDFSyntax
+			}
+			l.pos++
+			key := l.name()
+			v, err := l.object(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			if v != nil { // une valeur null équivaut à une clé absente
+				d[key] = v
+			}
+		}
+	case c == '<':
+		l.pos++
+		end := bytes.IndexByte(l.data[l.pos:], '>')
+		if end < 0 {
+			return nil, errPDFSyntax
+		}
+		var digits []byte
+		for _, h := range l.data[l.pos : l.pos+end] {
+			if !isPDFSpace(h) {
+				digits = append(digits, h)
+			}
+		}
+		l.pos += end + 1
+		if len(digits)%2 == 1 {
+			digits = append(digits, '0')
+		}
+		s := make(pdfString, len(digits)/2)
+		for i := range s {
+			v, err := strconv.ParseUint(string(digits[2*i:2*i+2]), 16, 8)
+			if err != nil {
+				return nil, errPDFSyntax
+			}
+			s[i] = byte(v)
+		}
+		return s, nil
+	case c == '(':
+		l.pos++
+		return l.literal()
+	case c == '[':
+		l.pos++
+		var a pdfArray
+		for {
+			l.skip()
+			if l.pos < len(l.data) && l.data[l.pos] == ']' {
+				l.pos++
+				return a, nil
+			}
+			v, err := l.object(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, v)
+		}
+	}
+
+	word := l.keyword()
+	switch word {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	case "":
+		return nil, errPDFSyntax
+	}
+	if _, err := strconv.ParseFloat(word, 64); err != nil {
+		return nil, errPDFSyntax
+	}
+	// « n g R » : deux entiers suivis de R — sinon, un simple nombre.
+	save := l.pos
+	if num, err := strconv.Atoi(word); err == nil && num >= 0 {
+		if gen, err := strconv.Atoi(l.keyword()); err == nil && l.keyword() == "R" {
+			return pdfRef{num, gen}, nil
+		}
+	}
+	l.pos = save
+	return pdfNum(word), nil
+}
+
+// name lit un nom après le « / », séquences #xx décodées.
+func (l *pdfLexer) name() pdfName {
+	var b []byte
+	for l.pos < len(l.data) && !isPDFSpace(l.data[l.pos]) && !isPDFDelim(l.data[l.pos]) {
+		c := l.data[l.pos]
+		if c == '#' && l.pos+2 < len(l.data) {
+			if v, err := strconv.ParseUint(string(l.data[l.pos+1:l.pos+3]), 16, 8); err == nil {
+				b = append(b, byte(v))
+				l.pos += 3
+				continue
+			}
+		}
+		b = append(b, c)
+		l.pos++
+	}
+	return pdfName(b)
+}
+
+// literal lit une chaîne (…) : parenthèses imbriquées et échappements.
+func (l *pdfLexer) literal() (pdfString, error) {
+	var s pdfString
+	depth := 1
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			if depth--; depth == 0 {
+				return s, nil
+			}
+		case '\\':
+			if l.pos >= len(l.data) {
+				return nil, errPDFSyntax
+			}
+			e := l.data[l.pos]
+			l.pos++
+			switch e {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r': // fin de ligne échappée : ignorée
+				if l.pos < len(l.data) && l.data[l.pos] == '\n' {
+					l.pos++
+				}
+				continue
+			case '\n':
+				continue
+			default:
+				if e >= '0' && e <= '7' { // octal, jusqu'à 3 chiffres
+					v := int(e - '0')
+					for range 2 {
+						if l.pos < len(l.data) && l.data[l.pos] >= '0' && l.data[l.pos] <= '7' {
+							v = v*8 + int(l.data[l.pos]-'0')
+							l.pos++
+						}
+					}
+					c = byte(v)
+				} else {
+					c = e
+				}
+			}
+		}
+		s = append(s, c)
+	}
+	return nil, errPDFSyntax
+}
+
+// ── Document ──
+
+var (
+	pdfStartXref = []byte("startxref")
+	pdfObjHeader = regexp.MustCompile(`(?m)(?:^|[\r\n\s])(\d+)\s+(\d+)\s+obj\b`)
+)
+
+// parsePDF indexe le document. Une table xref corrompue (offsets décalés par un outil fautif)
+// est reconstruite en parcourant le fichier.
+func parsePDF(data []byte) (*pdfDoc, error) {
+	if !bytes.HasPrefix(data, []byte("%PDF-")) {
+		return nil, errPDFSyntax
+	}
+	doc := &pdfDoc{data: data, xref: map[int]pdfXref{}, cache: map[int]any{}, objStms: map[int][]any{}}
+	i := bytes.LastIndex(data[max(len(data)-4096, 0):], pdfStartXref)
+	if i >= 0 {
+		l := &pdfLexer{data: data, pos: max(len(data)-4096, 0) + i + len(pdfStartXref)}
+		doc.startxref, _ = strconv.Atoi(l.keyword())
+		err := doc.readXref(doc.startxref, 0)
+		if err == nil && doc.trailer["Root"] != nil {
+			if _, err := doc.resolve(doc.trailer["Root"]); err == nil {
+				return doc, doc.checkEncrypt()
+			}
+		}
+	}
+	if err := doc.rebuildXref(); err != nil {
+		return nil, err
+	}
+	return doc, doc.checkEncrypt()
+}
+
+func (doc *pdfDoc) checkEncrypt() error {
+	if doc.trailer["Encrypt"] != nil {
+		return errPDFEncrypted
+	}
+	return nil
+}
+
+// readXref lit la section xref à offset puis, récursivement, les sections précédentes (/Prev).
+// Les entrées déjà connues (plus récentes) ne sont pas écrasées.
+func (doc *pdfDoc) readXref(offset, depth int) error {
+	if offset <= 0 || offset >= len(doc.data) || depth > 32 {
+		return errPDFSyntax
+	}
+	l := &pdfLexer{data: doc.data, pos: offset}
+	var trailer pdfDict
+	if l.keyword() == "xref" {
+		for {
+			word := l.keyword()
+			if word == "trailer" {
+				break
+			}
+			first, err1 := strconv.Atoi(word)
+			count, err2 := strconv.Atoi(l.keyword())
+			if err1 != nil || err2 != nil || count < 0 || count > len(doc.data)/18 {
+				return errPDFSyntax
+			}
+			for n := first; n < first+count; n++ {
+				off, err1 := strconv.Atoi(l.keyword())
+				gen, err2 := strconv.Atoi(l.keyword())
+				kind := l.keyword()
+				if err1 != nil || err2 != nil {
+					return errPDFSyntax
+				}
+				if _, known := doc.xref[n]; !known && kind == "n" {
+					doc.xref[n] = pdfXref{offset: off, gen: gen}
+				} else if !known {
+					doc.xref[n] = pdfXref{} // libre
+				}
+			}
+		}
+		t, err := l.object(0)
+		if trailer, _ = t.(pdfDict); err != nil || trailer == nil {
+			return errPDFSyntax
+		}
+		if depth == 0 {
+			doc.xrefIsStm = false
+		}
+		if stm, ok := trailer["XRefStm"].(pdfNum); ok { // fichier hybride : entrées des objets compressés
+			if off, err := strconv.Atoi(string(stm)); err == nil {
+				doc.readXrefStream(off, 0) //nolint:errcheck — facultatif, la table classique suffit aux lecteurs anciens
+			}
+		}
+	} else {
+		var err error
+		if trailer, err = doc.readXrefStream(offset, depth); err != nil {
+			return err
+		}
+		if depth == 0 {
+			doc.xrefIsStm = true
+		}
+	}
+	if doc.trailer == nil {
+		doc.trailer = trailer
+	}
+	if prev, ok := trailer["Prev"].(pdfNum); ok {
+		if off, err := strconv.Atoi(string(prev)); err == nil && off != offset {
+			return doc.readXref(off, depth+1)
+		}
+	}
+	return nil
+}
+
+// readXrefStream lit un flux /Type /XRef et retourne son dictionnaire (qui tient lieu de trailer).
+func (doc *pdfDoc) readXrefStream(offset, depth int) (pdfDict, error) {
+	_, obj, err := doc.objectAt(offset)
+	if err != nil {
+		return nil, err
+	}
+	stm, ok := obj.(pdfStream)
+	if !ok || stm.dict["Type"] != pdfName("XRef") {
+		return nil, errPDFSyntax
+	}
+	data, err := doc.decodeStream(stm)
+	if err != nil {
+		return nil, err
+	}
+	w, ok := stm.dict["W"].(pdfArray)
+	if !ok || len(w) != 3 {
+		return nil, errPDFSyntax
+	}
+	var widths [3]int
+	for i := range widths {
+		if widths[i] = pdfInt(w[i]); widths[i] < 0 || widths[i] > 8 {
+			return nil, errPDFSyntax
+		}
+	}
+	index := pdfArray{pdfNum("0"), stm.dict["Size"]}
+	if a, ok := stm.dict["Index"].(pdfArray); ok {
+		index = a
+	}
+	row := widths[0] + widths[1] + widths[2]
+	pos := 0
+	field := func(i int) int {
+		v := 0
+		for range widths[i] {
+			v = v<<8 | int(data[pos])
+			pos++
+		}
+		return v
+	}
+	for i := 0; i+1 < len(index); i += 2 {
+		first, count := pdfInt(index[i]), pdfInt(index[i+1])
+		for n := first; n < first+count && row > 0 && pos+row <= len(data); n++ {
+			kind := 1 // type absent (largeur 0) : objet direct
+			if widths[0] > 0 {
+				kind = field(0)
+			}
+			a, b := field(1), field(2)
+			if _, known := doc.xref[n]; known {
+				continue
+			}
+			switch kind {
+			case 1:
+				doc.xref[n] = pdfXref{offset: a, gen: b}
+			case 2:
+				doc.xref[n] = pdfXref{objStm: a, index: b}
+			default:
+				doc.xref[n] = pdfXref{}
+			}
+		}
+	}
+	return stm.dict, nil
+}
+
+// rebuildXref indexe tous les « n g obj » du fichier — le dernier l'emporte, comme dans une
+// mise à jour incrémentale — et prend le trailer le plus récent qui désigne un catalogue.
+func (doc *pdfDoc) rebuildXref() error {
+	doc.xref, doc.cache, doc.trailer = map[int]pdfXref{}, map[int]any{}, nil
+	doc.startxref, doc.xrefIsStm = 0, false
+	for _, m := range pdfObjHeader.FindAllSubmatchIndex(doc.data, -1) {
+		num, _ := strconv.Atoi(string(doc.data[m[2]:m[3]]))
+		gen, _ := strconv.Atoi(string(doc.data[m[4]:m[5]]))
+		doc.xref[num] = pdfXref{offset: m[2], gen: gen}
+	}
+	for _, n := range slices.Sorted(maps.Keys(doc.xref)) {
+		if _, obj, err := doc.objectAt(doc.xref[n].offset); err == nil {
+			if stm, ok := obj.(pdfStream); ok && stm.dict["Type"] == pdfName("XRef") && stm.dict["Root"] != nil {
+				// Flux xref : tient lieu de trailer, et seul à connaître les objets compressés.
+				doc.trailer = stm.dict
+				doc.readXrefStream(doc.xref[n].offset, 0) //nolint:errcheck — les objets directs sont déjà indexés
+			}
+		}
+	}
+	if i := bytes.LastIndex(doc.data, []byte("trailer")); i >= 0 {
+		l := &pdfLexer{data: doc.data, pos: i + len("trailer")}
+		if t, err := l.object(0); err == nil {
+			if d, ok := t.(pdfDict); ok && d["Root"] != nil {
+				doc.trailer = d
+			}
+		}
+	}
+	if doc.trailer == nil {
+		return errPDFSyntax
+	}
+	size := 0
+	for n := range doc.xref {
+		size = max(size, n+1)
+	}
+	doc.trailer["Size"] = pdfNum(strconv.Itoa(size))
+	return nil
+}
+
+// objectAt lit l'objet indirect « n g obj … endobj » à offset.
+func (doc *pdfDoc) objectAt(offset int) (num int, obj any, err error) {
+	if offset < 0 || offset >= len(doc.data) {
+		return 0, nil, errPDFSyntax
+	}
+	l := &pdfLexer{data: doc.data, pos: offset}
+	num, err1 := strconv.Atoi(l.keyword())
+	_, err2 := strconv.Atoi(l.keyword())
+	if err1 != nil || err2 != nil || l.keyword() != "obj" {
+		return 0, nil, errPDFSyntax
+	}
+	if obj, err = l.object(0); err != nil {
+		return 0, nil, err
+	}
+	dict, ok := obj.(pdfDict)
+	if !ok {
+		return num, obj, nil
+	}
+	save := l.pos
+	if l.keyword() != "stream" {
+		l.pos = save
+		return num, obj, nil
+	}
+	// Les données commencent après la fin de ligne qui suit « stream ».
+	if l.pos < len(l.data) && l.data[l.pos] == '\r' {
+		l.pos++
+	}
+	if l.pos < len(l.data) && l.data[l.pos] == '\n' {
+		l.pos++
+	}
+	start := l.pos
+	length := -1
+	switch v := dict["Length"].(type) {
+	case pdfNum:
+		length = pdfInt(v)
+	case pdfRef: // longueur indirecte — jamais un flux d'objets, pas de récursion possible sur elle-même
+		if v.num != num {
+			if n, err := doc.resolve(v); err == nil {
+				length = pdfInt(n)
+			}
+		}
+	}
+	if length < 0 || start+length > len(doc.data) ||
+		!bytes.Contains(doc.data[start+length:min(start+length+32, len(doc.data))], []byte("endstream")) {
+		end := bytes.Index(doc.data[start:], []byte("endstream")) // longueur fausse : repli sur le marqueur
+		if end < 0 {
+			return 0, nil, errPDFSyntax
+		}
+		length = len(bytes.TrimRight(doc.data[start:start+end], "\r\n"))
+	}
+	return num, pdfStream{dict: dict, data: doc.data[start : start+length]}, nil
+}
+
+// object retourne l'objet indirect num — nil s'il est absent ou libre.
+func (doc *pdfDoc) object(num int) (any, error) {
+	if obj, ok := doc.cache[num]; ok {
+		return obj, nil
+	}
+	doc.cache[num] = nil // garde contre les cycles
+	x, ok := doc.xref[num]
+	var obj any
+	var err error
+	switch {
+	case !ok || (x.offset == 0 && x.objStm == 0):
+		return nil, nil
+	case x.objStm != 0:
+		obj, err = doc.fromObjStm(x.objStm, x.index)
+	default:
+		var got int
+		if got, obj, err = doc.objectAt(x.offset); err == nil && got != num {
+			err = errPDFSyntax
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	doc.cache[num] = obj
+	return obj, nil
+}
+
+// fromObjStm retourne le index-ième objet du flux d'objets stm.
+func (doc *pdfDoc) fromObjStm(stm, index int) (any, error) {
+	objs, ok := doc.objStms[stm]
+	if !ok {
+		doc.objStms[stm] = nil // garde contre les cycles
+		raw, err := doc.object(stm)
+		if err != nil {
+			return nil, err
+		}
+		s, ok := raw.(pdfStream)
+		if !ok {
+			return nil, errPDFSyntax
+		}
+		data, err := doc.decodeStream(s)
+		if err != nil {
+			return nil, err
+		}
+		n, first := pdfInt(s.dict["N"]), pdfInt(s.dict["First"])
+		if n < 0 || n > len(data) || first < 0 || first > len(data) {
+			return nil, errPDFSyntax
+		}
+		head := &pdfLexer{data: data[:first]}
+		for range n {
+			head.keyword() // numéro d'objet, déjà connu par la xref
+			off, err := strconv.Atoi(head.keyword())
+			if err != nil || first+off > len(data) {
+				return nil, errPDFSyntax
+			}
+			obj, err := (&pdfLexer{data: data, pos: first + off}).object(0)
+			if err != nil {
+				return nil, err
+			}
+			objs = append(objs, obj)
+		}
+		doc.objStms[stm] = objs
+	}
+	if index < 0 || index >= len(objs) {
+		return nil, errPDFSyntax
+	}
+	return objs[index], nil
+}
+
+// resolve suit une référence indirecte ; tout autre objet est retourné tel quel.
+func (doc *pdfDoc) resolve(v any) (any, error) {
+	for range 8 { // chaîne de références : bornée
+		ref, ok := v.(pdfRef)
+		if !ok {
+			return v, nil
+		}
+		var err error
+		if v, err = doc.object(ref.num); err != nil {
+			return nil, err
+		}
+	}
+	return nil, errPDFSyntax
+}
+
+// decodeStream décode un flux FlateDecode (prédicteurs PNG compris) ou non filtré.
+func (doc *pdfDoc) decodeStream(s pdfStream) ([]byte, error) {
+	filter, _ := doc.resolve(s.dict["Filter"])
+	if a, ok := filter.(pdfArray); ok && len(a) == 1 {
+		filter = a[0]
+	}
+	switch filter {
+	case nil:
+		return s.data, nil
+	case pdfName("FlateDecode"):
+	default:
+		return nil, fmt.Errorf("filtre PDF non géré : %v", filter)
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(s.data))
+	if err != nil {
+		return nil, errPDFSyntax
+	}
+	data, err := io.ReadAll(io.LimitReader(zr, 256<<20))
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) { // flux tronqué : ce qui a été lu reste exploitable
+		return nil, errPDFSyntax
+	}
+
+	parms, _ := doc.resolve(s.dict["DecodeParms"])
+	if a, ok := parms.(pdfArray); ok && len(a) == 1 {
+		parms, _ = doc.resolve(a[0])
+	}
+	p, _ := parms.(pdfDict)
+	if pdfInt(p["Predictor"]) < 10 {
+		return data, nil
+	}
+	cols := max(pdfInt(p["Columns"]), 1) // prédicteurs PNG, un octet par colonne (flux xref)
+	var out, prev []byte
+	prev = make([]byte, cols)
+	for i := 0; i < len(data); i += cols + 1 {
+		kind, row := data[i], slices.Clone(data[i+1:min(i+1+cols, len(data))])
+		for j := range row {
+			left, up, upLeft := byte(0), prev[j], byte(0)
+			if j > 0 {
+				left, upLeft = row[j-1], prev[j-1]
+			}
+			switch kind {
+			case 1:
+				row[j] += left
+			case 2:
+				row[j] += up
+			case 3:
+				row[j] += byte((int(left) + int(up)) / 2)
+			case 4:
+				row[j] += paeth(left, up, upLeft)
+			}
+		}
+		out = append(out, row...)
+		copy(prev, row)
+	}
+	return out, nil
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := max(p-int(a), int(a)-p), max(p-int(b), int(b)-p), max(p-int(c), int(c)-p)
+	switch {
+	case pa <= pb && pa <= pc:
+		return a
+	case pb <= pc:
+		return b
+	}
+	return c
+}
+
+// pdfInt convertit un nombre PDF en entier — -1 si ce n'en est pas un.
+func pdfInt(v any) int {
+	n, ok := v.(pdfNum)
+	if !ok {
+		return -1
+	}
+	f, err := strconv.ParseFloat(string(n), 64)
+	if err != nil {
+		return -1
+	}
+	return int(f)
+}
+
+// pdfFloat convertit un nombre PDF en flottant — 0 si ce n'en est pas un.
+func pdfFloat(v any) float64 {
+	n, _ := v.(pdfNum)
+	f, _ := strconv.ParseFloat(string(n), 64)
+	return f
+}
+
+// ── Écriture ──
+
+// writePDFObject sérialise un objet direct. Les clés de dictionnaire sont triées : sortie stable.
+func writePDFObject(w *bytes.Buffer, v any) {
+	switch v := v.(type) {
+	case nil:
+		w.WriteString("null")
+	case bool:
+		w.WriteString(strconv.FormatBool(v))
+	case pdfNum:
+		w.WriteString(string(v))
+	case int:
+		w.WriteString(strconv.Itoa(v))
+	case pdfName:
+		w.WriteByte('/')
+		for _, c := range []byte(v) {
+			if c < '!' || c > '~' || c == '#' || isPDFDelim(c) {
+				fmt.Fprintf(w, "#%02X", c)
+			} else {
+				w.WriteByte(c)
+			}
+		}
+	case pdfString:
+		fmt.Fprintf(w, "<%X>", []byte(v))
+	case pdfRef:
+		fmt.Fprintf(w, "%d %d R", v.num, v.gen)
+	case pdfArray:
+		w.WriteByte('[')
+		for i, e := range v {
+			if i > 0 {
+				w.WriteByte(' ')
+			}
+			writePDFObject(w, e)
+		}
+		w.WriteByte(']')
+	case pdfDict:
+		w.WriteString("<<")
+		for _, k := range slices.Sorted(maps.Keys(v)) {
+			writePDFObject(w, k)
+			w.WriteByte(' ')
+			writePDFObject(w, v[k])
+		}
+		w.WriteString(">>")
+	}
+}
+
+// pdfUpdate accumule les objets d'une mise à jour incrémentale.
+type pdfUpdate struct {
+	doc     *pdfDoc
+	buf     bytes.Buffer // octets ajoutés après le fichier d'origine
+	offsets map[int]int  // numéro → offset absolu des objets écrits
+	gens    map[int]int
+	next    int // prochain numéro d'objet libre
+}
+
+func newPDFUpdate(doc *pdfDoc) *pdfUpdate {
+	u := &pdfUpdate{doc: doc, offsets: map[int]int{}, gens: map[int]int{}, next: pdfInt(doc.trailer["Size"])}
+	for n := range doc.xref { // /Size parfois sous-évalué par les outils fautifs
+		u.next = max(u.next, n+1)
+	}
+	if !bytes.HasSuffix(doc.data, []byte("\n")) {
+		u.buf.WriteByte('\n')
+	}
+	return u
+}
+
+// alloc réserve un numéro d'objet.
+func (u *pdfUpdate) alloc() pdfRef {
+	u.next++
+	return pdfRef{num: u.next - 1}
+}
+
+// put écrit (ou remplace) l'objet ref — un flux si data n'est pas nil.
+func (u *pdfUpdate) put(ref pdfRef, v any, data []byte) {
+	u.offsets[ref.num] = len(u.doc.data) + u.buf.Len()
+	u.gens[ref.num] = ref.gen
+	fmt.Fprintf(&u.buf, "%d %d obj\n", ref.num, ref.gen)
+	if data != nil {
+		d := v.(pdfDict)
+		d["Length"] = len(data)
+		writePDFObject(&u.buf, d)
+		u.buf.WriteString("\nstream\n")
+		u.buf.Write(data)
+		u.buf.WriteString("\nendstream")
+	} else {
+		writePDFObject(&u.buf, v)
+	}
+	u.buf.WriteString("\nendobj\n")
+}
+
+// putStream compresse data et l'écrit dans un nouvel objet flux.
+func (u *pdfUpdate) putStream(dict pdfDict, data []byte) pdfRef {
+	var z bytes.Buffer
+	zw, _ := zlib.NewWriterLevel(&z, zlib.BestCompression)
+	zw.Write(data) //nolint:errcheck — écriture en mémoire
+	zw.Close()     //nolint:errcheck
+	dict["Filter"] = pdfName("FlateDecode")
+	ref := u.alloc()
+	u.put(ref, dict, z.Bytes())
+	return ref
+}
+
+// finish ajoute la section xref et le trailer, et retourne le document complet.
+func (u *pdfUpdate) finish(w io.Writer) error {
+	trailer := pdfDict{"Root": u.doc.trailer["Root"]}
+	for _, k := range []pdfName{"Info", "ID"} {
+		if v, ok := u.doc.trailer[k]; ok {
+			trailer[k] = v
+		}
+	}
+	if u.doc.startxref > 0 {
+		trailer["Prev"] = u.doc.startxref
+	}
+
+	var xrefRef pdfRef
+	if u.doc.xrefIsStm { // le flux xref se référence lui-même
+		xrefRef = u.alloc()
+		u.offsets[xrefRef.num] = len(u.doc.data) + u.buf.Len()
+	}
+	nums := slices.Sorted(maps.Keys(u.offsets))
+	trailer["Size"] = u.next
+	start := len(u.doc.data) + u.buf.Len()
+
+	if u.doc.xrefIsStm {
+		var index pdfArray
+		var rows []byte
+		for i := 0; i < len(nums); {
+			j := i
+			for j+1 < len(nums) && nums[j+1] == nums[j]+1 {
+				j++
+			}
+			index = append(index, nums[i], j-i+1)
+			for _, n := range nums[i : j+1] {
+				off, gen := u.offsets[n], u.gens[n]
+				rows = append(rows, 1, byte(off>>24), byte(off>>16), byte(off>>8), byte(off), byte(gen>>8), byte(gen))
+			}
+			i = j + 1
+		}
+		trailer["Type"] = pdfName("XRef")
+		trailer["W"] = pdfArray{1, 4, 2}
+		trailer["Index"] = index
+		u.put(xrefRef, trailer, rows)
+	} else {
+		u.buf.WriteString("xref\n")
+		for i := 0; i < len(nums); {
+			j := i
+			for j+1 < len(nums) && nums[j+1] == nums[j]+1 {
+				j++
+			}
+			fmt.Fprintf(&u.buf, "%d %d\n", nums[i], j-i+1)
+			for _, n := range nums[i : j+1] {
+				fmt.Fprintf(&u.buf, "%010d %05d n\r\n", u.offsets[n], u.gens[n])
+			}
+			i = j + 1
+		}
+		u.buf.WriteString("trailer\n")
+		writePDFObject(&u.buf, trailer)
+		u.buf.WriteByte('\n')
+	}
+	fmt.Fprintf(&u.buf, "startxref\n%d\n%%%%EOF\n", start)
+
+	if _, err := w.Write(u.doc.data); err != nil {
+		return err
+	}
+	_, err := w.Write(u.buf.Bytes())
+	return err
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// buildPDF assemble un PDF dont les objets 1..n sont objs, avec une table xref exacte.
+// trailer complète le dictionnaire du trailer (/Size est ajouté).
+func buildPDF(objs []string, trailer string) []byte {
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objs))
+	for i, o := range objs {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, o)
+	}
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objs)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d %s >>\nstartxref\n%d\n%%%%EOF\n", len(objs)+1, trailer, xref)
+	return b.Bytes()
+}
+
+var testPDFObjects = []string{
+	"<< /Type /Catalog /Pages 2 0 R >>",
+	"<< /Type /Pages /Kids [3 0 R] /Count 1 /MediaBox [0 0 595 842] >>",
+	"<< /Type /Page /Parent 2 0 R /Contents 4 0 R >>",
+	"<< /Length 8 >>\nstream\n0 0 m S\n\nendstream",
+}
+
+func flate(data []byte) []byte {
+	var b bytes.Buffer
+	zw := zlib.NewWriter(&b)
+	zw.Write(data) //nolint:errcheck
+	zw.Close()     //nolint:errcheck
+	return b.Bytes()
+}
+
+func TestParsePDF(t *testing.T) {
+	valid := buildPDF(testPDFObjects, "/Root 1 0 R")
+	// Offsets de la xref décalés (outil fautif) : la table est reconstruite.
+	shifted := bytes.Replace(valid, []byte("%PDF-1.4\n"), []byte("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n"), 1)
+
+	for _, tc := range []struct {
+		name string
+		data []byte
+	}{
+		{"valide", valid},
+		{"xref décalée", shifted},
+		{"sans startxref", valid[:bytes.LastIndex(valid, []byte("startxref"))]},
+		{"xref démesurée", bytes.Replace(valid, []byte("xref\n0 5"), []byte("xref\n0 999999999"), 1)},
+		{"startxref hors du fichier", bytes.Replace(valid, []byte(fmt.Sprintf("startxref\n%d", bytes.Index(valid, []byte("xref\n")))), []byte("startxref\n99999999"), 1)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			doc, err := parsePDF(tc.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pages, err := doc.pages()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(pages) != 1 || pages[0].box != [4]float64{0, 0, 595, 842} {
+				t.Errorf("pages %+v", pages)
+			}
+		})
+	}
+}
+
+// Entrées mal formées : une erreur, jamais de panique ni de boucle sans fin.
+func TestParsePDFMalformed(t *testing.T) {
+	valid := buildPDF(testPDFObjects, "/Root 1 0 R")
+	for _, tc := range []struct {
+		name string
+		data []byte
+		err  error // nil : toute erreur convient
+	}{
+		{"vide", nil, errPDFSyntax},
+		{"pas un PDF", []byte("GIF89a"), errPDFSyntax},
+		{"en-tête seul", []byte("%PDF-1.7\n"), errPDFSyntax},
+		{"tronqué", valid[:len(valid)/2], errPDFSyntax},
+		{"chiffré", buildPDF(testPDFObjects, "/Root 1 0 R /Encrypt << /Filter /Standard >>"), errPDFEncrypted},
+		{"sans Root", buildPDF(testPDFObjects, ""), errPDFSyntax},
+		{"Root absent de la xref", buildPDF(testPDFObjects, "/Root 9 0 R"), nil},
+		{"Root qui se référence", buildPDF([]string{"1 0 R"}, "/Root 1 0 R"), nil},
+		{"arbre des pages cyclique", buildPDF([]string{
+			"<< /Type /Catalog /Pages 2 0 R >>",
+			"<< /Type /Pages /Kids [2 0 R 3 0 R] >>",
+			"<< /Type /Pages /Kids [2 0 R] >>",
+		}, "/Root 1 0 R"), errPDFSyntax},
+		{"Prev qui boucle", func() []byte {
+			b := buildPDF(testPDFObjects, "/Root 9 0 R /Prev 9")
+			return bytes.Replace(b, []byte("/Prev 9"), []byte(fmt.Sprintf("/Prev %d", bytes.Index(b, []byte("xref\n")))), 1)
+		}(), nil},
+		{"imbrication profonde", buildPDF([]string{"<< /Type /Catalog /Pages " + strings.Repeat("[", 100000) + " >>"}, "/Root 1 0 R"), nil},
+		{"chaîne non fermée", buildPDF([]string{"<< /Type /Catalog /Pages (abc >>"}, "/Root 1 0 R"), nil},
+		{"flux sans endstream", []byte("%PDF-1.4\n1 0 obj\n<< /Length 999 >>\nstream\nabc"), errPDFSyntax},
+		{"Length qui se référence", buildPDF([]string{
+			"<< /Type /Catalog /Pages 2 0 R >>",
+			"<< /Length 2 0 R >>\nstream\nabc\nendstream",
+		}, "/Root 1 0 R"), nil},
+		{"flux d'objets corrompu", buildPDF([]string{
+			"<< /Type /Catalog /Pages 2 0 R >>",
+			"<< /Type /ObjStm /N 99999 /First -4 /Length 3 >>\nstream\n1 0\nendstream",
+		}, "/Root 1 0 R"), nil},
+		{"flux xref invalide", []byte("%PDF-1.5\n1 0 obj\n<< /Type /XRef /W [9 9 9] /Size 2 /Root 1 0 R /Length 2 >>\nstream\nxx\nendstream\nendobj\nstartxref\n9\n%%EOF"), nil},
+		{"flux xref non zlib", []byte("%PDF-1.5\n1 0 obj\n<< /Type /XRef /W [1 2 1] /Size 2 /Root 1 0 R /Filter /FlateDecode /DecodeParms << /Predictor 12 /Columns 4 >> /Length 2 >>\nstream\nxx\nendstream\nendobj\nstartxref\n9\n%%EOF"), nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			doc, err := parsePDF(tc.data)
+			if err == nil {
+				_, err = doc.pages()
+			}
+			if err == nil {
+				t.Fatal("PDF mal formé accepté")
+			}
+			if tc.err != nil && !errors.Is(err, tc.err) {
+				t.Errorf("erreur %v, attendu %v", err, tc.err)
+			}
+		})
+	}
+}
+
+// Flux xref compressé avec prédicteur PNG, objets dans un flux d'objets (PDF 1.5+).
+func TestParsePDFXrefStream(t *testing.T) {
+	head := "3 0 4 50 "
+	body := fmt.Sprintf("%-50s", "<< /Type /Pages /Kids [4 0 R] /Count 1 >>") + "<< /Type /Page /Parent 3 0 R >>"
+	stmData := flate([]byte(head + body))
+
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.5\n")
+	off1 := b.Len()
+	b.WriteString("1 0 obj\n<< /Type /Catalog /Pages 3 0 R >>\nendobj\n")
+	off2 := b.Len()
+	fmt.Fprintf(&b, "2 0 obj\n<< /Type /ObjStm /N 2 /First %d /Filter /FlateDecode /Length %d >>\nstream\n", len(head), len(stmData))
+	b.Write(stmData)
+	b.WriteString("\nendstream\nendobj\n")
+	off5 := b.Len()
+
+	// Lignes : type (1), champ 2 (2), champ 3 (1) — prédicteur PNG « Up » sur chaque ligne.
+	rows := [][4]byte{{0, 0, 0, 255}, {1, byte(off1 >> 8), byte(off1), 0}, {1, byte(off2 >> 8), byte(off2), 0}, {2, 0, 2, 0}, {2, 0, 2, 1}, {1, byte(off5 >> 8), byte(off5), 0}}
+	var raw []byte
+	var prev [4]byte
+	for _, r := range rows {
+		raw = append(raw, 2)
+		for i := range r {
+			raw = append(raw, r[i]-prev[i])
+		}
+		prev = r
+	}
+	xref := flate(raw)
+	fmt.Fprintf(&b, "5 0 obj\n<< /Type /XRef /Size 6 /W [1 2 1] /Root 1 0 R /Filter /FlateDecode /DecodeParms << /Predictor 12 /Columns 4 >> /Length %d >>\nstream\n", len(xref))
+	b.Write(xref)
+	fmt.Fprintf(&b, "\nendstream\nendobj\nstartxref\n%d\n%%%%EOF\n", off5)
+
+	doc, err := parsePDF(b.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !doc.xrefIsStm || doc.xref[4].objStm != 2 || doc.xref[4].index != 1 {
+		t.Errorf("xref %+v", doc.xref)
+	}
+	pages, err := doc.pages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 1 || pages[0].box != defaultPDFBox {
+		t.Errorf("pages %+v", pages)
+	}
+}
+
+// FuzzParsePDF : go test -fuzz=FuzzParsePDF. Sans -fuzz, seuls les exemples ci-dessous tournent.
+func FuzzParsePDF(f *testing.F) {
+	f.Add(buildPDF(testPDFObjects, "/Root 1 0 R"))
+	f.Add(buildPDF(testPDFObjects, "/Root 1 0 R /Prev 9"))
+	f.Add([]byte("%PDF-1.5\n1 0 obj\n<< /Type /XRef /W [1 2 1] /Index [0 2 5 9] /Size 2 /Root 1 0 R /Length 8 >>\nstream\n\x01\x00\x09\x00\x02\x00\x01\x00\nendstream\nendobj\nstartxref\n9\n%%EOF"))
+	f.Add([]byte("%PDF-1.4\n1 0 obj\n<< /Type /Catalog /Pages 2 0 R >>\nendobj\n2 0 obj\n<< /Type /ObjStm /N 1 /First 4 /Length 8 >>\nstream\n1 0 <<\nendstream\nendobj\ntrailer\n<< /Root 1 0 R >>"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		doc, err := parsePDF(data)
+		if err != nil {
+			return
+		}
+		if pages, err := doc.pages(); err == nil {
+			u := newPDFUpdate(doc)
+			for _, p := range pages {
+				u.put(p.ref, p.dict, nil)
+			}
+			u.finish(&bytes.Buffer{}) //nolint:errcheck
+		}
+	})
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"slices"
+	"strings"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+const (
+	blurhashX      = 4  // composantes horizontales — 4×3 est le réglage recommandé pour une photo paysage
+	blurhashY      = 3  // composantes verticales
+	blurhashSample = 32 // l'image est réduite à 32px de large avant le calcul — le résultat est flou de toute façon
+)
+
+// base83 est l'alphabet de l'encodage BlurHash (spécification woltapp/blurhash).
+const base83 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// blurHash calcule le BlurHash de l'image : une chaîne de ~28 caractères que le front
+// décode en un dégradé flou affiché instantanément pendant le chargement de l'image.
+//
+// Le calcul est une DCT limitée à blurhashX×blurhashY composantes en espace RGB linéaire.
+// Il est fait sur une réduction de l'image : le coût est proportionnel au nombre de pixels
+// × composantes, et les basses fréquences ne changent pas avec la résolution.
+func blurHash(img image.Image) string {
+	b := img.Bounds()
+	w := min(b.Dx(), blurhashSample)
+	h := max(1, b.Dy()*w/max(1, b.Dx())) // ratio préservé — le BlurHash n'encode pas les dimensions
+	small := image.NewRGBA(image.Rect(0, 0, w, h))
+	xdraw.ApproxBiLinear.Scale(small, small.Bounds(), img, b, xdraw.Src, nil) // qualité sans importance à cette taille
+
+	// Pré-conversion en linéaire — chaque pixel est relu blurhashX×blurhashY fois.
+	lin := make([][3]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			o := small.PixOffset(x, y)
+			lin[y*w+x] = [3]float64{srgbToLinear(small.Pix[o]), srgbToLinear(small.Pix[o+1]), srgbToLinear(small.Pix[o+2])}
+		}
+	}
+
+	factors := make([][3]float64, 0, blurhashX*blurhashY)
+	for j := 0; j < blurhashY; j++ {
+		for i := 0; i < blurhashX; i++ {
+			norm := 2.0
+			if i == 0 && j == 0 { // composante DC = couleur moyenne, non doublée
+				norm = 1
+			}
+			var f [3]float64
+			for y := 0; y < h; y++ {
+				for x := 0; x < w; x++ {
+					basis := math.Cos(math.Pi*float64(i*x)/float64(w)) * math.Cos(math.Pi*float64(j*y)/float64(h))
+					p := lin[y*w+x]
+					f[0] += basis * p[0]
+					f[1] += basis * p[1]
+					f[2] += basis * p[2]
+				}
+			}
+			scale := norm / float64(w*h)
+			factors = append(factors, [3]float64{f[0] * scale, f[1] * scale, f[2] * scale})
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString(encode83((blurhashX-1)+(blurhashY-1)*9, 1)) // taille de la grille de composantes
+
+	// Les composantes AC sont quantifiées relativement à la plus grande d'entre elles.
+	maxAC := 0.0
+	for _, f := range factors[1:] {
+		maxAC = max(maxAC, math.Abs(f[0]), math.Abs(f[1]), math.Abs(f[2]))
+	}
+	quantMax := int(max(0, min(82, math.Floor(maxAC*166-0.5))))
+	maxValue := float64(quantMax+1) / 166
+	sb.WriteString(encode83(quantMax, 1))
+
+	dc := factors[0]
+	sb.WriteString(encode83(linearToSRGB(dc[0])<<16|linearToSRGB(dc[1])<<8|linearToSRGB(dc[2]), 4))
+
+	for _, f := range factors[1:] {
+		q := func(v float64) int { // racine signée : plus de précision pour les petites amplitudes
+			return int(max(0, min(18, math.Floor(signPow(v/maxValue, 0.5)*9+9.5))))
+		}
+		sb.WriteString(encode83(q(f[0])*19*19+q(f[1])*19+q(f[2]), 2))
+	}
+	return sb.String()
+}
+
+// encode83 écrit value en base 83 sur exactement length caractères.
+func encode83(value, length int) string {
+	out := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		out[i] = base83[value%83]
+		value /= 83
+	}
+	return string(out)
+}
+
+// srgbToLinear retire la courbe gamma sRGB (0-255 → 0-1 linéaire).
+func srgbToLinear(v uint8) float64 {
+	c := float64(v) / 255
+	if c <= 0.04045 {
+		return c / 12.92
+	}
+	return math.Pow((c+0.055)/1.055, 2.4)
+}
+
+// linearToSRGB applique la courbe gamma sRGB (0-1 linéaire → 0-255).
+func linearToSRGB(v float64) int {
+	c := max(0, min(1, v))
+	if c <= 0.0031308 {
+		return int(c*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(c, 1/2.4)-0.055)*255 + 0.5)
+}
+
+// signPow élève |v| à la puissance exp en conservant le signe.
+func signPow(v, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
+
+const (
+	paletteSize    = 5  // nombre de couleurs retournées dans X-Image-Palette
+	paletteSample  = 64 // côté max de la réduction analysée — suffisant pour un histogramme de couleurs
+	paletteMinDist = 48 // distance RGB minimale entre deux couleurs de la palette, évite 5 nuances du même ciel
+)
+
+// dominantPalette retourne les couleurs les plus représentées de l'image, la dominante en premier.
+//
+// Les pixels d'une réduction de l'image sont répartis dans un histogramme à 4 bits par canal
+// (4096 cases). Chaque case garde la somme des couleurs réelles pour restituer une moyenne
+// plutôt que le centre de la case. Les cases sont ensuite prises par effectif décroissant,
+// en écartant celles trop proches d'une couleur déjà retenue.
+func dominantPalette(img image.Image) []color.RGBA {
+	b := img.Bounds()
+	w, h := fitInside(b.Dx(), b.Dy(), paletteSample, paletteSample)
+	small := image.NewRGBA(image.Rect(0, 0, w, h))
+	xdraw.ApproxBiLinear.Scale(small, small.Bounds(), img, b, xdraw.Src, nil)
+
+	type bin struct {
+		count   int
+		r, g, b int // sommes des composantes — divisées par count à la fin
+	}
+	var bins [4096]bin
+	for i := 0; i+3 < len(small.Pix); i += 4 {
+		r, g, bl := int(small.Pix[i]), int(small.Pix[i+1]), int(small.Pix[i+2])
+		k := (r>>4)<<8 | (g>>4)<<4 | bl>>4 // 4 bits de poids fort par canal
+		bins[k].count++
+		bins[k].r += r
+		bins[k].g += g
+		bins[k].b += bl
+	}
+
+	order := make([]int, 0, len(bins))
+	for k := range bins {
+		if bins[k].count > 0 {
+			order = append(order, k)
+		}
+	}
+	slices.SortFunc(order, func(a, b int) int { return bins[b].count - bins[a].count }) // effectif décroissant
+
+	palette := make([]color.RGBA, 0, paletteSize)
+	for _, k := range order {
+		c := color.RGBA{
+			R: uint8(bins[k].r / bins[k].count),
+			G: uint8(bins[k].g / bins[k].count),
+			B: uint8(bins[k].b / bins[k].count),
+			A: 255,
+		}
+		if slices.ContainsFunc(palette, func(p color.RGBA) bool { return colorDist(p, c) < paletteMinDist }) {
+			continue
+		}
+		palette = append(palette, c)
+		if len(palette) == paletteSize {
+			break
+		}
+	}
+	return palette
+}
+
+// colorDist est la distance euclidienne entre deux couleurs dans l'espace RGB 8 bits.
+func colorDist(a, b color.RGBA) float64 {
+	dr, dg, db := float64(a.R)-float64(b.R), float64(a.G)-float64(b.G), float64(a.B)-float64(b.B)
+	return math.Sqrt(dr*dr + dg*dg + db*db)
+}
+
+// hexColor formate une couleur en "#rrggbb", directement utilisable en CSS.
+func hexColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
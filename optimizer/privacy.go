@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"net/http"
+	"strconv"
+)
+
+// ── Floutage de zones ─────────────────────────────────────────────────────────
+// blur_regions=[{"x":120,"y":80,"w":200,"h":240}, …] floute des rectangles (visages, plaques)
+// avant le watermark, dans la même passe que l'optimisation. Les coordonnées sont en px de
+// l'image envoyée, après rotate/flip : elles suivent ensuite le recadrage et le resize.
+// Le flou est calculé sur l'image réduite, après les filtres — moins de pixels à traiter.
+// Une zone hors de la partie recadrée est ignorée.
+//
+// auto_faces=true est refusé (501) : aucun détecteur de visages n'est embarqué
+// (cf. wm_position=auto). Les zones doivent être fournies par l'appelant.
+
+const (
+	maxBlurRegions = 64
+	blurPasses     = 3 // trois flous boîte successifs ≈ un flou gaussien
+	blurDivisor    = 8 // rayon = plus grand côté de la zone / 8 — un visage devient une tache
+	minBlurRadius  = 3
+)
+
+// errNoFaceDetector : auto_faces demandé alors qu'aucun détecteur n'est disponible (501).
+var errNoFaceDetector = errors.New("auto_faces indisponible : aucun détecteur de visages embarqué, fournir blur_regions")
+
+// blurRegion est un rectangle tel qu'envoyé par le client.
+type blurRegion struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+// blurRegionsParam lit blur_regions et auto_faces — nil si aucune zone n'est demandée.
+func blurRegionsParam(r *http.Request) ([]image.Rectangle, error) {
+	if v := r.FormValue("auto_faces"); v != "" {
+		on, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("auto_faces invalide : %q (true ou false)", v)
+		}
+		if on {
+			return nil, errNoFaceDetector
+		}
+	}
+	v := r.FormValue("blur_regions")
+	if v == "" {
+		return nil, nil
+	}
+	var regions []blurRegion
+	if err := json.Unmarshal([]byte(v), &regions); err != nil {
+		return nil, fmt.Errorf("blur_regions invalide : %v (liste JSON de {x, y, w, h})", err)
+	}
+	if len(regions) > maxBlurRegions {
+		return nil, fmt.Errorf("blur_regions : %d zones au maximum", maxBlurRegions)
+	}
+	rects := make([]image.Rectangle, 0, len(regions))
+	for i, z := range regions {
+		if z.X < 0 || z.Y < 0 || z.W < 1 || z.H < 1 {
+			return nil, fmt.Errorf("blur_regions[%d] invalide : x, y ≥ 0 et w, h ≥ 1", i)
+		}
+		rects = append(rects, image.Rect(z.X, z.Y, z.X+z.W, z.Y+z.H))
+	}
+	return rects, nil
+}
+
+// scaleRegions ramène les zones, exprimées dans le repère de src (l'image avant resize, recadrage
+// compris), dans celui de l'image réduite de w×h px. Les zones hors de src disparaissent.
+func scaleRegions(rects []image.Rectangle, src image.Rectangle, w, h int) []image.Rectangle {
+	var out []image.Rectangle
+	for _, z := range rects {
+		z = z.Intersect(src)
+		if z.Empty() {
+			continue
+		}
+		z = z.Sub(src.Min)
+		z = image.Rect( // bornes élargies à l'arrondi : le flou couvre toujours toute la zone demandée
+			z.Min.X*w/src.Dx(), z.Min.Y*h/src.Dy(),
+			(z.Max.X*w+src.Dx()-1)/src.Dx(), (z.Max.Y*h+src.Dy()-1)/src.Dy(),
+		)
+		if !z.Empty() {
+			out = append(out, z)
+		}
+	}
+	return out
+}
+
+// blurRegions floute en place les zones rects du canvas (repère du canvas).
+func blurRegions(dst *image.RGBA, rects []image.Rectangle) {
+	for _, z := range rects {
+		z = z.Intersect(dst.Bounds())
+		if z.Empty() {
+			continue
+		}
+		radius := max(max(z.Dx(), z.Dy())/blurDivisor, minBlurRadius)
+		for range blurPasses {
+			boxBlurRGBA(dst, z, radius, true)
+			boxBlurRGBA(dst, z, radius, false)
+		}
+	}
+}
+
+// boxBlurRGBA moyenne chaque pixel de z avec ses voisins à ±radius sur une ligne (ou une colonne),
+// par somme glissante. Les bords sont répétés : seuls les pixels de z sont lus, rien ne déborde
+// de la zone ni n'y entre. Le canvas est prémultiplié — la moyenne reste correcte avec l'alpha.
+// Même principe que le boxBlur du halo de texte (textmask.go), sur 4 canaux.
+func boxBlurRGBA(img *image.RGBA, z image.Rectangle, radius int, horizontal bool) {
+	n, lines := z.Dx(), z.Dy()
+	if !horizontal {
+		n, lines = lines, n
+	}
+	line := make([][4]int, n) // copie de la ligne en cours — la somme glissante lit les valeurs d'origine
+	for l := range lines {
+		at := func(i int) int { // offset du i-ème pixel de la ligne l
+			if horizontal {
+				return img.PixOffset(z.Min.X+i, z.Min.Y+l)
+			}
+			return img.PixOffset(z.Min.X+l, z.Min.Y+i)
+		}
+		for i := range n {
+			o := at(i)
+			line[i] = [4]int{int(img.Pix[o]), int(img.Pix[o+1]), int(img.Pix[o+2]), int(img.Pix[o+3])}
+		}
+		var sum [4]int
+		for k := -radius; k <= radius; k++ {
+			p := line[min(max(k, 0), n-1)]
+			for c := range 4 {
+				sum[c] += p[c]
+			}
+		}
+		width := 2*radius + 1
+		for i := range n {
+			o := at(i)
+			for c := range 4 {
+				img.Pix[o+c] = uint8(sum[c] / width)
+			}
+			in, out := line[min(i+radius+1, n-1)], line[max(i-radius, 0)]
+			for c := range 4 {
+				sum[c] += in[c] - out[c]
+			}
+		}
+	}
+}
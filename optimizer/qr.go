@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"strconv"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// ── Watermark QR code ─────────────────────────────────────────────────────────
+// wm_qr génère un QR code (typiquement l'URL d'une épreuve) composé comme un logo :
+// mêmes positions, même opacité. Le QR est tracé à la taille finale, en modules entiers,
+// pour rester net et lisible par un téléphone.
+
+const (
+	maxQRContent  = 1024 // au-delà le QR devient trop dense pour être scanné sur une photo
+	defaultQRSize = 12   // largeur du QR en % de la largeur de l'image
+)
+
+// qrParams génère le QR code demandé par wm_qr, dimensionné par wm_qr_size (% de la largeur width).
+// Un QR nil sans erreur signifie « pas de QR ».
+func qrParams(r wmSource, width int) (img image.Image, size int, err error) {
+	content := r.FormValue("wm_qr")
+	if content == "" {
+		return nil, 0, nil
+	}
+	if len(content) > maxQRContent {
+		return nil, 0, fmt.Errorf("wm_qr trop long (max %d octets)", maxQRContent)
+	}
+	size = defaultQRSize
+	if v := r.FormValue("wm_qr_size"); v != "" {
+		if size, err = strconv.Atoi(v); err != nil || size < 1 || size > 100 {
+			return nil, 0, fmt.Errorf("wm_qr_size invalide : %q (entier 1-100)", v)
+		}
+	}
+
+	qr, err := qrcode.New(content, qrcode.Medium) // Medium : 15 % de redondance, tolère une compression JPEG agressive
+	if err != nil {
+		return nil, 0, fmt.Errorf("wm_qr invalide : %w", err)
+	}
+	modules := len(qr.Bitmap())          // côté du symbole en modules, zone blanche comprise
+	px := max(width*size/100/modules, 2) // px par module — entier pour des modules tous identiques
+	return qr.Image(-px), size, nil      // taille négative : px par module plutôt que côté total
+}
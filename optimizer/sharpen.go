@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"net/http"
+	"strconv"
+)
+
+// ── Netteté ───────────────────────────────────────────────────────────────────
+// Une réduction en BiLinear adoucit les détails fins : sharpen=0..100 applique un masque flou
+// (unsharp mask) sur l'image réduite, avant le watermark — le texte n'a pas besoin d'être accentué.
+// Le renforcement est calculé par rapport à un flou de rayon 1 : seul le micro-contraste est
+// relevé, sans halo visible autour des grands contours.
+
+const (
+	sharpenMaxAmount = 1.5 // sharpen=100 → le détail est multiplié par 2,5 — au-delà, halos et grain
+	sharpenThreshold = 2   // écarts plus faibles ignorés : le bruit et les aplats JPEG ne sont pas accentués
+)
+
+// sharpenParam lit sharpen — 0 si le champ est absent.
+func sharpenParam(r *http.Request) (int, error) {
+	v := r.FormValue("sharpen")
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 || n > 100 {
+		return 0, fmt.Errorf("sharpen invalide : %q (entier 0-100)", v)
+	}
+	return n, nil
+}
+
+// unsharpMask accentue dst en place : pixel + amount × (pixel − flou).
+func unsharpMask(dst *image.RGBA, strength int) {
+	blurred := newCanvas(dst.Rect)
+	defer releaseCanvas(blurred)
+	copy(blurred.Pix, dst.Pix)
+	for range blurPasses { // même approximation gaussienne que blur_regions
+		boxBlurRGBA(blurred, blurred.Rect, 1, true)
+		boxBlurRGBA(blurred, blurred.Rect, 1, false)
+	}
+
+	amount := sharpenMaxAmount * float64(strength) / 100
+	pix, blur := dst.Pix, blurred.Pix
+	for i := 0; i+3 < len(pix); i += 4 {
+		a := float64(pix[i+3]) // canvas prémultiplié : un canal reste borné par l'alpha
+		for c := i; c < i+3; c++ {
+			d := float64(pix[c]) - float64(blur[c])
+			if d > -sharpenThreshold && d < sharpenThreshold {
+				continue
+			}
+			pix[c] = uint8(min(max(float64(pix[c])+amount*d, 0), a) + 0.5)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package main
+
+import "image"
+
+// newCanvas alloue toujours en RAM : le spill mmap n'est disponible que sur les systèmes unix.
+func newCanvas(r image.Rectangle) *image.RGBA {
+	return image.NewRGBA(r)
+}
+
+// releaseCanvas est sans effet hors unix — aucun canvas n'est mappé.
+func releaseCanvas(image.Image) {}
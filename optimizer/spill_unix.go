@@ -0,0 +1,77 @@
+//go:build unix
+
+package main
+
+import (
+	"image"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// spilled référence les canvases mappés sur disque, indexés par l'adresse de leur Pix[0].
+// releaseCanvas s'en sert pour retrouver le mapping à libérer à partir de l'image seule.
+var (
+	spilledMu sync.Mutex
+	spilled   = map[uintptr][]byte{}
+)
+
+// newCanvas alloue un canvas RGBA. Au-delà de spillThreshold octets, les pixels sont
+// placés dans un fichier temporaire mappé en mémoire (mmap MAP_SHARED) : le noyau peut
+// alors évincer les pages vers le disque au lieu de faire grossir le heap Go.
+// Le fichier est supprimé dès sa création — le mapping reste valide jusqu'au munmap.
+func newCanvas(r image.Rectangle) *image.RGBA {
+	size := 4 * r.Dx() * r.Dy() // 4 octets par pixel RGBA
+	if spillThreshold <= 0 || int64(size) < spillThreshold || size == 0 {
+		return image.NewRGBA(r)
+	}
+
+	pix, err := mmapTemp(size)
+	if err != nil { // disque plein ou mmap refusé — on retombe sur la RAM plutôt que d'échouer
+		logger.Warn().Str("step", "spill").Err(err).Str("size", formatBytes(size)).Msg("spill impossible, canvas en RAM")
+		return image.NewRGBA(r)
+	}
+
+	spilledMu.Lock()
+	spilled[uintptr(unsafe.Pointer(&pix[0]))] = pix
+	spilledMu.Unlock()
+
+	logger.Debug().Str("step", "spill").Str("size", formatBytes(size)).Msg("canvas mappé sur disque")
+	return &image.RGBA{Pix: pix, Stride: 4 * r.Dx(), Rect: r}
+}
+
+// releaseCanvas libère le mapping d'un canvas alloué par newCanvas.
+// Sans effet pour une image en RAM ou qui ne vient pas de newCanvas — le caller peut
+// l'appeler sur n'importe quelle étape du pipeline sans connaître son origine.
+func releaseCanvas(img image.Image) {
+	rgba, ok := img.(*image.RGBA)
+	if !ok || len(rgba.Pix) == 0 {
+		return
+	}
+	key := uintptr(unsafe.Pointer(&rgba.Pix[0]))
+
+	spilledMu.Lock()
+	pix, ok := spilled[key]
+	delete(spilled, key)
+	spilledMu.Unlock()
+
+	if ok {
+		syscall.Munmap(pix) //nolint:errcheck — rien à faire si le noyau refuse, le mapping disparaîtra avec le process
+	}
+}
+
+// mmapTemp crée un fichier temporaire de size octets dans SPILL_DIR et le mappe en lecture/écriture.
+func mmapTemp(size int) ([]byte, error) {
+	f, err := os.CreateTemp(os.Getenv("SPILL_DIR"), "canvas-*.rgba") // SPILL_DIR vide → os.TempDir()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()           // le mapping survit à la fermeture du descripteur
+	defer os.Remove(f.Name()) // unlink immédiat — l'espace disque est rendu au munmap, même après un crash
+
+	if err := f.Truncate(int64(size)); err != nil { // fichier creux : aucun bloc écrit tant que les pixels ne sont pas touchés
+		return nil, err
+	}
+	return syscall.Mmap(int(f.Fd()), 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+}
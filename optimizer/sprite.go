@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+const (
+	spriteDefaultCell = 128 // côté d'une cellule en px — taille de vignette courante pour une grille de galerie
+	spriteMaxCell     = 512
+	spriteMaxImages   = 100 // au-delà, la planche dépasse les ~5000px de côté et perd son intérêt
+	spriteQuality     = 80  // vignettes : les artefacts sont peu visibles à cette taille
+)
+
+// spriteFrame décrit la position d'une vignette dans la planche.
+// x/y/w/h sont en pixels dans l'image sprite — directement utilisables en background-position CSS.
+type spriteFrame struct {
+	Index    int    `json:"index"`
+	Filename string `json:"filename"`
+	X        int    `json:"x"`
+	Y        int    `json:"y"`
+	W        int    `json:"w"`
+	H        int    `json:"h"`
+	Error    string `json:"error,omitempty"` // cellule laissée vide si l'image n'a pas pu être décodée
+}
+
+// spriteResponse est la réponse JSON de POST /sprite : l'image en data URI + la carte des coordonnées.
+type spriteResponse struct {
+	Sprite string        `json:"sprite"`
+	Width  int           `json:"width"`
+	Height int           `json:"height"`
+	Cell   int           `json:"cell"`
+	Frames []spriteFrame `json:"frames"`
+}
+
+// handleSprite assemble les images reçues (champs multipart "image" répétés) en une seule
+// planche de miniatures, pour qu'une galerie affiche N vignettes avec une seule requête.
+// Les vignettes ne sont pas watermarkées : à 128px le texte serait illisible.
+func handleSprite(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	sem <- struct{}{} // une planche = un slot, comme une image — les décodages sont séquentiels
+	defer func() { <-sem }()
+
+	if err := r.ParseMultipartForm(32 << 20); err != nil { // 32 Mo en RAM, le reste sur disque temporaire
+		http.Error(w, "formulaire invalide", http.StatusBadRequest)
+		return
+	}
+	files := r.MultipartForm.File["image"]
+	if len(files) == 0 {
+		http.Error(w, "image manquante", http.StatusBadRequest)
+		return
+	}
+	if len(files) > spriteMaxImages {
+		http.Error(w, fmt.Sprintf("trop d'images (max %d, reçu %d)", spriteMaxImages, len(files)), http.StatusBadRequest)
+		return
+	}
+	encOpts, err := encodeParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cell := spriteDefaultCell
+	if v := r.FormValue("cell"); v != "" {
+		if cell, err = strconv.Atoi(v); err != nil || cell < 1 || cell > spriteMaxCell {
+			http.Error(w, fmt.Sprintf("cell invalide : %q (entier 1-%d)", v, spriteMaxCell), http.StatusBadRequest)
+			return
+		}
+	}
+	cols := int(math.Ceil(math.Sqrt(float64(len(files))))) // grille la plus carrée possible
+	if v := r.FormValue("cols"); v != "" {
+		if cols, err = strconv.Atoi(v); err != nil || cols < 1 || cols > spriteMaxImages {
+			http.Error(w, fmt.Sprintf("cols invalide : %q (entier 1-%d)", v, spriteMaxImages), http.StatusBadRequest)
+			return
+		}
+		cols = min(cols, len(files)) // plus de colonnes que d'images : une seule ligne
+	}
+	rows := (len(files) + cols - 1) / cols
+
+	sprite := image.NewRGBA(image.Rect(0, 0, cols*cell, rows*cell))
+	draw.Draw(sprite, sprite.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src) // fond blanc : le JPEG n'a pas d'alpha
+
+	frames := make([]spriteFrame, len(files))
+	for i, fh := range files {
+		frames[i] = spriteFrame{Index: i, Filename: fh.Filename}
+
+		f, err := fh.Open()
+		if err != nil {
+			frames[i].Error = "lecture échouée"
+			continue
+		}
+		img, _, _, err := decodeFile(f, false) // pas de mode strip : un panorama monopoliserait la planche
+		f.Close()
+		if err != nil {
+			frames[i].Error = err.Error()
+			continue
+		}
+
+		// Vignette centrée dans sa cellule, ratio préservé.
+		tw, th := fitInside(img.Bounds().Dx(), img.Bounds().Dy(), cell, cell)
+		cx, cy := (i%cols)*cell, (i/cols)*cell
+		dr := image.Rect(cx+(cell-tw)/2, cy+(cell-th)/2, cx+(cell-tw)/2+tw, cy+(cell-th)/2+th)
+		xdraw.BiLinear.Scale(sprite, dr, img, img.Bounds(), xdraw.Over, nil)
+
+		frames[i].X, frames[i].Y, frames[i].W, frames[i].H = dr.Min.X, dr.Min.Y, tw, th
+	}
+
+	format := outputFormat(r) // même champ wm_format que /optimize
+	buf, contentType, err := encodeToBuffer(sprite, format, formatQuality(format, spriteQuality), encOpts)
+	if err != nil {
+		http.Error(w, "Erreur encodage", http.StatusInternalServerError)
+		return
+	}
+	defer bufPool.Put(buf)
+
+	resp := spriteResponse{
+		Sprite: "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), // data URI : utilisable tel quel en CSS
+		Width:  sprite.Bounds().Dx(),
+		Height: sprite.Bounds().Dy(),
+		Cell:   cell,
+		Frames: frames,
+	}
+	logger.Info().Str("step", "sprite").Int("images", len(files)).Int("cols", cols).Int("cell", cell).Str("size", formatBytes(buf.Len())).Dur("duration", time.Since(start)).Msg("planche générée")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp) //nolint:errcheck — erreur réseau côté client, pas récupérable
+}
+
+// fitInside retourne les dimensions de (w, h) réduites pour tenir dans maxW×maxH, ratio préservé.
+// Les petites images sont aussi agrandies pour remplir la cellule — une planche a des vignettes homogènes.
+func fitInside(w, h, maxW, maxH int) (int, int) {
+	scale := math.Min(float64(maxW)/float64(w), float64(maxH)/float64(h))
+	return max(1, int(float64(w)*scale)), max(1, int(float64(h)*scale))
+}
@@ -0,0 +1,930 @@
+package main
+
+import (
+	"bufio"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+)
+
+// ── Mode strip (panoramas) ────────────────────────────────────────────────────
+// Au-delà de maxInputWidth×maxInputHeight, un panorama n'est jamais décodé en entier : le fichier
+// est lu rangée par rangée (une rangée de MCU en JPEG, une ligne en PNG) et chaque ligne est
+// aussitôt réduite par moyenne de surface (box) dans l'image de travail. Celle-ci tient dans le
+// budget de pixels d'une image normale (maxInputWidth × maxInputHeight) et suit ensuite le
+// pipeline habituel — orientation, recadrage, resize, watermark. La mémoire du décodage ne dépend
+// que de la largeur du panorama : quelques Mo pour 40000 px, au lieu de ~480 Mo en RGBA.
+//
+// Formats lus en bandes : JPEG baseline (Huffman, 8 bits, gris ou YCbCr/RGB, un seul scan
+// entrelacé) et PNG non entrelacé. Les autres (JPEG progressif ou CMYK, PNG Adam7, WebP, GIF,
+// TIFF) restent refusés au-delà des limites normales : leur décodage exige l'image entière.
+
+var errStripUnsupported = errors.New("mode strip : JPEG baseline ou PNG non entrelacé uniquement")
+
+// stripRows lit une image ligne par ligne, en RGBA prémultiplié.
+type stripRows interface {
+	size() (w, h int)
+	readRow(dst []uint8) error // len(dst) = 4 × largeur
+}
+
+// decodeStrip lit le panorama en bandes et retourne l'image de travail réduite (allouée par newCanvas).
+func decodeStrip(r io.Reader, format string) (*image.RGBA, error) {
+	var (
+		src stripRows
+		err error
+	)
+	switch format {
+	case "jpeg":
+		src, err = newJPEGRows(r)
+	case "png":
+		src, err = newPNGRows(r)
+	default:
+		err = errStripUnsupported
+	}
+	if err != nil {
+		return nil, err
+	}
+	w, h := src.size()
+	tw, th := stripTarget(w, h)
+	dst := newCanvas(image.Rect(0, 0, tw, th))
+	box := newBoxReducer(w, h, dst)
+	row := make([]uint8, 4*w)
+	for range h {
+		if err := src.readRow(row); err != nil {
+			releaseCanvas(dst)
+			return nil, err
+		}
+		box.addRow(row)
+	}
+	return dst, nil
+}
+
+// stripTarget retourne les dimensions de l'image de travail : celles du panorama, réduites au
+// besoin (ratio conservé) pour tenir dans le budget de pixels d'une image normale.
+func stripTarget(w, h int) (int, int) {
+	budget := float64(maxInputWidth) * float64(maxInputHeight)
+	if float64(w)*float64(h) <= budget {
+		return w, h
+	}
+	s := math.Sqrt(budget / (float64(w) * float64(h)))
+	return max(1, int(float64(w)*s)), max(1, int(float64(h)*s))
+}
+
+// ── Réduction par moyenne de surface ──
+
+// boxReducer réduit une image reçue ligne par ligne : chaque pixel destination est la moyenne des
+// pixels source qu'il recouvre, pondérés par la part recouverte — pas de crénelage, quel que soit
+// le facteur. Un pixel source recouvre au plus deux pixels destination par axe (réduction seule).
+// Seules deux lignes destination sont accumulées à la fois.
+type boxReducer struct {
+	sw, sh int
+	dst    *image.RGBA
+	xCol   []int     // par colonne source : première colonne destination recouverte
+	xW0    []float32 // part de la colonne source dans xCol
+	xW1    []float32 // et dans xCol+1
+	hRow   []float32 // ligne source réduite en largeur
+	cur    []float32 // ligne destination en cours
+	next   []float32 // ligne destination suivante (débordement de la ligne source à cheval)
+	sy, dy int
+}
+
+func newBoxReducer(sw, sh int, dst *image.RGBA) *boxReducer {
+	dw := dst.Rect.Dx()
+	b := &boxReducer{
+		sw: sw, sh: sh, dst: dst,
+		xCol: make([]int, sw),
+		xW0:  make([]float32, sw),
+		xW1:  make([]float32, sw),
+		hRow: make([]float32, 4*dw),
+		cur:  make([]float32, 4*dw),
+		next: make([]float32, 4*dw),
+	}
+	for x := range sw {
+		b.xCol[x], b.xW0[x], b.xW1[x] = boxSpan(x, sw, dw)
+	}
+	return b
+}
+
+// boxSpan situe le pixel source i (sur n) dans une destination de m pixels : premier pixel
+// recouvert, part qui lui revient et part du suivant — en fraction de pixel destination.
+// En unités de 1/n pixel destination, la source i couvre [i×m, (i+1)×m).
+func boxSpan(i, n, m int) (int, float32, float32) {
+	start, end := i*m, (i+1)*m
+	d := start / n
+	if edge := (d + 1) * n; end > edge {
+		return d, float32(edge-start) / float32(n), float32(end-edge) / float32(n)
+	}
+	return d, float32(m) / float32(n), 0
+}
+
+// addRow ajoute une ligne source (RGBA prémultiplié) et écrit les lignes destination complètes.
+func (b *boxReducer) addRow(row []uint8) {
+	h := b.hRow
+	clear(h)
+	for x, d := range b.xCol[:b.sw] {
+		p := (*[4]uint8)(row[4*x:])
+		r, g, bl, a := float32(p[0]), float32(p[1]), float32(p[2]), float32(p[3])
+		if w1 := b.xW1[x]; w1 > 0 { // jamais sur la dernière colonne : elle finit au bord
+			o := (*[4]float32)(h[4*d+4:])
+			o[0] += w1 * r
+			o[1] += w1 * g
+			o[2] += w1 * bl
+			o[3] += w1 * a
+		}
+		o, w0 := (*[4]float32)(h[4*d:]), b.xW0[x]
+		o[0] += w0 * r
+		o[1] += w0 * g
+		o[2] += w0 * bl
+		o[3] += w0 * a
+	}
+
+	dh := b.dst.Rect.Dy()
+	d, w0, w1 := boxSpan(b.sy, b.sh, dh)
+	for i, v := range b.hRow {
+		b.cur[i] += w0 * v
+		b.next[i] += w1 * v
+	}
+	b.sy++
+	if next, _, _ := boxSpan(b.sy, b.sh, dh); b.sy == b.sh || next != d { // ligne destination d complète
+		b.flush()
+	}
+}
+
+// flush écrit la ligne destination en cours et passe à la suivante.
+func (b *boxReducer) flush() {
+	if b.dy < b.dst.Rect.Dy() {
+		out := b.dst.Pix[b.dy*b.dst.Stride:]
+		for i, v := range b.cur {
+			out[i] = uint8(min(255, v+0.5))
+		}
+	}
+	b.dy++
+	b.cur, b.next = b.next, b.cur
+	clear(b.next)
+}
+
+// ── PNG ──
+
+// pngRows lit un PNG non entrelacé ligne par ligne : IDAT décompressé au fil de l'eau, filtres
+// défaits sur la ligne courante et la précédente seulement.
+type pngRows struct {
+	w, h        int
+	depth, ch   int // bits par échantillon, échantillons par pixel
+	ctype       byte
+	palette     []color.NRGBA
+	trns        []uint16 // couleur transparente (gris ou RGB), à la profondeur du fichier
+	z           io.Reader
+	cur, prev   []uint8
+	bpp, stride int // octets par pixel (au moins 1) et par ligne, octet de filtre exclu
+}
+
+func newPNGRows(r io.Reader) (*pngRows, error) {
+	br := bufio.NewReader(r)
+	var sig [8]byte
+	if _, err := io.ReadFull(br, sig[:]); err != nil || string(sig[:]) != "\x89PNG\r\n\x1a\n" {
+		return nil, errors.New("signature PNG invalide")
+	}
+	p := &pngRows{}
+	var trns []byte
+	for {
+		var hdr [8]byte
+		if _, err := io.ReadFull(br, hdr[:]); err != nil {
+			return nil, err
+		}
+		n, typ := binary.BigEndian.Uint32(hdr[:4]), string(hdr[4:])
+		if typ == "IDAT" {
+			if err := p.setup(trns); err != nil {
+				return nil, err
+			}
+			z, err := zlib.NewReader(&idatReader{r: br, left: n})
+			if err != nil {
+				return nil, err
+			}
+			p.z = z
+			return p, nil
+		}
+		if n > 1<<20 { // chunk annexe démesuré (texte, ICC) : sauté sans le charger
+			if _, err := br.Discard(int(n) + 4); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		data := make([]byte, n+4) // CRC compris, non vérifié
+		if _, err := io.ReadFull(br, data); err != nil {
+			return nil, err
+		}
+		data = data[:n]
+		switch typ {
+		case "IHDR":
+			if n < 13 {
+				return nil, errors.New("IHDR invalide")
+			}
+			p.w, p.h = int(binary.BigEndian.Uint32(data)), int(binary.BigEndian.Uint32(data[4:]))
+			p.depth, p.ctype = int(data[8]), data[9]
+			if data[12] != 0 {
+				return nil, errStripUnsupported // Adam7
+			}
+		case "PLTE":
+			for i := 0; i+2 < len(data); i += 3 {
+				p.palette = append(p.palette, color.NRGBA{data[i], data[i+1], data[i+2], 255})
+			}
+		case "tRNS":
+			trns = data
+		}
+	}
+}
+
+// setup valide l'en-tête et prépare les buffers de ligne, une fois les chunks avant IDAT lus.
+func (p *pngRows) setup(trns []byte) error {
+	switch p.ctype {
+	case 0:
+		p.ch = 1
+	case 2:
+		p.ch = 3
+	case 3:
+		p.ch = 1
+		if len(p.palette) == 0 || p.depth > 8 {
+			return errors.New("PNG palette invalide")
+		}
+		for i := 0; i < len(trns) && i < len(p.palette); i++ {
+			p.palette[i].A = trns[i]
+		}
+	case 4:
+		p.ch = 2
+	case 6:
+		p.ch = 4
+	default:
+		return errors.New("type de couleur PNG invalide")
+	}
+	switch p.depth {
+	case 1, 2, 4, 8, 16:
+	default:
+		return errors.New("profondeur PNG invalide")
+	}
+	if (p.ctype == 0 || p.ctype == 2) && len(trns) >= 2*p.ch {
+		for i := range p.ch {
+			p.trns = append(p.trns, binary.BigEndian.Uint16(trns[2*i:]))
+		}
+	}
+	if p.w <= 0 || p.h <= 0 {
+		return errors.New("dimensions PNG invalides")
+	}
+	p.bpp = max(1, p.ch*p.depth/8)
+	p.stride = (p.w*p.ch*p.depth + 7) / 8
+	p.cur, p.prev = make([]uint8, 1+p.stride), make([]uint8, 1+p.stride)
+	return nil
+}
+
+func (p *pngRows) size() (int, int) { return p.w, p.h }
+
+func (p *pngRows) readRow(dst []uint8) error {
+	p.cur, p.prev = p.prev, p.cur
+	if _, err := io.ReadFull(p.z, p.cur); err != nil {
+		return err
+	}
+	cur, prev := p.cur[1:], p.prev[1:]
+	switch p.cur[0] { // filtres PNG, ligne précédente à zéro pour la première
+	case 0:
+	case 1:
+		for i := p.bpp; i < len(cur); i++ {
+			cur[i] += cur[i-p.bpp]
+		}
+	case 2:
+		for i := range cur {
+			cur[i] += prev[i]
+		}
+	case 3:
+		for i := range cur {
+			var left int
+			if i >= p.bpp {
+				left = int(cur[i-p.bpp])
+			}
+			cur[i] += uint8((left + int(prev[i])) / 2)
+		}
+	case 4:
+		for i := range cur {
+			var a, c uint8
+			if i >= p.bpp {
+				a, c = cur[i-p.bpp], prev[i-p.bpp]
+			}
+			cur[i] += paeth(a, prev[i], c) // cf. pdfobj.go
+		}
+	default:
+		return fmt.Errorf("filtre PNG %d inconnu", p.cur[0])
+	}
+
+	for x := range p.w {
+		var r, g, b, a uint8
+		switch p.ctype {
+		case 3:
+			c := color.NRGBA{}
+			if i := p.sample(cur, x, 0); i < len(p.palette) {
+				c = p.palette[i]
+			}
+			r, g, b, a = c.R, c.G, c.B, c.A
+		case 0, 4:
+			r = p.sample8(cur, x, 0)
+			g, b, a = r, r, 255
+			if p.ctype == 4 {
+				a = p.sample8(cur, x, 1)
+			} else if p.trns != nil && p.sample(cur, x, 0) == int(p.trns[0]) {
+				a = 0
+			}
+		case 2, 6:
+			r, g, b, a = p.sample8(cur, x, 0), p.sample8(cur, x, 1), p.sample8(cur, x, 2), 255
+			if p.ctype == 6 {
+				a = p.sample8(cur, x, 3)
+			} else if p.trns != nil && p.sample(cur, x, 0) == int(p.trns[0]) && p.sample(cur, x, 1) == int(p.trns[1]) && p.sample(cur, x, 2) == int(p.trns[2]) {
+				a = 0
+			}
+		}
+		o := dst[4*x : 4*x+4]
+		if a == 255 {
+			o[0], o[1], o[2], o[3] = r, g, b, 255
+		} else { // prémultiplié, comme image.RGBA
+			o[0], o[1], o[2], o[3] = uint8(int(r)*int(a)/255), uint8(int(g)*int(a)/255), uint8(int(b)*int(a)/255), a
+		}
+	}
+	return nil
+}
+
+// sample retourne l'échantillon c du pixel x, à la profondeur du fichier.
+func (p *pngRows) sample(row []uint8, x, c int) int {
+	i := x*p.ch + c
+	switch p.depth {
+	case 8:
+		return int(row[i])
+	case 16:
+		return int(binary.BigEndian.Uint16(row[2*i:]))
+	}
+	bit := i * p.depth
+	return int(row[bit/8]>>(8-p.depth-bit%8)) & (1<<p.depth - 1)
+}
+
+// sample8 retourne l'échantillon c du pixel x ramené sur 8 bits.
+func (p *pngRows) sample8(row []uint8, x, c int) uint8 {
+	v := p.sample(row, x, c)
+	switch p.depth {
+	case 8:
+		return uint8(v)
+	case 16:
+		return uint8(v >> 8)
+	}
+	return uint8(v * 255 / (1<<p.depth - 1))
+}
+
+// idatReader enchaîne les données des chunks IDAT consécutifs.
+type idatReader struct {
+	r    *bufio.Reader
+	left uint32
+}
+
+func (d *idatReader) Read(p []byte) (int, error) {
+	for d.left == 0 {
+		var hdr [12]byte // CRC du chunk précédent, longueur et type du suivant
+		if _, err := io.ReadFull(d.r, hdr[:]); err != nil {
+			return 0, err
+		}
+		if string(hdr[8:]) != "IDAT" {
+			return 0, io.EOF // fin des données image
+		}
+		d.left = binary.BigEndian.Uint32(hdr[4:8])
+	}
+	n, err := d.r.Read(p[:min(len(p), int(d.left))])
+	d.left -= uint32(n)
+	return n, err
+}
+
+// ── JPEG baseline ──
+
+// jpegRows décode un JPEG baseline une rangée de MCU à la fois : coefficients, IDCT et
+// suréchantillonnage de la chroma (au plus proche, comme image/jpeg) sur une bande de 8×vmax lignes.
+type jpegRows struct {
+	w, h       int
+	comps      []stripComp
+	hmax, vmax int
+	mcusX      int
+	restart    int // intervalle de restart en MCU, 0 = aucun
+	rgb        bool
+	bits       stripBits
+	y          int // prochaine ligne à rendre
+	bandY      int // première ligne de la bande décodée
+	bandH      int // lignes décodées dans la bande (0 : rien)
+	mcu        int // MCU décodées
+}
+
+type stripComp struct {
+	id, h, v int
+	tq       byte // table de quantification, résolue au SOS
+	q        *[64]int32
+	dc, ac   *stripHuff
+	pred     int32
+	band     []uint8 // 8×v lignes de mcusX×h×8 échantillons
+	stride   int
+	xs       []int // colonne de l'échantillon de chaque pixel (suréchantillonnage)
+}
+
+type stripHuff struct {
+	lut     [256]uint16 // codes ≤ 8 bits : longueur << 8 | valeur, 0 si plus long
+	maxCode [17]int32
+	valPtr  [17]int32
+	vals    []uint8
+}
+
+func newJPEGRows(r io.Reader) (*jpegRows, error) {
+	j := &jpegRows{bits: stripBits{r: bufio.NewReaderSize(r, 64<<10)}}
+	br := j.bits.r
+	var (
+		quant [4][64]int32
+		huffs [2][4]*stripHuff
+		adobe = -1
+	)
+	if b0, err := br.ReadByte(); err != nil || b0 != 0xFF {
+		return nil, errors.New("SOI manquant")
+	}
+	if b1, err := br.ReadByte(); err != nil || b1 != 0xD8 {
+		return nil, errors.New("SOI manquant")
+	}
+	for {
+		marker, err := jpegMarker(br)
+		if err != nil {
+			return nil, err
+		}
+		if marker == 0xD8 || marker >= 0xD0 && marker <= 0xD7 || marker == 0x01 {
+			continue // marqueurs sans segment
+		}
+		var ln [2]byte
+		if _, err := io.ReadFull(br, ln[:]); err != nil {
+			return nil, err
+		}
+		n := int(binary.BigEndian.Uint16(ln[:])) - 2
+		if n < 0 {
+			return nil, errors.New("segment JPEG invalide")
+		}
+		if marker >= 0xE0 && marker != 0xEE || marker == 0xFE { // APPn (sauf Adobe) et commentaires : sautés
+			if _, err := br.Discard(n); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		seg := make([]byte, n)
+		if _, err := io.ReadFull(br, seg); err != nil {
+			return nil, err
+		}
+		switch {
+		case marker == 0xC0 || marker == 0xC1: // SOF baseline / séquentiel étendu, Huffman
+			if err := j.readSOF(seg); err != nil {
+				return nil, err
+			}
+		case marker >= 0xC2 && marker <= 0xCF && marker != 0xC4 && marker != 0xC8 && marker != 0xCC:
+			return nil, errStripUnsupported // progressif, sans perte ou arithmétique
+		case marker == 0xC4:
+			if err := readDHT(seg, &huffs); err != nil {
+				return nil, err
+			}
+		case marker == 0xDB:
+			if err := readDQT(seg, &quant); err != nil {
+				return nil, err
+			}
+		case marker == 0xDD:
+			if n < 2 {
+				return nil, errors.New("DRI invalide")
+			}
+			j.restart = int(binary.BigEndian.Uint16(seg))
+		case marker == 0xEE:
+			if n >= 12 && string(seg[:5]) == "Adobe" {
+				adobe = int(seg[11])
+			}
+		case marker == 0xDA:
+			if err := j.readSOS(seg, &quant, &huffs); err != nil {
+				return nil, err
+			}
+			if len(j.comps) == 3 {
+				j.rgb = adobe == 0 || j.comps[0].id == 'R' && j.comps[1].id == 'G' && j.comps[2].id == 'B'
+			}
+			return j, nil
+		case marker == 0xD9:
+			return nil, errors.New("EOI avant le premier scan")
+		}
+	}
+}
+
+// jpegMarker lit le prochain marqueur, octets de remplissage 0xFF compris.
+func jpegMarker(br *bufio.Reader) (byte, error) {
+	b, err := br.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if b != 0xFF {
+		return 0, errors.New("marqueur JPEG attendu")
+	}
+	for b == 0xFF {
+		if b, err = br.ReadByte(); err != nil {
+			return 0, err
+		}
+	}
+	return b, nil
+}
+
+func (j *jpegRows) readSOF(seg []byte) error {
+	if len(seg) < 6 || seg[0] != 8 {
+		return errStripUnsupported // précision 12 bits
+	}
+	j.h, j.w = int(binary.BigEndian.Uint16(seg[1:])), int(binary.BigEndian.Uint16(seg[3:]))
+	n := int(seg[5])
+	if n != 1 && n != 3 {
+		return errStripUnsupported // CMYK / YCCK
+	}
+	if j.w == 0 || j.h == 0 || len(seg) < 6+3*n {
+		return errors.New("SOF invalide")
+	}
+	j.comps = make([]stripComp, n)
+	for i := range n {
+		c := seg[6+3*i:]
+		h, v := int(c[1]>>4), int(c[1]&15)
+		if h < 1 || h > 4 || v < 1 || v > 4 || c[2] > 3 {
+			return errors.New("SOF invalide")
+		}
+		if n == 1 { // une seule composante : données non entrelacées, un bloc par MCU (A.2)
+			h, v = 1, 1
+		}
+		j.comps[i] = stripComp{id: int(c[0]), h: h, v: v, tq: c[2]}
+		j.hmax, j.vmax = max(j.hmax, h), max(j.vmax, v)
+	}
+	return nil
+}
+
+func (j *jpegRows) readSOS(seg []byte, quant *[4][64]int32, huffs *[2][4]*stripHuff) error {
+	if j.comps == nil {
+		return errors.New("SOS avant SOF")
+	}
+	if len(seg) < 1 || int(seg[0]) != len(j.comps) || len(seg) < 4+2*len(j.comps) {
+		return errStripUnsupported // scans non entrelacés, une composante à la fois
+	}
+	for i := range j.comps {
+		c := &j.comps[i]
+		id, tables := int(seg[1+2*i]), seg[2+2*i]
+		if id != c.id {
+			return errStripUnsupported
+		}
+		c.q = &quant[c.tq]
+		c.dc, c.ac = huffs[0][tables>>4&3], huffs[1][tables&3]
+		if c.dc == nil || c.ac == nil {
+			return errors.New("table de Huffman manquante")
+		}
+	}
+	j.mcusX = (j.w + 8*j.hmax - 1) / (8 * j.hmax)
+	for i := range j.comps {
+		c := &j.comps[i]
+		c.stride = j.mcusX * c.h * 8
+		c.band = make([]uint8, c.stride*c.v*8)
+		c.xs = make([]int, j.w)
+		for x := range j.w {
+			c.xs[x] = x * c.h / j.hmax
+		}
+	}
+	return nil
+}
+
+func readDQT(seg []byte, quant *[4][64]int32) error {
+	for len(seg) > 0 {
+		pq, tq := seg[0]>>4, seg[0]&15
+		if tq > 3 || pq > 1 || len(seg) < 1+64*(1+int(pq)) {
+			return errors.New("DQT invalide")
+		}
+		for k := range 64 {
+			if pq == 0 {
+				quant[tq][k] = int32(seg[1+k])
+			} else {
+				quant[tq][k] = int32(binary.BigEndian.Uint16(seg[1+2*k:]))
+			}
+		}
+		seg = seg[1+64*(1+int(pq)):]
+	}
+	return nil
+}
+
+func readDHT(seg []byte, huffs *[2][4]*stripHuff) error {
+	for len(seg) > 0 {
+		if len(seg) < 17 || seg[0]>>4 > 1 || seg[0]&15 > 3 {
+			return errors.New("DHT invalide")
+		}
+		class, id := seg[0]>>4, seg[0]&15
+		counts := seg[1:17]
+		total := 0
+		for _, n := range counts {
+			total += int(n)
+		}
+		if total > 256 || len(seg) < 17+total {
+			return errors.New("DHT invalide")
+		}
+		t := &stripHuff{vals: append([]uint8(nil), seg[17:17+total]...)}
+		code, k := int32(0), int32(0)
+		for l := 1; l <= 16; l++ {
+			n := int32(counts[l-1])
+			t.valPtr[l] = k - code // valeur = vals[valPtr + code]
+			if code+n > 1<<l {
+				return errors.New("DHT invalide")
+			}
+			if n == 0 {
+				t.maxCode[l] = -1
+			} else {
+				if l <= 8 {
+					for c := code; c < code+n; c++ {
+						for fill := range int32(1) << (8 - l) {
+							t.lut[c<<(8-l)|fill] = uint16(l)<<8 | uint16(t.vals[k+c-code])
+						}
+					}
+				}
+				t.maxCode[l] = code + n - 1
+			}
+			k += n
+			code = (code + n) << 1
+		}
+		huffs[class][id] = t
+		seg = seg[17+total:]
+	}
+	return nil
+}
+
+func (j *jpegRows) size() (int, int) { return j.w, j.h }
+
+func (j *jpegRows) readRow(dst []uint8) error {
+	if j.y >= j.bandY+j.bandH {
+		if err := j.decodeMCURow(); err != nil {
+			return err
+		}
+	}
+	yy := j.y - j.bandY
+	j.y++
+	if len(j.comps) == 1 {
+		c := &j.comps[0]
+		for x, v := range c.band[yy*c.stride : yy*c.stride+j.w] {
+			dst[4*x], dst[4*x+1], dst[4*x+2], dst[4*x+3] = v, v, v, 255
+		}
+		return nil
+	}
+	var rows [3][]uint8 // ligne de chaque composante, suréchantillonnée en hauteur
+	for i := range rows {
+		c := &j.comps[i]
+		rows[i] = c.band[(yy*c.v/j.vmax)*c.stride:]
+	}
+	c0, c1, c2 := &j.comps[0], &j.comps[1], &j.comps[2]
+	for x := range j.w {
+		a, b, c := rows[0][c0.xs[x]], rows[1][c1.xs[x]], rows[2][c2.xs[x]]
+		if !j.rgb {
+			a, b, c = color.YCbCrToRGB(a, b, c)
+		}
+		dst[4*x], dst[4*x+1], dst[4*x+2], dst[4*x+3] = a, b, c, 255
+	}
+	return nil
+}
+
+// decodeMCURow décode la rangée de MCU suivante dans les bandes des composantes.
+func (j *jpegRows) decodeMCURow() error {
+	var blk [64]int32
+	for mx := range j.mcusX {
+		if j.restart > 0 && j.mcu > 0 && j.mcu%j.restart == 0 {
+			if err := j.bits.restart(); err != nil {
+				return err
+			}
+			for i := range j.comps {
+				j.comps[i].pred = 0
+			}
+		}
+		for i := range j.comps {
+			c := &j.comps[i]
+			for by := range c.v {
+				for bx := range c.h {
+					if err := j.decodeBlock(c, &blk); err != nil {
+						return err
+					}
+					idct8x8(&blk, c.band[by*8*c.stride+(mx*c.h+bx)*8:], c.stride)
+				}
+			}
+		}
+		j.mcu++
+	}
+	if j.bandH > 0 {
+		j.bandY += j.bandH
+	}
+	j.bandH = 8 * j.vmax
+	return nil
+}
+
+// decodeBlock lit les coefficients d'un bloc (ordre naturel, déquantifiés).
+func (j *jpegRows) decodeBlock(c *stripComp, blk *[64]int32) error {
+	*blk = [64]int32{}
+	t, err := j.bits.decode(c.dc)
+	if err != nil {
+		return err
+	}
+	diff, err := j.bits.receive(t)
+	if err != nil {
+		return err
+	}
+	c.pred += diff
+	blk[0] = c.pred * c.q[0]
+	for k := 1; k < 64; {
+		rs, err := j.bits.decode(c.ac)
+		if err != nil {
+			return err
+		}
+		run, size := int(rs>>4), rs&15
+		if size == 0 {
+			if run != 15 {
+				break // EOB
+			}
+			k += 16
+			continue
+		}
+		k += run
+		if k > 63 {
+			return errors.New("coefficients JPEG invalides")
+		}
+		v, err := j.bits.receive(size)
+		if err != nil {
+			return err
+		}
+		blk[jpegUnzig[k]] = v * c.q[k]
+		k++
+	}
+	return nil
+}
+
+// stripBits lit les données entropiques : octets 0xFF00 ramenés à 0xFF, zéros après un marqueur.
+type stripBits struct {
+	r      *bufio.Reader
+	acc    uint64
+	n      uint
+	marker byte // marqueur atteint (0 sinon) : les bits suivants sont du bourrage
+}
+
+func (b *stripBits) fill() error {
+	for b.n <= 56 {
+		if b.marker != 0 {
+			b.acc <<= 8
+			b.n += 8
+			continue
+		}
+		c, err := b.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if c == 0xFF {
+			next, err := b.r.ReadByte()
+			for err == nil && next == 0xFF { // octets de remplissage avant un marqueur
+				next, err = b.r.ReadByte()
+			}
+			if err != nil {
+				return err
+			}
+			if next != 0x00 {
+				b.marker = next
+				continue
+			}
+		}
+		b.acc = b.acc<<8 | uint64(c)
+		b.n += 8
+	}
+	return nil
+}
+
+func (b *stripBits) peek(k uint) (uint32, error) {
+	if b.n < k {
+		if err := b.fill(); err != nil {
+			return 0, err
+		}
+	}
+	return uint32(b.acc>>(b.n-k)) & (1<<k - 1), nil
+}
+
+func (b *stripBits) decode(t *stripHuff) (uint8, error) {
+	code, err := b.peek(16)
+	if err != nil {
+		return 0, err
+	}
+	if e := t.lut[code>>8]; e != 0 {
+		b.n -= uint(e >> 8)
+		return uint8(e), nil
+	}
+	for l := 9; l <= 16; l++ {
+		if c := int32(code >> (16 - l)); c <= t.maxCode[l] {
+			b.n -= uint(l)
+			return t.vals[t.valPtr[l]+c], nil
+		}
+	}
+	return 0, errors.New("code de Huffman invalide")
+}
+
+// receive lit s bits et les étend en valeur signée (F.2.2.1).
+func (b *stripBits) receive(s uint8) (int32, error) {
+	if s == 0 {
+		return 0, nil
+	}
+	if s > 16 {
+		return 0, errors.New("coefficient JPEG invalide")
+	}
+	v, err := b.peek(uint(s))
+	if err != nil {
+		return 0, err
+	}
+	b.n -= uint(s)
+	if v < 1<<(s-1) {
+		return int32(v) - 1<<s + 1, nil
+	}
+	return int32(v), nil
+}
+
+// restart abandonne les bits restants et consomme le marqueur RSTn attendu.
+func (b *stripBits) restart() error {
+	m := b.marker
+	if m == 0 {
+		var err error
+		if m, err = jpegMarker(b.r); err != nil {
+			return err
+		}
+	}
+	b.acc, b.n, b.marker = 0, 0, 0
+	if m < 0xD0 || m > 0xD7 {
+		return errors.New("marqueur RST attendu")
+	}
+	return nil
+}
+
+// aanScale[k] = cos(kπ/16)·√2 (1 pour k = 0) : facteurs d'échelle de l'IDCT AAN, appliqués aux
+// coefficients à l'entrée.
+var aanScale = func() (t [8]float32) {
+	for k := range 8 {
+		t[k] = 1
+		if k > 0 {
+			t[k] = float32(math.Cos(float64(k)*math.Pi/16) * math.Sqrt2)
+		}
+	}
+	return
+}()
+
+// idct8x8 écrit la transformée inverse de blk (+128, bornée à 0-255) dans dst, ligne à ligne.
+// Algorithme AAN flottant (Arai, Agui, Nakajima — celui de jidctflt.c de libjpeg) : 5
+// multiplications par passe de 8 au lieu de 64. Colonnes d'abord, sautées si sans AC.
+func idct8x8(blk *[64]int32, dst []uint8, stride int) {
+	var ws [64]float32
+	for x := range 8 {
+		if blk[8+x]|blk[16+x]|blk[24+x]|blk[32+x]|blk[40+x]|blk[48+x]|blk[56+x] == 0 {
+			dc := float32(blk[x]) * aanScale[0] * aanScale[x]
+			for y := range 8 {
+				ws[8*y+x] = dc
+			}
+			continue
+		}
+		var in [8]float32
+		for y := range 8 {
+			in[y] = float32(blk[8*y+x]) * aanScale[y] * aanScale[x]
+		}
+		out := aanIDCT1D(&in)
+		for y := range 8 {
+			ws[8*y+x] = out[y]
+		}
+	}
+	for y := range 8 {
+		row := (*[8]float32)(ws[8*y : 8*y+8])
+		out := aanIDCT1D(row)
+		d := dst[y*stride : y*stride+8]
+		for x := range 8 {
+			d[x] = uint8(min(255, max(0, out[x]/8+128.5)))
+		}
+	}
+}
+
+// aanIDCT1D : IDCT 1D sur 8 valeurs prémultipliées par aanScale, résultat × 8 (√8 par passe).
+func aanIDCT1D(in *[8]float32) (out [8]float32) {
+	// Partie paire.
+	tmp10, tmp11 := in[0]+in[4], in[0]-in[4]
+	tmp13 := in[2] + in[6]
+	tmp12 := (in[2]-in[6])*1.414213562 - tmp13
+	t0, t3 := tmp10+tmp13, tmp10-tmp13
+	t1, t2 := tmp11+tmp12, tmp11-tmp12
+
+	// Partie impaire.
+	z13, z10 := in[5]+in[3], in[5]-in[3]
+	z11, z12 := in[1]+in[7], in[1]-in[7]
+	t7 := z11 + z13
+	tmp11 = (z11 - z13) * 1.414213562
+	z5 := (z10 + z12) * 1.847759065
+	tmp10 = 1.082392200*z12 - z5
+	tmp12 = -2.613125930*z10 + z5
+	t6 := tmp12 - t7
+	t5 := tmp11 - t6
+	t4 := tmp10 + t5
+
+	out[0], out[7] = t0+t7, t0-t7
+	out[1], out[6] = t1+t6, t1-t6
+	out[2], out[5] = t2+t5, t2-t5
+	out[4], out[3] = t3+t4, t3-t4
+	return
+}
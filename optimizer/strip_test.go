@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// testPattern : dégradés et motif fin, pour que l'IDCT et les filtres PNG travaillent vraiment.
+func testPattern(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x * 255 / w), uint8(y * 255 / h), uint8((x ^ y) * 7), uint8(255 - (x+y)%64)})
+		}
+	}
+	return img
+}
+
+// decodeStripAll décode data en mode strip, sans réduction.
+func decodeStripAll(t *testing.T, data []byte, format string) *image.RGBA {
+	t.Helper()
+	defer func(w, h int) { maxInputWidth, maxInputHeight = w, h }(maxInputWidth, maxInputHeight)
+	maxInputWidth, maxInputHeight = 1<<15, 1<<15
+	got, err := decodeStrip(bytes.NewReader(data), format)
+	if err != nil {
+		t.Fatalf("decodeStrip : %v", err)
+	}
+	return got
+}
+
+// maxDiff compare got à want converti en RGBA prémultiplié.
+func maxDiff(t *testing.T, got *image.RGBA, want image.Image) int {
+	t.Helper()
+	if got.Bounds() != want.Bounds() {
+		t.Fatalf("dimensions %v, attendu %v", got.Bounds(), want.Bounds())
+	}
+	ref := image.NewRGBA(want.Bounds())
+	draw.Draw(ref, ref.Bounds(), want, want.Bounds().Min, draw.Src)
+	diff := 0
+	for i := range got.Pix {
+		diff = max(diff, abs(int(got.Pix[i])-int(ref.Pix[i])))
+	}
+	return diff
+}
+
+func TestStripJPEG(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		img  image.Image
+	}{
+		{"ycbcr", testPattern(203, 77)},
+		{"gris", func() image.Image {
+			g := image.NewGray(image.Rect(0, 0, 61, 130))
+			draw.Draw(g, g.Bounds(), testPattern(61, 130), image.Point{}, draw.Src)
+			return g
+		}()},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := jpeg.Encode(&buf, tc.img, &jpeg.Options{Quality: 90}); err != nil {
+				t.Fatal(err)
+			}
+			want, err := jpeg.Decode(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// IDCT flottante contre IDCT entière d'image/jpeg : quelques niveaux d'écart au plus.
+			if d := maxDiff(t, decodeStripAll(t, buf.Bytes(), "jpeg"), want); d > 3 {
+				t.Errorf("écart max %d avec image/jpeg", d)
+			}
+		})
+	}
+}
+
+func TestStripPNG(t *testing.T) {
+	pal := image.NewPaletted(image.Rect(0, 0, 45, 33), color.Palette{color.NRGBA{255, 0, 0, 255}, color.NRGBA{0, 0, 255, 128}, color.Transparent})
+	for i := range pal.Pix {
+		pal.Pix[i] = uint8(i % 3)
+	}
+	gray16 := image.NewGray16(image.Rect(0, 0, 50, 20))
+	draw.Draw(gray16, gray16.Bounds(), testPattern(50, 20), image.Point{}, draw.Src)
+	for _, tc := range []struct {
+		name string
+		img  image.Image
+	}{
+		{"nrgba", testPattern(131, 47)},
+		{"palette", pal},
+		{"gris16", gray16},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := png.Encode(&buf, tc.img); err != nil {
+				t.Fatal(err)
+			}
+			want, err := png.Decode(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d := maxDiff(t, decodeStripAll(t, buf.Bytes(), "png"), want); d > 1 {
+				t.Errorf("écart max %d avec image/png", d)
+			}
+		})
+	}
+}
+
+func TestStripUnsupported(t *testing.T) {
+	var buf bytes.Buffer
+	if err := encodeProgressiveJPEG(&buf, image.NewRGBA(image.Rect(0, 0, 16, 16)), 80, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decodeStrip(bytes.NewReader(buf.Bytes()), "jpeg"); err != errStripUnsupported {
+		t.Errorf("JPEG progressif : %v, attendu errStripUnsupported", err)
+	}
+	if _, err := decodeStrip(bytes.NewReader(nil), "webp"); err != errStripUnsupported {
+		t.Errorf("WebP : %v, attendu errStripUnsupported", err)
+	}
+}
+
+// La réduction par moyenne de surface ne crénèle pas : un damier fin devient un gris uniforme.
+func TestBoxReducer(t *testing.T) {
+	const sw, sh = 1000, 300
+	dst := image.NewRGBA(image.Rect(0, 0, 37, 11))
+	box := newBoxReducer(sw, sh, dst)
+	row := make([]uint8, 4*sw)
+	for y := range sh {
+		for x := range sw {
+			v := uint8(255 * ((x + y) % 2))
+			copy(row[4*x:], []uint8{v, v, v, 255})
+		}
+		box.addRow(row)
+	}
+	for i := 0; i < len(dst.Pix); i += 4 {
+		if v := int(dst.Pix[i]); abs(v-128) > 2 || dst.Pix[i+3] != 255 {
+			t.Fatalf("pixel %d : %v, attendu gris opaque", i/4, dst.Pix[i:i+4])
+		}
+	}
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func TestStripTarget(t *testing.T) {
+	defer func(w, h int) { maxInputWidth, maxInputHeight = w, h }(maxInputWidth, maxInputHeight)
+	maxInputWidth, maxInputHeight = 8000, 8000
+	for _, tc := range []struct{ w, h, tw, th int }{
+		{20000, 3000, 20000, 3000}, // dans le budget de pixels : pas de réduction
+		{40000, 3000, 29211, 2190},
+	} {
+		if tw, th := stripTarget(tc.w, tc.h); tw != tc.tw || th != tc.th {
+			t.Errorf("stripTarget(%d, %d) = %d×%d, attendu %d×%d", tc.w, tc.h, tw, th, tc.tw, tc.th)
+		}
+	}
+}
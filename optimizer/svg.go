@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+
+	"github.com/srwiley/oksvg"
+	"github.com/srwiley/rasterx"
+)
+
+// ── Logo SVG ──────────────────────────────────────────────────────────────────
+// Un logo vectoriel est rasterisé directement à sa taille finale : il reste net quelle que
+// soit la largeur de sortie, là où un PNG agrandi ou fortement réduit perd en netteté.
+
+const maxSVGBytes = 1 << 20 // un logo SVG dépasse rarement quelques dizaines de Ko
+
+// isSVG reconnaît un document SVG à son début — pas de magic bytes pour un format texte.
+func isSVG(head []byte) bool {
+	head = bytes.TrimLeft(head, "\xef\xbb\xbf \t\r\n") // BOM UTF-8 et blancs avant le prologue
+	return (bytes.HasPrefix(head, []byte("<?xml")) || bytes.HasPrefix(head, []byte("<svg")) || bytes.HasPrefix(head, []byte("<!--"))) &&
+		bytes.Contains(head, []byte("<svg"))
+}
+
+// rasterizeSVG trace le SVG en RGBA, maxW px de large au plus, proportions du viewBox conservées.
+func rasterizeSVG(data []byte, maxW int) (image.Image, error) {
+	icon, err := oksvg.ReadIconStream(bytes.NewReader(data), oksvg.IgnoreErrorMode) // éléments non supportés (filtres, texte) ignorés plutôt que refusés
+	if err != nil {
+		return nil, err
+	}
+	vb := icon.ViewBox
+	if vb.W <= 0 || vb.H <= 0 {
+		return nil, fmt.Errorf("viewBox absent ou vide")
+	}
+	w, h := fitInside(int(vb.W+0.5), int(vb.H+0.5), maxW, maxLogoSide)
+	icon.SetTarget(0, 0, float64(w), float64(h))
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	scanner := rasterx.NewScannerGV(w, h, img, img.Bounds())
+	icon.Draw(rasterx.NewDasher(w, h, scanner), 1) // opacité 1 : wm_opacity est appliquée à la composition
+	return img, nil
+}
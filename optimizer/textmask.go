@@ -0,0 +1,179 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/f64"
+	"golang.org/x/image/math/fixed"
+)
+
+// ── Masques de texte ──────────────────────────────────────────────────────────
+// Le texte est d'abord rasterisé dans un masque alpha, puis composé sur l'image.
+// Passer par un masque permet les effets qui dérivent de la forme des glyphes
+// (contour, ombre) sans redessiner le texte.
+
+const maxOutline = 4 // au-delà de 4px le contour empâte les lettres au lieu de les détacher du fond
+
+// textMask rasterise text avec sa baseline en (x, y), dans un masque couvrant le texte plus pad px
+// de chaque côté — la marge laisse la place aux effets qui débordent des glyphes.
+// Le masque est en coordonnées image : son Rect se compose tel quel sur le canvas.
+func textMask(face font.Face, text string, x, y, pad int) *image.Alpha {
+	dot := fixed.P(x, y)
+	bounds, _ := font.BoundString(face, text) // relatif à l'origine — décalé ensuite sur le dot
+	r := image.Rect(
+		(bounds.Min.X+dot.X).Floor()-pad, (bounds.Min.Y+dot.Y).Floor()-pad,
+		(bounds.Max.X+dot.X).Ceil()+pad, (bounds.Max.Y+dot.Y).Ceil()+pad,
+	)
+	mask := image.NewAlpha(r)
+	d := &font.Drawer{
+		Dst:  mask,
+		Src:  image.Opaque, // couverture pleine : l'opacité est appliquée à la composition
+		Face: face,
+		Dot:  dot,
+	}
+	d.DrawString(text)
+	return mask
+}
+
+// outlineMask retourne l'anneau de radius px autour des glyphes : le masque dilaté (disque),
+// privé du texte lui-même pour que le contour ne fonce pas les lettres semi-transparentes.
+func outlineMask(mask *image.Alpha, radius int) *image.Alpha {
+	var offsets []image.Point // disque discret plutôt que carré : coins arrondis autour des lettres
+	for dy := -radius; dy <= radius; dy++ {
+		for dx := -radius; dx <= radius; dx++ {
+			if dx*dx+dy*dy <= radius*radius+radius { // +radius : disque un peu plus plein aux petits rayons
+				offsets = append(offsets, image.Pt(dx, dy))
+			}
+		}
+	}
+
+	r := mask.Rect
+	ring := image.NewAlpha(r)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			var m uint8
+			for _, o := range offsets { // dilatation : maximum de couverture dans le voisinage
+				p := image.Pt(x+o.X, y+o.Y)
+				if p.In(r) {
+					m = max(m, mask.AlphaAt(p.X, p.Y).A)
+				}
+			}
+			inside := uint32(mask.AlphaAt(x, y).A)
+			ring.SetAlpha(x, y, color.Alpha{A: uint8(uint32(m) * (255 - inside) / 255)})
+		}
+	}
+	return ring
+}
+
+// contrastColor retourne la couleur opposée (blanc ↔ gris foncé) avec la même opacité.
+// La luminance BT.601 départage les couleurs imposées par wm_color (un jaune vif est « clair »).
+func contrastColor(c color.NRGBA) color.NRGBA {
+	if 299*int(c.R)+587*int(c.G)+114*int(c.B) > 128*1000 { // texte clair → contour sombre
+		return color.NRGBA{R: 30, G: 30, B: 30, A: c.A}
+	}
+	return color.NRGBA{R: 255, G: 255, B: 255, A: c.A}
+}
+
+// shadowGeometry retourne le décalage et le rayon de flou de l'ombre, proportionnels à la taille
+// du texte — une ombre fixe serait invisible sous un gros titre et baverait sous un petit texte.
+func shadowGeometry(size int) (offset, radius int) {
+	return max(size/20, 1), max(size/48, 1)
+}
+
+// blurMask floute le masque par trois passes de flou boîte séparable (approximation de gaussienne).
+// Le flou s'étale sur 3×radius px : le masque doit avoir été rasterisé avec au moins cette marge.
+func blurMask(mask *image.Alpha, radius int) *image.Alpha {
+	r := mask.Rect
+	src := image.NewAlpha(r)
+	copy(src.Pix, mask.Pix)
+	dst := image.NewAlpha(r)
+	for range 3 {
+		boxBlur(dst, src, radius, 1, 0) // horizontal
+		boxBlur(src, dst, radius, 0, 1) // vertical — le résultat revient dans src
+	}
+	return src
+}
+
+// boxBlur moyenne chaque pixel sur 2×radius+1 voisins dans la direction (dx, dy).
+// Les voisins hors du masque comptent comme transparents.
+func boxBlur(dst, src *image.Alpha, radius, dx, dy int) {
+	r := src.Rect
+	n := uint32(2*radius + 1)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			var sum uint32
+			for i := -radius; i <= radius; i++ {
+				p := image.Pt(x+i*dx, y+i*dy)
+				if p.In(r) {
+					sum += uint32(src.AlphaAt(p.X, p.Y).A)
+				}
+			}
+			dst.SetAlpha(x, y, color.Alpha{A: uint8(sum / n)})
+		}
+	}
+}
+
+// rotateMask tourne le masque de deg degrés (sens horaire) autour du point (cx, cy).
+// Un centre commun garde alignés les masques d'un même watermark (texte, plaque).
+// Le masque retourné englobe le rectangle tourné.
+func rotateMask(mask *image.Alpha, deg, cx, cy float64) *image.Alpha {
+	r := mask.Rect
+	sin, cos := math.Sincos(deg * math.Pi / 180)
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, p := range []image.Point{r.Min, {r.Max.X, r.Min.Y}, {r.Min.X, r.Max.Y}, r.Max} { // boîte englobante des coins tournés
+		dx, dy := float64(p.X)-cx, float64(p.Y)-cy
+		x, y := cx+dx*cos-dy*sin, cy+dx*sin+dy*cos
+		minX, minY = min(minX, x), min(minY, y)
+		maxX, maxY = max(maxX, x), max(maxY, y)
+	}
+
+	dst := image.NewAlpha(image.Rect(
+		int(math.Floor(minX)), int(math.Floor(minY)),
+		int(math.Ceil(maxX)), int(math.Ceil(maxY)),
+	))
+	// Rotation autour de (cx, cy), exprimée de l'espace source vers l'espace destination :
+	// l'axe y de l'image pointant vers le bas, un angle positif tourne dans le sens horaire.
+	s2d := f64.Aff3{
+		cos, -sin, cx - cos*cx + sin*cy,
+		sin, cos, cy - sin*cx - cos*cy,
+	}
+	xdraw.BiLinear.Transform(dst, s2d, mask, r, xdraw.Src, nil)
+	return dst
+}
+
+// insideCanvas retourne la translation qui ramène r dans bounds — nulle si r y est déjà,
+// ou si r est trop grand pour y tenir (le texte est alors rogné plutôt que décalé hors cadre).
+func insideCanvas(r, bounds image.Rectangle) image.Point {
+	var d image.Point
+	if r.Dx() <= bounds.Dx() {
+		d.X = max(bounds.Min.X-r.Min.X, 0) + min(bounds.Max.X-r.Max.X, 0)
+	}
+	if r.Dy() <= bounds.Dy() {
+		d.Y = max(bounds.Min.Y-r.Min.Y, 0) + min(bounds.Max.Y-r.Max.Y, 0)
+	}
+	return d
+}
+
+// plateMask retourne la couverture d'un rectangle à coins arrondis de rayon radius, antialiasé :
+// la distance signée au bord arrondi donne une couverture partielle sur le pixel de bordure.
+func plateMask(r image.Rectangle, radius int) *image.Alpha {
+	mask := image.NewAlpha(r)
+	rad := float64(min(radius, r.Dx()/2, r.Dy()/2)) // un rayon plus grand que la demi-hauteur donne une pilule
+	cx, cy := float64(r.Min.X+r.Max.X)/2, float64(r.Min.Y+r.Max.Y)/2
+	hw, hh := float64(r.Dx())/2-rad, float64(r.Dy())/2-rad // demi-dimensions du rectangle intérieur aux arrondis
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			dx := max(math.Abs(float64(x)+0.5-cx)-hw, 0) // centre du pixel
+			dy := max(math.Abs(float64(y)+0.5-cy)-hh, 0)
+			d := math.Hypot(dx, dy) - rad // < 0 à l'intérieur
+			cov := min(max(0.5-d, 0), 1)
+			mask.SetAlpha(x, y, color.Alpha{A: uint8(cov*255 + 0.5)})
+		}
+	}
+	return mask
+}
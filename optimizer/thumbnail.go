@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// ── Miniature ─────────────────────────────────────────────────────────────────
+// POST /thumbnail produit un aperçu rapide pour les grilles de galerie et les écrans d'admin :
+// pas de palette, pas de BlurHash, pas d'expérience qualité, une interpolation rapide et une
+// compression forte. Le watermark reste appliqué par défaut (mêmes champs wm_* que /optimize) —
+// une miniature sans watermark doit être demandée explicitement avec watermark=false.
+
+const (
+	thumbDefaultSize = 320 // côté max en px — une colonne de grille sur un écran retina
+	thumbMaxSize     = 1024
+	thumbQuality     = 60 // artefacts invisibles à cette taille, fichier ~3× plus léger qu'en qualité 85
+)
+
+func handleThumbnail(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	sem <- struct{}{} // même worker pool que /optimize — une miniature décode quand même l'image entière
+	defer func() { <-sem }()
+
+	size := thumbDefaultSize
+	if v := r.FormValue("size"); v != "" {
+		var err error
+		if size, err = strconv.Atoi(v); err != nil || size < 1 || size > thumbMaxSize {
+			http.Error(w, fmt.Sprintf("size invalide : %q (entier 1-%d)", v, thumbMaxSize), http.StatusBadRequest)
+			return
+		}
+	}
+	watermark := true
+	if v := r.FormValue("watermark"); v != "" {
+		var err error
+		if watermark, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, fmt.Sprintf("watermark invalide : %q (true ou false)", v), http.StatusBadRequest)
+			return
+		}
+	}
+	fx, err := effectsParams(r) // même rendu que l'image pleine taille — et mêmes zones floutées
+	if errors.Is(err, errNoFaceDetector) {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	bg, err := bgColorParam(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	metaOpts, err := metaParamsFrom(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	encOpts, err := encodeParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	file, _, err := r.FormFile("image")
+	if err != nil {
+		http.Error(w, "image manquante", http.StatusBadRequest)
+		return
+	}
+	if !metaOpts.keepICC {
+		fx.color = newICCTransform(readICC(file))
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil { // readICC a avancé le curseur
+		file.Close()
+		http.Error(w, "seek échoué", http.StatusInternalServerError)
+		return
+	}
+	img, format, _, err := decodeFile(file, false) // pas de mode strip : un panorama n'a pas sa place dans un aperçu rapide
+	file.Close()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !keepsAlpha(outputFormat(r)) {
+		if flat, ok := flatten(img, bg); ok {
+			img = flat
+			defer releaseCanvas(img)
+		}
+	}
+
+	b := img.Bounds()
+	tw, th := b.Dx(), b.Dy()
+	if tw > size || th > size { // jamais agrandie
+		tw, th = fitInside(tw, th, size, size)
+	}
+	thumb := image.NewRGBA(image.Rect(0, 0, tw, th))
+	xdraw.ApproxBiLinear.Scale(thumb, thumb.Bounds(), img, b, xdraw.Src, nil) // le plus rapide sans crénelage visible à cette taille
+
+	if !fx.none() {
+		thumb = fx.apply(thumb, b)
+		defer releaseCanvas(thumb)
+	}
+
+	var out image.Image = thumb
+	var layers []wmOptions // sans watermark, set_copyright n'a pas de texte à écrire
+	if watermark {
+		if r.FormValue("wm_size") == "" {
+			r.Form.Set("wm_size", "auto") // 48px par défaut couvrirait la moitié d'une miniature
+		}
+		if layers, err = wmLayers(r, tw); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resolveAutoPositions(thumb, layers)
+		if out, err = applyLayers(thumb, layers); err != nil {
+			http.Error(w, "Erreur watermark", http.StatusInternalServerError)
+			return
+		}
+		defer releaseCanvas(out)
+	}
+
+	outFormat := outputFormat(r)
+	buf, contentType, err := encodeToBuffer(out, outFormat, formatQuality(outFormat, thumbQuality), encOpts)
+	if err != nil {
+		http.Error(w, "Erreur encodage", http.StatusInternalServerError)
+		return
+	}
+	defer bufPool.Put(buf)
+	if meta := buildOutMeta(r, metaOpts, layers); !meta.empty() {
+		if err := embedMetadata(buf, contentType, tw, th, meta); err != nil {
+			logger.Warn().Str("step", "metadata").Err(err).Msg("métadonnées non écrites")
+		}
+	}
+	logger.Info().Str("step", "thumbnail").Str("format", format).Int("width", tw).Int("height", th).Bool("watermark", watermark).Str("size", formatBytes(buf.Len())).Dur("duration", time.Since(start)).Msg("miniature générée")
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Image-Width", strconv.Itoa(tw))
+	w.Header().Set("X-Image-Height", strconv.Itoa(th))
+	w.Write(buf.Bytes()) //nolint:errcheck — erreur réseau côté client, pas récupérable
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ── Variantes responsive ──────────────────────────────────────────────────────
+// sizes=1920,1280,640,320 produit une variante watermarkée par largeur à partir d'un seul
+// décodage, renvoyées ensemble dans une réponse multipart/mixed : le front construit son
+// srcset sans envoyer N fois la même image. Chaque partie porte son Content-Type et ses
+// dimensions (X-Image-Width / X-Image-Height) — une petite image n'est jamais agrandie,
+// deux largeurs peuvent donc donner la même variante.
+
+const maxVariants = 8 // au-delà, le srcset n'y gagne plus rien et la requête monopolise un slot
+
+// errRender signale un échec de composition ou d'encodage (500) — les autres erreurs de
+// renderVariants sont des paramètres watermark refusés (400), ou errBudget (422).
+var errRender = errors.New("rendu des variantes impossible")
+
+// variant est une image encodée, prête à être écrite dans la réponse multipart.
+type variant struct {
+	buf           *bytes.Buffer // issu de bufPool — rendu par writeVariants
+	contentType   string
+	width, height int
+	quality       int
+}
+
+// sizesParam lit la liste de largeurs demandées, triée de la plus grande à la plus petite.
+// Une liste vide (champ absent) signifie « une seule image » : le pipeline normal s'applique.
+func sizesParam(r *http.Request, spec resizeSpec) ([]int, error) {
+	v := r.FormValue("sizes")
+	if v == "" {
+		return nil, nil
+	}
+	if spec.exact() || r.FormValue("max_w") != "" {
+		return nil, fmt.Errorf("sizes est exclusif avec w/h et max_w")
+	}
+	var sizes []int
+	for _, s := range strings.Split(v, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || n < 1 || n > maxOutputSide {
+			return nil, fmt.Errorf("sizes invalide : %q (largeurs 1-%d séparées par des virgules)", s, maxOutputSide)
+		}
+		if !slices.Contains(sizes, n) {
+			sizes = append(sizes, n)
+		}
+	}
+	if len(sizes) > maxVariants {
+		return nil, fmt.Errorf("sizes : %d largeurs au maximum", maxVariants)
+	}
+	slices.Sort(sizes)
+	slices.Reverse(sizes) // de la plus grande à la plus petite : chaque variante sert de source à la suivante
+	return sizes, nil
+}
+
+// renderVariants redimensionne, watermarke et encode une variante par largeur.
+// Chaque variante est réduite depuis la précédente (déjà plus petite que la source) : moins de
+// pixels à lire, et une réduction par paliers évite le crénelage du BiLinear sur les gros facteurs.
+// La hauteur n'est bornée que par max_h : sizes décrit des largeurs de srcset.
+// Filtres et zones floutées sont appliqués à la première variante, dont toutes les autres descendent ;
+// la netteté est refaite à chaque variante — accentuer avant une nouvelle réduction ne sert à rien.
+func renderVariants(r *http.Request, img image.Image, spec resizeSpec, sizes []int, fx effects) ([]variant, error) {
+	maxH := maxOutputSide
+	if r.FormValue("max_h") != "" {
+		maxH = spec.maxH
+	}
+	outFormat := outputFormat(r)
+	variants := make([]variant, 0, len(sizes))
+	release := func() { // en cas d'erreur, les buffers déjà encodés retournent au pool
+		for _, v := range variants {
+			bufPool.Put(v.buf)
+		}
+	}
+
+	cascade := fx // sans la netteté, refaite par variante
+	cascade.sharpen = 0
+	metaOpts, _ := metaParamsFrom(r) // déjà validé par le handler
+	encOpts, _ := encodeParams(r)
+	fixedQ, _ := qualityParam(r)
+	maxBytes, _ := maxBytesParam(r)
+	var meta *outMeta // construit à la première variante — identique pour toutes
+	src := img
+	for i, size := range sizes {
+		t := time.Now()
+		vspec := resizeSpec{maxW: size, maxH: maxH, upscale: spec.upscale}
+		resized := resize(src, vspec)
+		if i == 0 && !cascade.none() { // retouchée une fois : les variantes suivantes en héritent
+			filtered := cascade.apply(resized, img.Bounds())
+			if resized != img {
+				releaseCanvas(resized)
+			}
+			resized = filtered
+		}
+		if src != img && src != resized {
+			releaseCanvas(src) // variante précédente (non watermarkée) — plus utile
+		}
+		src = resized
+		newW, newH := resized.Bounds().Dx(), resized.Bounds().Dy()
+
+		layers, err := wmLayers(r, newW) // relu par variante : les tailles auto suivent la largeur
+		if err != nil {
+			release()
+			return nil, err
+		}
+		out := resized
+		if fx.sharpen > 0 { // netteté par variante, sur une copie : la cascade repart d'une image non accentuée
+			out = effects{sharpen: fx.sharpen}.apply(resized, resized.Bounds())
+		}
+		resolveAutoPositions(out, layers)
+		watermarked, err := applyLayers(out, layers)
+		if out != resized {
+			releaseCanvas(out)
+		}
+		if err != nil {
+			release()
+			return nil, fmt.Errorf("%w : %w", errRender, err)
+		}
+		q, _ := chooseQuality(newW, newH, fixedQ)
+		q = formatQuality(outFormat, q)
+		if meta == nil {
+			m := buildOutMeta(r, metaOpts, layers)
+			meta = &m
+		}
+		var contentType string
+		buf, q, err := fitBudget(outFormat, q, maxBytes, func(q int) (*bytes.Buffer, error) {
+			buf, ct, err := encodeToBuffer(watermarked, outFormat, q, encOpts)
+			if err == nil && !meta.empty() {
+				err = embedMetadata(buf, ct, newW, newH, *meta)
+			}
+			if err != nil {
+				if buf != nil {
+					bufPool.Put(buf)
+				}
+				return nil, err
+			}
+			contentType = ct
+			return buf, nil
+		})
+		releaseCanvas(watermarked)
+		if errors.Is(err, errBudget) {
+			release()
+			return nil, err
+		}
+		if err != nil {
+			release()
+			return nil, fmt.Errorf("%w : %w", errRender, err)
+		}
+		variants = append(variants, variant{buf: buf, contentType: contentType, width: newW, height: newH, quality: q})
+		logger.Info().Str("step", "variant").Int("size", size).Int("width", newW).Int("height", newH).Int("quality", q).Str("bytes", formatBytes(buf.Len())).Dur("duration", time.Since(t)).Msg("variante encodée")
+	}
+	if src != img {
+		releaseCanvas(src)
+	}
+	return variants, nil
+}
+
+// writeVariants écrit les variantes dans une réponse multipart/mixed, puis rend leurs buffers au pool.
+func writeVariants(w http.ResponseWriter, variants []variant) {
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+	w.Header().Set("X-Image-Variants", strconv.Itoa(len(variants)))
+	for _, v := range variants {
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":    {v.contentType},
+			"Content-Length":  {strconv.Itoa(v.buf.Len())},
+			"X-Image-Width":   {strconv.Itoa(v.width)},
+			"X-Image-Height":  {strconv.Itoa(v.height)},
+			"X-Image-Quality": {strconv.Itoa(v.quality)},
+		})
+		if err == nil {
+			part.Write(v.buf.Bytes()) //nolint:errcheck — erreur réseau côté client, pas récupérable
+		}
+		bufPool.Put(v.buf)
+	}
+	mw.Close() //nolint:errcheck — boundary final, même cas que les parties
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ── Watermark vidéo ───────────────────────────────────────────────────────────
+// POST /optimize-video incruste le watermark dans une vidéo MP4/MOV ou WebM, avec les mêmes champs
+// wm_* que /optimize. Le calque est rendu une fois, à la taille affichée de la vidéo (rotation des
+// smartphones comprise), sur un canvas transparent (cf. renderOverlay) ; ffmpeg le superpose à
+// chaque image et ré-encode : H.264 + AAC en MP4, VP9 + Opus en WebM, selon le conteneur reçu.
+// Sous-système optionnel : sans ffmpeg et ffprobe dans le PATH, la route répond 501 — l'image
+// Docker par défaut (scratch) ne les embarque pas. Une seule vidéo à la fois (ffmpeg occupe déjà
+// tous les cœurs), durée bornée par VIDEO_MAX_SECONDS, traitement par VIDEO_TIMEOUT_SECONDS.
+// Sans wm_color, le texte est blanc : pas de fond mesuré, et une vidéo est le plus souvent sombre.
+
+const (
+	defaultVideoMaxSeconds = 300 // 5 min — au-delà, le traitement synchrone dépasse ce qu'un client HTTP attend
+	defaultVideoTimeout    = 600 // secondes
+	maxVideoBytes          = 1 << 30
+	videoFormOverhead      = 16 << 20 // champs wm_*, logo et police joints à la vidéo
+	ffmpegStderrTail       = 2048     // fin de la sortie d'erreur de ffmpeg gardée pour les logs
+)
+
+// errNoFFmpeg : /optimize-video appelé sans ffmpeg installé (501).
+var errNoFFmpeg = errors.New("vidéo indisponible : ffmpeg et ffprobe absents du PATH de l'optimizer")
+
+// errNoDuration : ffprobe ne donne pas de durée (absente ou "N/A") — VIDEO_MAX_SECONDS invérifiable.
+var errNoDuration = errors.New("durée de la vidéo inconnue")
+
+// videoInput : options d'entrée de la vidéo reçue, pour ffprobe comme pour ffmpeg. Seuls les
+// démuxeurs MP4/MOV et Matroska/WebM, et le seul protocole file : un fichier piégé (playlist HLS,
+// concat, références externes) ne peut ouvrir ni URL ni autre fichier du disque.
+var videoInput = []string{"-format_whitelist", "mov,mp4,m4a,3gp,3g2,mj2,matroska,webm", "-protocol_whitelist", "file"}
+
+var (
+	ffmpegPath, ffprobePath string                   // vides : sous-système désactivé
+	videoSem                = make(chan struct{}, 1) // une vidéo à la fois
+	videoMaxSeconds         = defaultVideoMaxSeconds
+	videoTimeout            = defaultVideoTimeout * time.Second
+)
+
+// initVideo cherche ffmpeg et ffprobe et lit les limites — appelé au démarrage.
+func initVideo() {
+	videoMaxSeconds = envInt("VIDEO_MAX_SECONDS", defaultVideoMaxSeconds)
+	videoTimeout = time.Duration(envInt("VIDEO_TIMEOUT_SECONDS", defaultVideoTimeout)) * time.Second
+	ffmpeg, err1 := exec.LookPath("ffmpeg")
+	ffprobe, err2 := exec.LookPath("ffprobe")
+	if err1 != nil || err2 != nil {
+		logger.Info().Str("component", "init").Msg("ffmpeg absent : /optimize-video désactivé")
+		return
+	}
+	ffmpegPath, ffprobePath = ffmpeg, ffprobe
+	logger.Info().Str("component", "init").Str("ffmpeg", ffmpegPath).Int("max_seconds", videoMaxSeconds).Dur("timeout", videoTimeout).Msg("vidéo activée")
+}
+
+func handleVideo(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	if ffmpegPath == "" {
+		http.Error(w, errNoFFmpeg.Error(), http.StatusNotImplemented)
+		return
+	}
+
+	// Body borné avant le parsing : FormFile écrit tout le multipart sur disque avant de rendre la main.
+	tooLarge := fmt.Sprintf("vidéo trop volumineuse (max %s)", formatBytes(maxVideoBytes))
+	if r.ContentLength > maxVideoBytes+videoFormOverhead {
+		http.Error(w, tooLarge, http.StatusRequestEntityTooLarge)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxVideoBytes+videoFormOverhead)
+	file, _, err := r.FormFile("video")
+	if errors.As(err, new(*http.MaxBytesError)) {
+		http.Error(w, tooLarge, http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, "video manquante", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	dir, err := os.MkdirTemp("", "wm-video-*")
+	if err != nil {
+		http.Error(w, "Erreur fichier temporaire", http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(dir) //nolint:errcheck — répertoire temporaire, au pire nettoyé au redémarrage
+	in := filepath.Join(dir, "in")
+	n, err := copyToFile(in, io.LimitReader(file, maxVideoBytes+1))
+	if err != nil {
+		http.Error(w, "Erreur fichier temporaire", http.StatusInternalServerError)
+		return
+	}
+	if n > maxVideoBytes {
+		http.Error(w, tooLarge, http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	videoSem <- struct{}{}
+	defer func() { <-videoSem }()
+	ctx, cancel := context.WithTimeout(r.Context(), videoTimeout)
+	defer cancel()
+
+	info, err := probeVideo(ctx, in)
+	if errors.Is(err, errNoDuration) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "vidéo illisible", http.StatusBadRequest)
+		return
+	}
+	if info.width > maxInputWidth || info.height > maxInputHeight {
+		http.Error(w, fmt.Sprintf("vidéo trop grande (max %dx%d, reçu %dx%d)", maxInputWidth, maxInputHeight, info.width, info.height), http.StatusBadRequest)
+		return
+	}
+	if info.duration > float64(videoMaxSeconds) {
+		http.Error(w, fmt.Sprintf("vidéo trop longue (max %d s, reçu %.0f s)", videoMaxSeconds, info.duration), http.StatusBadRequest)
+		return
+	}
+
+	overlay, err := renderOverlay(r, info.width, info.height, color.NRGBA{R: 255, G: 255, B: 255}, "une vidéo")
+	if errors.Is(err, errRender) {
+		http.Error(w, "Erreur watermark", http.StatusInternalServerError)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var png bytes.Buffer
+	err = pngEncoder.Encode(&png, overlay)
+	releaseCanvas(overlay)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, "wm.png"), png.Bytes(), 0o600)
+	}
+	if err != nil {
+		http.Error(w, "Erreur watermark", http.StatusInternalServerError)
+		return
+	}
+
+	out := filepath.Join(dir, "out."+info.container)
+	args := append([]string{"-nostdin", "-v", "error", "-y"}, videoInput...)
+	args = append(args, "-i", in, "-i", filepath.Join(dir, "wm.png"),
+		"-filter_complex", "[0:v:0][1:v]overlay=0:0[v]", "-map", "[v]", "-map", "0:a:0?")
+	if info.container == "webm" {
+		args = append(args, "-c:v", "libvpx-vp9", "-crf", "32", "-b:v", "0", "-row-mt", "1", "-c:a", "libopus")
+	} else {
+		args = append(args, "-c:v", "libx264", "-preset", "veryfast", "-crf", "23", "-pix_fmt", "yuv420p",
+			"-c:a", "aac", "-b:a", "128k", "-movflags", "+faststart") // moov en tête : lecture avant la fin du téléchargement
+	}
+	cmd := exec.CommandContext(ctx, ffmpegPath, append(args, out)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	t := time.Now()
+	if err := cmd.Run(); err != nil {
+		tail := stderr.String()
+		tail = tail[max(len(tail)-ffmpegStderrTail, 0):]
+		logger.Error().Str("step", "video").Err(err).Str("ffmpeg", strings.TrimSpace(tail)).Msg("ffmpeg en échec")
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			http.Error(w, fmt.Sprintf("traitement vidéo trop long (max %s)", videoTimeout), http.StatusGatewayTimeout)
+			return
+		}
+		http.Error(w, "Erreur encodage vidéo", http.StatusInternalServerError)
+		return
+	}
+
+	result, err := os.Open(out)
+	if err != nil {
+		http.Error(w, "Erreur encodage vidéo", http.StatusInternalServerError)
+		return
+	}
+	defer result.Close()
+	st, _ := result.Stat()
+	logger.Info().Str("step", "video").Str("container", info.container).Int("width", info.width).Int("height", info.height).
+		Float64("seconds", info.duration).Str("size", formatBytes(int(st.Size()))).Dur("ffmpeg", time.Since(t)).Dur("duration", time.Since(start)).Msg("vidéo watermarkée")
+
+	w.Header().Set("Content-Type", "video/"+info.container)
+	w.Header().Set("Content-Length", strconv.FormatInt(st.Size(), 10))
+	w.Header().Set("X-Image-Width", strconv.Itoa(info.width))
+	w.Header().Set("X-Image-Height", strconv.Itoa(info.height))
+	io.Copy(w, result) //nolint:errcheck — erreur réseau côté client, pas récupérable
+}
+
+// copyToFile écrit r dans un nouveau fichier path et retourne le nombre d'octets copiés.
+func copyToFile(path string, r io.Reader) (int64, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return n, err
+}
+
+// videoInfo : ce que ffprobe dit de la vidéo, dimensions affichées (rotation appliquée).
+type videoInfo struct {
+	width, height int
+	duration      float64 // secondes
+	container     string  // "mp4" ou "webm" — conteneur de sortie
+}
+
+// probeVideo lit les dimensions, la rotation et la durée de la première piste vidéo.
+func probeVideo(ctx context.Context, path string) (videoInfo, error) {
+	args := append([]string{"-v", "error"}, videoInput...)
+	raw, err := exec.CommandContext(ctx, ffprobePath, append(args, "-select_streams", "v:0",
+		"-show_entries", "stream=width,height:stream_tags=rotate:stream_side_data=rotation:format=duration,format_name",
+		"-of", "json", path)...).Output()
+	if err != nil {
+		return videoInfo{}, err
+	}
+	var probe struct {
+		Streams []struct {
+			Width    int                     `json:"width"`
+			Height   int                     `json:"height"`
+			Tags     struct{ Rotate string } `json:"tags"`
+			SideData []struct {
+				Rotation float64 `json:"rotation"`
+			} `json:"side_data_list"`
+		} `json:"streams"`
+		Format struct {
+			Duration   string `json:"duration"`
+			FormatName string `json:"format_name"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(raw, &probe); err != nil || len(probe.Streams) == 0 || probe.Streams[0].Width <= 0 || probe.Streams[0].Height <= 0 {
+		return videoInfo{}, errors.New("pas de piste vidéo")
+	}
+	s := probe.Streams[0]
+	info := videoInfo{width: s.Width, height: s.Height, container: "mp4"}
+	info.duration, err = strconv.ParseFloat(probe.Format.Duration, 64) // "N/A" ou absente : erreur, pas 0
+	if err != nil || !(info.duration > 0) || math.IsInf(info.duration, 0) {
+		return videoInfo{}, errNoDuration
+	}
+	if strings.Contains(probe.Format.FormatName, "webm") {
+		info.container = "webm"
+	}
+	rotation, _ := strconv.ParseFloat(s.Tags.Rotate, 64) // ffmpeg < 5 : tag rotate — depuis : matrice d'affichage
+	for _, sd := range s.SideData {
+		if sd.Rotation != 0 {
+			rotation = sd.Rotation
+		}
+	}
+	if int(math.Abs(rotation))%180 == 90 { // vidéo portrait de smartphone : ffmpeg la redresse avant le filtre
+		info.width, info.height = info.height, info.width
+	}
+	return info, nil
+}
@@ -0,0 +1,789 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	"io"
+	"math"
+)
+
+// ── Encodeur WebP (VP8 lossy) ────────────────────────────────────────────────
+// Encodeur pur Go : pas de cgo, pas de libwebp à embarquer dans l'image Docker.
+// Périmètre volontairement réduit mais conforme à la RFC 6386 :
+//   - une seule image clé, une seule partition de tokens, probabilités par défaut
+//   - prédiction intra 16×16 (luma) et 8×8 (chroma), mode choisi au plus petit SSE
+//   - filtre de boucle activé : c'est le décodeur qui l'applique, la prédiction
+//     intra d'une image clé travaille sur les pixels non filtrés
+// Le gain face au JPEG vient surtout de la prédiction intra et du codage arithmétique.
+
+const vp8MaxDim = 16383 // largeur/hauteur codées sur 14 bits dans l'en-tête d'image clé
+
+// Modes de prédiction intra (numérotation interne — le codage en arbre est dans writeTo).
+const (
+	vp8PredDC = iota
+	vp8PredTM
+	vp8PredVE
+	vp8PredHE
+)
+
+// Plans de tokens (RFC 6386 §13.3) — seuls les trois premiers servent en prédiction 16×16.
+const (
+	vp8PlaneYAfterY2 = 0 // blocs luma dont le DC est porté par Y2
+	vp8PlaneY2       = 1 // bloc des DC luma (transformée de Walsh-Hadamard)
+	vp8PlaneUV       = 2 // blocs chroma
+)
+
+var (
+	vp8Zigzag  = [16]int{0, 1, 4, 8, 5, 2, 3, 6, 9, 12, 13, 10, 7, 11, 14, 15}
+	vp8Band    = [17]int{0, 1, 2, 3, 6, 4, 5, 6, 6, 6, 6, 6, 6, 6, 6, 7, 0}
+	vp8Cat3456 = [4][]uint8{ // probabilités des bits supplémentaires des catégories 3 à 6
+		{173, 148, 140},
+		{176, 155, 140, 135},
+		{180, 157, 141, 134, 130},
+		{254, 254, 243, 230, 196, 177, 153, 140, 133, 130, 129},
+	}
+)
+
+// Biais d'arrondi de la quantification en 1/256 de pas (DC, AC) — valeurs de libwebp pour l'intra.
+// Un biais < 128 crée une zone morte : les petits coefficients tombent à zéro et ne coûtent rien.
+var (
+	vp8BiasY1 = [2]int32{96, 110}
+	vp8BiasY2 = [2]int32{96, 108}
+	vp8BiasUV = [2]int32{110, 115}
+)
+
+// encodeWebP écrit img en WebP lossy à la qualité q (0..100, même échelle que libwebp).
+func encodeWebP(w io.Writer, img image.Image, q int) error {
+	b := img.Bounds()
+	if b.Dx() > vp8MaxDim || b.Dy() > vp8MaxDim {
+		return errors.New("image trop grande pour WebP (max 16383px)")
+	}
+	src, ok := img.(*image.RGBA)
+	if !ok { // le pipeline produit des *image.RGBA — conversion seulement pour les autres appelants
+		src = image.NewRGBA(b)
+		draw.Draw(src, b, img, b.Min, draw.Src)
+	}
+
+	e := newVP8Encoder(src, vp8QualityIndex(q))
+	for mby := 0; mby < e.mbh; mby++ {
+		e.leftNz = vp8Nz{} // pas de voisin gauche en début de ligne
+		for mbx := 0; mbx < e.mbw; mbx++ {
+			e.encodeMacroblock(mbx, mby)
+		}
+	}
+	return e.writeTo(w)
+}
+
+// vp8QualityIndex convertit une qualité 0..100 en index de quantification VP8 0..127.
+// Même courbe que libwebp : une qualité WebP donnée reste comparable entre les deux encodeurs.
+func vp8QualityIndex(q int) int {
+	c := float64(min(max(q, 0), 100)) / 100
+	linear := 2*c - 1
+	if c < 0.75 {
+		linear = c * 2 / 3
+	}
+	return min(max(int(127*(1-math.Cbrt(linear))), 0), 127)
+}
+
+// vp8Nz mémorise quels blocs voisins ont des coefficients non nuls — contexte des probabilités de tokens.
+type vp8Nz struct {
+	y    [4]uint8 // une entrée par colonne (voisin du haut) ou par ligne (voisin de gauche)
+	u, v [2]uint8
+	y2   uint8
+}
+
+type vp8Encoder struct {
+	width, height int
+	mbw, mbh      int // nombre de macroblocs 16×16
+	qi            int // index de quantification 0..127
+	level         int // force du filtre de boucle 0..63
+
+	y1, y2, uv [2]int32 // pas de quantification (DC, AC) par type de bloc
+
+	srcY, srcU, srcV []uint8 // source YUV 4:2:0 aux dimensions arrondies au macrobloc
+	recY, recU, recV []uint8 // reconstruction : exactement ce que verra le décodeur
+
+	modeY, modeC []uint8 // modes de prédiction par macrobloc
+	skip         []bool  // macroblocs sans aucun coefficient
+
+	tokens *vp8BoolWriter // partition des coefficients
+	topNz  []vp8Nz        // contexte non nul de la ligne de macroblocs précédente
+	leftNz vp8Nz          // contexte non nul du macrobloc de gauche
+}
+
+func newVP8Encoder(m *image.RGBA, qi int) *vp8Encoder {
+	b := m.Bounds()
+	e := &vp8Encoder{
+		width:  b.Dx(),
+		height: b.Dy(),
+		mbw:    (b.Dx() + 15) / 16,
+		mbh:    (b.Dy() + 15) / 16,
+		qi:     qi,
+		tokens: newVP8BoolWriter(),
+	}
+	n := e.mbw * e.mbh
+	e.modeY, e.modeC, e.skip = make([]uint8, n), make([]uint8, n), make([]bool, n)
+	e.topNz = make([]vp8Nz, e.mbw)
+
+	// Pas de quantification dérivés de l'index, comme le fait le décodeur (RFC 6386 §9.6).
+	e.y1 = [2]int32{int32(vp8DequantDC[qi]), int32(vp8DequantAC[qi])}
+	e.y2 = [2]int32{int32(vp8DequantDC[qi]) * 2, max(int32(vp8DequantAC[qi])*155/100, 8)}
+	e.uv = [2]int32{int32(vp8DequantDC[min(qi, 117)]), int32(vp8DequantAC[qi])}
+
+	// Filtre proportionnel au pas AC : plus la quantification est grossière, plus les bords de blocs se voient.
+	e.level = min(int(e.y1[1])/2, 63)
+
+	e.importRGBA(m)
+	return e
+}
+
+// importRGBA convertit en YUV 4:2:0 BT.601 « studio » (16..235), l'espace attendu par les décodeurs WebP.
+// Les bords sont répliqués jusqu'au multiple de 16 pour que les macroblocs partiels prédisent bien.
+func (e *vp8Encoder) importRGBA(m *image.RGBA) {
+	ys, cs := e.mbw*16, e.mbw*8
+	e.srcY, e.recY = make([]uint8, ys*e.mbh*16), make([]uint8, ys*e.mbh*16)
+	e.srcU, e.recU = make([]uint8, cs*e.mbh*8), make([]uint8, cs*e.mbh*8)
+	e.srcV, e.recV = make([]uint8, cs*e.mbh*8), make([]uint8, cs*e.mbh*8)
+
+	b := m.Bounds()
+	rgb := func(x, y int) (int32, int32, int32) {
+		i := m.PixOffset(b.Min.X+min(x, e.width-1), b.Min.Y+min(y, e.height-1))
+		return int32(m.Pix[i]), int32(m.Pix[i+1]), int32(m.Pix[i+2])
+	}
+	for y := 0; y < e.mbh*16; y++ {
+		for x := 0; x < ys; x++ {
+			r, g, bl := rgb(x, y)
+			e.srcY[y*ys+x] = uint8((16839*r + 33059*g + 6420*bl + 1<<15 + 16<<16) >> 16)
+		}
+	}
+	for y := 0; y < e.mbh*8; y++ {
+		for x := 0; x < cs; x++ {
+			var r, g, bl int32 // somme des 4 pixels couverts par l'échantillon chroma
+			for _, d := range [4][2]int{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+				pr, pg, pb := rgb(2*x+d[0], 2*y+d[1])
+				r, g, bl = r+pr, g+pg, bl+pb
+			}
+			e.srcU[y*cs+x] = clip8((-9719*r - 19081*g + 28800*bl + 1<<17 + 128<<18) >> 18)
+			e.srcV[y*cs+x] = clip8((28800*r - 24116*g - 4684*bl + 1<<17 + 128<<18) >> 18)
+		}
+	}
+}
+
+// encodeMacroblock choisit les modes, quantifie, reconstruit et écrit les tokens d'un macrobloc.
+func (e *vp8Encoder) encodeMacroblock(mbx, mby int) {
+	ys, cs := e.mbw*16, e.mbw*8
+	idx := mby*e.mbw + mbx
+
+	// ① Luma : prédiction 16×16, DCT par bloc 4×4, DC regroupés dans le bloc Y2
+	mode, pred := vp8BestMode(e.srcY, e.recY, ys, mbx*16, mby*16, 16)
+	e.modeY[idx] = mode
+
+	var coeffs [16][16]int32
+	var dcs [16]int32
+	for n := 0; n < 16; n++ {
+		var res [16]int32
+		for j := 0; j < 4; j++ {
+			for i := 0; i < 4; i++ {
+				x, y := n%4*4+i, n/4*4+j
+				res[j*4+i] = int32(e.srcY[(mby*16+y)*ys+mbx*16+x]) - int32(pred[y*16+x])
+			}
+		}
+		vp8FDCT(&res, &coeffs[n])
+		dcs[n] = coeffs[n][0]
+	}
+	var wht, levelsY2, deqY2, dcRec [16]int32
+	vp8FWHT(&dcs, &wht)
+	nonZero := vp8QuantizeBlock(&wht, &levelsY2, &deqY2, e.y2, vp8BiasY2, 0)
+	vp8IWHT(&deqY2, &dcRec) // le décodeur redistribue les DC quantifiés, pas les DC d'origine
+
+	var levelsY, deqY [16][16]int32
+	for n := 0; n < 16; n++ {
+		nonZero = vp8QuantizeBlock(&coeffs[n], &levelsY[n], &deqY[n], e.y1, vp8BiasY1, 1) || nonZero
+		deqY[n][0] = dcRec[n]
+	}
+	for y := 0; y < 16; y++ {
+		copy(e.recY[(mby*16+y)*ys+mbx*16:][:16], pred[y*16:][:16])
+	}
+	for n := 0; n < 16; n++ {
+		vp8IDCTAdd(&deqY[n], e.recY[(mby*16+n/4*4)*ys+mbx*16+n%4*4:], ys)
+	}
+
+	// ② Chroma : un seul mode pour U et V, choisi sur l'erreur cumulée des deux plans
+	modeC, predU, predV := e.bestChromaMode(mbx, mby)
+	e.modeC[idx] = modeC
+
+	var levelsU, levelsV [4][16]int32
+	for _, plane := range []struct {
+		src, rec []uint8
+		pred     []uint8
+		levels   *[4][16]int32
+	}{{e.srcU, e.recU, predU, &levelsU}, {e.srcV, e.recV, predV, &levelsV}} {
+		for y := 0; y < 8; y++ {
+			copy(plane.rec[(mby*8+y)*cs+mbx*8:][:8], plane.pred[y*8:][:8])
+		}
+		for n := 0; n < 4; n++ {
+			var res, coef, deq [16]int32
+			for j := 0; j < 4; j++ {
+				for i := 0; i < 4; i++ {
+					x, y := n%2*4+i, n/2*4+j
+					res[j*4+i] = int32(plane.src[(mby*8+y)*cs+mbx*8+x]) - int32(plane.pred[y*8+x])
+				}
+			}
+			vp8FDCT(&res, &coef)
+			nonZero = vp8QuantizeBlock(&coef, &plane.levels[n], &deq, e.uv, vp8BiasUV, 0) || nonZero
+			vp8IDCTAdd(&deq, plane.rec[(mby*8+n/2*4)*cs+mbx*8+n%2*4:], cs)
+		}
+	}
+
+	// ③ Tokens — un macrobloc sans coefficient est signalé par le drapeau skip et remet les contextes à zéro
+	top := &e.topNz[mbx]
+	if !nonZero {
+		e.skip[idx] = true
+		*top, e.leftNz = vp8Nz{}, vp8Nz{}
+		return
+	}
+	nz := e.putBlock(vp8PlaneY2, e.leftNz.y2+top.y2, &levelsY2, 0)
+	e.leftNz.y2, top.y2 = nz, nz
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			nz := e.putBlock(vp8PlaneYAfterY2, e.leftNz.y[y]+top.y[x], &levelsY[y*4+x], 1)
+			e.leftNz.y[y], top.y[x] = nz, nz
+		}
+	}
+	for _, c := range []struct {
+		left, top *[2]uint8
+		levels    *[4][16]int32
+	}{{&e.leftNz.u, &top.u, &levelsU}, {&e.leftNz.v, &top.v, &levelsV}} {
+		for y := 0; y < 2; y++ {
+			for x := 0; x < 2; x++ {
+				nz := e.putBlock(vp8PlaneUV, c.left[y]+c.top[x], &c.levels[y*2+x], 0)
+				c.left[y], c.top[x] = nz, nz
+			}
+		}
+	}
+}
+
+// bestChromaMode évalue les 4 modes sur U et V ensemble et retourne les deux prédictions retenues.
+func (e *vp8Encoder) bestChromaMode(mbx, mby int) (uint8, []uint8, []uint8) {
+	cs := e.mbw * 8
+	edU := vp8EdgesOf(e.recU, cs, mbx*8, mby*8, 8)
+	edV := vp8EdgesOf(e.recV, cs, mbx*8, mby*8, 8)
+	best, bestErr := uint8(vp8PredDC), int64(math.MaxInt64)
+	predU, predV := make([]uint8, 64), make([]uint8, 64)
+	var bestU, bestV [64]uint8
+	for mode := uint8(vp8PredDC); mode <= vp8PredHE; mode++ {
+		vp8Predict(predU, mode, &edU, 8)
+		vp8Predict(predV, mode, &edV, 8)
+		sse := vp8SSE(e.srcU, cs, mbx*8, mby*8, predU, 8) + vp8SSE(e.srcV, cs, mbx*8, mby*8, predV, 8)
+		if sse < bestErr {
+			best, bestErr = mode, sse
+			copy(bestU[:], predU)
+			copy(bestV[:], predV)
+		}
+	}
+	return best, bestU[:], bestV[:]
+}
+
+// vp8BestMode évalue les 4 modes intra d'un bloc size×size et retourne le meilleur avec sa prédiction.
+func vp8BestMode(src, rec []uint8, stride, x0, y0, size int) (uint8, []uint8) {
+	ed := vp8EdgesOf(rec, stride, x0, y0, size)
+	best, bestErr := uint8(vp8PredDC), int64(math.MaxInt64)
+	pred, bestPred := make([]uint8, size*size), make([]uint8, size*size)
+	for mode := uint8(vp8PredDC); mode <= vp8PredHE; mode++ {
+		vp8Predict(pred, mode, &ed, size)
+		if sse := vp8SSE(src, stride, x0, y0, pred, size); sse < bestErr {
+			best, bestErr = mode, sse
+			copy(bestPred, pred)
+		}
+	}
+	return best, bestPred
+}
+
+func vp8SSE(src []uint8, stride, x0, y0 int, pred []uint8, size int) int64 {
+	var sse int64
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			d := int64(src[(y0+y)*stride+x0+x]) - int64(pred[y*size+x])
+			sse += d * d
+		}
+	}
+	return sse
+}
+
+// vp8Edges rassemble les pixels reconstruits voisins d'un bloc.
+// Hors image, la RFC impose 127 pour la ligne du haut et 129 pour la colonne de gauche.
+type vp8Edges struct {
+	top, left       [16]int32
+	corner          int32
+	hasTop, hasLeft bool
+}
+
+func vp8EdgesOf(rec []uint8, stride, x0, y0, size int) vp8Edges {
+	ed := vp8Edges{hasTop: y0 > 0, hasLeft: x0 > 0, corner: 127}
+	for i := 0; i < size; i++ {
+		ed.top[i], ed.left[i] = 127, 129
+		if ed.hasTop {
+			ed.top[i] = int32(rec[(y0-1)*stride+x0+i])
+		}
+		if ed.hasLeft {
+			ed.left[i] = int32(rec[(y0+i)*stride+x0-1])
+		}
+	}
+	switch {
+	case ed.hasTop && ed.hasLeft:
+		ed.corner = int32(rec[(y0-1)*stride+x0-1])
+	case ed.hasTop: // première colonne : le coin prend la valeur de la colonne de gauche
+		ed.corner = 129
+	}
+	return ed
+}
+
+// vp8Predict remplit dst (size×size) avec la prédiction du mode donné — calculs identiques au décodeur.
+func vp8Predict(dst []uint8, mode uint8, ed *vp8Edges, size int) {
+	switch mode {
+	case vp8PredDC:
+		var sum int32
+		dc := int32(128) // ni haut ni gauche : valeur médiane
+		switch {
+		case ed.hasTop && ed.hasLeft:
+			for i := 0; i < size; i++ {
+				sum += ed.top[i] + ed.left[i]
+			}
+			dc = (sum + int32(size)) / int32(2*size)
+		case ed.hasTop:
+			for i := 0; i < size; i++ {
+				sum += ed.top[i]
+			}
+			dc = (sum + int32(size/2)) / int32(size)
+		case ed.hasLeft:
+			for i := 0; i < size; i++ {
+				sum += ed.left[i]
+			}
+			dc = (sum + int32(size/2)) / int32(size)
+		}
+		for i := range dst[:size*size] {
+			dst[i] = uint8(dc)
+		}
+	case vp8PredTM:
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				dst[y*size+x] = clip8(ed.left[y] + ed.top[x] - ed.corner)
+			}
+		}
+	case vp8PredVE:
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				dst[y*size+x] = uint8(ed.top[x])
+			}
+		}
+	case vp8PredHE:
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				dst[y*size+x] = uint8(ed.left[y])
+			}
+		}
+	}
+}
+
+// vp8QuantizeBlock quantifie un bloc (ordre raster) à partir de l'index first.
+// levels est rangé en zigzag (ordre des tokens), deq en raster (ordre de la transformée inverse).
+func vp8QuantizeBlock(coef, levels, deq *[16]int32, step, bias [2]int32, first int) bool {
+	nonZero := false
+	for n := first; n < 16; n++ {
+		z := vp8Zigzag[n]
+		k := min(z, 1) // 0 pour le DC, 1 pour les AC
+		c := coef[z]
+		a := c
+		if a < 0 {
+			a = -a
+		}
+		l := min((a+(bias[k]*step[k])>>8)/step[k], 2048) // 2048 : plus grande valeur codable (catégorie 6)
+		if c < 0 {
+			l = -l
+		}
+		levels[n], deq[z] = l, l*step[k]
+		nonZero = nonZero || l != 0
+	}
+	return nonZero
+}
+
+// vp8FDCT : DCT 4×4 directe, arithmétique entière de libvpx (vp8_short_fdct4x4_c).
+func vp8FDCT(in, out *[16]int32) {
+	var tmp [16]int32
+	for i := 0; i < 4; i++ {
+		ip := in[i*4:]
+		a := (ip[0] + ip[3]) * 8
+		b := (ip[1] + ip[2]) * 8
+		c := (ip[1] - ip[2]) * 8
+		d := (ip[0] - ip[3]) * 8
+		tmp[i*4+0] = a + b
+		tmp[i*4+2] = a - b
+		tmp[i*4+1] = (c*2217 + d*5352 + 14500) >> 12
+		tmp[i*4+3] = (d*2217 - c*5352 + 7500) >> 12
+	}
+	for i := 0; i < 4; i++ {
+		a := tmp[i] + tmp[12+i]
+		b := tmp[4+i] + tmp[8+i]
+		c := tmp[4+i] - tmp[8+i]
+		d := tmp[i] - tmp[12+i]
+		out[i] = (a + b + 7) >> 4
+		out[8+i] = (a - b + 7) >> 4
+		out[4+i] = (c*2217 + d*5352 + 12000) >> 16
+		if d != 0 {
+			out[4+i]++
+		}
+		out[12+i] = (d*2217 - c*5352 + 51000) >> 16
+	}
+}
+
+// vp8FWHT : transformée de Walsh-Hadamard des 16 DC luma (vp8_short_walsh4x4_c).
+func vp8FWHT(in, out *[16]int32) {
+	var tmp [16]int32
+	for i := 0; i < 4; i++ {
+		ip := in[i*4:]
+		a := (ip[0] + ip[2]) * 4
+		d := (ip[1] + ip[3]) * 4
+		c := (ip[1] - ip[3]) * 4
+		b := (ip[0] - ip[2]) * 4
+		tmp[i*4+0] = a + d
+		if a != 0 {
+			tmp[i*4+0]++
+		}
+		tmp[i*4+1] = b + c
+		tmp[i*4+2] = b - c
+		tmp[i*4+3] = a - d
+	}
+	for i := 0; i < 4; i++ {
+		a := tmp[i] + tmp[8+i]
+		d := tmp[4+i] + tmp[12+i]
+		c := tmp[4+i] - tmp[12+i]
+		b := tmp[i] - tmp[8+i]
+		for j, v := range [4]int32{a + d, b + c, b - c, a - d} {
+			if v < 0 {
+				v++
+			}
+			out[j*4+i] = (v + 3) >> 3
+		}
+	}
+}
+
+// vp8IWHT redistribue les DC quantifiés vers les 16 blocs luma (même arithmétique que le décodeur).
+func vp8IWHT(in, out *[16]int32) {
+	var m [16]int32
+	for i := 0; i < 4; i++ {
+		a0 := in[i] + in[12+i]
+		a1 := in[4+i] + in[8+i]
+		a2 := in[4+i] - in[8+i]
+		a3 := in[i] - in[12+i]
+		m[i] = a0 + a1
+		m[8+i] = a0 - a1
+		m[4+i] = a3 + a2
+		m[12+i] = a3 - a2
+	}
+	for i := 0; i < 4; i++ {
+		dc := m[i*4] + 3
+		a0 := dc + m[i*4+3]
+		a1 := m[i*4+1] + m[i*4+2]
+		a2 := m[i*4+1] - m[i*4+2]
+		a3 := dc - m[i*4+3]
+		out[i*4+0] = (a0 + a1) >> 3
+		out[i*4+1] = (a3 + a2) >> 3
+		out[i*4+2] = (a0 - a1) >> 3
+		out[i*4+3] = (a3 - a2) >> 3
+	}
+}
+
+// vp8IDCTAdd ajoute la DCT inverse de coef aux 4×4 pixels de dst — bit à bit identique au décodeur,
+// sinon les erreurs de prédiction s'accumulent d'un macrobloc à l'autre.
+func vp8IDCTAdd(coef *[16]int32, dst []uint8, stride int) {
+	const (
+		c1 = 85627 // 65536 * cos(pi/8) * sqrt(2)
+		c2 = 35468 // 65536 * sin(pi/8) * sqrt(2)
+	)
+	if *coef == [16]int32{} {
+		return
+	}
+	var m [4][4]int32
+	for i := 0; i < 4; i++ {
+		a := coef[i] + coef[8+i]
+		b := coef[i] - coef[8+i]
+		c := (coef[4+i]*c2)>>16 - (coef[12+i]*c1)>>16
+		d := (coef[4+i]*c1)>>16 + (coef[12+i]*c2)>>16
+		m[i] = [4]int32{a + d, b + c, b - c, a - d}
+	}
+	for j := 0; j < 4; j++ {
+		dc := m[0][j] + 4
+		a := dc + m[2][j]
+		b := dc - m[2][j]
+		c := (m[1][j]*c2)>>16 - (m[3][j]*c1)>>16
+		d := (m[1][j]*c1)>>16 + (m[3][j]*c2)>>16
+		row := dst[j*stride:]
+		row[0] = clip8(int32(row[0]) + (a+d)>>3)
+		row[1] = clip8(int32(row[1]) + (b+c)>>3)
+		row[2] = clip8(int32(row[2]) + (b-c)>>3)
+		row[3] = clip8(int32(row[3]) + (a-d)>>3)
+	}
+}
+
+func clip8(v int32) uint8 {
+	return uint8(min(max(v, 0), 255))
+}
+
+// putBlock écrit les tokens d'un bloc et retourne 1 s'il contient au moins un coefficient non nul.
+func (e *vp8Encoder) putBlock(plane int, ctx uint8, levels *[16]int32, first int) uint8 {
+	bw, probs := e.tokens, &vp8DefaultTokenProb[plane]
+	last := -1
+	for n := 15; n >= first; n-- {
+		if levels[n] != 0 {
+			last = n
+			break
+		}
+	}
+	p := &probs[vp8Band[first]][ctx]
+	bw.putBit(last >= 0, p[0]) // fin de bloc immédiate si tout est nul
+	if last < 0 {
+		return 0
+	}
+	for n := first; n < 16; n++ {
+		v := levels[n]
+		if v < 0 {
+			v = -v
+		}
+		next := vp8Band[n+1]
+		bw.putBit(v != 0, p[1])
+		if v == 0 { // après un zéro, le décodeur ne teste pas la fin de bloc
+			p = &probs[next][0]
+			continue
+		}
+		if v == 1 {
+			bw.putBit(false, p[2])
+			p = &probs[next][1]
+		} else {
+			bw.putBit(true, p[2])
+			bw.putLarge(p, v)
+			p = &probs[next][2]
+		}
+		bw.putBit(levels[n] < 0, 128) // signe
+		if n == 15 {
+			break
+		}
+		bw.putBit(n != last, p[0])
+		if n == last {
+			break
+		}
+	}
+	return 1
+}
+
+// putLarge code une valeur absolue ≥ 2 selon l'arbre de tokens (RFC 6386 §13.2).
+func (w *vp8BoolWriter) putLarge(p *[vp8Probs]uint8, v int32) {
+	switch {
+	case v <= 4:
+		w.putBit(false, p[3])
+		w.putBit(v != 2, p[4])
+		if v != 2 {
+			w.putBit(v == 4, p[5])
+		}
+	case v <= 10:
+		w.putBit(true, p[3])
+		w.putBit(false, p[6])
+		if v <= 6 {
+			w.putBit(false, p[7])
+			w.putBit(v == 6, 159)
+		} else {
+			w.putBit(true, p[7])
+			w.putBit((v-7)&2 != 0, 165)
+			w.putBit((v-7)&1 != 0, 145)
+		}
+	default:
+		w.putBit(true, p[3])
+		w.putBit(true, p[6])
+		cat := 3
+		switch {
+		case v < 19:
+			cat = 0
+		case v < 35:
+			cat = 1
+		case v < 67:
+			cat = 2
+		}
+		w.putBit(cat >= 2, p[8])
+		w.putBit(cat&1 != 0, p[9+cat>>1])
+		tab, extra := vp8Cat3456[cat], v-(3+8<<cat)
+		for i, prob := range tab {
+			w.putBit(extra>>(len(tab)-1-i)&1 != 0, prob)
+		}
+	}
+}
+
+// writeTo assemble l'en-tête, la première partition (modes) et les tokens dans un conteneur RIFF.
+func (e *vp8Encoder) writeTo(w io.Writer) error {
+	skipped := 0
+	for _, s := range e.skip {
+		if s {
+			skipped++
+		}
+	}
+	// Probabilité qu'un macrobloc ne soit PAS sauté, estimée sur l'image entière.
+	skipProb := uint8(min(max((len(e.skip)-skipped)*256/len(e.skip), 1), 254))
+
+	hdr := newVP8BoolWriter()
+	hdr.putLiteral(0, 1)               // espace colorimétrique YUV
+	hdr.putLiteral(0, 1)               // clamping requis
+	hdr.putLiteral(0, 1)               // pas de segmentation
+	hdr.putLiteral(0, 1)               // filtre normal
+	hdr.putLiteral(uint32(e.level), 6) // force du filtre
+	hdr.putLiteral(0, 3)               // netteté
+	hdr.putLiteral(0, 1)               // pas d'ajustement du filtre par mode
+	hdr.putLiteral(0, 2)               // une seule partition de tokens
+	hdr.putLiteral(uint32(e.qi), 7)    // index de quantification de base
+	for range 5 {
+		hdr.putLiteral(0, 1) // pas de delta par type de bloc
+	}
+	hdr.putLiteral(0, 1) // refresh_entropy_probs — sans effet pour une image unique
+	for i := range vp8TokenUpdateProb {
+		for j := range vp8TokenUpdateProb[i] {
+			for k := range vp8TokenUpdateProb[i][j] {
+				for _, p := range vp8TokenUpdateProb[i][j][k] {
+					hdr.putBit(false, p) // probabilités par défaut conservées
+				}
+			}
+		}
+	}
+	useSkip := skipped > 0
+	hdr.putBit(useSkip, 128)
+	if useSkip {
+		hdr.putLiteral(uint32(skipProb), 8)
+	}
+	for i := range e.modeY {
+		if useSkip {
+			hdr.putBit(e.skip[i], skipProb)
+		}
+		hdr.putBit(true, 145) // prédiction 16×16 (pas de sous-blocs 4×4)
+		switch e.modeY[i] {
+		case vp8PredDC:
+			hdr.putBit(false, 156)
+			hdr.putBit(false, 163)
+		case vp8PredVE:
+			hdr.putBit(false, 156)
+			hdr.putBit(true, 163)
+		case vp8PredHE:
+			hdr.putBit(true, 156)
+			hdr.putBit(false, 128)
+		case vp8PredTM:
+			hdr.putBit(true, 156)
+			hdr.putBit(true, 128)
+		}
+		hdr.putBit(e.modeC[i] != vp8PredDC, 142)
+		if e.modeC[i] != vp8PredDC {
+			hdr.putBit(e.modeC[i] != vp8PredVE, 114)
+			if e.modeC[i] != vp8PredVE {
+				hdr.putBit(e.modeC[i] == vp8PredTM, 183)
+			}
+		}
+	}
+	first, tokens := hdr.flush(), e.tokens.flush()
+	if len(first) >= 1<<19 {
+		return errors.New("partition VP8 trop grande")
+	}
+
+	// En-tête d'image clé : étiquette 3 octets, code de synchro, dimensions (RFC 6386 §9.1).
+	frame := make([]byte, 10, 10+len(first)+len(tokens))
+	tag := uint32(1<<4) | uint32(len(first))<<5 // image clé, version 0, affichée
+	frame[0], frame[1], frame[2] = byte(tag), byte(tag>>8), byte(tag>>16)
+	frame[3], frame[4], frame[5] = 0x9d, 0x01, 0x2a
+	binary.LittleEndian.PutUint16(frame[6:], uint16(e.width))
+	binary.LittleEndian.PutUint16(frame[8:], uint16(e.height))
+	frame = append(append(frame, first...), tokens...)
+
+	pad := len(frame) & 1 // les chunks RIFF sont alignés sur 2 octets
+	var riff [20]byte
+	copy(riff[0:], "RIFF")
+	binary.LittleEndian.PutUint32(riff[4:], uint32(12+len(frame)+pad))
+	copy(riff[8:], "WEBPVP8 ")
+	binary.LittleEndian.PutUint32(riff[16:], uint32(len(frame)))
+	if _, err := w.Write(riff[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write(frame); err != nil {
+		return err
+	}
+	if pad == 1 {
+		_, err := w.Write([]byte{0})
+		return err
+	}
+	return nil
+}
+
+// ── Codeur booléen ───────────────────────────────────────────────────────────
+
+// vp8BoolWriter est le codeur arithmétique binaire de VP8 (RFC 6386 §7.3).
+type vp8BoolWriter struct {
+	buf    []byte
+	rng    uint32 // largeur de l'intervalle, renormalisée dans [128, 255]
+	bottom uint32 // borne basse de l'intervalle (24 bits + retenue)
+	nbits  int    // décalages restants avant l'émission du prochain octet
+}
+
+func newVP8BoolWriter() *vp8BoolWriter {
+	return &vp8BoolWriter{rng: 255, nbits: 24}
+}
+
+// putBit code bit avec prob = probabilité (sur 256) que le bit vaille 0.
+func (w *vp8BoolWriter) putBit(bit bool, prob uint8) {
+	split := 1 + (w.rng-1)*uint32(prob)>>8
+	if bit {
+		w.bottom += split
+		w.rng -= split
+	} else {
+		w.rng = split
+	}
+	for w.rng < 128 {
+		w.rng <<= 1
+		if w.bottom&(1<<31) != 0 {
+			w.carry()
+		}
+		w.bottom <<= 1
+		w.nbits--
+		if w.nbits == 0 {
+			w.buf = append(w.buf, byte(w.bottom>>24))
+			w.bottom &= 1<<24 - 1
+			w.nbits = 8
+		}
+	}
+}
+
+// putLiteral écrit les n bits de poids faible de v, poids fort en premier, à probabilité 1/2.
+func (w *vp8BoolWriter) putLiteral(v uint32, n int) {
+	for i := n - 1; i >= 0; i-- {
+		w.putBit(v>>i&1 != 0, 128)
+	}
+}
+
+// carry propage une retenue dans les octets déjà émis.
+func (w *vp8BoolWriter) carry() {
+	i := len(w.buf) - 1
+	for ; i >= 0 && w.buf[i] == 0xff; i-- {
+		w.buf[i] = 0
+	}
+	w.buf[i]++
+}
+
+// flush vide les bits en attente et retourne le flux complet.
+func (w *vp8BoolWriter) flush() []byte {
+	c, v := w.nbits, w.bottom
+	if v&(1<<(32-c)) != 0 {
+		w.carry()
+	}
+	v <<= c & 7
+	for c >>= 3; c > 0; c-- {
+		v <<= 8
+	}
+	for range 4 {
+		w.buf = append(w.buf, byte(v>>24))
+		v <<= 8
+	}
+	return w.buf
+}
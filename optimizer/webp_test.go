@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+// gradient : dégradés doux et un bord net, de quoi faire travailler prédiction et quantification.
+func gradient(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			c := color.RGBA{uint8(x * 255 / max(w-1, 1)), uint8(y * 255 / max(h-1, 1)), 128, 255}
+			if x > w/2 && y > h/2 {
+				c.B = 20
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// lumaDiff : écart moyen de luminance entre src et l'image décodée. La luma seule : la chroma 4:2:0
+// perd d'office le détail d'un pixel sur deux, quelle que soit la qualité. Luma BT.601 « studio »
+// (16..235), l'espace des décodeurs WebP.
+func lumaDiff(t *testing.T, src *image.RGBA, got image.Image) float64 {
+	t.Helper()
+	ycc, ok := got.(*image.YCbCr)
+	if !ok {
+		t.Fatalf("image décodée %T, attendu *image.YCbCr", got)
+	}
+	var sum float64
+	b := src.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			p := src.RGBAAt(x, y)
+			want := 16 + 0.257*float64(p.R) + 0.504*float64(p.G) + 0.098*float64(p.B)
+			sum += math.Abs(want - float64(ycc.Y[ycc.YOffset(x, y)]))
+		}
+	}
+	return sum / float64(b.Dx()*b.Dy())
+}
+
+// Les fichiers produits par encodeWebP se relisent avec x/image/webp, aux dimensions impaires et
+// aux qualités extrêmes : luma fidèle à q=100, dégradée mais bornée à q=1.
+func TestEncodeWebPRoundTrip(t *testing.T) {
+	for _, size := range []image.Point{{1, 1}, {17, 9}, {3, 255}, {33, 65}, {130, 47}} {
+		for _, tc := range []struct {
+			q       int
+			maxDiff float64
+		}{{1, 12}, {50, 4}, {100, 1}} {
+			t.Run(fmt.Sprintf("%dx%d_q%d", size.X, size.Y, tc.q), func(t *testing.T) {
+				src := gradient(size.X, size.Y)
+				var buf bytes.Buffer
+				if err := encodeWebP(&buf, src, tc.q); err != nil {
+					t.Fatal(err)
+				}
+				got, err := webp.Decode(bytes.NewReader(buf.Bytes()))
+				if err != nil {
+					t.Fatalf("décodage x/image/webp : %v", err)
+				}
+				if got.Bounds() != src.Bounds() {
+					t.Fatalf("dimensions %v, attendu %v", got.Bounds(), src.Bounds())
+				}
+				if d := lumaDiff(t, src, got); d > tc.maxDiff {
+					t.Errorf("écart moyen de luma %.2f, attendu ≤ %g", d, tc.maxDiff)
+				}
+			})
+		}
+	}
+}
+
+func TestEncodeWebPTooLarge(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, vp8MaxDim+1, 1))
+	if err := encodeWebP(&bytes.Buffer{}, img, 80); err == nil {
+		t.Error("image plus large que 16383 px acceptée")
+	}
+}